@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/tahardi/bearclave-examples/internal/engine"
+	"github.com/tahardi/bearclave-examples/internal/metrics"
 	"github.com/tahardi/bearclave-examples/internal/networking"
 	"github.com/tahardi/bearclave-examples/internal/setup"
 
@@ -94,17 +95,92 @@ func main() {
 	}
 	logger.Info("loaded config", slog.Any(configFile, config))
 
+	limits := networking.DefaultLimits()
+	err = config.Enclave.DecodeArg(networking.LimitsKey, &limits)
+	if err != nil {
+		logger.Error("loading limits", slog.String("error", err.Error()))
+		return
+	}
+
+	rateLimitConfig := networking.DefaultRateLimitConfig()
+	err = config.Enclave.DecodeArg(networking.RateLimitKey, &rateLimitConfig)
+	if err != nil {
+		logger.Error("loading rate limit config", slog.String("error", err.Error()))
+		return
+	}
+	limiter := networking.NewLimiter(rateLimitConfig, logger)
+
+	authConfig := networking.DefaultAuthConfig()
+	err = config.Enclave.DecodeArg(networking.AuthKey, &authConfig)
+	if err != nil {
+		logger.Error("loading auth config", slog.String("error", err.Error()))
+		return
+	}
+
+	authenticator, err := networking.NewAuthenticator(authConfig, logger)
+	if err != nil {
+		logger.Error("making authenticator", slog.String("error", err.Error()))
+		return
+	}
+
+	challengeConfig := networking.DefaultChallengeConfig()
+	err = config.Enclave.DecodeArg(networking.ChallengeKey, &challengeConfig)
+	if err != nil {
+		logger.Error("loading challenge config", slog.String("error", err.Error()))
+		return
+	}
+
+	challenges, err := networking.NewChallengeIssuer(challengeConfig)
+	if err != nil {
+		logger.Error("making challenge issuer", slog.String("error", err.Error()))
+		return
+	}
+
+	batchConfig := networking.DefaultBatchConfig()
+	err = config.Enclave.DecodeArg(networking.BatchKey, &batchConfig)
+	if err != nil {
+		logger.Error("loading batch config", slog.String("error", err.Error()))
+		return
+	}
+
+	sessionKeyConfig := networking.DefaultSessionKeyConfig()
+	err = config.Enclave.DecodeArg(networking.SessionKeyKey, &sessionKeyConfig)
+	if err != nil {
+		logger.Error("loading session key config", slog.String("error", err.Error()))
+		return
+	}
+
+	encryptionConfig := networking.DefaultEncryptionConfig()
+	err = config.Enclave.DecodeArg(networking.EncryptionKey, &encryptionConfig)
+	if err != nil {
+		logger.Error("loading encryption config", slog.String("error", err.Error()))
+		return
+	}
+
+	// Signed responses skip hardware attestation altogether, so there is
+	// nothing left to batch.
+	if batchConfig.Enabled && sessionKeyConfig.Enabled {
+		logger.Error("batch and session_key cannot both be enabled")
+		return
+	}
+
 	attester, err := tee.NewAttester(config.Platform)
 	if err != nil {
 		logger.Error("making attester", slog.String("error", err.Error()))
 		return
 	}
+	sequencer := networking.NewSequencer()
 
 	client, err := tee.NewProxiedClient(config.Platform, config.Proxy.Addr)
 	if err != nil {
 		logger.Error("making proxied client", slog.String("error", err.Error()))
 		return
 	}
+	client.Transport = networking.LimitResponseBody(
+		limits.MaxUpstreamResponseBytes,
+		client.Transport,
+	)
+	client.Transport = networking.InstrumentRoundTripper("proxied", client.Transport)
 
 	whitelist := map[string]engine.CELEngineFn{
 		"httpGet": MakeHTTPGet(client),
@@ -115,10 +191,81 @@ func main() {
 		return
 	}
 
+	celHandler := networking.MakeAttestCELHandler(
+		celEngine,
+		DefaultTimeout,
+		attester,
+		sequencer,
+		logger,
+	)
+	if batchConfig.Enabled {
+		batcher := networking.NewBatchAttester(
+			attester,
+			networking.WithBatchWindow(batchConfig.Window),
+			networking.WithBatchMaxSize(batchConfig.MaxSize),
+		)
+		celHandler = networking.MakeBatchedAttestCELHandler(
+			celEngine,
+			DefaultTimeout,
+			batcher,
+			sequencer,
+			logger,
+		)
+	}
+
 	serverMux := http.NewServeMux()
+	if challengeConfig.Enabled {
+		serverMux.Handle(
+			"POST "+networking.ChallengePath,
+			networking.MakeChallengeHandler(challenges, logger),
+		)
+	}
+	if sessionKeyConfig.Enabled {
+		sessionKey, err := networking.NewSessionKey()
+		if err != nil {
+			logger.Error("making session key", slog.String("error", err.Error()))
+			return
+		}
+		logger.Info("generated session key", slog.String("key_id", sessionKey.ID()))
+
+		celHandler = networking.MakeSignedAttestCELHandler(
+			celEngine,
+			DefaultTimeout,
+			sessionKey,
+			sequencer,
+			logger,
+		)
+		serverMux.Handle(
+			"POST "+networking.AttestKeyPath,
+			networking.MakeAttestKeyHandler(attester, sessionKey, logger),
+		)
+	}
+	var hpkeKey *networking.HPKEKey
+	if encryptionConfig.Enabled {
+		hpkeKey, err = networking.NewHPKEKey()
+		if err != nil {
+			logger.Error("making hpke key", slog.String("error", err.Error()))
+			return
+		}
+		logger.Info("generated hpke key", slog.String("key_id", hpkeKey.ID()))
+
+		serverMux.Handle(
+			"POST "+networking.AttestHPKEKeyPath,
+			networking.MakeAttestHPKEKeyHandler(attester, hpkeKey, logger),
+		)
+	}
+	serverMux.Handle("POST "+networking.AttestCELPath, celHandler)
+	serverMux.Handle("GET "+metrics.Path, metrics.Handler())
+	serverMux.Handle("GET "+networking.HealthzPath, networking.MakeHealthzHandler())
+	// Readiness is public, so probes are rate limited like any other request.
 	serverMux.Handle(
-		"POST "+networking.AttestCELPath,
-		networking.MakeAttestCELHandler(celEngine, DefaultTimeout, attester, logger),
+		"GET "+networking.ReadyzPath,
+		networking.MakeReadyzHandler(
+			networking.DefaultReadyTimeout,
+			logger,
+			networking.AttesterCheck(attester),
+			networking.EngineCheck("cel_engine", celEngine),
+		),
 	)
 
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
@@ -127,7 +274,15 @@ func main() {
 		ctx,
 		config.Platform,
 		config.Enclave.Addr,
-		serverMux,
+		networking.AccessLog(
+			logger,
+			networking.LimitRequestBody(
+				limits.MaxRequestBytes,
+				limiter.Wrap(authenticator.Wrap(
+					challenges.Wrap(networking.DecryptRequests(hpkeKey, logger, serverMux)),
+				)),
+			),
+		),
 		logger,
 	)
 	if err != nil {
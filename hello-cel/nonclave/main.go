@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log/slog"
 	"net"
 	"os"
@@ -66,6 +67,46 @@ func main() {
 	}
 	logger.Info("loaded config", slog.Any(configFile, config))
 
+	limits := networking.DefaultLimits()
+	err = config.Nonclave.DecodeArg(networking.LimitsKey, &limits)
+	if err != nil {
+		logger.Error("loading limits", slog.String("error", err.Error()))
+		return
+	}
+
+	credential := networking.Credential{}
+	err = config.Nonclave.DecodeArg(networking.AuthKey, &credential)
+	if err != nil {
+		logger.Error("loading credential", slog.String("error", err.Error()))
+		return
+	}
+
+	encryptionConfig := networking.DefaultEncryptionConfig()
+	err = config.Nonclave.DecodeArg(networking.EncryptionKey, &encryptionConfig)
+	if err != nil {
+		logger.Error("loading encryption config", slog.String("error", err.Error()))
+		return
+	}
+
+	challengeConfig := networking.DefaultChallengeConfig()
+	err = config.Nonclave.DecodeArg(networking.ChallengeKey, &challengeConfig)
+	if err != nil {
+		logger.Error("loading challenge config", slog.String("error", err.Error()))
+		return
+	}
+
+	codecName := "json"
+	err = config.Nonclave.DecodeArg(networking.CodecKey, &codecName)
+	if err != nil {
+		logger.Error("loading codec", slog.String("error", err.Error()))
+		return
+	}
+	codec, err := networking.CodecByName(codecName)
+	if err != nil {
+		logger.Error("loading codec", slog.String("error", err.Error()))
+		return
+	}
+
 	verifier, err := tee.NewVerifier(config.Platform)
 	if err != nil {
 		logger.Error("making verifier", slog.String("error", err.Error()))
@@ -75,43 +116,86 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 
+	// The request ID follows the request through the proxy and into the
+	// Enclave, so the logs of all three can be matched up.
+	requestID := networking.NewRequestID()
+	ctx = networking.WithRequestID(ctx, requestID)
+	logger = logger.With(slog.String("request_id", requestID))
+
 	proxyURL := "http://" + net.JoinHostPort(host, strconv.Itoa(port))
-	client := networking.NewClient(proxyURL)
+	measurement := config.Nonclave.Measurement
+	keyVerifyOptions := []tee.VerifyOption{
+		tee.WithVerifyMeasurement(measurement),
+		tee.WithVerifyDebug(verifyDebug),
+	}
+	clientOptions := []networking.ClientOption{
+		networking.WithClientMaxResponseBytes(limits.MaxResponseBytes),
+		networking.WithClientCredential(credential),
+		networking.WithClientCodec(codec),
+		networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		networking.WithClientSessionKey(verifier, keyVerifyOptions...),
+	}
+	if challengeConfig.Enabled {
+		clientOptions = append(clientOptions, networking.WithClientChallenges())
+	}
+	// With encryption the env and the result only cross the proxy sealed.
+	if encryptionConfig.Enabled {
+		clientOptions = append(
+			clientOptions,
+			networking.WithClientEncryption(verifier, keyVerifyOptions...),
+		)
+	}
+	client := networking.NewClient(proxyURL, clientOptions...)
 
 	env := map[string]any{
 		"targetUrl": "http://httpbin.org/get",
 	}
 	expression := `httpGet(targetUrl).url == targetUrl ? "URL Match Success" : "URL Mismatch"`
-	got, err := client.AttestCEL(ctx, expression, env)
+	nonce, err := client.Nonce(ctx)
 	if err != nil {
-		logger.Error("attesting expr", slog.String("error", err.Error()))
+		logger.Error("making nonce", slog.String("error", err.Error()))
 		return
 	}
-
-	attestation := got.Attestation
-	measurement := config.Nonclave.Measurement
-	verified, err := verifier.Verify(
-		attestation,
-		tee.WithVerifyMeasurement(measurement),
-		tee.WithVerifyDebug(verifyDebug),
-	)
+	got, err := client.AttestCEL(ctx, nonce, expression, env)
 	if err != nil {
-		logger.Error("verifying attestation", slog.String("error", err.Error()))
+		logger.Error("attesting expr", slog.String("error", err.Error()))
 		return
 	}
-	logger.Info("verified attestation")
+
+	// If the Enclave signed the response with its session key, the client has
+	// already verified the signature against the key's attestation.
+	payload := got.Payload
+	if got.Signature != nil {
+		logger.Info("verified session signature", slog.String("key_id", got.Signature.KeyID))
+	} else {
+		payload, err = verifyAttestedPayload(verifier, got, nonce, measurement, verifyDebug)
+		if err != nil {
+			logger.Error("verifying attested payload", slog.String("error", err.Error()))
+			return
+		}
+		logger.Info("verified attestation")
+	}
 
 	attestedCEL := networking.AttestedCEL{}
-	err = json.Unmarshal(verified.UserData, &attestedCEL)
+	err = json.Unmarshal(payload, &attestedCEL)
 	if err != nil {
 		logger.Error("unmarshaling attested cel", slog.String("error", err.Error()))
 		return
 	}
 
+	err = attestedCEL.CheckAge(time.Now(), networking.DefaultMaxStampAge)
+	if err != nil {
+		logger.Error("checking attested cel age", slog.String("error", err.Error()))
+		return
+	}
+
 	logger.Info(
 		"attested cel",
 		slog.String("expression", attestedCEL.Expression),
 		slog.Any("env", attestedCEL.Env),
+		slog.String("instance_id", attestedCEL.InstanceID),
+		slog.Uint64("sequence", attestedCEL.Sequence),
+		slog.Time("timestamp", attestedCEL.Timestamp),
 	)
 
 	resultString, ok := attestedCEL.Output.(string)
@@ -121,3 +205,31 @@ func main() {
 	}
 	logger.Info("expression result:", slog.String("value", resultString))
 }
+
+// verifyAttestedPayload verifies got's attestation and returns its payload. A
+// batched attestation is shared by every request in its batch, so our nonce
+// is bound by the inclusion proof rather than by the attestation.
+func verifyAttestedPayload(
+	verifier *tee.Verifier,
+	got networking.AttestCELResponse,
+	nonce []byte,
+	measurement string,
+	verifyDebug bool,
+) ([]byte, error) {
+	verifyOptions := []tee.VerifyOption{
+		tee.WithVerifyMeasurement(measurement),
+		tee.WithVerifyDebug(verifyDebug),
+	}
+	if got.Proof == nil {
+		verifyOptions = append(verifyOptions, tee.WithVerifyNonce(nonce))
+	}
+	verified, err := verifier.Verify(got.Attestation, verifyOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("verifying attestation: %w", err)
+	}
+
+	if got.Proof != nil {
+		return networking.AttestedBatchPayload(verified, nonce, got.Payload, got.Proof)
+	}
+	return networking.AttestedPayload(verified, got.Payload)
+}
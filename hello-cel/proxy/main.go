@@ -9,6 +9,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/tahardi/bearclave-examples/internal/networking"
 	"github.com/tahardi/bearclave-examples/internal/setup"
 
 	"github.com/tahardi/bearclave/tee"
@@ -38,13 +39,25 @@ func main() {
 
 	revCtx, revCancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer revCancel()
-	revProxy, err := tee.NewReverseProxy(
-		revCtx,
-		config.Platform,
-		config.Proxy.RevAddr,
+	dialContext, err := tee.NewDialContext(config.Platform)
+	if err != nil {
+		logger.Error("making dialer", slog.String("error", err.Error()))
+		return
+	}
+
+	// The reverse proxy goes through AccessLog, so that each request is
+	// logged here under the request ID the Enclave logs it under.
+	revHandler, err := networking.MakeReverseProxyHandler(
 		config.Enclave.Addr,
+		networking.InstrumentDialContext("reverse_proxy", dialContext),
 		logger,
 	)
+	if err != nil {
+		logger.Error("making reverse proxy handler", slog.String("error", err.Error()))
+		return
+	}
+	// Like tee.NewReverseProxy, it always listens on a regular socket.
+	revProxy, err := tee.NewServer(revCtx, tee.NoTEE, config.Proxy.RevAddr, revHandler, logger)
 	if err != nil {
 		logger.Error("making inbound server", slog.String("error", err.Error()))
 		return
@@ -54,7 +67,10 @@ func main() {
 	proxyCtx, proxyCancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer proxyCancel()
 
-	forwardingClient := &http.Client{Timeout: DefaultTimeout}
+	forwardingClient := &http.Client{
+		Timeout:   DefaultTimeout,
+		Transport: networking.InstrumentRoundTripper("proxy", nil),
+	}
 	proxy, err := tee.NewProxy(
 		proxyCtx,
 		config.Platform,
@@ -68,6 +84,41 @@ func main() {
 	}
 	defer proxy.Close()
 
+	if config.Proxy.AdminAddr != "" {
+		adminCtx, adminCancel := context.WithTimeout(context.Background(), DefaultTimeout)
+		defer adminCancel()
+
+		// Readiness requires reaching the enclave through the same dialer
+		// as the reverse proxy.
+		enclaveClient := &http.Client{
+			Timeout:   networking.DefaultReadyTimeout,
+			Transport: &http.Transport{DialContext: dialContext},
+		}
+		adminServer, err := networking.NewAdminServer(
+			adminCtx,
+			config.Proxy.AdminAddr,
+			logger,
+			networking.UpstreamCheck(
+				"enclave",
+				enclaveClient,
+				config.Enclave.Addr+networking.HealthzPath,
+			),
+		)
+		if err != nil {
+			logger.Error("making admin server", slog.String("error", err.Error()))
+			return
+		}
+		defer adminServer.Close()
+
+		go func() {
+			logger.Info("admin server started", slog.String("addr", adminServer.Addr()))
+			err := adminServer.Serve()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("admin server error", slog.String("error", err.Error()))
+			}
+		}()
+	}
+
 	go func() {
 		logger.Info("proxy inbound server started")
 		err := revProxy.Serve()
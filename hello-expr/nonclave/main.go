@@ -66,6 +66,46 @@ func main() {
 	}
 	logger.Info("loaded config", slog.Any(configFile, config))
 
+	limits := networking.DefaultLimits()
+	err = config.Nonclave.DecodeArg(networking.LimitsKey, &limits)
+	if err != nil {
+		logger.Error("loading limits", slog.String("error", err.Error()))
+		return
+	}
+
+	credential := networking.Credential{}
+	err = config.Nonclave.DecodeArg(networking.AuthKey, &credential)
+	if err != nil {
+		logger.Error("loading credential", slog.String("error", err.Error()))
+		return
+	}
+
+	encryptionConfig := networking.DefaultEncryptionConfig()
+	err = config.Nonclave.DecodeArg(networking.EncryptionKey, &encryptionConfig)
+	if err != nil {
+		logger.Error("loading encryption config", slog.String("error", err.Error()))
+		return
+	}
+
+	challengeConfig := networking.DefaultChallengeConfig()
+	err = config.Nonclave.DecodeArg(networking.ChallengeKey, &challengeConfig)
+	if err != nil {
+		logger.Error("loading challenge config", slog.String("error", err.Error()))
+		return
+	}
+
+	codecName := "json"
+	err = config.Nonclave.DecodeArg(networking.CodecKey, &codecName)
+	if err != nil {
+		logger.Error("loading codec", slog.String("error", err.Error()))
+		return
+	}
+	codec, err := networking.CodecByName(codecName)
+	if err != nil {
+		logger.Error("loading codec", slog.String("error", err.Error()))
+		return
+	}
+
 	verifier, err := tee.NewVerifier(config.Platform)
 	if err != nil {
 		logger.Error("making verifier", slog.String("error", err.Error()))
@@ -75,43 +115,97 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 
+	// The request ID follows the request through the proxy and into the
+	// Enclave, so the logs of all three can be matched up.
+	requestID := networking.NewRequestID()
+	ctx = networking.WithRequestID(ctx, requestID)
+	logger = logger.With(slog.String("request_id", requestID))
+
 	proxyURL := "http://" + net.JoinHostPort(host, strconv.Itoa(port))
-	client := networking.NewClient(proxyURL)
+	measurement := config.Nonclave.Measurement
+	keyVerifyOptions := []tee.VerifyOption{
+		tee.WithVerifyMeasurement(measurement),
+		tee.WithVerifyDebug(verifyDebug),
+	}
+	clientOptions := []networking.ClientOption{
+		networking.WithClientMaxResponseBytes(limits.MaxResponseBytes),
+		networking.WithClientCredential(credential),
+		networking.WithClientCodec(codec),
+		networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		networking.WithClientSessionKey(verifier, keyVerifyOptions...),
+	}
+	if challengeConfig.Enabled {
+		clientOptions = append(clientOptions, networking.WithClientChallenges())
+	}
+	// With encryption the env and the result only cross the proxy sealed.
+	if encryptionConfig.Enabled {
+		clientOptions = append(
+			clientOptions,
+			networking.WithClientEncryption(verifier, keyVerifyOptions...),
+		)
+	}
+	client := networking.NewClient(proxyURL, clientOptions...)
 
 	env := map[string]any{
 		"targetUrl": "http://httpbin.org/get",
 	}
 	expression := `httpGet(targetUrl).url == targetUrl ? "URL Match Success" : "URL Mismatch"`
-	got, err := client.AttestExpr(ctx, expression, env)
+	nonce, err := client.Nonce(ctx)
 	if err != nil {
-		logger.Error("attesting expr", slog.String("error", err.Error()))
+		logger.Error("making nonce", slog.String("error", err.Error()))
 		return
 	}
-
-	attestation := got.Attestation
-	measurement := config.Nonclave.Measurement
-	verified, err := verifier.Verify(
-		attestation,
-		tee.WithVerifyMeasurement(measurement),
-		tee.WithVerifyDebug(verifyDebug),
-	)
+	got, err := client.AttestExpr(ctx, nonce, expression, env)
 	if err != nil {
-		logger.Error("verifying attestation", slog.String("error", err.Error()))
+		logger.Error("attesting expr", slog.String("error", err.Error()))
 		return
 	}
-	logger.Info("verified attestation")
+
+	// If the Enclave signed the response with its session key, the client has
+	// already verified the signature against the key's attestation.
+	payload := got.Payload
+	if got.Signature != nil {
+		logger.Info("verified session signature", slog.String("key_id", got.Signature.KeyID))
+	} else {
+		verified, err := verifier.Verify(
+			got.Attestation,
+			tee.WithVerifyMeasurement(measurement),
+			tee.WithVerifyNonce(nonce),
+			tee.WithVerifyDebug(verifyDebug),
+		)
+		if err != nil {
+			logger.Error("verifying attestation", slog.String("error", err.Error()))
+			return
+		}
+		logger.Info("verified attestation")
+
+		payload, err = networking.AttestedPayload(verified, got.Payload)
+		if err != nil {
+			logger.Error("verifying attested payload", slog.String("error", err.Error()))
+			return
+		}
+	}
 
 	attestedExpr := networking.AttestedExpr{}
-	err = json.Unmarshal(verified.UserData, &attestedExpr)
+	err = json.Unmarshal(payload, &attestedExpr)
 	if err != nil {
 		logger.Error("unmarshaling attested expression", slog.String("error", err.Error()))
 		return
 	}
 
+	err = attestedExpr.CheckAge(time.Now(), networking.DefaultMaxStampAge)
+	if err != nil {
+		logger.Error("checking attested expression age", slog.String("error", err.Error()))
+		return
+	}
+
 	logger.Info(
 		"attested expression",
 		slog.String("expression", attestedExpr.Expression),
 		slog.Any("env", attestedExpr.Env),
+		slog.String("instance_id", attestedExpr.InstanceID),
+		slog.Uint64("sequence", attestedExpr.Sequence),
+		slog.Time("timestamp", attestedExpr.Timestamp),
 	)
 
 	resultString, ok := attestedExpr.Output.(string)
@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/tahardi/bearclave-examples/internal/metrics"
 	"github.com/tahardi/bearclave-examples/internal/networking"
 	"github.com/tahardi/bearclave-examples/internal/setup"
 
@@ -36,22 +37,131 @@ func main() {
 	}
 	logger.Info("loaded config", slog.Any(configFile, config))
 
+	limits := networking.DefaultLimits()
+	err = config.Enclave.DecodeArg(networking.LimitsKey, &limits)
+	if err != nil {
+		logger.Error("loading limits", slog.String("error", err.Error()))
+		return
+	}
+
+	rateLimitConfig := networking.DefaultRateLimitConfig()
+	err = config.Enclave.DecodeArg(networking.RateLimitKey, &rateLimitConfig)
+	if err != nil {
+		logger.Error("loading rate limit config", slog.String("error", err.Error()))
+		return
+	}
+	limiter := networking.NewLimiter(rateLimitConfig, logger)
+
+	authConfig := networking.DefaultAuthConfig()
+	err = config.Enclave.DecodeArg(networking.AuthKey, &authConfig)
+	if err != nil {
+		logger.Error("loading auth config", slog.String("error", err.Error()))
+		return
+	}
+
+	authenticator, err := networking.NewAuthenticator(authConfig, logger)
+	if err != nil {
+		logger.Error("making authenticator", slog.String("error", err.Error()))
+		return
+	}
+
+	challengeConfig := networking.DefaultChallengeConfig()
+	err = config.Enclave.DecodeArg(networking.ChallengeKey, &challengeConfig)
+	if err != nil {
+		logger.Error("loading challenge config", slog.String("error", err.Error()))
+		return
+	}
+
+	challenges, err := networking.NewChallengeIssuer(challengeConfig)
+	if err != nil {
+		logger.Error("making challenge issuer", slog.String("error", err.Error()))
+		return
+	}
+
+	sessionKeyConfig := networking.DefaultSessionKeyConfig()
+	err = config.Enclave.DecodeArg(networking.SessionKeyKey, &sessionKeyConfig)
+	if err != nil {
+		logger.Error("loading session key config", slog.String("error", err.Error()))
+		return
+	}
+
 	attester, err := tee.NewAttester(config.Platform)
 	if err != nil {
 		logger.Error("making attester", slog.String("error", err.Error()))
 		return
 	}
+	sequencer := networking.NewSequencer()
 
 	client, err := tee.NewProxiedClient(config.Platform, config.Proxy.Addr)
 	if err != nil {
 		logger.Error("making proxied client", slog.String("error", err.Error()))
 		return
 	}
+	client.Transport = networking.LimitResponseBody(
+		limits.MaxUpstreamResponseBytes,
+		client.Transport,
+	)
+	client.Transport = networking.InstrumentRoundTripper("proxied", client.Transport)
+
+	egressPolicy := networking.DefaultEgressPolicy()
+	err = config.Enclave.DecodeArg(networking.EgressPolicyKey, &egressPolicy)
+	if err != nil {
+		logger.Error("loading egress policy", slog.String("error", err.Error()))
+		return
+	}
+
+	egressGuard, err := networking.NewEgressGuard(egressPolicy)
+	if err != nil {
+		logger.Error("making egress guard", slog.String("error", err.Error()))
+		return
+	}
+	client = egressGuard.Apply(client)
 
+	httpCallHandler := networking.MakeAttestHTTPCallHandler(
+		DefaultTimeout,
+		attester,
+		sequencer,
+		client,
+		logger,
+	)
 	serverMux := http.NewServeMux()
+	if challengeConfig.Enabled {
+		serverMux.Handle(
+			"POST "+networking.ChallengePath,
+			networking.MakeChallengeHandler(challenges, logger),
+		)
+	}
+	if sessionKeyConfig.Enabled {
+		sessionKey, err := networking.NewSessionKey()
+		if err != nil {
+			logger.Error("making session key", slog.String("error", err.Error()))
+			return
+		}
+		logger.Info("generated session key", slog.String("key_id", sessionKey.ID()))
+
+		httpCallHandler = networking.MakeSignedAttestHTTPCallHandler(
+			DefaultTimeout,
+			sessionKey,
+			sequencer,
+			client,
+			logger,
+		)
+		serverMux.Handle(
+			"POST "+networking.AttestKeyPath,
+			networking.MakeAttestKeyHandler(attester, sessionKey, logger),
+		)
+	}
+	serverMux.Handle("POST "+networking.AttestHTTPCallPath, httpCallHandler)
+	serverMux.Handle("GET "+metrics.Path, metrics.Handler())
+	serverMux.Handle("GET "+networking.HealthzPath, networking.MakeHealthzHandler())
+	// Readiness is public, so probes are rate limited like any other request.
 	serverMux.Handle(
-		"POST "+networking.AttestHTTPCallPath,
-		networking.MakeAttestHTTPCallHandler(DefaultTimeout, attester, client, logger),
+		"GET "+networking.ReadyzPath,
+		networking.MakeReadyzHandler(
+			networking.DefaultReadyTimeout,
+			logger,
+			networking.AttesterCheck(attester),
+		),
 	)
 
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
@@ -60,7 +170,13 @@ func main() {
 		ctx,
 		config.Platform,
 		config.Enclave.Addr,
-		serverMux,
+		networking.AccessLog(
+			logger,
+			networking.LimitRequestBody(
+				limits.MaxRequestBytes,
+				limiter.Wrap(authenticator.Wrap(challenges.Wrap(serverMux))),
+			),
+		),
 		logger,
 	)
 	if err != nil {
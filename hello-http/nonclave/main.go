@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
 	"net"
 	"os"
@@ -23,6 +25,11 @@ const (
 	DefaultTimeout     = 15 * time.Second
 	TargetMethod       = "GET"
 	TargetURL          = "http://httpbin.org/get"
+	PostMethod         = "POST"
+	PostURL            = "http://httpbin.org/post"
+	PostBody           = `{"greeting":"Hello, world!"}`
+	PostHeader         = "X-Bearclave-Example"
+	PostHeaderValue    = "hello-http"
 )
 
 var (
@@ -39,6 +46,12 @@ type HTTPBinGetResponse struct {
 	URL     string            `json:"url"`
 }
 
+type HTTPBinPostResponse struct {
+	Headers map[string]string `json:"headers"`
+	JSON    map[string]any    `json:"json"`
+	URL     string            `json:"url"`
+}
+
 func main() {
 	flag.StringVar(
 		&configFile,
@@ -75,6 +88,39 @@ func main() {
 	}
 	logger.Info("loaded config", slog.Any(configFile, config))
 
+	limits := networking.DefaultLimits()
+	err = config.Nonclave.DecodeArg(networking.LimitsKey, &limits)
+	if err != nil {
+		logger.Error("loading limits", slog.String("error", err.Error()))
+		return
+	}
+
+	credential := networking.Credential{}
+	err = config.Nonclave.DecodeArg(networking.AuthKey, &credential)
+	if err != nil {
+		logger.Error("loading credential", slog.String("error", err.Error()))
+		return
+	}
+
+	challengeConfig := networking.DefaultChallengeConfig()
+	err = config.Nonclave.DecodeArg(networking.ChallengeKey, &challengeConfig)
+	if err != nil {
+		logger.Error("loading challenge config", slog.String("error", err.Error()))
+		return
+	}
+
+	codecName := "json"
+	err = config.Nonclave.DecodeArg(networking.CodecKey, &codecName)
+	if err != nil {
+		logger.Error("loading codec", slog.String("error", err.Error()))
+		return
+	}
+	codec, err := networking.CodecByName(codecName)
+	if err != nil {
+		logger.Error("loading codec", slog.String("error", err.Error()))
+		return
+	}
+
 	verifier, err := tee.NewVerifier(config.Platform)
 	if err != nil {
 		logger.Error("making verifier", slog.String("error", err.Error()))
@@ -84,29 +130,49 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 
-	proxyURL := "http://" + net.JoinHostPort(host, strconv.Itoa(port))
-	client := networking.NewClient(proxyURL)
-	got, err := client.AttestHTTPCall(ctx, TargetMethod, TargetURL)
-	if err != nil {
-		logger.Error("attesting http call", slog.String("error", err.Error()))
-		return
-	}
+	// The request ID follows the request through the proxy and into the
+	// Enclave, so the logs of all three can be matched up.
+	requestID := networking.NewRequestID()
+	ctx = networking.WithRequestID(ctx, requestID)
+	logger = logger.With(slog.String("request_id", requestID))
 
-	attestation := got.Attestation
+	proxyURL := "http://" + net.JoinHostPort(host, strconv.Itoa(port))
 	measurement := config.Nonclave.Measurement
-	verified, err := verifier.Verify(
-		attestation,
-		tee.WithVerifyMeasurement(measurement),
-		tee.WithVerifyDebug(verifyDebug),
+	clientOptions := []networking.ClientOption{
+		networking.WithClientMaxResponseBytes(limits.MaxResponseBytes),
+		networking.WithClientCredential(credential),
+		networking.WithClientCodec(codec),
+		networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		networking.WithClientSessionKey(
+			verifier,
+			tee.WithVerifyMeasurement(measurement),
+			tee.WithVerifyDebug(verifyDebug),
+		),
+	}
+	if challengeConfig.Enabled {
+		clientOptions = append(clientOptions, networking.WithClientChallenges())
+	}
+	client := networking.NewClient(proxyURL, clientOptions...)
+	tracker := networking.NewSequenceTracker()
+	attestedCall, err := attestHTTPCall(
+		ctx,
+		logger,
+		client,
+		tracker,
+		verifier,
+		measurement,
+		TargetMethod,
+		TargetURL,
+		nil,
+		nil,
 	)
 	if err != nil {
-		logger.Error("verifying attestation", slog.String("error", err.Error()))
+		logger.Error("attesting http call", slog.String("error", err.Error()))
 		return
 	}
-	logger.Info("verified attestation")
 
 	httpBinResp := HTTPBinGetResponse{}
-	err = json.Unmarshal(verified.UserData, &httpBinResp)
+	err = json.Unmarshal(attestedCall.Body, &httpBinResp)
 	if err != nil {
 		logger.Error("unmarshaling httpbin response", slog.String("error", err.Error()))
 		return
@@ -117,4 +183,133 @@ func main() {
 		slog.String("url", httpBinResp.URL),
 		slog.Any("response", httpBinResp),
 	)
+
+	// A POST carries a body and headers, which the Enclave's egress policy must
+	// allow. The attestation commits to the body's digest, but not to the
+	// headers, since they often carry credentials.
+	attestedPost, err := attestHTTPCall(
+		ctx,
+		logger,
+		client,
+		tracker,
+		verifier,
+		measurement,
+		PostMethod,
+		PostURL,
+		[]byte(PostBody),
+		map[string]string{PostHeader: PostHeaderValue},
+	)
+	if err != nil {
+		logger.Error("attesting http post", slog.String("error", err.Error()))
+		return
+	}
+
+	httpBinPostResp := HTTPBinPostResponse{}
+	err = json.Unmarshal(attestedPost.Body, &httpBinPostResp)
+	if err != nil {
+		logger.Error("unmarshaling httpbin post response", slog.String("error", err.Error()))
+		return
+	}
+
+	logger.Info(
+		"verified http post response",
+		slog.String("url", httpBinPostResp.URL),
+		slog.Any("json", httpBinPostResp.JSON),
+		slog.String("header", httpBinPostResp.Headers[PostHeader]),
+	)
+}
+
+// attestHTTPCall has the Enclave make an HTTP call with body and headers, if
+// any, and verifies the result, whether it was attested or signed with the
+// session key. tracker checks that each result is newer than the last.
+func attestHTTPCall(
+	ctx context.Context,
+	logger *slog.Logger,
+	client *networking.Client,
+	tracker *networking.SequenceTracker,
+	verifier *tee.Verifier,
+	measurement string,
+	method string,
+	targetURL string,
+	body []byte,
+	headers map[string]string,
+) (networking.AttestedHTTPCall, error) {
+	var options []networking.HTTPCallOption
+	if body != nil {
+		options = append(options, networking.WithHTTPCallBody(body, networking.ContentTypeJSON))
+	}
+	if headers != nil {
+		options = append(options, networking.WithHTTPCallHeaders(headers))
+	}
+
+	nonce, err := client.Nonce(ctx)
+	if err != nil {
+		return networking.AttestedHTTPCall{}, fmt.Errorf("making nonce: %w", err)
+	}
+	got, err := client.AttestHTTPCall(ctx, nonce, method, targetURL, options...)
+	if err != nil {
+		return networking.AttestedHTTPCall{}, fmt.Errorf("attesting http call: %w", err)
+	}
+
+	// If the Enclave signed the response with its session key, the client has
+	// already verified the signature against the key's attestation.
+	payload := got.Payload
+	if got.Signature != nil {
+		logger.Info("verified session signature", slog.String("key_id", got.Signature.KeyID))
+	} else {
+		verified, err := verifier.Verify(
+			got.Attestation,
+			tee.WithVerifyMeasurement(measurement),
+			tee.WithVerifyNonce(nonce),
+			tee.WithVerifyDebug(verifyDebug),
+		)
+		if err != nil {
+			return networking.AttestedHTTPCall{}, fmt.Errorf("verifying attestation: %w", err)
+		}
+		logger.Info("verified attestation")
+
+		payload, err = networking.AttestedPayload(verified, got.Payload)
+		if err != nil {
+			return networking.AttestedHTTPCall{}, fmt.Errorf("verifying attested payload: %w", err)
+		}
+	}
+
+	attestedCall := networking.AttestedHTTPCall{}
+	err = json.Unmarshal(payload, &attestedCall)
+	if err != nil {
+		return networking.AttestedHTTPCall{}, fmt.Errorf("unmarshaling attested http call: %w", err)
+	}
+	err = attestedCall.Matches(method, targetURL)
+	if err != nil {
+		return networking.AttestedHTTPCall{}, fmt.Errorf("checking attested http call: %w", err)
+	}
+	err = attestedCall.MatchesBody(body)
+	if err != nil {
+		return networking.AttestedHTTPCall{}, fmt.Errorf("checking attested http call: %w", err)
+	}
+	err = attestedCall.CheckAge(time.Now(), networking.DefaultMaxStampAge)
+	if err != nil {
+		return networking.AttestedHTTPCall{}, fmt.Errorf("checking attested http call age: %w", err)
+	}
+
+	// Calls from other clients take sequence numbers too, so a gap is expected,
+	// but a result that is not newer than the last one was replayed.
+	err = tracker.Observe(attestedCall.Stamp)
+	switch {
+	case errors.Is(err, networking.ErrStampGap):
+		logger.Warn("missed results", slog.String("error", err.Error()))
+	case err != nil:
+		return networking.AttestedHTTPCall{}, fmt.Errorf("checking attested http call order: %w", err)
+	}
+	logger.Info(
+		"attested http call",
+		slog.String("method", attestedCall.Method),
+		slog.String("url", attestedCall.URL),
+		slog.Int("status", attestedCall.StatusCode),
+		slog.Time("fetched_at", attestedCall.FetchedAt),
+		slog.String("instance_id", attestedCall.InstanceID),
+		slog.Uint64("sequence", attestedCall.Sequence),
+		slog.Time("timestamp", attestedCall.Timestamp),
+	)
+	return attestedCall, nil
 }
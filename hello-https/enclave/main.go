@@ -8,6 +8,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/tahardi/bearclave-examples/internal/metrics"
 	"github.com/tahardi/bearclave-examples/internal/networking"
 	"github.com/tahardi/bearclave-examples/internal/setup"
 
@@ -15,8 +16,9 @@ import (
 )
 
 const (
-	DefaultTimeout = 15 * time.Second
-	DomainKey      = "domain"
+	DefaultTimeout     = 15 * time.Second
+	DefaultACMETimeout = 2 * time.Minute
+	DomainKey          = "domain"
 )
 
 var (
@@ -41,24 +43,167 @@ func main() {
 	}
 	logger.Info("loaded config", slog.Any(configFile, config))
 
+	limits := networking.DefaultLimits()
+	err = config.Enclave.DecodeArg(networking.LimitsKey, &limits)
+	if err != nil {
+		logger.Error("loading limits", slog.String("error", err.Error()))
+		return
+	}
+
+	rateLimitConfig := networking.DefaultRateLimitConfig()
+	err = config.Enclave.DecodeArg(networking.RateLimitKey, &rateLimitConfig)
+	if err != nil {
+		logger.Error("loading rate limit config", slog.String("error", err.Error()))
+		return
+	}
+	limiter := networking.NewLimiter(rateLimitConfig, logger)
+
+	// TLS is passed through the proxy untouched, so X-Forwarded-For on the TLS
+	// server comes from the client and cannot be trusted.
+	tlsRateLimitConfig := rateLimitConfig
+	tlsRateLimitConfig.TrustForwardedFor = false
+	limiterTLS := networking.NewLimiter(tlsRateLimitConfig, logger)
+
+	authConfig := networking.DefaultAuthConfig()
+	err = config.Enclave.DecodeArg(networking.AuthKey, &authConfig)
+	if err != nil {
+		logger.Error("loading auth config", slog.String("error", err.Error()))
+		return
+	}
+
+	authenticator, err := networking.NewAuthenticator(authConfig, logger)
+	if err != nil {
+		logger.Error("making authenticator", slog.String("error", err.Error()))
+		return
+	}
+
+	challengeConfig := networking.DefaultChallengeConfig()
+	err = config.Enclave.DecodeArg(networking.ChallengeKey, &challengeConfig)
+	if err != nil {
+		logger.Error("loading challenge config", slog.String("error", err.Error()))
+		return
+	}
+
+	challenges, err := networking.NewChallengeIssuer(challengeConfig)
+	if err != nil {
+		logger.Error("making challenge issuer", slog.String("error", err.Error()))
+		return
+	}
+
+	certCacheConfig := networking.DefaultCertCacheConfig()
+	err = config.Enclave.DecodeArg(networking.CertCacheKey, &certCacheConfig)
+	if err != nil {
+		logger.Error("loading cert cache config", slog.String("error", err.Error()))
+		return
+	}
+
+	acmeConfig := networking.DefaultACMEConfig()
+	err = config.Enclave.DecodeArg(networking.ACMEKey, &acmeConfig)
+	if err != nil {
+		logger.Error("loading acme config", slog.String("error", err.Error()))
+		return
+	}
+
+	sessionKeyConfig := networking.DefaultSessionKeyConfig()
+	err = config.Enclave.DecodeArg(networking.SessionKeyKey, &sessionKeyConfig)
+	if err != nil {
+		logger.Error("loading session key config", slog.String("error", err.Error()))
+		return
+	}
+
+	rotationConfig := networking.DefaultCertRotationConfig()
+	err = config.Enclave.DecodeArg(networking.CertRotationKey, &rotationConfig)
+	if err != nil {
+		logger.Error("loading cert rotation config", slog.String("error", err.Error()))
+		return
+	}
+
 	attester, err := tee.NewAttester(config.Platform)
 	if err != nil {
 		logger.Error("making attester", slog.String("error", err.Error()))
 		return
 	}
 	defer attester.Close()
+	sequencer := networking.NewSequencer()
 
 	domain, _ := config.Enclave.GetArg(DomainKey, tee.DefaultDomain).(string)
-	certProvider, err := tee.NewSelfSignedCertProvider(domain, tee.DefaultIP, tee.DefaultValidity)
-	if err != nil {
-		logger.Error("making certProvider", slog.String("error", err.Error()))
-		return
+	var certProvider tee.CertProvider
+	var acmeProvider *networking.ACMECertProvider
+	if acmeConfig.Enabled {
+		// The CA is reached through the proxy, like any other egress, but is
+		// not subject to the egress policy of the attested HTTPS calls.
+		acmeClient, err := tee.NewProxiedClient(config.Platform, config.Proxy.AddrTLS)
+		if err != nil {
+			logger.Error("making acme client", slog.String("error", err.Error()))
+			return
+		}
+		acmeClient.Transport = networking.LimitResponseBody(
+			limits.MaxUpstreamResponseBytes,
+			acmeClient.Transport,
+		)
+		acmeProvider, err = networking.NewACMECertProvider(
+			acmeConfig,
+			domain,
+			logger,
+			networking.WithACMEHTTPClient(acmeClient),
+		)
+		if err != nil {
+			logger.Error("making certProvider", slog.String("error", err.Error()))
+			return
+		}
+		certProvider = acmeProvider
+	} else {
+		validity := tee.DefaultValidity
+		if rotationConfig.Enabled {
+			validity = rotationConfig.Validity
+		}
+		certProvider, err = tee.NewSelfSignedCertProvider(
+			domain,
+			tee.DefaultIP,
+			validity,
+		)
+		if err != nil {
+			logger.Error("making certProvider", slog.String("error", err.Error()))
+			return
+		}
+	}
+
+	// A non-positive TTL disables caching, so every request is attested.
+	var certCache *networking.CertAttestationCache
+	if certCacheConfig.TTL > 0 {
+		certCache = networking.NewCertAttestationCache(certCacheConfig.TTL)
 	}
 
 	serverMux := http.NewServeMux()
-	serverMux.HandleFunc(
+	if challengeConfig.Enabled {
+		serverMux.Handle(
+			"POST "+networking.ChallengePath,
+			networking.MakeChallengeHandler(challenges, logger),
+		)
+	}
+	serverMux.Handle(
 		networking.AttestCertPath,
-		networking.MakeAttestCertHandler(attester, certProvider, logger),
+		networking.MakeAttestCertHandler(attester, certProvider, certCache, logger),
+	)
+	if acmeProvider != nil {
+		// The CA fetches HTTP-01 challenges from port 80 of the domain, which
+		// the reverse proxy forwards here.
+		serverMux.Handle(
+			"GET "+networking.ACMEChallengePath,
+			networking.MakeACMEChallengeHandler(acmeProvider, logger),
+		)
+	}
+	serverMux.Handle("GET "+metrics.Path, metrics.Handler())
+	serverMux.Handle("GET "+networking.HealthzPath, networking.MakeHealthzHandler())
+	// Readiness is public, so probes are rate limited like any other request.
+	serverMux.Handle(
+		"GET "+networking.ReadyzPath,
+		networking.MakeReadyzHandler(
+			networking.DefaultReadyTimeout,
+			logger,
+			networking.AttesterCheck(attester),
+			networking.CertProviderCheck(certProvider),
+		),
 	)
 
 	serverCtx, serverCancel := context.WithTimeout(context.Background(), DefaultTimeout)
@@ -67,7 +212,13 @@ func main() {
 		serverCtx,
 		config.Platform,
 		config.Enclave.Addr,
-		serverMux,
+		networking.AccessLog(
+			logger,
+			networking.LimitRequestBody(
+				limits.MaxRequestBytes,
+				limiter.Wrap(authenticator.Wrap(challenges.Wrap(serverMux))),
+			),
+		),
 		logger,
 	)
 	if err != nil {
@@ -81,12 +232,68 @@ func main() {
 		logger.Error("making proxied client", slog.String("error", err.Error()))
 		return
 	}
+	proxiedClient.Transport = networking.LimitResponseBody(
+		limits.MaxUpstreamResponseBytes,
+		proxiedClient.Transport,
+	)
+	proxiedClient.Transport = networking.InstrumentRoundTripper("proxied", proxiedClient.Transport)
+
+	egressPolicy := networking.DefaultEgressPolicy()
+	err = config.Enclave.DecodeArg(networking.EgressPolicyKey, &egressPolicy)
+	if err != nil {
+		logger.Error("loading egress policy", slog.String("error", err.Error()))
+		return
+	}
+
+	egressGuard, err := networking.NewEgressGuard(egressPolicy)
+	if err != nil {
+		logger.Error("making egress guard", slog.String("error", err.Error()))
+		return
+	}
+	proxiedClient = egressGuard.Apply(proxiedClient)
 
 	serverTLSMux := http.NewServeMux()
-	serverTLSMux.HandleFunc(
-		networking.AttestHTTPSCallPath,
-		networking.MakeAttestHTTPSCallHandler(DefaultTimeout, attester, proxiedClient, logger),
+	// TLS is passed through the reverse proxy untouched, so there is no
+	// X-Forwarded-For and unauthenticated requests are all keyed by the proxy's
+	// address.
+	if challengeConfig.Enabled {
+		serverTLSMux.Handle(
+			"POST "+networking.ChallengePath,
+			networking.MakeChallengeHandler(challenges, logger),
+		)
+	}
+	serverTLSMux.Handle(
+		networking.AttestChannelPath,
+		networking.MakeAttestChannelHandler(attester, logger),
 	)
+	httpsCallHandler := networking.MakeAttestHTTPSCallHandler(
+		DefaultTimeout,
+		attester,
+		sequencer,
+		proxiedClient,
+		logger,
+	)
+	if sessionKeyConfig.Enabled {
+		sessionKey, err := networking.NewSessionKey()
+		if err != nil {
+			logger.Error("making session key", slog.String("error", err.Error()))
+			return
+		}
+		logger.Info("generated session key", slog.String("key_id", sessionKey.ID()))
+
+		httpsCallHandler = networking.MakeSignedAttestHTTPSCallHandler(
+			DefaultTimeout,
+			sessionKey,
+			sequencer,
+			proxiedClient,
+			logger,
+		)
+		serverTLSMux.Handle(
+			"POST "+networking.AttestKeyPath,
+			networking.MakeAttestKeyHandler(attester, sessionKey, logger),
+		)
+	}
+	serverTLSMux.Handle(networking.AttestHTTPSCallPath, httpsCallHandler)
 
 	serverTLSCtx, serverTLSCancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer serverTLSCancel()
@@ -94,7 +301,13 @@ func main() {
 		serverTLSCtx,
 		config.Platform,
 		config.Enclave.AddrTLS,
-		serverTLSMux,
+		networking.AccessLog(
+			logger,
+			networking.LimitRequestBody(
+				limits.MaxRequestBytes,
+				limiterTLS.Wrap(authenticator.Wrap(challenges.Wrap(serverTLSMux))),
+			),
+		),
 		certProvider,
 		logger,
 	)
@@ -112,6 +325,34 @@ func main() {
 		}
 	}()
 
+	// The first certificate needs the server above to answer its challenge.
+	if acmeProvider != nil {
+		acmeCtx, acmeCancel := context.WithTimeout(context.Background(), DefaultACMETimeout)
+		defer acmeCancel()
+		_, err = acmeProvider.GetCert(acmeCtx)
+		if err != nil {
+			logger.Error("obtaining acme cert", slog.String("error", err.Error()))
+			return
+		}
+	}
+
+	// ACME certificates are renewed in the background, so that handshakes never
+	// wait on the CA. They keep the CA's validity and are renewed renew_before,
+	// or a third of their lifetime if that is shorter, ahead of expiring.
+	if acmeProvider != nil {
+		rotationConfig.Enabled = true
+		rotationConfig.Validity = 0
+		rotationConfig.RotateBefore = acmeConfig.RenewBefore
+	}
+	if rotationConfig.Enabled {
+		rotator, err := networking.NewCertRotator(certProvider, rotationConfig, logger)
+		if err != nil {
+			logger.Error("making cert rotator", slog.String("error", err.Error()))
+			return
+		}
+		go rotator.Run(context.Background())
+	}
+
 	logger.Info("enclave serverTLS started", slog.String("addr", serverTLS.Addr()))
 	err = serverTLS.Serve()
 	if err != nil {
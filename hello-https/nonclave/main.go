@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log/slog"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"time"
 
@@ -84,64 +86,178 @@ func main() {
 	}
 	logger.Info("loaded config", slog.Any(configFile, config))
 
-	verifier, err := tee.NewVerifier(config.Platform)
+	limits := networking.DefaultLimits()
+	err = config.Nonclave.DecodeArg(networking.LimitsKey, &limits)
 	if err != nil {
-		logger.Error("making verifier", slog.String("error", err.Error()))
+		logger.Error("loading limits", slog.String("error", err.Error()))
 		return
 	}
 
-	proxyURL := "http://" + net.JoinHostPort(host, strconv.Itoa(port))
-	client := networking.NewClient(proxyURL)
+	credential := networking.Credential{}
+	err = config.Nonclave.DecodeArg(networking.AuthKey, &credential)
+	if err != nil {
+		logger.Error("loading credential", slog.String("error", err.Error()))
+		return
+	}
 
-	certCtx, certCancel := context.WithTimeout(context.Background(), DefaultTimeout)
-	defer certCancel()
-	attestedCert, err := client.AttestCertChain(certCtx)
+	challengeConfig := networking.DefaultChallengeConfig()
+	err = config.Nonclave.DecodeArg(networking.ChallengeKey, &challengeConfig)
 	if err != nil {
-		logger.Error("attesting cert", slog.String("error", err.Error()))
+		logger.Error("loading challenge config", slog.String("error", err.Error()))
 		return
 	}
 
-	verifiedCert, err := verifier.Verify(
-		attestedCert.Attestation,
-		tee.WithVerifyMeasurement(config.Nonclave.Measurement),
-		tee.WithVerifyDebug(verifyDebug),
-	)
+	codecName := "json"
+	err = config.Nonclave.DecodeArg(networking.CodecKey, &codecName)
+	if err != nil {
+		logger.Error("loading codec", slog.String("error", err.Error()))
+		return
+	}
+	codec, err := networking.CodecByName(codecName)
 	if err != nil {
-		logger.Error("verifying cert attestation", slog.String("error", err.Error()))
+		logger.Error("loading codec", slog.String("error", err.Error()))
 		return
 	}
-	logger.Info("verified cert attestation")
 
-	proxyTLSURL := "https://" + net.JoinHostPort(host, strconv.Itoa(portTLS))
-	clientTLS := networking.NewClient(proxyTLSURL)
-	domain, _ := config.Nonclave.GetArg(DomainKey, tee.DefaultDomain).(string)
-	err = clientTLS.AddCertChain(verifiedCert.UserData, domain)
+	channelBindingConfig := networking.DefaultChannelBindingConfig()
+	err = config.Nonclave.DecodeArg(networking.ChannelBindingKey, &channelBindingConfig)
 	if err != nil {
-		logger.Error("adding cert", slog.String("error", err.Error()))
+		logger.Error("loading channel binding config", slog.String("error", err.Error()))
 		return
 	}
 
+	verifier, err := tee.NewVerifier(config.Platform)
+	if err != nil {
+		logger.Error("making verifier", slog.String("error", err.Error()))
+		return
+	}
+
+	proxyURL := "http://" + net.JoinHostPort(host, strconv.Itoa(port))
+	clientOptions := []networking.ClientOption{
+		networking.WithClientMaxResponseBytes(limits.MaxResponseBytes),
+		networking.WithClientCredential(credential),
+		networking.WithClientCodec(codec),
+		networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		networking.WithClientSessionKey(
+			verifier,
+			tee.WithVerifyMeasurement(config.Nonclave.Measurement),
+			tee.WithVerifyDebug(verifyDebug),
+		),
+	}
+	if challengeConfig.Enabled {
+		clientOptions = append(clientOptions, networking.WithClientChallenges())
+	}
+	domain, _ := config.Nonclave.GetArg(DomainKey, tee.DefaultDomain).(string)
+	proxyTLSURL := "https://" + net.JoinHostPort(host, strconv.Itoa(portTLS))
+	var clientTLS *networking.Client
+	if channelBindingConfig.Enabled {
+		// The Enclave attests the TLS connection itself, so there is no cert
+		// chain to fetch first.
+		clientTLS = networking.NewClient(
+			proxyTLSURL,
+			append(
+				clientOptions,
+				networking.WithClientChannelBinding(
+					verifier,
+					tee.WithVerifyMeasurement(config.Nonclave.Measurement),
+					tee.WithVerifyDebug(verifyDebug),
+				),
+			)...,
+		)
+	} else {
+		clientTLS, err = newCertChainClient(
+			verifier,
+			proxyURL,
+			proxyTLSURL,
+			clientOptions,
+			config.Nonclave.Measurement,
+			domain,
+		)
+		if err != nil {
+			logger.Error("attesting cert chain", slog.String("error", err.Error()))
+			return
+		}
+		logger.Info("verified cert attestation")
+	}
+
 	logger.Info("attesting https call", slog.String("revProxyTLS", proxyTLSURL))
 	httpsCtx, httpsCancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer httpsCancel()
-	attestedCall, err := clientTLS.AttestHTTPSCall(httpsCtx, TargetMethod, TargetURL)
+	httpsRequestID := networking.NewRequestID()
+	httpsCtx = networking.WithRequestID(httpsCtx, httpsRequestID)
+	callNonce, err := clientTLS.Nonce(httpsCtx)
 	if err != nil {
-		logger.Error("attesting https call", slog.String("error", err.Error()))
+		logger.Error("making call nonce", slog.String("error", err.Error()))
+		return
+	}
+	attestedCall, err := clientTLS.AttestHTTPSCall(httpsCtx, callNonce, TargetMethod, TargetURL)
+	if err != nil {
+		logger.Error(
+			"attesting https call",
+			slog.String("request_id", httpsRequestID),
+			slog.String("error", err.Error()),
+		)
 		return
 	}
 
-	verifiedCall, err := verifier.Verify(
-		attestedCall.Attestation,
-		tee.WithVerifyMeasurement(config.Nonclave.Measurement),
-		tee.WithVerifyDebug(verifyDebug),
-	)
+	// If the Enclave signed the response with its session key, the client has
+	// already verified the signature against the key's attestation.
+	callPayload := attestedCall.Payload
+	if attestedCall.Signature != nil {
+		logger.Info(
+			"verified session signature",
+			slog.String("key_id", attestedCall.Signature.KeyID),
+		)
+	} else {
+		verifiedCall, err := verifier.Verify(
+			attestedCall.Attestation,
+			tee.WithVerifyMeasurement(config.Nonclave.Measurement),
+			tee.WithVerifyNonce(callNonce),
+			tee.WithVerifyDebug(verifyDebug),
+		)
+		if err != nil {
+			logger.Error("verifying call attestation", slog.String("error", err.Error()))
+			return
+		}
+
+		callPayload, err = networking.AttestedPayload(verifiedCall, attestedCall.Payload)
+		if err != nil {
+			logger.Error("verifying attested call", slog.String("error", err.Error()))
+			return
+		}
+	}
+
+	httpsCall := networking.AttestedHTTPCall{}
+	err = json.Unmarshal(callPayload, &httpsCall)
+	if err != nil {
+		logger.Error("unmarshaling attested https call", slog.String("error", err.Error()))
+		return
+	}
+
+	err = httpsCall.Matches(TargetMethod, TargetURL)
+	if err != nil {
+		logger.Error("checking attested https call", slog.String("error", err.Error()))
+		return
+	}
+
+	err = httpsCall.CheckAge(time.Now(), networking.DefaultMaxStampAge)
 	if err != nil {
-		logger.Error("verifying call attestation", slog.String("error", err.Error()))
+		logger.Error("checking attested https call age", slog.String("error", err.Error()))
 		return
 	}
+	logger.Info(
+		"attested https call",
+		slog.String("method", httpsCall.Method),
+		slog.String("url", httpsCall.URL),
+		slog.Int("status", httpsCall.StatusCode),
+		slog.Time("fetched_at", httpsCall.FetchedAt),
+		slog.String("instance_id", httpsCall.InstanceID),
+		slog.Uint64("sequence", httpsCall.Sequence),
+		slog.Time("timestamp", httpsCall.Timestamp),
+	)
 
 	httpBinResp := HTTPBinGetResponse{}
-	err = json.Unmarshal(verifiedCall.UserData, &httpBinResp)
+	err = json.Unmarshal(httpsCall.Body, &httpBinResp)
 	if err != nil {
 		logger.Error("unmarshaling httpbin response", slog.String("error", err.Error()))
 		return
@@ -153,3 +269,45 @@ func main() {
 		slog.Any("response", httpBinResp),
 	)
 }
+
+// newCertChainClient returns a client for the Enclave's TLS server that pins
+// the leaf key of the cert chain attested at AttestCertPath, which is fetched
+// over the plain HTTP proxy, and fetches it again when the Enclave presents
+// another key. Fetches leave the nonce out, so that they are served from the
+// Enclave's CertAttestationCache.
+func newCertChainClient(
+	verifier *tee.Verifier,
+	proxyURL string,
+	proxyTLSURL string,
+	clientOptions []networking.ClientOption,
+	measurement string,
+	domain string,
+) (*networking.Client, error) {
+	client := networking.NewClient(proxyURL, clientOptions...)
+	certRefresh := networking.WithClientCertRefresh(
+		client,
+		domain,
+		verifier,
+		tee.WithVerifyMeasurement(measurement),
+		tee.WithVerifyDebug(verifyDebug),
+	)
+	clientTLS := networking.NewClient(
+		proxyTLSURL,
+		append(
+			slices.Clone(clientOptions),
+			networking.WithClientCertPins(networking.NewCertPins()),
+			networking.WithClientCachedCertChain(),
+			certRefresh,
+		)...,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	requestID := networking.NewRequestID()
+	ctx = networking.WithRequestID(ctx, requestID)
+	err := clientTLS.RefreshCertChain(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("attesting cert (request_id %s): %w", requestID, err)
+	}
+	return clientTLS, nil
+}
@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"log/slog"
+	"net/http"
 	"os"
 	"time"
 
@@ -42,6 +42,81 @@ func main() {
 		return
 	}
 
+	encryptionConfig := networking.DefaultEncryptionConfig()
+	err = config.Enclave.DecodeArg(networking.EncryptionKey, &encryptionConfig)
+	if err != nil {
+		logger.Error("loading encryption config", slog.String("error", err.Error()))
+		return
+	}
+
+	challengeConfig := networking.DefaultChallengeConfig()
+	err = config.Enclave.DecodeArg(networking.ChallengeKey, &challengeConfig)
+	if err != nil {
+		logger.Error("loading challenge config", slog.String("error", err.Error()))
+		return
+	}
+
+	challenges, err := networking.NewChallengeIssuer(challengeConfig)
+	if err != nil {
+		logger.Error("making challenge issuer", slog.String("error", err.Error()))
+		return
+	}
+
+	authConfig := networking.DefaultAuthConfig()
+	err = config.Enclave.DecodeArg(networking.AuthKey, &authConfig)
+	if err != nil {
+		logger.Error("loading auth config", slog.String("error", err.Error()))
+		return
+	}
+
+	authenticator, err := networking.NewAuthenticator(authConfig, logger)
+	if err != nil {
+		logger.Error("making authenticator", slog.String("error", err.Error()))
+		return
+	}
+
+	// The Enclave serves the proxy's socket requests with the same handlers it
+	// would use over HTTP.
+	mux := http.NewServeMux()
+	mux.Handle(
+		"POST "+networking.AttestUserDataPath,
+		networking.MakeAttestUserDataHandler(attester, logger),
+	)
+
+	if challengeConfig.Enabled {
+		mux.Handle("POST "+networking.ChallengePath, networking.MakeChallengeHandler(challenges, logger))
+	}
+	mux.Handle("GET "+networking.HealthzPath, networking.MakeHealthzHandler())
+	mux.Handle(
+		"GET "+networking.ReadyzPath,
+		networking.MakeReadyzHandler(
+			networking.DefaultReadyTimeout,
+			logger,
+			networking.AttesterCheck(attester),
+		),
+	)
+
+	var hpkeKey *networking.HPKEKey
+	if encryptionConfig.Enabled {
+		hpkeKey, err = networking.NewHPKEKey()
+		if err != nil {
+			logger.Error("making hpke key", slog.String("error", err.Error()))
+			return
+		}
+		logger.Info("generated hpke key", slog.String("key_id", hpkeKey.ID()))
+		mux.Handle(
+			"POST "+networking.AttestHPKEKeyPath,
+			networking.MakeAttestHPKEKeyHandler(attester, hpkeKey, logger),
+		)
+	}
+	// Requests are authenticated before they are decrypted, since clients sign
+	// the body they send. The proxy forwards the auth headers over the socket
+	// but cannot forge them.
+	handler := networking.AccessLog(
+		logger,
+		authenticator.Wrap(challenges.Wrap(networking.DecryptRequests(hpkeKey, logger, mux))),
+	)
+
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 	socket, err := tee.NewSocket(
@@ -56,46 +131,33 @@ func main() {
 	}
 
 	for {
-		logger.Info("waiting to receive userdata from enclave-proxy...")
+		logger.Info("waiting to receive request from enclave-proxy...")
 		ctx := context.Background()
 		reqBytes, err := socket.Receive(ctx)
 		if err != nil {
-			logger.Error("receiving userdata", slog.String("error", err.Error()))
+			logger.Error("receiving request", slog.String("error", err.Error()))
 			return
 		}
 
-		req := networking.AttestUserDataRequest{}
-		err = json.Unmarshal(reqBytes, &req)
-		if err != nil {
-			logger.Error("unmarshaling request", slog.String("error", err.Error()))
-			return
-		}
-
-		userdata := req.UserData
-		logger.Info(
-			"attesting",
-			slog.String("nonce", string(req.Nonce)),
-			slog.String("userdata", string(userdata)),
-		)
-		attestResult, err := attester.Attest(
-			tee.WithAttestNonce(req.Nonce),
-			tee.WithAttestUserData(userdata),
-		)
+		socketReq := networking.SocketRequest{}
+		err = networking.CBORCodec.Unmarshal(reqBytes, &socketReq)
 		if err != nil {
-			logger.Error("attesting", slog.String("error", err.Error()))
+			logger.Error("unmarshaling socket request", slog.String("error", err.Error()))
 			return
 		}
+		reqLogger := logger.With(slog.String("request_id", socketReq.RequestID))
 
-		attestBytes, err := json.Marshal(attestResult)
+		socketResp := networking.ServeSocketRequest(ctx, handler, socketReq)
+		respBytes, err := networking.CBORCodec.Marshal(socketResp)
 		if err != nil {
-			logger.Error("marshaling attestation", slog.String("error", err.Error()))
+			reqLogger.Error("marshaling socket response", slog.String("error", err.Error()))
 			return
 		}
 
-		logger.Info("sending attestation to enclave-proxy...")
-		err = socket.Send(ctx, config.Proxy.Addr, attestBytes)
+		reqLogger.Info("sending response to enclave-proxy...")
+		err = socket.Send(ctx, config.Proxy.Addr, respBytes)
 		if err != nil {
-			logger.Error("sending attestation", slog.String("error", err.Error()))
+			reqLogger.Error("sending response", slog.String("error", err.Error()))
 			return
 		}
 	}
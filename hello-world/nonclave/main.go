@@ -65,26 +65,92 @@ func main() {
 	}
 	logger.Info("loaded config", slog.Any(configFile, config))
 
+	limits := networking.DefaultLimits()
+	err = config.Nonclave.DecodeArg(networking.LimitsKey, &limits)
+	if err != nil {
+		logger.Error("loading limits", slog.String("error", err.Error()))
+		return
+	}
+
+	credential := networking.Credential{}
+	err = config.Nonclave.DecodeArg(networking.AuthKey, &credential)
+	if err != nil {
+		logger.Error("loading credential", slog.String("error", err.Error()))
+		return
+	}
+
+	encryptionConfig := networking.DefaultEncryptionConfig()
+	err = config.Nonclave.DecodeArg(networking.EncryptionKey, &encryptionConfig)
+	if err != nil {
+		logger.Error("loading encryption config", slog.String("error", err.Error()))
+		return
+	}
+
+	challengeConfig := networking.DefaultChallengeConfig()
+	err = config.Nonclave.DecodeArg(networking.ChallengeKey, &challengeConfig)
+	if err != nil {
+		logger.Error("loading challenge config", slog.String("error", err.Error()))
+		return
+	}
+
+	codecName := "json"
+	err = config.Nonclave.DecodeArg(networking.CodecKey, &codecName)
+	if err != nil {
+		logger.Error("loading codec", slog.String("error", err.Error()))
+		return
+	}
+	codec, err := networking.CodecByName(codecName)
+	if err != nil {
+		logger.Error("loading codec", slog.String("error", err.Error()))
+		return
+	}
+
 	verifier, err := tee.NewVerifier(config.Platform)
 	if err != nil {
 		logger.Error("making verifier", slog.String("error", err.Error()))
 		return
 	}
 
-	nonce := []byte("random nonce here")
 	want := []byte("Hello, world!")
 	url := "http://" + net.JoinHostPort(host, strconv.Itoa(port))
-	client := networking.NewClient(url)
+	measurement := config.Nonclave.Measurement
+	clientOptions := []networking.ClientOption{
+		networking.WithClientMaxResponseBytes(limits.MaxResponseBytes),
+		networking.WithClientCredential(credential),
+		networking.WithClientCodec(codec),
+	}
+	if challengeConfig.Enabled {
+		clientOptions = append(clientOptions, networking.WithClientChallenges())
+	}
+	// With encryption the user data only crosses the proxy sealed.
+	if encryptionConfig.Enabled {
+		clientOptions = append(clientOptions, networking.WithClientEncryption(
+			verifier,
+			tee.WithVerifyMeasurement(measurement),
+			tee.WithVerifyDebug(verifyDebug),
+		))
+	}
+	client := networking.NewClient(url, clientOptions...)
 
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
+
+	// The request ID follows the request through the proxy and into the
+	// Enclave, so the logs of all three can be matched up.
+	requestID := networking.NewRequestID()
+	ctx = networking.WithRequestID(ctx, requestID)
+	logger = logger.With(slog.String("request_id", requestID))
+	nonce, err := client.Nonce(ctx)
+	if err != nil {
+		logger.Error("making nonce", slog.String("error", err.Error()))
+		return
+	}
 	got, err := client.AttestUserData(ctx, nonce, want)
 	if err != nil {
 		logger.Error("attesting userdata", slog.String("error", err.Error()))
 		return
 	}
 
-	measurement := config.Nonclave.Measurement
 	verified, err := verifier.Verify(
 		got.Attestation,
 		tee.WithVerifyMeasurement(measurement),
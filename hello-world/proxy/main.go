@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,6 +9,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/tahardi/bearclave-examples/internal/networking"
@@ -20,53 +20,78 @@ import (
 
 const DefaultTimeout = 5 * time.Second
 
-func MakeAttestHandler(
-	socket *tee.Socket,
-	enclaveAddr string,
-	logger *slog.Logger,
-) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		bodyBytes, err := io.ReadAll(r.Body)
-		if err != nil {
-			logger.Error("reading request body", slog.String("error", err.Error()))
-			tee.WriteError(w, fmt.Errorf("reading request body: %w", err))
-			return
-		}
-		defer r.Body.Close()
+// EnclaveSocket sends requests to the Enclave over a socket. The socket
+// carries one exchange at a time, so round trips are serialized.
+type EnclaveSocket struct {
+	mu          sync.Mutex
+	socket      *tee.Socket
+	enclaveAddr string
+}
 
-		sendCtx, sendCancel := context.WithTimeout(r.Context(), DefaultTimeout)
-		defer sendCancel()
+func (e *EnclaveSocket) RoundTrip(
+	ctx context.Context,
+	socketReq networking.SocketRequest,
+) (networking.SocketResponse, error) {
+	// The socket has no headers, so the request ID and content types travel
+	// in the message. Encrypted bodies are forwarded without being read,
+	// and the message is CBOR so that bodies are not base64 encoded.
+	socketBytes, err := networking.CBORCodec.Marshal(socketReq)
+	if err != nil {
+		return networking.SocketResponse{}, fmt.Errorf("marshaling socket request: %w", err)
+	}
 
-		logger.Info("sending attestation request to enclave...")
-		err = socket.Send(sendCtx, enclaveAddr, bodyBytes)
-		if err != nil {
-			logger.Error("sending attestation to enclave", slog.String("error", err.Error()))
-			tee.WriteError(w, fmt.Errorf("sending attestation to enclave: %w", err))
-			return
-		}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sendCtx, sendCancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer sendCancel()
+	err = e.socket.Send(sendCtx, e.enclaveAddr, socketBytes)
+	if err != nil {
+		return networking.SocketResponse{}, fmt.Errorf("sending request to enclave: %w", err)
+	}
 
-		receiveCtx, receiveCancel := context.WithTimeout(r.Context(), DefaultTimeout)
-		defer receiveCancel()
+	receiveCtx, receiveCancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer receiveCancel()
+	respBytes, err := e.socket.Receive(receiveCtx)
+	if err != nil {
+		return networking.SocketResponse{}, fmt.Errorf("receiving response from enclave: %w", err)
+	}
 
-		logger.Info("waiting for attestation from enclave...")
-		attestBytes, err := socket.Receive(receiveCtx)
+	socketResp := networking.SocketResponse{}
+	err = networking.CBORCodec.Unmarshal(respBytes, &socketResp)
+	if err != nil {
+		return networking.SocketResponse{}, fmt.Errorf("unmarshaling response: %w", err)
+	}
+	return socketResp, nil
+}
+
+func MakeAttestHandler(enclave *EnclaveSocket, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := networking.LoggerFromContext(r.Context(), logger)
+		bodyBytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			logger.Error("receiving attestation from enclave", slog.String("error", err.Error()))
-			tee.WriteError(w, fmt.Errorf("receiving attestation from enclave: %w", err))
+			logger.Error("reading request body", slog.String("error", err.Error()))
+			networking.WriteError(
+				w,
+				networking.NewAPIError(networking.ErrorCodeBadRequest, "reading request body", err),
+			)
 			return
 		}
+		defer r.Body.Close()
 
-		attestResult := tee.AttestResult{}
-		err = json.Unmarshal(attestBytes, &attestResult)
+		logger.Info("forwarding request to enclave...")
+		socketResp, err := enclave.RoundTrip(r.Context(), networking.NewSocketRequest(r, bodyBytes))
 		if err != nil {
-			logger.Error("unmarshaling attestation", slog.String("error", err.Error()))
-			tee.WriteError(w, fmt.Errorf("unmarshaling attestation: %w", err))
+			logger.Error("forwarding request to enclave", slog.String("error", err.Error()))
+			networking.WriteError(
+				w,
+				networking.NewAPIError(networking.ErrorCodeUpstream, "forwarding request to enclave", err),
+			)
 			return
 		}
 
-		resp := networking.AttestUserDataResponse{Attestation: &attestResult}
-		tee.WriteResponse(w, resp)
-		logger.Info("sent attestation to client")
+		networking.WriteSocketResponse(w, socketResp)
+		logger.Info("sent response to client", slog.Int("status", socketResp.StatusCode))
 	}
 }
 
@@ -90,6 +115,21 @@ func main() {
 	}
 	logger.Info("loaded config", slog.Any(configFile, config))
 
+	limits := networking.DefaultLimits()
+	err = config.Enclave.DecodeArg(networking.LimitsKey, &limits)
+	if err != nil {
+		logger.Error("loading limits", slog.String("error", err.Error()))
+		return
+	}
+
+	rateLimitConfig := networking.DefaultRateLimitConfig()
+	err = config.Enclave.DecodeArg(networking.RateLimitKey, &rateLimitConfig)
+	if err != nil {
+		logger.Error("loading rate limit config", slog.String("error", err.Error()))
+		return
+	}
+	limiter := networking.NewLimiter(rateLimitConfig, logger)
+
 	sockCtx, sockCancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer sockCancel()
 	socket, err := tee.NewSocket(
@@ -103,11 +143,57 @@ func main() {
 		return
 	}
 	defer socket.Close()
+	enclave := &EnclaveSocket{socket: socket, enclaveAddr: config.Enclave.Addr}
+
+	if config.Proxy.AdminAddr != "" {
+		adminCtx, adminCancel := context.WithTimeout(context.Background(), DefaultTimeout)
+		defer adminCancel()
+
+		// Readiness requires a round trip to the enclave over the socket.
+		adminServer, err := networking.NewAdminServer(
+			adminCtx,
+			config.Proxy.AdminAddr,
+			logger,
+			networking.SocketCheck("enclave", enclave.RoundTrip),
+		)
+		if err != nil {
+			logger.Error("making admin server", slog.String("error", err.Error()))
+			return
+		}
+		defer adminServer.Close()
+
+		go func() {
+			logger.Info("admin server started", slog.String("addr", adminServer.Addr()))
+			err := adminServer.Serve()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("admin server error", slog.String("error", err.Error()))
+			}
+		}()
+	}
 
+	// The proxy forwards requests as they are, so it never needs to read the
+	// encrypted ones, and leaves authenticating them to the enclave.
+	attestHandler := MakeAttestHandler(enclave, logger)
 	mux := http.NewServeMux()
+	mux.Handle(
+		"GET "+networking.HealthzPath,
+		networking.InstrumentHandler("healthz", limiter.Wrap(attestHandler)),
+	)
+	mux.Handle(
+		"GET "+networking.ReadyzPath,
+		networking.InstrumentHandler("readyz", limiter.Wrap(attestHandler)),
+	)
 	mux.Handle(
 		"POST "+networking.AttestUserDataPath,
-		MakeAttestHandler(socket, config.Enclave.Addr, logger),
+		networking.InstrumentHandler("attest_user_data", limiter.Wrap(attestHandler)),
+	)
+	mux.Handle(
+		"POST "+networking.AttestHPKEKeyPath,
+		networking.InstrumentHandler("attest_hpke_key", limiter.Wrap(attestHandler)),
+	)
+	mux.Handle(
+		"POST "+networking.ChallengePath,
+		networking.InstrumentHandler("challenge", limiter.Wrap(attestHandler)),
 	)
 	servCtx, servCancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer servCancel()
@@ -115,7 +201,7 @@ func main() {
 		servCtx,
 		tee.NoTEE,
 		config.Proxy.RevAddr,
-		mux,
+		networking.AccessLog(logger, networking.LimitRequestBody(limits.MaxRequestBytes, mux)),
 		logger,
 	)
 	if err != nil {
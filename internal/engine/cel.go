@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/common/types"
@@ -30,7 +31,7 @@ func NewCELEngineWithWhitelist(
 	opts := MakeWhitelistedFnOpts(whitelist)
 	baseEnv, err := cel.NewEnv(opts...)
 	if err != nil {
-		return nil, fmt.Errorf("creating base CEL env: %w", err)
+		return nil, engineError("creating base CEL env", err)
 	}
 	return &CELEngine{baseEnv: baseEnv}, nil
 }
@@ -39,6 +40,17 @@ func (e *CELEngine) Execute(
 	ctx context.Context,
 	expression string,
 	env map[string]any,
+) (any, error) {
+	start := time.Now()
+	res, err := e.execute(ctx, expression, env)
+	observeExecution("cel", start, err)
+	return res, err
+}
+
+func (e *CELEngine) execute(
+	ctx context.Context,
+	expression string,
+	env map[string]any,
 ) (any, error) {
 	//nolint:prealloc
 	opts := []cel.EnvOption{}
@@ -49,17 +61,17 @@ func (e *CELEngine) Execute(
 	// Extend the pre-configured base environment with request-specific variables
 	celEnv, err := e.baseEnv.Extend(opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to extend CEL env: %w", err)
+		return nil, engineError("extending CEL env", err)
 	}
 
 	ast, iss := celEnv.Compile(expression)
 	if iss.Err() != nil {
-		return nil, fmt.Errorf("compile error: %w", iss.Err())
+		return nil, engineErrorCompile("", iss.Err())
 	}
 
 	program, err := celEnv.Program(ast)
 	if err != nil {
-		return nil, fmt.Errorf("program construction error: %w", err)
+		return nil, engineErrorCompile("constructing program", err)
 	}
 
 	resultChan := make(chan any, 1)
@@ -77,7 +89,7 @@ func (e *CELEngine) Execute(
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	case err := <-errChan:
-		return nil, fmt.Errorf("running cel: %w", err)
+		return nil, engineErrorRun("running cel", err)
 	case res := <-resultChan:
 		return res, nil
 	}
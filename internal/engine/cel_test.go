@@ -68,6 +68,7 @@ func TestCELEngine_Execute(t *testing.T) {
 		_, err = celEngine.Execute(context.Background(), expression, env)
 
 		// then
+		require.ErrorIs(t, err, engine.ErrEngineCompile)
 		assert.ErrorContains(t, err, "compile error")
 	})
 
@@ -86,6 +87,7 @@ func TestCELEngine_Execute(t *testing.T) {
 		_, err = celEngine.Execute(context.Background(), expression, env)
 
 		// then
+		require.ErrorIs(t, err, engine.ErrEngineRun)
 		assert.ErrorContains(t, err, "running cel")
 	})
 }
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrEngine        = errors.New("engine")
+	ErrEngineCompile = fmt.Errorf("%w: compile error", ErrEngine)
+	ErrEngineRun     = fmt.Errorf("%w: run error", ErrEngine)
+)
+
+func wrapError(baseErr error, msg string, err error) error {
+	switch {
+	case msg == "" && err == nil:
+		return baseErr
+	case msg != "" && err != nil:
+		return fmt.Errorf("%w: %s: %w", baseErr, msg, err)
+	case msg != "":
+		return fmt.Errorf("%w: %s", baseErr, msg)
+	default:
+		return fmt.Errorf("%w: %w", baseErr, err)
+	}
+}
+
+func engineError(msg string, err error) error {
+	return wrapError(ErrEngine, msg, err)
+}
+
+func engineErrorCompile(msg string, err error) error {
+	return wrapError(ErrEngineCompile, msg, err)
+}
+
+func engineErrorRun(msg string, err error) error {
+	return wrapError(ErrEngineRun, msg, err)
+}
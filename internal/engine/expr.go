@@ -2,7 +2,7 @@ package engine
 
 import (
 	"context"
-	"fmt"
+	"time"
 
 	"github.com/expr-lang/expr"
 )
@@ -31,10 +31,21 @@ func (e *ExprEngine) Execute(
 	ctx context.Context,
 	expression string,
 	env map[string]any,
+) (any, error) {
+	start := time.Now()
+	res, err := e.execute(ctx, expression, env)
+	observeExecution("expr", start, err)
+	return res, err
+}
+
+func (e *ExprEngine) execute(
+	ctx context.Context,
+	expression string,
+	env map[string]any,
 ) (any, error) {
 	program, err := expr.Compile(expression, append(e.baseOptions, expr.Env(env))...)
 	if err != nil {
-		return nil, fmt.Errorf("compile error: %w", err)
+		return nil, engineErrorCompile("", err)
 	}
 
 	resultChan := make(chan any, 1)
@@ -52,7 +63,7 @@ func (e *ExprEngine) Execute(
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	case err := <-errChan:
-		return nil, fmt.Errorf("running expr: %w", err)
+		return nil, engineErrorRun("running expr", err)
 	case res := <-resultChan:
 		return res, nil
 	}
@@ -68,6 +68,7 @@ func TestExprEngine_Execute(t *testing.T) {
 		_, err = exprEngine.Execute(context.Background(), expression, env)
 
 		// then
+		require.ErrorIs(t, err, engine.ErrEngineCompile)
 		assert.ErrorContains(t, err, "compile error")
 	})
 
@@ -86,6 +87,7 @@ func TestExprEngine_Execute(t *testing.T) {
 		_, err = exprEngine.Execute(context.Background(), expression, env)
 
 		// then
+		require.ErrorIs(t, err, engine.ErrEngineRun)
 		assert.ErrorContains(t, err, "running expr")
 	})
 }
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/metrics"
+)
+
+var (
+	executionsTotal = metrics.Default.NewCounter(
+		"bearclave_engine_executions_total",
+		"Expression executions by engine and result.",
+		"engine",
+		"result",
+	)
+	executionDuration = metrics.Default.NewHistogram(
+		"bearclave_engine_execution_duration_seconds",
+		"Time spent compiling and running expressions.",
+		nil,
+		"engine",
+	)
+)
+
+func observeExecution(engine string, start time.Time, err error) {
+	executionDuration.With(engine).Observe(time.Since(start).Seconds())
+	executionsTotal.With(engine, executionResult(err)).Inc()
+}
+
+func executionResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrEngineCompile):
+		return "compile_error"
+	case errors.Is(err, ErrEngineRun):
+		return "run_error"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,38 @@
+package engine_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/engine"
+	"github.com/tahardi/bearclave-examples/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Metrics(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		celEngine, err := engine.NewCELEngine()
+		require.NoError(t, err)
+		exprEngine, err := engine.NewExprEngine()
+		require.NoError(t, err)
+
+		// when
+		_, celErr := celEngine.Execute(context.Background(), "1 +", map[string]any{})
+		_, exprErr := exprEngine.Execute(context.Background(), "1 + 1", map[string]any{})
+
+		// then
+		require.ErrorIs(t, celErr, engine.ErrEngineCompile)
+		require.NoError(t, exprErr)
+
+		var buf bytes.Buffer
+		err = metrics.Default.WriteText(&buf)
+		require.NoError(t, err)
+		assert.Contains(t, buf.String(), `bearclave_engine_executions_total{engine="cel",result="compile_error"}`)
+		assert.Contains(t, buf.String(), `bearclave_engine_executions_total{engine="expr",result="ok"}`)
+		assert.Contains(t, buf.String(), `bearclave_engine_execution_duration_seconds_count{engine="expr"}`)
+	})
+}
@@ -0,0 +1,350 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	Path        = "/metrics"
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// DefaultBuckets are latency buckets in seconds, matching the Prometheus
+// client defaults.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry instrumented packages register their metrics with
+// and that Handler serves.
+var Default = NewRegistry()
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// Registry holds metric families and writes them in the Prometheus text
+// exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// NewCounter registers a counter family. Registering the same family twice
+// returns the existing one; registering a conflicting family panics, since
+// metric names are fixed at compile time.
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{family: r.register(name, help, kindCounter, nil, labels)}
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{family: r.register(name, help, kindGauge, nil, labels)}
+}
+
+// NewHistogram registers a histogram family. Nil buckets means
+// DefaultBuckets.
+func (r *Registry) NewHistogram(
+	name string,
+	help string,
+	buckets []float64,
+	labels ...string,
+) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &HistogramVec{family: r.register(name, help, kindHistogram, buckets, labels)}
+}
+
+func (r *Registry) register(
+	name string,
+	help string,
+	k kind,
+	buckets []float64,
+	labels []string,
+) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != k || !slices.Equal(f.labels, labels) || !slices.Equal(f.buckets, buckets) {
+			panic("metrics: conflicting registration of " + name)
+		}
+		return f
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  slices.Clone(labels),
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.families[name] = f
+	return f
+}
+
+// WriteText writes every family with at least one series, sorted by name, in
+// the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_ = r.WriteText(w)
+	})
+}
+
+// Handler serves the Default registry.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+type CounterVec struct{ family *family }
+
+// With returns the counter for the given label values, which must match the
+// registered label names in number and order.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{series: v.family.with(labelValues)}
+}
+
+type Counter struct{ series *series }
+
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add increments the counter by delta. Negative deltas are ignored.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.series.mu.Lock()
+	c.series.value += delta
+	c.series.mu.Unlock()
+}
+
+func (c *Counter) Value() float64 { return c.series.get() }
+
+type GaugeVec struct{ family *family }
+
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{series: v.family.with(labelValues)}
+}
+
+type Gauge struct{ series *series }
+
+func (g *Gauge) Set(value float64) {
+	g.series.mu.Lock()
+	g.series.value = value
+	g.series.mu.Unlock()
+}
+
+func (g *Gauge) Inc() { g.Add(1) }
+func (g *Gauge) Dec() { g.Add(-1) }
+
+func (g *Gauge) Add(delta float64) {
+	g.series.mu.Lock()
+	g.series.value += delta
+	g.series.mu.Unlock()
+}
+
+func (g *Gauge) Value() float64 { return g.series.get() }
+
+type HistogramVec struct{ family *family }
+
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return &Histogram{series: v.family.with(labelValues)}
+}
+
+type Histogram struct{ series *series }
+
+func (h *Histogram) Observe(value float64) {
+	s := h.series
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, bound := range s.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (h *Histogram) Count() uint64 {
+	h.series.mu.Lock()
+	defer h.series.mu.Unlock()
+	return h.series.count
+}
+
+func (h *Histogram) Sum() float64 { return h.series.get() }
+
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	buckets     []float64
+
+	mu     sync.Mutex
+	value  float64 // counter or gauge value, or histogram sum
+	count  uint64
+	counts []uint64
+}
+
+func (s *series) get() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.value
+}
+
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf(
+			"metrics: %s expects %d label values, got %d",
+			f.name,
+			len(f.labels),
+			len(labelValues),
+		))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			labelValues: slices.Clone(labelValues),
+			buckets:     f.buckets,
+			counts:      make([]uint64, len(f.buckets)),
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.Unlock()
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return slices.Compare(all[i].labelValues, all[j].labelValues) < 0
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range all {
+		s.mu.Lock()
+		switch f.kind {
+		case kindCounter, kindGauge:
+			writeSample(w, f.name, f.labels, s.labelValues, "", "", s.value)
+		case kindHistogram:
+			for i, bound := range s.buckets {
+				le := formatFloat(bound)
+				writeSample(w, f.name+"_bucket", f.labels, s.labelValues, "le", le, float64(s.counts[i]))
+			}
+			writeSample(w, f.name+"_bucket", f.labels, s.labelValues, "le", "+Inf", float64(s.count))
+			writeSample(w, f.name+"_sum", f.labels, s.labelValues, "", "", s.value)
+			writeSample(w, f.name+"_count", f.labels, s.labelValues, "", "", float64(s.count))
+		}
+		s.mu.Unlock()
+	}
+}
+
+func writeSample(
+	w *bufio.Writer,
+	name string,
+	labels []string,
+	labelValues []string,
+	extraLabel string,
+	extraValue string,
+	value float64,
+) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabelValue(labelValues[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,146 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeText(t *testing.T, registry *metrics.Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	err := registry.WriteText(&buf)
+	require.NoError(t, err)
+	return buf.String()
+}
+
+func TestRegistry_WriteText(t *testing.T) {
+	t.Run("happy path - counter and gauge", func(t *testing.T) {
+		// given
+		registry := metrics.NewRegistry()
+		counter := registry.NewCounter("requests_total", "Requests served.", "code")
+		gauge := registry.NewGauge("in_flight", "Requests in flight.")
+
+		counter.With("500").Inc()
+		counter.With("200").Add(2)
+		counter.With("200").Add(-1)
+		gauge.With().Inc()
+		gauge.With().Inc()
+		gauge.With().Dec()
+
+		want := `# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{code="200"} 2
+requests_total{code="500"} 1
+`
+
+		// when
+		got := writeText(t, registry)
+
+		// then
+		assert.Equal(t, want, got)
+		assert.InDelta(t, 2.0, counter.With("200").Value(), 0)
+		assert.InDelta(t, 1.0, gauge.With().Value(), 0)
+	})
+
+	t.Run("happy path - histogram", func(t *testing.T) {
+		// given
+		registry := metrics.NewRegistry()
+		histogram := registry.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.5}, "op")
+
+		histogram.With("get").Observe(0.25)
+		histogram.With("get").Observe(0.75)
+		histogram.With("get").Observe(2)
+
+		want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.5"} 1
+latency_seconds_bucket{op="get",le="1"} 2
+latency_seconds_bucket{op="get",le="+Inf"} 3
+latency_seconds_sum{op="get"} 3
+latency_seconds_count{op="get"} 3
+`
+
+		// when
+		got := writeText(t, registry)
+
+		// then
+		assert.Equal(t, want, got)
+		assert.Equal(t, uint64(3), histogram.With("get").Count())
+		assert.InDelta(t, 3.0, histogram.With("get").Sum(), 0)
+	})
+
+	t.Run("happy path - escapes help and label values", func(t *testing.T) {
+		// given
+		registry := metrics.NewRegistry()
+		counter := registry.NewCounter("escaped_total", "Line one\nline \\two.", "value")
+		counter.With("say \"hi\"\n").Inc()
+
+		// when
+		got := writeText(t, registry)
+
+		// then
+		assert.Contains(t, got, `# HELP escaped_total Line one\nline \\two.`)
+		assert.Contains(t, got, `escaped_total{value="say \"hi\"\n"} 1`)
+	})
+
+	t.Run("happy path - registering twice returns the same family", func(t *testing.T) {
+		// given
+		registry := metrics.NewRegistry()
+		first := registry.NewCounter("shared_total", "Shared.", "code")
+		second := registry.NewCounter("shared_total", "Shared.", "code")
+
+		// when
+		first.With("200").Inc()
+		second.With("200").Inc()
+
+		// then
+		assert.InDelta(t, 2.0, first.With("200").Value(), 0)
+	})
+
+	t.Run("error - conflicting registration", func(t *testing.T) {
+		// given
+		registry := metrics.NewRegistry()
+		registry.NewCounter("conflict", "Conflict.", "code")
+
+		// when/then
+		assert.Panics(t, func() { registry.NewGauge("conflict", "Conflict.", "code") })
+	})
+
+	t.Run("error - wrong number of label values", func(t *testing.T) {
+		// given
+		registry := metrics.NewRegistry()
+		counter := registry.NewCounter("labeled_total", "Labeled.", "code")
+
+		// when/then
+		assert.Panics(t, func() { counter.With("200", "extra") })
+	})
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		registry := metrics.NewRegistry()
+		registry.NewCounter("served_total", "Served.").With().Inc()
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, metrics.Path, nil)
+
+		// when
+		registry.Handler().ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, metrics.ContentType, recorder.Header().Get("Content-Type"))
+		assert.Contains(t, recorder.Body.String(), "served_total 1\n")
+	})
+}
@@ -0,0 +1,314 @@
+package networking
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	ACMEKey                = "acme"
+	ACMEChallengePath      = "/.well-known/acme-challenge/"
+	DefaultACMEDirectory   = acme.LetsEncryptURL
+	DefaultACMERenewBefore = 30 * 24 * time.Hour
+)
+
+type ACMEConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	DirectoryURL string        `mapstructure:"directory_url"`
+	Email        string        `mapstructure:"email"`
+	RenewBefore  time.Duration `mapstructure:"renew_before"`
+}
+
+func DefaultACMEConfig() ACMEConfig {
+	return ACMEConfig{
+		Enabled:      false,
+		DirectoryURL: DefaultACMEDirectory,
+		RenewBefore:  DefaultACMERenewBefore,
+	}
+}
+
+// ACMECertProvider is a tee.CertProvider whose certificates are issued by an
+// ACME CA, such as Let's Encrypt, so that browsers and other ordinary TLS
+// clients trust the Enclave without attesting it first. The certificate key
+// is generated inside the Enclave and never leaves it, and is kept across
+// renewals. Domain ownership is proven with HTTP-01 challenges, which the CA
+// fetches through the reverse proxy from MakeACMEChallengeHandler.
+//
+// A certificate is obtained the first time one is needed. After that GetCert
+// only returns the current certificate, so handshakes never wait on the CA.
+// Renewals are left to a CertRotator whose RotateBefore is RenewBefore, which
+// backs off when they fail. Until a renewal succeeds, the current certificate
+// is served.
+type ACMECertProvider struct {
+	client  *acme.Client
+	domain  string
+	email   string
+	logger  *slog.Logger
+	certKey crypto.Signer
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+
+	// obtainMu serializes orders, so that concurrent first handshakes do not
+	// each order a certificate.
+	obtainMu   sync.Mutex
+	registered bool
+
+	tokensMu sync.Mutex
+	tokens   map[string]string
+}
+
+func NewACMECertProvider(
+	config ACMEConfig,
+	domain string,
+	logger *slog.Logger,
+	options ...ACMEOption,
+) (*ACMECertProvider, error) {
+	opts := MakeDefaultACMEOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	if domain == "" {
+		return nil, acmeError("missing domain", nil)
+	}
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, acmeError("generating account key", err)
+	}
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, acmeError("generating cert key", err)
+	}
+
+	directoryURL := config.DirectoryURL
+	if directoryURL == "" {
+		directoryURL = DefaultACMEDirectory
+	}
+	return &ACMECertProvider{
+		client: &acme.Client{
+			Key:          accountKey,
+			HTTPClient:   opts.HTTPClient,
+			DirectoryURL: directoryURL,
+		},
+		domain:  domain,
+		email:   config.Email,
+		logger:  logger,
+		certKey: certKey,
+		tokens:  map[string]string{},
+	}, nil
+}
+
+// GetCert returns the current certificate. Only if there is none yet does it
+// contact the CA.
+func (p *ACMECertProvider) GetCert(ctx context.Context) (*tls.Certificate, error) {
+	cert := p.currentCert()
+	if cert != nil {
+		return cert, nil
+	}
+
+	p.obtainMu.Lock()
+	defer p.obtainMu.Unlock()
+	cert = p.currentCert()
+	if cert != nil {
+		return cert, nil
+	}
+	err := p.obtain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.currentCert(), nil
+}
+
+// RotateCert obtains a new certificate for the same key, whether or not the
+// current one is due for renewal. The current certificate is served until
+// the new one is issued.
+func (p *ACMECertProvider) RotateCert(ctx context.Context) error {
+	p.obtainMu.Lock()
+	defer p.obtainMu.Unlock()
+	return p.obtain(ctx)
+}
+
+func (p *ACMECertProvider) currentCert() *tls.Certificate {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cert
+}
+
+// obtain must be called with obtainMu held.
+func (p *ACMECertProvider) obtain(ctx context.Context) error {
+	err := p.register(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := p.client.AuthorizeOrder(ctx, acme.DomainIDs(p.domain))
+	if err != nil {
+		return acmeError("creating order", err)
+	}
+	for _, authzURL := range order.AuthzURLs {
+		err = p.authorize(ctx, authzURL)
+		if err != nil {
+			return err
+		}
+	}
+	order, err = p.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return acmeError("waiting for order", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: p.domain},
+		DNSNames: []string{p.domain},
+	}, p.certKey)
+	if err != nil {
+		return acmeError("creating csr", err)
+	}
+	chain, _, err := p.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return acmeError("finalizing order", err)
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return acmeError("parsing cert", err)
+	}
+	err = leaf.VerifyHostname(p.domain)
+	if err != nil {
+		return acmeError("checking cert", err)
+	}
+	p.mu.Lock()
+	p.cert = &tls.Certificate{Certificate: chain, PrivateKey: p.certKey, Leaf: leaf}
+	p.mu.Unlock()
+	p.logger.Info(
+		"obtained acme cert",
+		slog.String("domain", p.domain),
+		slog.Time("not_after", leaf.NotAfter),
+	)
+	return nil
+}
+
+// register must be called with obtainMu held.
+func (p *ACMECertProvider) register(ctx context.Context) error {
+	if p.registered {
+		return nil
+	}
+
+	account := &acme.Account{}
+	if p.email != "" {
+		account.Contact = []string{"mailto:" + p.email}
+	}
+	_, err := p.client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return acmeError("registering account", err)
+	}
+	p.registered = true
+	return nil
+}
+
+func (p *ACMECertProvider) authorize(ctx context.Context, authzURL string) error {
+	authz, err := p.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return acmeError("getting authorization", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return acmeError("no http-01 challenge for "+authz.Identifier.Value, nil)
+	}
+
+	keyAuth, err := p.client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return acmeError("making challenge response", err)
+	}
+	p.setToken(challenge.Token, keyAuth)
+	defer p.setToken(challenge.Token, "")
+
+	_, err = p.client.Accept(ctx, challenge)
+	if err != nil {
+		return acmeError("accepting challenge", err)
+	}
+	_, err = p.client.WaitAuthorization(ctx, authz.URI)
+	if err != nil {
+		return acmeError("waiting for authorization", err)
+	}
+	return nil
+}
+
+func (p *ACMECertProvider) setToken(token string, keyAuth string) {
+	p.tokensMu.Lock()
+	defer p.tokensMu.Unlock()
+	if keyAuth == "" {
+		delete(p.tokens, token)
+		return
+	}
+	p.tokens[token] = keyAuth
+}
+
+func (p *ACMECertProvider) keyAuth(token string) (string, bool) {
+	p.tokensMu.Lock()
+	defer p.tokensMu.Unlock()
+	keyAuth, ok := p.tokens[token]
+	return keyAuth, ok
+}
+
+// MakeACMEChallengeHandler answers the HTTP-01 challenges of provider's
+// pending orders at ACMEChallengePath. It must be reachable on port 80 of the
+// domain, which for an Enclave means through the reverse proxy, and without
+// authentication.
+func MakeACMEChallengeHandler(provider *ACMECertProvider, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := LoggerFromContext(r.Context(), logger)
+		token := strings.TrimPrefix(r.URL.Path, ACMEChallengePath)
+		keyAuth, ok := provider.keyAuth(token)
+		if !ok {
+			logger.Warn("unknown acme challenge", slog.String("token", token))
+			http.NotFound(w, r)
+			return
+		}
+
+		logger.Info("answering acme challenge", slog.String("token", token))
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(keyAuth))
+	}
+}
+
+type ACMEOption func(*ACMEOptions)
+type ACMEOptions struct {
+	HTTPClient *http.Client
+}
+
+// WithACMEHTTPClient sends requests to the CA with client. Enclaves without
+// network access pass a tee.NewProxiedClient.
+func WithACMEHTTPClient(client *http.Client) ACMEOption {
+	return func(opts *ACMEOptions) {
+		opts.HTTPClient = client
+	}
+}
+
+func MakeDefaultACMEOptions() ACMEOptions {
+	return ACMEOptions{HTTPClient: http.DefaultClient}
+}
@@ -0,0 +1,276 @@
+package networking_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+const acmeDomain = "enclave.example.com"
+
+// newACMEProvider returns a provider that gets its certificates from ca, and
+// serves its challenges the way the Enclave does, behind authentication.
+func newACMEProvider(t *testing.T, ca *acmeServer) *networking.ACMECertProvider {
+	t.Helper()
+	logger := slog.New(slog.DiscardHandler)
+	config := networking.DefaultACMEConfig()
+	config.Enabled = true
+	config.DirectoryURL = ca.DirectoryURL()
+	config.Email = "admin@example.com"
+	provider, err := networking.NewACMECertProvider(config, acmeDomain, logger)
+	require.NoError(t, err)
+
+	authenticator, err := networking.NewAuthenticator(makeAuthConfig(), logger)
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.Handle(
+		"GET "+networking.ACMEChallengePath,
+		networking.MakeACMEChallengeHandler(provider, logger),
+	)
+	enclave := httptest.NewServer(authenticator.Wrap(mux))
+	t.Cleanup(enclave.Close)
+	ca.validationAddr = enclave.Listener.Addr().String()
+	return provider
+}
+
+func TestACMECertProvider_GetCert(t *testing.T) {
+	t.Run("happy path - issues a trusted cert", func(t *testing.T) {
+		// given
+		ca := newACMEServer(t)
+		provider := newACMEProvider(t, ca)
+
+		// when
+		cert, err := provider.GetCert(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, cert.Certificate, 2)
+		_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: acmeDomain, Roots: ca.roots})
+		require.NoError(t, err)
+
+		key, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
+		require.True(t, ok)
+		assert.True(t, key.PublicKey.Equal(cert.Leaf.PublicKey))
+	})
+
+	t.Run("happy path - reuses cert", func(t *testing.T) {
+		// given
+		ca := newACMEServer(t)
+		provider := newACMEProvider(t, ca)
+		first, err := provider.GetCert(context.Background())
+		require.NoError(t, err)
+
+		// when
+		second, err := provider.GetCert(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Same(t, first, second)
+		assert.Equal(t, int32(1), ca.issued.Load())
+	})
+
+	t.Run("happy path - does not contact ca when renewal is due", func(t *testing.T) {
+		// given
+		ca := newACMEServer(t)
+		provider := newACMEProvider(t, ca)
+		first, err := provider.GetCert(context.Background())
+		require.NoError(t, err)
+		require.Less(t, time.Until(first.Leaf.NotAfter), networking.DefaultACMERenewBefore)
+
+		// when
+		second, err := provider.GetCert(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Same(t, first, second)
+		assert.Equal(t, int32(1), ca.issued.Load())
+	})
+
+	t.Run("error - challenge not answered", func(t *testing.T) {
+		// given
+		ca := newACMEServer(t)
+		provider := newACMEProvider(t, ca)
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		t.Cleanup(unreachable.Close)
+		ca.validationAddr = unreachable.Listener.Addr().String()
+
+		// when
+		_, err := provider.GetCert(context.Background())
+
+		// then
+		require.ErrorIs(t, err, networking.ErrACME)
+		assert.Equal(t, int32(0), ca.issued.Load())
+	})
+}
+
+func TestACMECertProvider_RotateCert(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		ca := newACMEServer(t)
+		provider := newACMEProvider(t, ca)
+		first, err := provider.GetCert(context.Background())
+		require.NoError(t, err)
+
+		// when
+		err = provider.RotateCert(context.Background())
+
+		// then
+		require.NoError(t, err)
+		second, err := provider.GetCert(context.Background())
+		require.NoError(t, err)
+		assert.NotEqual(t, first.Leaf.SerialNumber, second.Leaf.SerialNumber)
+		assert.Equal(t, int32(2), ca.issued.Load())
+	})
+}
+
+// newACMERotator returns a rotator, like the Enclave's, that finds provider's
+// certificates from ca already due for renewal. ca backdates them, as
+// rotate before is capped at a third of their lifetime.
+func newACMERotator(
+	t *testing.T,
+	provider *networking.ACMECertProvider,
+	ca *acmeServer,
+) *networking.CertRotator {
+	t.Helper()
+	config := networking.DefaultCertRotationConfig()
+	config.Validity = 0
+	config.RotateBefore = 2 * ca.validity
+	ca.age = 2 * ca.validity
+	rotator, err := networking.NewCertRotator(provider, config, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	return rotator
+}
+
+func TestCertRotator_Check_ACME(t *testing.T) {
+	t.Run("happy path - renews with the same key", func(t *testing.T) {
+		// given
+		ca := newACMEServer(t)
+		provider := newACMEProvider(t, ca)
+		rotator := newACMERotator(t, provider, ca)
+		first, err := provider.GetCert(context.Background())
+		require.NoError(t, err)
+
+		// when
+		rotated, err := rotator.Check(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.True(t, rotated)
+		second, err := provider.GetCert(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), ca.issued.Load())
+		assert.NotEqual(t, first.Leaf.SerialNumber, second.Leaf.SerialNumber)
+		assert.Equal(t, first.PrivateKey, second.PrivateKey)
+	})
+
+	t.Run("happy path - keeps cert shorter than renew before", func(t *testing.T) {
+		// given
+		ca := newACMEServer(t)
+		provider := newACMEProvider(t, ca)
+		config := networking.DefaultCertRotationConfig()
+		config.Validity = 0
+		config.RotateBefore = networking.DefaultACMERenewBefore
+		rotator, err := networking.NewCertRotator(provider, config, slog.New(slog.DiscardHandler))
+		require.NoError(t, err)
+		first, err := provider.GetCert(context.Background())
+		require.NoError(t, err)
+		require.Less(t, time.Until(first.Leaf.NotAfter), networking.DefaultACMERenewBefore)
+
+		// when
+		rotated, err := rotator.Check(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.False(t, rotated)
+		assert.Equal(t, int32(1), ca.issued.Load())
+	})
+
+	t.Run("error - keeps cert when renewal fails", func(t *testing.T) {
+		// given
+		ca := newACMEServer(t)
+		provider := newACMEProvider(t, ca)
+		rotator := newACMERotator(t, provider, ca)
+		first, err := provider.GetCert(context.Background())
+		require.NoError(t, err)
+
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		t.Cleanup(unreachable.Close)
+		ca.validationAddr = unreachable.Listener.Addr().String()
+
+		// when
+		_, err = rotator.Check(context.Background())
+
+		// then
+		require.ErrorIs(t, err, networking.ErrCertRotation)
+		second, err := provider.GetCert(context.Background())
+		require.NoError(t, err)
+		assert.Same(t, first, second)
+	})
+}
+
+func TestMakeAttestCertHandler_ACME(t *testing.T) {
+	t.Run("happy path - attests the issued chain", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+		ca := newACMEServer(t)
+		provider := newACMEProvider(t, ca)
+		handler := networking.MakeAttestCertHandler(
+			attester,
+			provider,
+			nil,
+			slog.New(slog.DiscardHandler),
+		)
+		req := makeRequest(t, http.MethodPost, networking.AttestCertPath, networking.AttestCertRequest{})
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		require.Equal(t, http.StatusOK, recorder.Code)
+		resp := networking.AttestCertResponse{}
+		err = json.Unmarshal(recorder.Body.Bytes(), &resp)
+		require.NoError(t, err)
+		verified, err := verifier.Verify(resp.Attestation)
+		require.NoError(t, err)
+
+		cert, err := provider.GetCert(context.Background())
+		require.NoError(t, err)
+		err = networking.VerifyCanonicalJSON(verified.UserData, cert.Certificate)
+		require.NoError(t, err)
+	})
+}
+
+func TestMakeACMEChallengeHandler(t *testing.T) {
+	t.Run("error - unknown token", func(t *testing.T) {
+		// given
+		config := networking.DefaultACMEConfig()
+		logger := slog.New(slog.DiscardHandler)
+		provider, err := networking.NewACMECertProvider(config, acmeDomain, logger)
+		require.NoError(t, err)
+		handler := networking.MakeACMEChallengeHandler(provider, logger)
+		req := httptest.NewRequest(http.MethodGet, networking.ACMEChallengePath+"token", nil)
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+}
@@ -0,0 +1,533 @@
+package networking_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+)
+
+// acmeServer is a stand-in for an ACME CA (RFC 8555), just complete enough
+// for ACMECertProvider. It verifies request signatures and nonces, validates
+// HTTP-01 challenges by fetching them from validationAddr, as a CA would from
+// port 80 of the domain, and issues certificates from an in-memory root that
+// were valid from age ago and last validity from now.
+type acmeServer struct {
+	*httptest.Server
+	validationAddr string
+	validity       time.Duration
+	age            time.Duration
+	roots          *x509.CertPool
+	issued         atomic.Int32
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu       sync.Mutex
+	nextID   int
+	nonces   map[string]struct{}
+	accounts map[string]*ecdsa.PublicKey
+	orders   map[string]*acmeOrder
+	authzs   map[string]*acmeAuthz
+	certs    map[string][]byte
+}
+
+type acmeOrder struct {
+	Status         string           `json:"status"`
+	Identifiers    []acmeIdentifier `json:"identifiers"`
+	Authorizations []string         `json:"authorizations"`
+	Finalize       string           `json:"finalize"`
+	Certificate    string           `json:"certificate,omitempty"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeAuthz struct {
+	Status     string          `json:"status"`
+	Identifier acmeIdentifier  `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"`
+	order      string
+}
+
+type acmeChallenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+type acmeJWS struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type acmeJWSHeader struct {
+	Alg   string `json:"alg"`
+	KID   string `json:"kid"`
+	Nonce string `json:"nonce"`
+	URL   string `json:"url"`
+	JWK   *struct {
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	} `json:"jwk"`
+}
+
+func newACMEServer(t *testing.T) *acmeServer {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acme stand-in root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	s := &acmeServer{
+		validity: 12 * time.Hour,
+		age:      time.Minute,
+		roots:    x509.NewCertPool(),
+		caKey:    caKey,
+		caCert:   caCert,
+		nonces:   map[string]struct{}{},
+		accounts: map[string]*ecdsa.PublicKey{},
+		orders:   map[string]*acmeOrder{},
+		authzs:   map[string]*acmeAuthz{},
+		certs:    map[string][]byte{},
+	}
+	s.roots.AddCert(caCert)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /directory", s.directory)
+	mux.HandleFunc("/new-nonce", func(w http.ResponseWriter, _ *http.Request) {
+		s.addNonce(w)
+	})
+	mux.HandleFunc("POST /new-account", s.newAccount)
+	mux.HandleFunc("POST /new-order", s.newOrder)
+	mux.HandleFunc("POST /order/{id}", s.getOrder)
+	mux.HandleFunc("POST /authz/{id}", s.getAuthz)
+	mux.HandleFunc("POST /challenge/{id}", s.validate)
+	mux.HandleFunc("POST /finalize/{id}", s.finalize)
+	mux.HandleFunc("POST /cert/{id}", s.getCert)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *acmeServer) DirectoryURL() string {
+	return s.URL + "/directory"
+}
+
+func (s *acmeServer) directory(w http.ResponseWriter, _ *http.Request) {
+	s.writeJSON(w, http.StatusOK, "", map[string]string{
+		"newNonce":   s.URL + "/new-nonce",
+		"newAccount": s.URL + "/new-account",
+		"newOrder":   s.URL + "/new-order",
+		"revokeCert": s.URL + "/revoke-cert",
+		"keyChange":  s.URL + "/key-change",
+	})
+}
+
+func (s *acmeServer) newAccount(w http.ResponseWriter, r *http.Request) {
+	_, account, err := s.verify(r)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, account, map[string]string{"status": acme.StatusValid})
+}
+
+func (s *acmeServer) newOrder(w http.ResponseWriter, r *http.Request) {
+	payload, _, err := s.verify(r)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+	req := struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}{}
+	err = json.Unmarshal(payload, &req)
+	if err != nil || len(req.Identifiers) == 0 {
+		s.writeProblem(w, errors.New("malformed order"))
+		return
+	}
+
+	s.mu.Lock()
+	orderID := s.newID()
+	order := &acmeOrder{
+		Status:      acme.StatusPending,
+		Identifiers: req.Identifiers,
+		Finalize:    s.URL + "/finalize/" + orderID,
+	}
+	for _, identifier := range req.Identifiers {
+		authzID := s.newID()
+		s.authzs[authzID] = &acmeAuthz{
+			Status:     acme.StatusPending,
+			Identifier: identifier,
+			Challenges: []acmeChallenge{{
+				Type:   "http-01",
+				URL:    s.URL + "/challenge/" + authzID,
+				Token:  rand.Text(),
+				Status: acme.StatusPending,
+			}},
+			order: orderID,
+		}
+		order.Authorizations = append(order.Authorizations, s.URL+"/authz/"+authzID)
+	}
+	s.orders[orderID] = order
+	out := *order
+	s.mu.Unlock()
+
+	s.writeJSON(w, http.StatusCreated, s.URL+"/order/"+orderID, out)
+}
+
+func (s *acmeServer) getOrder(w http.ResponseWriter, r *http.Request) {
+	_, _, err := s.verify(r)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	order, ok := s.orders[r.PathValue("id")]
+	var out acmeOrder
+	if ok {
+		out = *order
+	}
+	s.mu.Unlock()
+	if !ok {
+		s.writeProblem(w, errors.New("unknown order"))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.URL+r.URL.Path, out)
+}
+
+func (s *acmeServer) getAuthz(w http.ResponseWriter, r *http.Request) {
+	_, _, err := s.verify(r)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	authz, ok := s.authzs[r.PathValue("id")]
+	var out acmeAuthz
+	if ok {
+		out = *authz
+	}
+	s.mu.Unlock()
+	if !ok {
+		s.writeProblem(w, errors.New("unknown authorization"))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, "", out)
+}
+
+// validate fetches the key authorization for a challenge synchronously,
+// where a real CA would do so in the background.
+func (s *acmeServer) validate(w http.ResponseWriter, r *http.Request) {
+	_, account, err := s.verify(r)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	authz, ok := s.authzs[r.PathValue("id")]
+	var challenge acmeChallenge
+	var domain string
+	if ok {
+		challenge = authz.Challenges[0]
+		domain = authz.Identifier.Value
+	}
+	accountKey := s.accounts[account]
+	s.mu.Unlock()
+	if !ok {
+		s.writeProblem(w, errors.New("unknown challenge"))
+		return
+	}
+
+	thumbprint, err := acme.JWKThumbprint(accountKey)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+	status := acme.StatusValid
+	keyAuth, err := s.fetchKeyAuth(r.Context(), domain, challenge.Token)
+	if err != nil || keyAuth != challenge.Token+"."+thumbprint {
+		status = acme.StatusInvalid
+	}
+
+	s.mu.Lock()
+	authz.Status = status
+	authz.Challenges[0].Status = status
+	challenge = authz.Challenges[0]
+	order := s.orders[authz.order]
+	order.Status = acme.StatusReady
+	for _, authzURL := range order.Authorizations {
+		other := s.authzs[authzURL[len(s.URL+"/authz/"):]]
+		switch other.Status {
+		case acme.StatusInvalid:
+			order.Status = acme.StatusInvalid
+		case acme.StatusPending:
+			if order.Status != acme.StatusInvalid {
+				order.Status = acme.StatusPending
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	s.writeJSON(w, http.StatusOK, "", challenge)
+}
+
+func (s *acmeServer) fetchKeyAuth(ctx context.Context, domain string, token string) (string, error) {
+	url := "http://" + s.validationAddr + networking.ACMEChallengePath + token
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Host = domain
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("challenge response status " + strconv.Itoa(resp.StatusCode))
+	}
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func (s *acmeServer) finalize(w http.ResponseWriter, r *http.Request) {
+	payload, _, err := s.verify(r)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+	req := struct {
+		CSR string `json:"csr"`
+	}{}
+	err = json.Unmarshal(payload, &req)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+	csrDER, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+	err = csr.CheckSignature()
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+
+	orderID := r.PathValue("id")
+	order, err := s.issue(orderID, csr)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.URL+"/order/"+orderID, order)
+}
+
+func (s *acmeServer) issue(orderID string, csr *x509.CertificateRequest) (acmeOrder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	switch {
+	case !ok:
+		return acmeOrder{}, errors.New("unknown order")
+	case order.Status != acme.StatusReady:
+		return acmeOrder{}, errors.New("order is " + order.Status)
+	}
+	for _, identifier := range order.Identifiers {
+		if !slices.Contains(csr.DNSNames, identifier.Value) {
+			return acmeOrder{}, errors.New("csr is missing " + identifier.Value)
+		}
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(s.issued.Add(1)) + 1),
+		Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-s.age),
+		NotAfter:     time.Now().Add(s.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		return acmeOrder{}, err
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})...)
+	s.certs[orderID] = chain
+
+	order.Status = acme.StatusValid
+	order.Certificate = s.URL + "/cert/" + orderID
+	return *order, nil
+}
+
+func (s *acmeServer) getCert(w http.ResponseWriter, r *http.Request) {
+	_, _, err := s.verify(r)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	chain, ok := s.certs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		s.writeProblem(w, errors.New("unknown certificate"))
+		return
+	}
+	s.addNonce(w)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	_, _ = w.Write(chain)
+}
+
+// verify checks r's JWS, consuming its nonce, and returns its payload and the
+// account it is from. A JWS with an embedded key creates that account.
+func (s *acmeServer) verify(r *http.Request) ([]byte, string, error) {
+	jws := acmeJWS{}
+	err := json.NewDecoder(r.Body).Decode(&jws)
+	if err != nil {
+		return nil, "", err
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, "", err
+	}
+	header := acmeJWSHeader{}
+	err = json.Unmarshal(protected, &header)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.nonces[header.Nonce]; !ok {
+		return nil, "", errors.New("bad nonce")
+	}
+	delete(s.nonces, header.Nonce)
+	if header.URL != s.URL+r.URL.Path {
+		return nil, "", errors.New("jws url " + header.URL + " does not match request")
+	}
+
+	account := header.KID
+	key := s.accounts[account]
+	if header.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(header.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(header.JWK.Y)
+		key = &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		thumbprint, err := acme.JWKThumbprint(key)
+		if err != nil {
+			return nil, "", err
+		}
+		account = s.URL + "/account/" + thumbprint
+		s.accounts[account] = key
+	}
+	if key == nil || header.Alg != "ES256" {
+		return nil, "", errors.New("unknown account or algorithm")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil || len(sig) != 64 {
+		return nil, "", errors.New("malformed signature")
+	}
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	r1, s1 := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(key, digest[:], r1, s1) {
+		return nil, "", errors.New("invalid signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, account, nil
+}
+
+// newID must be called with mu held.
+func (s *acmeServer) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+func (s *acmeServer) addNonce(w http.ResponseWriter) {
+	nonce := rand.Text()
+	s.mu.Lock()
+	s.nonces[nonce] = struct{}{}
+	s.mu.Unlock()
+	w.Header().Set("Replay-Nonce", nonce)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (s *acmeServer) writeJSON(w http.ResponseWriter, status int, location string, out any) {
+	s.addNonce(w)
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (s *acmeServer) writeProblem(w http.ResponseWriter, err error) {
+	problem := "urn:ietf:params:acme:error:malformed"
+	if err.Error() == "bad nonce" {
+		problem = "urn:ietf:params:acme:error:badNonce"
+	}
+	s.addNonce(w)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"type": problem, "detail": err.Error()})
+}
@@ -0,0 +1,269 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/engine"
+)
+
+const (
+	RequestIDHeader  = "X-Request-Id"
+	RetryAfterHeader = "Retry-After"
+)
+
+type ErrorCode string
+
+const (
+	ErrorCodeBadRequest       ErrorCode = "bad_request"
+	ErrorCodeUnauthorized     ErrorCode = "unauthorized"
+	ErrorCodeForbidden        ErrorCode = "forbidden"
+	ErrorCodeUnknownKey       ErrorCode = "unknown_key"
+	ErrorCodeInvalidChallenge ErrorCode = "invalid_challenge"
+	ErrorCodePayloadTooLarge  ErrorCode = "payload_too_large"
+	ErrorCodeEvaluation       ErrorCode = "evaluation_failed"
+	ErrorCodeUpstream         ErrorCode = "upstream_failed"
+	ErrorCodeUpstreamTimeout  ErrorCode = "upstream_timeout"
+	ErrorCodeUpstreamTooLarge ErrorCode = "upstream_too_large"
+	ErrorCodeAttestation      ErrorCode = "attestation_failed"
+	ErrorCodeRateLimited      ErrorCode = "rate_limited"
+	ErrorCodeOverloaded       ErrorCode = "overloaded"
+	ErrorCodeInternal         ErrorCode = "internal"
+)
+
+func (c ErrorCode) Status() int {
+	switch c {
+	case ErrorCodeBadRequest:
+		return http.StatusBadRequest
+	case ErrorCodeUnauthorized:
+		return http.StatusUnauthorized
+	case ErrorCodeForbidden:
+		return http.StatusForbidden
+	case ErrorCodeUnknownKey:
+		return http.StatusConflict
+	case ErrorCodeInvalidChallenge:
+		return http.StatusBadRequest
+	case ErrorCodePayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrorCodeEvaluation:
+		return http.StatusUnprocessableEntity
+	case ErrorCodeUpstream:
+		return http.StatusBadGateway
+	case ErrorCodeUpstreamTimeout:
+		return http.StatusGatewayTimeout
+	case ErrorCodeUpstreamTooLarge:
+		return http.StatusBadGateway
+	case ErrorCodeAttestation:
+		return http.StatusServiceUnavailable
+	case ErrorCodeRateLimited:
+		return http.StatusTooManyRequests
+	case ErrorCodeOverloaded:
+		return http.StatusServiceUnavailable
+	case ErrorCodeInternal:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (c ErrorCode) Retryable() bool {
+	switch c {
+	case ErrorCodeUpstream,
+		ErrorCodeUpstreamTimeout,
+		ErrorCodeAttestation,
+		ErrorCodeRateLimited,
+		ErrorCodeOverloaded:
+		return true
+	case ErrorCodeBadRequest,
+		ErrorCodeUnauthorized,
+		ErrorCodeForbidden,
+		ErrorCodeUnknownKey,
+		ErrorCodeInvalidChallenge,
+		ErrorCodePayloadTooLarge,
+		ErrorCodeEvaluation,
+		ErrorCodeUpstreamTooLarge,
+		ErrorCodeInternal:
+		return false
+	default:
+		return false
+	}
+}
+
+// Err returns the sentinel error for c so that callers can use errors.Is
+// against an APIError, including one decoded from a response body.
+func (c ErrorCode) Err() error {
+	switch c {
+	case ErrorCodeBadRequest:
+		return ErrAPIBadRequest
+	case ErrorCodeUnauthorized:
+		return ErrAPIUnauthorized
+	case ErrorCodeForbidden:
+		return ErrAPIForbidden
+	case ErrorCodeUnknownKey:
+		return ErrAPIUnknownKey
+	case ErrorCodeInvalidChallenge:
+		return ErrAPIInvalidChallenge
+	case ErrorCodePayloadTooLarge:
+		return ErrAPIPayloadTooLarge
+	case ErrorCodeEvaluation:
+		return ErrAPIEvaluation
+	case ErrorCodeUpstream:
+		return ErrAPIUpstream
+	case ErrorCodeUpstreamTimeout:
+		return ErrAPIUpstreamTimeout
+	case ErrorCodeUpstreamTooLarge:
+		return ErrAPIUpstreamTooLarge
+	case ErrorCodeAttestation:
+		return ErrAPIAttestation
+	case ErrorCodeRateLimited:
+		return ErrAPIRateLimited
+	case ErrorCodeOverloaded:
+		return ErrAPIOverloaded
+	case ErrorCodeInternal:
+		return ErrAPIInternal
+	default:
+		return ErrAPIInternal
+	}
+}
+
+type APIError struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	Retryable bool      `json:"retryable"`
+	RequestID string    `json:"request_id,omitempty"`
+
+	// RetryAfter is sent in the Retry-After header rather than the body.
+	RetryAfter time.Duration `json:"-"`
+	cause      error
+}
+
+func NewAPIError(code ErrorCode, msg string, err error) *APIError {
+	message := msg
+	switch {
+	case msg == "" && err != nil:
+		message = err.Error()
+	case msg != "" && err != nil:
+		message = msg + ": " + err.Error()
+	}
+	return &APIError{
+		Code:      code,
+		Message:   message,
+		Retryable: code.Retryable(),
+		cause:     err,
+	}
+}
+
+func (e *APIError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *APIError) Unwrap() []error {
+	if e.cause == nil {
+		return []error{e.Code.Err()}
+	}
+	return []error{e.Code.Err(), e.cause}
+}
+
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// AsAPIError returns the APIError in err's chain, or wraps err as an internal
+// error if there is none.
+func AsAPIError(err error) *APIError {
+	apiErr := &APIError{}
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return NewAPIError(ErrorCodeInternal, "", err)
+}
+
+func WriteError(w http.ResponseWriter, err error) {
+	apiErr := *AsAPIError(err)
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = w.Header().Get(RequestIDHeader)
+	}
+
+	data, marshalErr := json.Marshal(ErrorResponse{Error: &apiErr})
+	if marshalErr != nil {
+		http.Error(w, apiErr.Error(), apiErr.Code.Status())
+		return
+	}
+
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set(RetryAfterHeader, formatRetryAfter(apiErr.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Code.Status())
+	_, _ = w.Write(data)
+}
+
+func badRequestError(msg string, err error) error {
+	maxBytesErr := &http.MaxBytesError{}
+	if errors.As(err, &maxBytesErr) || errors.Is(err, ErrPayloadTooLarge) {
+		return NewAPIError(ErrorCodePayloadTooLarge, msg, err)
+	}
+	return NewAPIError(ErrorCodeBadRequest, msg, err)
+}
+
+func unauthorizedError(msg string, err error) error {
+	return NewAPIError(ErrorCodeUnauthorized, msg, err)
+}
+
+func attestationError(msg string, err error) error {
+	return NewAPIError(ErrorCodeAttestation, msg, err)
+}
+
+func internalError(msg string, err error) error {
+	return NewAPIError(ErrorCodeInternal, msg, err)
+}
+
+// evaluationError classifies an engine error. Compile errors are bad requests,
+// and every other failure, including running past the handler's timeout, is
+// an evaluation error. An expression that times out would time out again, so
+// it is not retryable, unlike an upstream timeout.
+func evaluationError(msg string, err error) error {
+	if errors.Is(err, engine.ErrEngineCompile) {
+		return NewAPIError(ErrorCodeBadRequest, msg, err)
+	}
+	return NewAPIError(ErrorCodeEvaluation, msg, err)
+}
+
+func upstreamError(msg string, err error) error {
+	switch {
+	case errors.Is(err, ErrEgressDenied):
+		return NewAPIError(ErrorCodeForbidden, msg, err)
+	case errors.Is(err, ErrPayloadTooLarge):
+		return NewAPIError(ErrorCodeUpstreamTooLarge, msg, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return NewAPIError(ErrorCodeUpstreamTimeout, msg, err)
+	default:
+		return NewAPIError(ErrorCodeUpstream, msg, err)
+	}
+}
+
+// formatRetryAfter rounds d up to whole seconds, since Retry-After does not
+// allow fractions.
+func formatRetryAfter(d time.Duration) string {
+	seconds := (d + time.Second - 1) / time.Second
+	return strconv.FormatInt(int64(seconds), 10)
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP
+// date. It reports false if the header is missing or malformed.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,68 @@
+package networking_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteError(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		requestID := "request-id"
+		recorder := httptest.NewRecorder()
+		recorder.Header().Set(networking.RequestIDHeader, requestID)
+		apiErr := networking.NewAPIError(networking.ErrorCodeUpstreamTimeout, "sending request", assert.AnError)
+
+		// when
+		networking.WriteError(recorder, apiErr)
+
+		// then
+		assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+		response := networking.ErrorResponse{}
+		err := json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+		assert.Equal(t, networking.ErrorCodeUpstreamTimeout, response.Error.Code)
+		assert.Equal(t, "sending request: "+assert.AnError.Error(), response.Error.Message)
+		assert.True(t, response.Error.Retryable)
+		assert.Equal(t, requestID, response.Error.RequestID)
+	})
+
+	t.Run("happy path - untyped error", func(t *testing.T) {
+		// given
+		recorder := httptest.NewRecorder()
+
+		// when
+		networking.WriteError(recorder, assert.AnError)
+
+		// then
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+
+		response := networking.ErrorResponse{}
+		err := json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+		assert.Equal(t, networking.ErrorCodeInternal, response.Error.Code)
+		assert.False(t, response.Error.Retryable)
+	})
+}
+
+func TestAPIError_Is(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		err := networking.NewAPIError(networking.ErrorCodeBadRequest, "decoding request", assert.AnError)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAPI)
+		require.ErrorIs(t, err, networking.ErrAPIBadRequest)
+		require.ErrorIs(t, err, assert.AnError)
+		assert.NotErrorIs(t, err, networking.ErrAPIInternal)
+	})
+}
@@ -0,0 +1,259 @@
+package networking
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/metrics"
+)
+
+const (
+	AuthKey             = "auth"
+	APIKeyHeader        = "X-Api-Key"
+	AuthKeyIDHeader     = "X-Bearclave-Key-Id"
+	AuthTimestampHeader = "X-Bearclave-Timestamp"
+	AuthSignatureHeader = "X-Bearclave-Signature"
+	DefaultMaxClockSkew = 5 * time.Minute
+
+	// ScopeAll allows a credential to call every path.
+	ScopeAll = "*"
+)
+
+type CredentialType string
+
+const (
+	CredentialTypeAPIKey CredentialType = "api_key"
+	CredentialTypeHMAC   CredentialType = "hmac"
+)
+
+// Credential is a client's API key or HMAC secret. API keys are sent as is in
+// the APIKeyHeader, whereas HMAC secrets never leave the client and are used
+// to sign each request. Scopes lists the API paths the credential may call.
+type Credential struct {
+	ID     string         `mapstructure:"id"`
+	Type   CredentialType `mapstructure:"type"`
+	Secret string         `mapstructure:"secret"`
+	Scopes []string       `mapstructure:"scopes"`
+}
+
+type AuthConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	Credentials  []Credential  `mapstructure:"credentials"`
+	PublicPaths  []string      `mapstructure:"public_paths"`
+	MaxClockSkew time.Duration `mapstructure:"max_clock_skew"`
+}
+
+func DefaultAuthConfig() AuthConfig {
+	return AuthConfig{
+		Enabled:      false,
+		Credentials:  []Credential{},
+		PublicPaths:  []string{HealthzPath, ReadyzPath, ACMEChallengePath},
+		MaxClockSkew: DefaultMaxClockSkew,
+	}
+}
+
+var authFailuresTotal = metrics.Default.NewCounter(
+	"bearclave_auth_failures_total",
+	"Requests rejected by authentication or authorization, by reason.",
+	"reason",
+)
+
+// Authenticator requires every request outside the public paths to carry a
+// valid API key or HMAC signature whose credential is scoped to the path.
+// Authenticated requests carry the credential ID as their client identity.
+type Authenticator struct {
+	config      AuthConfig
+	logger      *slog.Logger
+	credentials map[string]Credential
+	apiKeys     []Credential
+}
+
+func NewAuthenticator(config AuthConfig, logger *slog.Logger) (*Authenticator, error) {
+	credentials := make(map[string]Credential, len(config.Credentials))
+	apiKeys := []Credential{}
+	for _, cred := range config.Credentials {
+		switch {
+		case cred.ID == "":
+			return nil, authError("credential missing id", nil)
+		case cred.Secret == "":
+			return nil, authError("credential "+cred.ID+" missing secret", nil)
+		case cred.Type != CredentialTypeAPIKey && cred.Type != CredentialTypeHMAC:
+			return nil, authError("credential "+cred.ID+" has unknown type "+string(cred.Type), nil)
+		}
+		if _, ok := credentials[cred.ID]; ok {
+			return nil, authError("duplicate credential "+cred.ID, nil)
+		}
+
+		credentials[cred.ID] = cred
+		if cred.Type == CredentialTypeAPIKey {
+			apiKeys = append(apiKeys, cred)
+		}
+	}
+	return &Authenticator{
+		config:      config,
+		logger:      logger,
+		credentials: credentials,
+		apiKeys:     apiKeys,
+	}, nil
+}
+
+func (a *Authenticator) isPublicPath(path string) bool {
+	return matchesPath(a.config.PublicPaths, path)
+}
+
+// matchesPath reports whether path is one of paths, or is under one that ends
+// in a slash.
+func matchesPath(paths []string, path string) bool {
+	return slices.ContainsFunc(paths, func(match string) bool {
+		if strings.HasSuffix(match, "/") {
+			return strings.HasPrefix(path, match)
+		}
+		return path == match
+	})
+}
+
+// Wrap authenticates requests to next. It returns next unchanged if
+// authentication is disabled.
+func (a *Authenticator) Wrap(next http.Handler) http.Handler {
+	if !a.config.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger := LoggerFromContext(r.Context(), a.logger)
+		cred, err := a.authenticate(r)
+		if err != nil {
+			apiErr := AsAPIError(err)
+			authFailuresTotal.With(string(apiErr.Code)).Inc()
+			logger.Warn("authenticating request", slog.String("error", apiErr.Message))
+			WriteError(w, apiErr)
+			return
+		}
+
+		if !slices.Contains(cred.Scopes, ScopeAll) && !slices.Contains(cred.Scopes, r.URL.Path) {
+			authFailuresTotal.With(string(ErrorCodeForbidden)).Inc()
+			logger.Warn(
+				"credential not scoped to path",
+				slog.String("credential", cred.ID),
+				slog.String("path", r.URL.Path),
+			)
+			WriteError(w, NewAPIError(ErrorCodeForbidden, "credential not scoped to "+r.URL.Path, nil))
+			return
+		}
+
+		ctx := WithClientIdentity(r.Context(), cred.ID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (Credential, error) {
+	if apiKey := r.Header.Get(APIKeyHeader); apiKey != "" {
+		return a.authenticateAPIKey(apiKey)
+	}
+	if r.Header.Get(AuthSignatureHeader) != "" {
+		return a.authenticateSignature(r)
+	}
+	return Credential{}, unauthorizedError("missing credentials", nil)
+}
+
+// authenticateAPIKey compares apiKey against every API key in constant time,
+// so the response time does not reveal how close a guess was.
+func (a *Authenticator) authenticateAPIKey(apiKey string) (Credential, error) {
+	var match *Credential
+	for i, cred := range a.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(cred.Secret), []byte(apiKey)) == 1 {
+			match = &a.apiKeys[i]
+		}
+	}
+	if match == nil {
+		return Credential{}, unauthorizedError("invalid api key", nil)
+	}
+	return *match, nil
+}
+
+func (a *Authenticator) authenticateSignature(r *http.Request) (Credential, error) {
+	cred, ok := a.credentials[r.Header.Get(AuthKeyIDHeader)]
+	if !ok || cred.Type != CredentialTypeHMAC {
+		return Credential{}, unauthorizedError("unknown key id", nil)
+	}
+
+	timestamp := r.Header.Get(AuthTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Credential{}, unauthorizedError("invalid timestamp", err)
+	}
+	skew := time.Since(time.Unix(seconds, 0)).Abs()
+	if skew > a.config.MaxClockSkew {
+		return Credential{}, unauthorizedError("timestamp outside allowed clock skew", nil)
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(AuthSignatureHeader))
+	if err != nil {
+		return Credential{}, unauthorizedError("invalid signature encoding", err)
+	}
+
+	// Read the body to digest it, then restore it for the next handler. Any
+	// request body limit still applies.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return Credential{}, badRequestError("reading request body", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	want := signRequest([]byte(cred.Secret), r.Method, requestTarget(r), timestamp, body)
+	if !hmac.Equal(signature, want) {
+		return Credential{}, unauthorizedError("invalid signature", nil)
+	}
+	return cred, nil
+}
+
+// SignRequest adds an HMAC-SHA256 signature over req's method, target,
+// timestamp, and body digest. The body must be passed separately since req's
+// body can only be read once.
+func SignRequest(req *http.Request, keyID string, secret []byte, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := signRequest(secret, req.Method, requestTarget(req), timestamp, body)
+	req.Header.Set(AuthKeyIDHeader, keyID)
+	req.Header.Set(AuthTimestampHeader, timestamp)
+	req.Header.Set(AuthSignatureHeader, hex.EncodeToString(signature))
+}
+
+// signRequest signs the newline-separated method, target, timestamp, and
+// hex-encoded SHA-256 digest of body.
+func signRequest(
+	secret []byte,
+	method string,
+	target string,
+	timestamp string,
+	body []byte,
+) []byte {
+	bodyDigest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + target + "\n" + timestamp + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyDigest[:])))
+	return mac.Sum(nil)
+}
+
+// requestTarget is the escaped path and query, which the reverse proxy
+// forwards unchanged.
+func requestTarget(r *http.Request) string {
+	target := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	return target
+}
@@ -0,0 +1,326 @@
+package networking_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/metrics"
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeAuthConfig() networking.AuthConfig {
+	config := networking.DefaultAuthConfig()
+	config.Enabled = true
+	config.Credentials = []networking.Credential{
+		{
+			ID:     "alice",
+			Type:   networking.CredentialTypeHMAC,
+			Secret: "alice secret",
+			Scopes: []string{networking.AttestCELPath},
+		},
+		{
+			ID:     "bob",
+			Type:   networking.CredentialTypeAPIKey,
+			Secret: "bob secret",
+			Scopes: []string{networking.ScopeAll},
+		},
+	}
+	return config
+}
+
+// identityHandler echoes the client identity and request body.
+func identityHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(networking.ClientIdentityFromContext(r.Context()) + ":" + string(body)))
+	})
+}
+
+func makeAuthRequest(path string, body string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+
+	t.Run("happy path", func(t *testing.T) {
+		// when
+		_, err := networking.NewAuthenticator(makeAuthConfig(), logger)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - invalid credentials", func(t *testing.T) {
+		tests := map[string]networking.Credential{
+			"missing id":     {Type: networking.CredentialTypeHMAC, Secret: "secret"},
+			"missing secret": {ID: "carol", Type: networking.CredentialTypeHMAC},
+			"unknown type":   {ID: "carol", Type: "password", Secret: "secret"},
+			"duplicate id":   {ID: "alice", Type: networking.CredentialTypeHMAC, Secret: "secret"},
+		}
+		for name, cred := range tests {
+			t.Run(name, func(t *testing.T) {
+				// given
+				config := makeAuthConfig()
+				config.Credentials = append(config.Credentials, cred)
+
+				// when
+				_, err := networking.NewAuthenticator(config, logger)
+
+				// then
+				require.ErrorIs(t, err, networking.ErrAuth)
+			})
+		}
+	})
+}
+
+func TestAuthenticator_Wrap(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	authenticator, err := networking.NewAuthenticator(makeAuthConfig(), logger)
+	require.NoError(t, err)
+	handler := authenticator.Wrap(identityHandler())
+
+	t.Run("happy path - signed request", func(t *testing.T) {
+		// given
+		body := `{"expression":"true"}`
+		req := makeAuthRequest(networking.AttestCELPath, body)
+		networking.SignRequest(req, "alice", []byte("alice secret"), []byte(body), time.Now())
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "alice:"+body, recorder.Body.String())
+	})
+
+	t.Run("happy path - api key", func(t *testing.T) {
+		// given
+		req := makeAuthRequest(networking.AttestHTTPCallPath, "")
+		req.Header.Set(networking.APIKeyHeader, "bob secret")
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "bob:", recorder.Body.String())
+	})
+
+	t.Run("happy path - public path", func(t *testing.T) {
+		// given
+		req := httptest.NewRequest(http.MethodGet, networking.ReadyzPath, nil)
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("happy path - public path prefix", func(t *testing.T) {
+		// given
+		req := httptest.NewRequest(http.MethodGet, networking.ACMEChallengePath+"token", nil)
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("happy path - disabled", func(t *testing.T) {
+		// given
+		disabled, err := networking.NewAuthenticator(networking.DefaultAuthConfig(), logger)
+		require.NoError(t, err)
+		req := makeAuthRequest(networking.AttestCELPath, "")
+		recorder := httptest.NewRecorder()
+
+		// when
+		disabled.Wrap(identityHandler()).ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("error - metrics not public", func(t *testing.T) {
+		// given
+		req := httptest.NewRequest(http.MethodGet, metrics.Path, nil)
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("error - unauthorized", func(t *testing.T) {
+		body := `{"expression":"true"}`
+		tests := map[string]func(req *http.Request){
+			"missing credentials": func(*http.Request) {},
+			"invalid api key": func(req *http.Request) {
+				req.Header.Set(networking.APIKeyHeader, "bob secreT")
+			},
+			"api key used as hmac secret": func(req *http.Request) {
+				networking.SignRequest(req, "bob", []byte("bob secret"), []byte(body), time.Now())
+			},
+			"hmac secret used as api key": func(req *http.Request) {
+				req.Header.Set(networking.APIKeyHeader, "alice secret")
+			},
+			"unknown key id": func(req *http.Request) {
+				networking.SignRequest(req, "carol", []byte("alice secret"), []byte(body), time.Now())
+			},
+			"wrong secret": func(req *http.Request) {
+				networking.SignRequest(req, "alice", []byte("wrong"), []byte(body), time.Now())
+			},
+			"tampered body": func(req *http.Request) {
+				networking.SignRequest(req, "alice", []byte("alice secret"), []byte(`{}`), time.Now())
+			},
+			"tampered method": func(req *http.Request) {
+				networking.SignRequest(req, "alice", []byte("alice secret"), []byte(body), time.Now())
+				req.Method = http.MethodPut
+			},
+			"stale timestamp": func(req *http.Request) {
+				then := time.Now().Add(-networking.DefaultMaxClockSkew - time.Minute)
+				networking.SignRequest(req, "alice", []byte("alice secret"), []byte(body), then)
+			},
+			"future timestamp": func(req *http.Request) {
+				then := time.Now().Add(networking.DefaultMaxClockSkew + time.Minute)
+				networking.SignRequest(req, "alice", []byte("alice secret"), []byte(body), then)
+			},
+		}
+		for name, prepare := range tests {
+			t.Run(name, func(t *testing.T) {
+				// given
+				req := makeAuthRequest(networking.AttestCELPath, body)
+				prepare(req)
+				recorder := httptest.NewRecorder()
+
+				// when
+				handler.ServeHTTP(recorder, req)
+
+				// then
+				assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+				assert.Equal(t, networking.ErrorCodeUnauthorized, decodeAPIError(t, recorder).Code)
+			})
+		}
+	})
+
+	t.Run("error - path outside scopes", func(t *testing.T) {
+		// given
+		req := makeAuthRequest(networking.AttestHTTPCallPath, "")
+		networking.SignRequest(req, "alice", []byte("alice secret"), nil, time.Now())
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		assert.Equal(t, networking.ErrorCodeForbidden, decodeAPIError(t, recorder).Code)
+	})
+}
+
+func TestClient_Do_Credential(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	authenticator, err := networking.NewAuthenticator(makeAuthConfig(), logger)
+	require.NoError(t, err)
+
+	var identity string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = networking.ClientIdentityFromContext(r.Context())
+		writeResponse(t, w, doResponse{Data: []byte("ok")})
+	})
+	server := httptest.NewServer(authenticator.Wrap(handler))
+	defer server.Close()
+
+	t.Run("happy path - signs requests", func(t *testing.T) {
+		// given
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientCredential(networking.Credential{
+				ID:     "alice",
+				Type:   networking.CredentialTypeHMAC,
+				Secret: "alice secret",
+			}),
+		)
+
+		// when
+		resp := doResponse{}
+		err := client.Do(context.Background(), http.MethodPost, networking.AttestCELPath, doRequest{}, &resp)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "alice", identity)
+	})
+
+	t.Run("happy path - sends api key", func(t *testing.T) {
+		// given
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientCredential(networking.Credential{
+				ID:     "bob",
+				Type:   networking.CredentialTypeAPIKey,
+				Secret: "bob secret",
+			}),
+		)
+
+		// when
+		resp := doResponse{}
+		err := client.Do(context.Background(), http.MethodPost, networking.AttestExprPath, doRequest{}, &resp)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "bob", identity)
+	})
+
+	t.Run("error - no credential", func(t *testing.T) {
+		// given
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientCredential(networking.Credential{}),
+		)
+
+		// when
+		err := client.Do(context.Background(), http.MethodPost, networking.AttestCELPath, doRequest{}, &doResponse{})
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAPIUnauthorized)
+	})
+
+	t.Run("error - out of scope", func(t *testing.T) {
+		// given
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientCredential(networking.Credential{
+				ID:     "alice",
+				Type:   networking.CredentialTypeHMAC,
+				Secret: "alice secret",
+			}),
+		)
+
+		// when
+		err := client.Do(context.Background(), http.MethodPost, networking.AttestHTTPCallPath, doRequest{}, &doResponse{})
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAPIForbidden)
+	})
+}
@@ -0,0 +1,241 @@
+package networking
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+const (
+	DefaultBatchWindow  = 10 * time.Millisecond
+	DefaultBatchMaxSize = 256
+	BatchKey            = "batch"
+	batchRootPrefix     = "merkle-sha256:"
+)
+
+type BatchConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Window  time.Duration `mapstructure:"window"`
+	MaxSize int           `mapstructure:"max_size"`
+}
+
+func DefaultBatchConfig() BatchConfig {
+	return BatchConfig{
+		Enabled: false,
+		Window:  DefaultBatchWindow,
+		MaxSize: DefaultBatchMaxSize,
+	}
+}
+
+// BatchAttester coalesces attestation requests that arrive within a short
+// window. Each request's nonce and payload become a leaf in a Merkle tree, a
+// single attestation covers the tree's root, and each caller gets back the
+// shared attestation along with an inclusion proof for its own leaf.
+//
+// Since the attestation is shared, the per-request nonce is bound by the leaf
+// rather than by the platform's nonce field. Verifiers must check freshness
+// with VerifyBatchInclusion instead of tee.WithVerifyNonce.
+type BatchAttester struct {
+	attester *tee.Attester
+	window   time.Duration
+	maxSize  int
+
+	mu      sync.Mutex
+	pending []*batchRequest
+	batchID uint64
+}
+
+type batchRequest struct {
+	leaf []byte
+	done chan batchResult
+}
+
+type batchResult struct {
+	attestation *tee.AttestResult
+	proof       *MerkleProof
+	err         error
+}
+
+func NewBatchAttester(attester *tee.Attester, options ...BatchOption) *BatchAttester {
+	opts := MakeDefaultBatchOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+	return &BatchAttester{
+		attester: attester,
+		window:   opts.Window,
+		maxSize:  opts.MaxSize,
+	}
+}
+
+// Attest adds nonce and payload to the current batch and blocks until the
+// batch has been attested or ctx is done.
+func (b *BatchAttester) Attest(
+	ctx context.Context,
+	nonce []byte,
+	payload []byte,
+) (*tee.AttestResult, *MerkleProof, error) {
+	req := &batchRequest{
+		leaf: BatchLeaf(nonce, payload),
+		done: make(chan batchResult, 1),
+	}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, req)
+	switch {
+	case len(b.pending) >= b.maxSize:
+		batch := b.takeLocked()
+		b.mu.Unlock()
+		b.flush(batch)
+	case len(b.pending) == 1:
+		batchID := b.batchID
+		time.AfterFunc(b.window, func() { b.flushBatch(batchID) })
+		b.mu.Unlock()
+	default:
+		b.mu.Unlock()
+	}
+
+	select {
+	case res := <-req.done:
+		return res.attestation, res.proof, res.err
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// AttestPayload implements PayloadAttester. The batch root is already a
+// commitment to every payload in the batch, so commitment is ignored and the
+// payload is always returned next to the attestation.
+func (b *BatchAttester) AttestPayload(
+	ctx context.Context,
+	nonce []byte,
+	payload []byte,
+	_ DigestAlgorithm,
+) (AttestResponse, error) {
+	attestation, proof, err := b.Attest(ctx, nonce, payload)
+	if err != nil {
+		return AttestResponse{}, err
+	}
+	return AttestResponse{Attestation: attestation, Payload: payload, Proof: proof}, nil
+}
+
+// flushBatch attests the pending batch if it is still batchID. The batch may
+// already have been flushed early because it reached the max size.
+func (b *BatchAttester) flushBatch(batchID uint64) {
+	b.mu.Lock()
+	if b.batchID != batchID {
+		b.mu.Unlock()
+		return
+	}
+	batch := b.takeLocked()
+	b.mu.Unlock()
+	b.flush(batch)
+}
+
+func (b *BatchAttester) takeLocked() []*batchRequest {
+	batch := b.pending
+	b.pending = nil
+	b.batchID++
+	return batch
+}
+
+func (b *BatchAttester) flush(batch []*batchRequest) {
+	leaves := make([][]byte, len(batch))
+	for i, req := range batch {
+		leaves[i] = req.leaf
+	}
+	tree := NewMerkleTree(leaves)
+
+	attestation, err := b.attester.Attest(
+		tee.WithAttestUserData(batchRootUserData(tree.Root())),
+	)
+	for i, req := range batch {
+		if err != nil {
+			req.done <- batchResult{err: err}
+			continue
+		}
+		proof, proofErr := tree.Proof(i)
+		req.done <- batchResult{attestation: attestation, proof: proof, err: proofErr}
+	}
+}
+
+// BatchLeaf binds nonce to payload. The nonce is length-prefixed so that no
+// two (nonce, payload) pairs share a leaf.
+func BatchLeaf(nonce []byte, payload []byte) []byte {
+	leaf := make([]byte, 0, 8+len(nonce)+len(payload))
+	leaf = binary.BigEndian.AppendUint64(leaf, uint64(len(nonce)))
+	leaf = append(leaf, nonce...)
+	return append(leaf, payload...)
+}
+
+// VerifyBatchInclusion checks that nonce and payload are a leaf of the batch
+// whose root is attested in userData.
+func VerifyBatchInclusion(
+	userData []byte,
+	nonce []byte,
+	payload []byte,
+	proof *MerkleProof,
+) error {
+	if proof == nil {
+		return merkleProofError("missing proof", nil)
+	}
+
+	rootHex, found := strings.CutPrefix(string(userData), batchRootPrefix)
+	if !found {
+		return merkleProofError("user data is not a batch root", nil)
+	}
+	root, err := hex.DecodeString(rootHex)
+	if err != nil {
+		return merkleProofError("decoding batch root", err)
+	}
+	return proof.Verify(BatchLeaf(nonce, payload), root)
+}
+
+// AttestedBatchPayload returns payload if it and nonce are included in the
+// batch root attested by verified.
+func AttestedBatchPayload(
+	verified *tee.VerifyResult,
+	nonce []byte,
+	payload []byte,
+	proof *MerkleProof,
+) ([]byte, error) {
+	err := VerifyBatchInclusion(verified.UserData, nonce, payload, proof)
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func batchRootUserData(root []byte) []byte {
+	return []byte(batchRootPrefix + hex.EncodeToString(root))
+}
+
+type BatchOption func(*BatchOptions)
+type BatchOptions struct {
+	Window  time.Duration
+	MaxSize int
+}
+
+func WithBatchMaxSize(maxSize int) BatchOption {
+	return func(opts *BatchOptions) {
+		opts.MaxSize = maxSize
+	}
+}
+
+func WithBatchWindow(window time.Duration) BatchOption {
+	return func(opts *BatchOptions) {
+		opts.Window = window
+	}
+}
+
+func MakeDefaultBatchOptions() BatchOptions {
+	return BatchOptions{
+		Window:  DefaultBatchWindow,
+		MaxSize: DefaultBatchMaxSize,
+	}
+}
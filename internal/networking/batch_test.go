@@ -0,0 +1,122 @@
+package networking_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestBatchAttester_Attest(t *testing.T) {
+	t.Run("happy path - batch fills up", func(t *testing.T) {
+		// given
+		size := 4
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+
+		batcher := networking.NewBatchAttester(
+			attester,
+			networking.WithBatchWindow(time.Hour),
+			networking.WithBatchMaxSize(size),
+		)
+
+		type result struct {
+			nonce       []byte
+			payload     []byte
+			attestation *tee.AttestResult
+			proof       *networking.MerkleProof
+			err         error
+		}
+		results := make([]result, size)
+
+		// when
+		var wg sync.WaitGroup
+		for i := range size {
+			wg.Go(func() {
+				nonce := fmt.Appendf(nil, "nonce %d", i)
+				payload := fmt.Appendf(nil, "payload %d", i)
+				att, proof, err := batcher.Attest(context.Background(), nonce, payload)
+				results[i] = result{nonce, payload, att, proof, err}
+			})
+		}
+		wg.Wait()
+
+		// then
+		for _, res := range results {
+			require.NoError(t, res.err)
+			assert.Same(t, results[0].attestation, res.attestation)
+			assert.Equal(t, size, res.proof.TreeSize)
+
+			verified, err := verifier.Verify(res.attestation)
+			require.NoError(t, err)
+
+			got, err := networking.AttestedBatchPayload(verified, res.nonce, res.payload, res.proof)
+			require.NoError(t, err)
+			assert.Equal(t, res.payload, got)
+		}
+	})
+
+	t.Run("happy path - window elapses", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		batcher := networking.NewBatchAttester(
+			attester,
+			networking.WithBatchWindow(time.Millisecond),
+		)
+		nonce := []byte("nonce")
+		payload := []byte("payload")
+
+		// when
+		att, proof, err := batcher.Attest(context.Background(), nonce, payload)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, proof.TreeSize)
+		require.NoError(t, networking.VerifyBatchInclusion(att.UserData, nonce, payload, proof))
+	})
+
+	t.Run("error - nonce not in batch", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		batcher := networking.NewBatchAttester(attester, networking.WithBatchMaxSize(1))
+		payload := []byte("payload")
+
+		att, proof, err := batcher.Attest(context.Background(), []byte("nonce"), payload)
+		require.NoError(t, err)
+
+		// when
+		err = networking.VerifyBatchInclusion(att.UserData, []byte("other nonce"), payload, proof)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrMerkleProof)
+	})
+
+	t.Run("error - context canceled", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		batcher := networking.NewBatchAttester(attester, networking.WithBatchWindow(time.Hour))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// when
+		_, _, err = batcher.Attest(ctx, []byte("nonce"), []byte("payload"))
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,312 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// surrogateLow is the first low (trailing) UTF-16 surrogate.
+const surrogateLow = 0xdc00
+
+// CanonicalizeJSON rewrites data in the JSON Canonicalization Scheme of
+// RFC 8785: no whitespace, object members sorted by the UTF-16 code units of
+// their names, strings with only the mandatory escapes, and numbers formatted
+// as ECMAScript would format the nearest IEEE 754 double. Any conforming
+// implementation, in any language, produces the same bytes for the same JSON
+// value, so a verifier can re-derive an attested payload from its contents.
+//
+// Duplicate member names are rejected, as are integers that a double cannot
+// represent exactly, since canonicalizing them would silently change the
+// value. Send such integers as strings. Strings with invalid UTF-8 or unpaired
+// surrogate escapes are rejected for the same reason.
+func CanonicalizeJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	buf := &bytes.Buffer{}
+	err := canonicalizeValue(decoder, data, buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, canonicalJSONError("trailing data after value", nil)
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalCanonicalJSON marshals v with encoding/json and canonicalizes the
+// result.
+func MarshalCanonicalJSON(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, canonicalJSONError("marshaling", err)
+	}
+	return CanonicalizeJSON(data)
+}
+
+// VerifyCanonicalJSON checks that userData, taken from a verified
+// attestation, is the canonical JSON of v or a commitment to it. A verifier
+// that knows what the Enclave should have attested can rebuild v and compare
+// without trusting the payload bytes the Enclave sent alongside.
+func VerifyCanonicalJSON(userData []byte, v any) error {
+	payload, err := MarshalCanonicalJSON(v)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(userData, payload) {
+		return nil
+	}
+
+	err = VerifyCommitment(userData, payload)
+	if err != nil {
+		return attestedPayloadErrorMismatch("user data is not the canonical json of value", err)
+	}
+	return nil
+}
+
+func canonicalizeValue(decoder *json.Decoder, data []byte, buf *bytes.Buffer) error {
+	start := decoder.InputOffset()
+	token, err := decoder.Token()
+	if err != nil {
+		return canonicalJSONError("reading token", err)
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '[' {
+			return canonicalizeArray(decoder, data, buf)
+		}
+		return canonicalizeObject(decoder, data, buf)
+	case string:
+		err = checkRawString(data[start:decoder.InputOffset()])
+		if err != nil {
+			return err
+		}
+		writeCanonicalString(buf, token)
+	case json.Number:
+		number, err := canonicalNumber(token)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(number)
+	case bool:
+		buf.WriteString(strconv.FormatBool(token))
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func canonicalizeArray(decoder *json.Decoder, data []byte, buf *bytes.Buffer) error {
+	buf.WriteByte('[')
+	for i := 0; decoder.More(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		err := canonicalizeValue(decoder, data, buf)
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	_, err := decoder.Token()
+	if err != nil {
+		return canonicalJSONError("reading array end", err)
+	}
+	return nil
+}
+
+func canonicalizeObject(decoder *json.Decoder, data []byte, buf *bytes.Buffer) error {
+	type member struct {
+		name  string
+		key   []uint16
+		value []byte
+	}
+
+	members := []member{}
+	seen := map[string]struct{}{}
+	for decoder.More() {
+		start := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err != nil {
+			return canonicalJSONError("reading member name", err)
+		}
+		err = checkRawString(data[start:decoder.InputOffset()])
+		if err != nil {
+			return err
+		}
+		name, _ := token.(string)
+		if _, ok := seen[name]; ok {
+			return canonicalJSONError("duplicate member "+strconv.Quote(name), nil)
+		}
+		seen[name] = struct{}{}
+
+		value := &bytes.Buffer{}
+		err = canonicalizeValue(decoder, data, value)
+		if err != nil {
+			return err
+		}
+		members = append(members, member{
+			name:  name,
+			key:   utf16.Encode([]rune(name)),
+			value: value.Bytes(),
+		})
+	}
+	_, err := decoder.Token()
+	if err != nil {
+		return canonicalJSONError("reading object end", err)
+	}
+
+	slices.SortFunc(members, func(a, b member) int {
+		return slices.Compare(a.key, b.key)
+	})
+	buf.WriteByte('{')
+	for i, m := range members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeCanonicalString(buf, m.name)
+		buf.WriteByte(':')
+		buf.Write(m.value)
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// checkRawString checks a string token as it appears in the input, along with
+// any whitespace and separator before it. encoding/json replaces invalid UTF-8
+// and unpaired surrogate escapes with U+FFFD, so different inputs would
+// canonicalize to the same string.
+func checkRawString(raw []byte) error {
+	if !utf8.Valid(raw) {
+		return canonicalJSONError("string is not valid utf-8", nil)
+	}
+	raw = raw[bytes.IndexByte(raw, '"'):]
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			continue
+		}
+		i++
+		if raw[i] != 'u' {
+			continue
+		}
+		r := rawEscape(raw[i+1:])
+		i += 4
+		switch {
+		case !utf16.IsSurrogate(r):
+		case r < surrogateLow && isLowSurrogateEscape(raw[i+1:]):
+			i += 6
+		default:
+			return canonicalJSONError("string has an unpaired surrogate escape", nil)
+		}
+	}
+	return nil
+}
+
+// rawEscape decodes the four hex digits of a \u escape, which the decoder has
+// already checked.
+func rawEscape(digits []byte) rune {
+	r, _ := strconv.ParseUint(string(digits[:4]), 16, 16)
+	return rune(r)
+}
+
+func isLowSurrogateEscape(raw []byte) bool {
+	if len(raw) < 6 || raw[0] != '\\' || raw[1] != 'u' {
+		return false
+	}
+	r := rawEscape(raw[2:])
+	return r >= surrogateLow && utf16.IsSurrogate(r)
+}
+
+// writeCanonicalString escapes only '"', '\\' and control characters, using
+// the short escapes where JSON has them.
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[r>>4])
+				buf.WriteByte(hex[r&0xf])
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// canonicalNumber formats number the way ECMAScript's Number.prototype.toString
+// formats the nearest double.
+func canonicalNumber(number json.Number) (string, error) {
+	f, err := strconv.ParseFloat(string(number), 64)
+	if err != nil || math.IsInf(f, 0) {
+		return "", canonicalJSONError("number "+string(number)+" is not a double", err)
+	}
+	if !strings.ContainsAny(string(number), ".eE") {
+		exact, ok := new(big.Int).SetString(string(number), 10)
+		rounded, _ := big.NewFloat(f).Int(nil)
+		if !ok || rounded.Cmp(exact) != 0 {
+			return "", canonicalJSONError("integer "+string(number)+" is not exact as a double", nil)
+		}
+	}
+	if f == 0 {
+		return "0", nil
+	}
+
+	// Shortest round-tripping digits, as d.ddde±x.
+	sign := ""
+	if f < 0 {
+		sign, f = "-", -f
+	}
+	mantissa, exponent, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	exp, _ := strconv.Atoi(exponent)
+	k := len(digits)
+	n := exp + 1
+
+	switch {
+	case k <= n && n <= 21:
+		return sign + digits + strings.Repeat("0", n-k), nil
+	case 0 < n && n <= 21:
+		return sign + digits[:n] + "." + digits[n:], nil
+	case -6 < n && n <= 0:
+		return sign + "0." + strings.Repeat("0", -n) + digits, nil
+	}
+
+	expSign := "+"
+	if n-1 < 0 {
+		expSign = "-"
+	}
+	out := digits[:1]
+	if k > 1 {
+		out += "." + digits[1:]
+	}
+	return sign + out + "e" + expSign + strconv.Itoa(max(n-1, 1-n)), nil
+}
@@ -0,0 +1,213 @@
+package networking_test
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalizeJSON(t *testing.T) {
+	t.Run("happy path - rfc 8785 example", func(t *testing.T) {
+		// given
+		data := []byte(`{
+			"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+			"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+			"literals": [null, true, false]
+		}`)
+		want := `{"literals":[null,true,false],` +
+			`"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],` +
+			`"string":"€$\u000f\nA'B\"\\\\\"/"}`
+
+		// when
+		got, err := networking.CanonicalizeJSON(data)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("happy path - sorts by utf-16 code units", func(t *testing.T) {
+		// given
+		data := []byte(`{
+			"\u20ac": "Euro Sign",
+			"\r": "Carriage Return",
+			"\ufb33": "Hebrew Letter Dalet With Dagesh",
+			"1": "One",
+			"\ud83d\ude00": "Emoji: Grinning Face",
+			"\u0080": "Control",
+			"\u00f6": "Latin Small Letter O With Diaeresis"
+		}`)
+		want := "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\"," +
+			"\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\"," +
+			"\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}"
+
+		// when
+		got, err := networking.CanonicalizeJSON(data)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	})
+
+	t.Run("happy path - does not escape html", func(t *testing.T) {
+		// when
+		got, err := networking.MarshalCanonicalJSON(map[string]any{"b": "<&>", "a": 1})
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, `{"a":1,"b":"<&>"}`, string(got))
+	})
+
+	t.Run("error - duplicate member", func(t *testing.T) {
+		// when
+		_, err := networking.CanonicalizeJSON([]byte(`{"a":1,"a":2}`))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrCanonicalJSON)
+	})
+
+	t.Run("error - inexact integer", func(t *testing.T) {
+		// when
+		_, err := networking.CanonicalizeJSON([]byte(`{"id":9007199254740993}`))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrCanonicalJSON)
+	})
+
+	t.Run("happy path - surrogate pair", func(t *testing.T) {
+		// when
+		got, err := networking.CanonicalizeJSON([]byte(`{"\ud83d\ude00":"\ud83d\ude00"}`))
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "{\"\U0001F600\":\"\U0001F600\"}", string(got))
+	})
+
+	t.Run("happy path - escaped backslash before u", func(t *testing.T) {
+		// when
+		got, err := networking.CanonicalizeJSON([]byte(`["\\ud800"]`))
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, `["\\ud800"]`, string(got))
+	})
+
+	t.Run("error - lone surrogate", func(t *testing.T) {
+		// given
+		inputs := []string{
+			`{"a":"\ud800"}`,
+			`{"a":"\udc00"}`,
+			`{"a":"\ud800\u0041"}`,
+			`{"\ud800":1}`,
+			`["\\\ud800x"]`,
+		}
+
+		for _, input := range inputs {
+			// when
+			_, err := networking.CanonicalizeJSON([]byte(input))
+
+			// then
+			require.ErrorIs(t, err, networking.ErrCanonicalJSON, input)
+		}
+	})
+
+	t.Run("error - invalid utf-8", func(t *testing.T) {
+		// when
+		_, err := networking.CanonicalizeJSON([]byte("{\"a\":\"\xff\"}"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrCanonicalJSON)
+	})
+
+	t.Run("error - trailing data", func(t *testing.T) {
+		// when
+		_, err := networking.CanonicalizeJSON([]byte(`{} {}`))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrCanonicalJSON)
+	})
+}
+
+func TestCanonicalizeJSON_Numbers(t *testing.T) {
+	// Appendix B of RFC 8785.
+	tests := map[uint64]string{
+		0x0000000000000000: "0",
+		0x8000000000000000: "0",
+		0x0000000000000001: "5e-324",
+		0x8000000000000001: "-5e-324",
+		0x7fefffffffffffff: "1.7976931348623157e+308",
+		0xffefffffffffffff: "-1.7976931348623157e+308",
+		0x4340000000000000: "9007199254740992",
+		0xc340000000000000: "-9007199254740992",
+		0x4430000000000000: "295147905179352830000",
+		0x44b52d02c7e14af5: "9.999999999999997e+22",
+		0x44b52d02c7e14af6: "1e+23",
+		0x44b52d02c7e14af7: "1.0000000000000001e+23",
+		0x444b1ae4d6e2ef4e: "999999999999999700000",
+		0x444b1ae4d6e2ef4f: "999999999999999900000",
+		0x444b1ae4d6e2ef50: "1e+21",
+		0x3eb0c6f7a0b5ed8c: "9.999999999999997e-7",
+		0x3eb0c6f7a0b5ed8d: "0.000001",
+		0x41b3de4355555553: "333333333.3333332",
+		0x41b3de4355555554: "333333333.33333325",
+		0x41b3de4355555555: "333333333.3333333",
+		0x41b3de4355555556: "333333333.3333334",
+		0x41b3de4355555557: "333333333.33333343",
+		0xbecbf647612f3696: "-0.0000033333333333333333",
+		0x43143ff3c1cb0959: "1424953923781206.2",
+	}
+	for bits, want := range tests {
+		t.Run("happy path - "+want, func(t *testing.T) {
+			// given
+			data := strconv.FormatFloat(math.Float64frombits(bits), 'g', -1, 64)
+
+			// when
+			got, err := networking.CanonicalizeJSON([]byte(data))
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, want, string(got))
+		})
+	}
+}
+
+func TestVerifyCanonicalJSON(t *testing.T) {
+	value := networking.AttestedCEL{Expression: "1 + 1", Output: 2}
+	payload, err := networking.MarshalCanonicalJSON(value)
+	require.NoError(t, err)
+	commitment, err := networking.Commit(networking.DigestAlgorithmSHA256, payload)
+	require.NoError(t, err)
+
+	t.Run("happy path - payload", func(t *testing.T) {
+		// when
+		err := networking.VerifyCanonicalJSON(payload, value)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("happy path - commitment", func(t *testing.T) {
+		// when
+		err := networking.VerifyCanonicalJSON(commitment, value)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - different value", func(t *testing.T) {
+		// given
+		other := value
+		other.Output = 3
+
+		// when
+		err := networking.VerifyCanonicalJSON(payload, other)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAttestedPayloadMismatch)
+	})
+}
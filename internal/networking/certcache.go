@@ -0,0 +1,112 @@
+package networking
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCertCacheTTL = 5 * time.Minute
+	CertCacheKey        = "cert_cache"
+)
+
+type CertCacheConfig struct {
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+func DefaultCertCacheConfig() CertCacheConfig {
+	return CertCacheConfig{TTL: DefaultCertCacheTTL}
+}
+
+// CertAttestationCache caches cert chain attestations per leaf certificate
+// fingerprint. An entry is refreshed once its TTL has elapsed or as soon as the
+// CertProvider hands out a different certificate. Concurrent misses for the
+// same certificate share a single attestation. Only requests without a nonce
+// are served from the cache, since a nonce asks for a fresh attestation, so
+// clients opt in with WithClientCachedCertChain.
+type CertAttestationCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[certCacheKey]*certCacheEntry
+}
+
+type certCacheKey struct {
+	fingerprint string
+	commitment  DigestAlgorithm
+}
+
+type certCacheEntry struct {
+	ready     chan struct{}
+	resp      AttestResponse
+	expiresAt time.Time
+	err       error
+}
+
+func NewCertAttestationCache(ttl time.Duration) *CertAttestationCache {
+	return &CertAttestationCache{
+		ttl:     ttl,
+		entries: map[certCacheKey]*certCacheEntry{},
+	}
+}
+
+// Invalidate drops every cached attestation.
+func (c *CertAttestationCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+func (c *CertAttestationCache) getOrAttest(
+	fingerprint string,
+	commitment DigestAlgorithm,
+	attest func() (AttestResponse, error),
+) (AttestResponse, error) {
+	key := certCacheKey{fingerprint: fingerprint, commitment: commitment}
+
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok {
+		select {
+		case <-entry.ready:
+			if entry.err == nil && time.Now().Before(entry.expiresAt) {
+				c.mu.Unlock()
+				return entry.resp, nil
+			}
+		default:
+			c.mu.Unlock()
+			<-entry.ready
+			return entry.resp, entry.err
+		}
+	}
+
+	// Entries for any other certificate are stale, since the provider has
+	// rotated to this one.
+	for k := range c.entries {
+		if k.fingerprint != fingerprint {
+			delete(c.entries, k)
+		}
+	}
+	entry := &certCacheEntry{ready: make(chan struct{})}
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	entry.resp, entry.err = attest()
+	entry.expiresAt = time.Now().Add(c.ttl)
+	close(entry.ready)
+
+	if entry.err != nil {
+		c.mu.Lock()
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+	return entry.resp, entry.err
+}
+
+// CertFingerprint returns the hex-encoded SHA-256 digest of a DER certificate.
+func CertFingerprint(certDER []byte) string {
+	sum := sha256.Sum256(certDER)
+	return hex.EncodeToString(sum[:])
+}
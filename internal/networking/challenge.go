@@ -0,0 +1,225 @@
+package networking
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/metrics"
+)
+
+const (
+	ChallengePath              = "/challenge"
+	ChallengeKey               = "challenge"
+	DefaultChallengeTTL        = 30 * time.Second
+	DefaultChallengeMaxEntries = 100000
+	challengeTimeSize          = 8
+	challengeRandomSize        = 24
+	challengeSize              = challengeTimeSize + challengeRandomSize + sha256.Size
+	challengeSecretSize        = 32
+)
+
+// ChallengeConfig configures a ChallengeIssuer. MaxEntries bounds the replay
+// cache, which holds every consumed challenge until it expires.
+type ChallengeConfig struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	TTL        time.Duration `mapstructure:"ttl"`
+	MaxEntries int           `mapstructure:"max_entries"`
+}
+
+func DefaultChallengeConfig() ChallengeConfig {
+	return ChallengeConfig{
+		Enabled:    false,
+		TTL:        DefaultChallengeTTL,
+		MaxEntries: DefaultChallengeMaxEntries,
+	}
+}
+
+var challengeResults = metrics.Default.NewCounter(
+	"bearclave_challenges_total",
+	"Challenges issued and consumed, by result.",
+	"result",
+)
+
+type challengeIssuerKey struct{}
+
+// ChallengeIssuer hands out single-use nonces that attest requests must
+// carry. A challenge is the time it was issued, random bytes, and an HMAC
+// over both under a secret generated at startup, so issuing is stateless and
+// forged or pre-restart challenges are rejected without any lookup. Consumed
+// challenges are remembered until they expire so that none can be used twice.
+type ChallengeIssuer struct {
+	config ChallengeConfig
+	secret []byte
+
+	mu       sync.Mutex
+	consumed map[string]struct{}
+	order    []consumedChallenge
+}
+
+type consumedChallenge struct {
+	key    string
+	expiry time.Time
+}
+
+func NewChallengeIssuer(config ChallengeConfig) (*ChallengeIssuer, error) {
+	if config.Enabled && (config.TTL <= 0 || config.MaxEntries <= 0) {
+		return nil, challengeError("ttl and max_entries must be positive", nil)
+	}
+
+	secret := make([]byte, challengeSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, challengeError("generating secret", err)
+	}
+	return &ChallengeIssuer{
+		config:   config,
+		secret:   secret,
+		consumed: map[string]struct{}{},
+	}, nil
+}
+
+// Issue returns a new challenge and the time it expires.
+func (c *ChallengeIssuer) Issue() ([]byte, time.Time, error) {
+	now := time.Now()
+	challenge := make([]byte, challengeTimeSize+challengeRandomSize, challengeSize)
+	binary.BigEndian.PutUint64(challenge, uint64(now.UnixMilli()))
+	_, err := rand.Read(challenge[challengeTimeSize:])
+	if err != nil {
+		return nil, time.Time{}, challengeError("reading random challenge", err)
+	}
+
+	challenge = append(challenge, c.mac(challenge)...)
+	challengeResults.With("issued").Inc()
+	return challenge, now.Add(c.config.TTL), nil
+}
+
+// Consume accepts challenge if this issuer issued it, it has not expired, and
+// it has not been consumed before.
+func (c *ChallengeIssuer) Consume(challenge []byte) error {
+	err := c.consume(challenge)
+	switch {
+	case err == nil:
+		challengeResults.With("consumed").Inc()
+	case errors.Is(err, ErrChallengeExpired):
+		challengeResults.With("expired").Inc()
+	case errors.Is(err, ErrChallengeReplayed):
+		challengeResults.With("replayed").Inc()
+	case errors.Is(err, ErrChallengeCacheFull):
+		challengeResults.With("cache_full").Inc()
+	default:
+		challengeResults.With("invalid").Inc()
+	}
+	return err
+}
+
+func (c *ChallengeIssuer) consume(challenge []byte) error {
+	if len(challenge) != challengeSize {
+		return challengeError("malformed challenge", nil)
+	}
+	signed := challenge[:challengeTimeSize+challengeRandomSize]
+	if !hmac.Equal(challenge[len(signed):], c.mac(signed)) {
+		return challengeError("challenge was not issued by this enclave", nil)
+	}
+
+	now := time.Now()
+	issued := time.UnixMilli(int64(binary.BigEndian.Uint64(challenge)))
+	if now.Sub(issued) > c.config.TTL {
+		return challengeErrorExpired("", nil)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evict(now)
+	key := string(challenge)
+	if _, ok := c.consumed[key]; ok {
+		return challengeErrorReplayed("", nil)
+	}
+	if len(c.consumed) >= c.config.MaxEntries {
+		return challengeErrorCacheFull("", nil)
+	}
+
+	// A challenge is kept for a full TTL after it is consumed, which is at
+	// least as long as it could still be accepted, and keeps order sorted.
+	c.consumed[key] = struct{}{}
+	c.order = append(c.order, consumedChallenge{key: key, expiry: now.Add(c.config.TTL)})
+	return nil
+}
+
+// evict forgets consumed challenges that have expired.
+func (c *ChallengeIssuer) evict(now time.Time) {
+	expired := 0
+	for expired < len(c.order) && !now.Before(c.order[expired].expiry) {
+		delete(c.consumed, c.order[expired].key)
+		expired++
+	}
+	c.order = c.order[expired:]
+}
+
+func (c *ChallengeIssuer) mac(data []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Wrap makes every AttestedEndpoint behind next require its nonce to be a
+// challenge from c. It returns next unchanged when challenges are disabled.
+// The endpoints consume challenges themselves, inside any Limiter that wraps
+// them, so requests that are rate limited or shed keep their challenge and
+// can be retried.
+func (c *ChallengeIssuer) Wrap(next http.Handler) http.Handler {
+	if !c.config.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), challengeIssuerKey{}, c)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func challengeIssuerFromContext(ctx context.Context) *ChallengeIssuer {
+	issuer, _ := ctx.Value(challengeIssuerKey{}).(*ChallengeIssuer)
+	return issuer
+}
+
+type ChallengeRequest struct{}
+type ChallengeResponse struct {
+	Challenge []byte    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// MakeChallengeHandler issues challenges. They need no attestation, since an
+// attestation over a challenge the Enclave did not issue is rejected anyway.
+func MakeChallengeHandler(issuer *ChallengeIssuer, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := LoggerFromContext(r.Context(), logger)
+		challenge, expiresAt, err := issuer.Issue()
+		if err != nil {
+			logger.Error("issuing challenge", slog.String("error", err.Error()))
+			WriteError(w, internalError("issuing challenge", err))
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		WriteNegotiatedResponse(w, r, ChallengeResponse{Challenge: challenge, ExpiresAt: expiresAt})
+	}
+}
+
+// challengeAPIError classifies a Consume error. A full replay cache is the
+// Enclave's problem rather than the caller's, and clears as entries expire.
+func challengeAPIError(err error) error {
+	if errors.Is(err, ErrChallengeCacheFull) {
+		apiErr := NewAPIError(ErrorCodeOverloaded, "consuming challenge", err)
+		apiErr.RetryAfter = time.Second
+		return apiErr
+	}
+	return NewAPIError(ErrorCodeInvalidChallenge, "consuming challenge", err)
+}
@@ -0,0 +1,248 @@
+package networking_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func newChallengeIssuer(t *testing.T, ttl time.Duration, maxEntries int) *networking.ChallengeIssuer {
+	t.Helper()
+	issuer, err := networking.NewChallengeIssuer(networking.ChallengeConfig{
+		Enabled:    true,
+		TTL:        ttl,
+		MaxEntries: maxEntries,
+	})
+	require.NoError(t, err)
+	return issuer
+}
+
+func TestNewChallengeIssuer(t *testing.T) {
+	t.Run("error - invalid config", func(t *testing.T) {
+		// given
+		config := networking.DefaultChallengeConfig()
+		config.Enabled = true
+		config.MaxEntries = 0
+
+		// when
+		_, err := networking.NewChallengeIssuer(config)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrChallenge)
+	})
+}
+
+func TestChallengeIssuer_Consume(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		issuer := newChallengeIssuer(t, time.Minute, 10)
+		challenge, expiresAt, err := issuer.Issue()
+		require.NoError(t, err)
+
+		// when
+		err = issuer.Consume(challenge)
+
+		// then
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), expiresAt, time.Second)
+	})
+
+	t.Run("happy path - evicts expired challenges", func(t *testing.T) {
+		// given
+		issuer := newChallengeIssuer(t, 20*time.Millisecond, 1)
+		first, _, err := issuer.Issue()
+		require.NoError(t, err)
+		require.NoError(t, issuer.Consume(first))
+		time.Sleep(30 * time.Millisecond)
+
+		second, _, err := issuer.Issue()
+		require.NoError(t, err)
+
+		// when
+		err = issuer.Consume(second)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - replayed", func(t *testing.T) {
+		// given
+		issuer := newChallengeIssuer(t, time.Minute, 10)
+		challenge, _, err := issuer.Issue()
+		require.NoError(t, err)
+		require.NoError(t, issuer.Consume(challenge))
+
+		// when
+		err = issuer.Consume(challenge)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrChallengeReplayed)
+	})
+
+	t.Run("error - expired", func(t *testing.T) {
+		// given
+		issuer := newChallengeIssuer(t, time.Millisecond, 10)
+		challenge, _, err := issuer.Issue()
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+
+		// when
+		err = issuer.Consume(challenge)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrChallengeExpired)
+	})
+
+	t.Run("error - forged", func(t *testing.T) {
+		// given
+		issuer := newChallengeIssuer(t, time.Minute, 10)
+		challenge, _, err := issuer.Issue()
+		require.NoError(t, err)
+		challenge[0] ^= 0xff
+
+		// when
+		err = issuer.Consume(challenge)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrChallenge)
+	})
+
+	t.Run("error - issued by another enclave", func(t *testing.T) {
+		// given
+		issuer := newChallengeIssuer(t, time.Minute, 10)
+		challenge, _, err := newChallengeIssuer(t, time.Minute, 10).Issue()
+		require.NoError(t, err)
+
+		// when
+		err = issuer.Consume(challenge)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrChallenge)
+	})
+
+	t.Run("error - random nonce", func(t *testing.T) {
+		// given
+		issuer := newChallengeIssuer(t, time.Minute, 10)
+		nonce, err := networking.NewNonce()
+		require.NoError(t, err)
+
+		// when
+		err = issuer.Consume(nonce)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrChallenge)
+	})
+
+	t.Run("error - replay cache full", func(t *testing.T) {
+		// given
+		issuer := newChallengeIssuer(t, time.Minute, 1)
+		first, _, err := issuer.Issue()
+		require.NoError(t, err)
+		second, _, err := issuer.Issue()
+		require.NoError(t, err)
+		require.NoError(t, issuer.Consume(first))
+
+		// when
+		err = issuer.Consume(second)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrChallengeCacheFull)
+	})
+}
+
+func newChallengeServer(
+	t *testing.T,
+	issuer *networking.ChallengeIssuer,
+	limiter *networking.Limiter,
+) *httptest.Server {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	logger := slog.New(slog.DiscardHandler)
+
+	mux := http.NewServeMux()
+	mux.Handle("POST "+networking.ChallengePath, networking.MakeChallengeHandler(issuer, logger))
+	mux.Handle(
+		"POST "+networking.AttestUserDataPath,
+		limiter.Wrap(networking.MakeAttestUserDataHandler(attester, logger)),
+	)
+	server := httptest.NewServer(issuer.Wrap(mux))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient_Nonce(t *testing.T) {
+	unlimited := networking.NewLimiter(networking.RateLimitConfig{}, slog.New(slog.DiscardHandler))
+
+	t.Run("happy path - challenge is accepted once", func(t *testing.T) {
+		// given
+		server := newChallengeServer(t, newChallengeIssuer(t, time.Minute, 10), unlimited)
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientChallenges(),
+		)
+		nonce, err := client.Nonce(context.Background())
+		require.NoError(t, err)
+
+		// when
+		_, firstErr := client.AttestUserData(context.Background(), nonce, []byte("hello"))
+		_, secondErr := client.AttestUserData(context.Background(), nonce, []byte("hello"))
+
+		// then
+		require.NoError(t, firstErr)
+		require.ErrorIs(t, secondErr, networking.ErrAPIInvalidChallenge)
+	})
+
+	t.Run("happy path - rate limited requests keep their challenge", func(t *testing.T) {
+		// given
+		issuer := newChallengeIssuer(t, time.Minute, 10)
+		limiter := networking.NewLimiter(
+			networking.RateLimitConfig{RequestsPerSecond: 0.001, Burst: 1},
+			slog.New(slog.DiscardHandler),
+		)
+		server := newChallengeServer(t, issuer, limiter)
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientChallenges(),
+			networking.WithClientRetries(0, 0),
+		)
+		first, err := client.Nonce(context.Background())
+		require.NoError(t, err)
+		second, err := client.Nonce(context.Background())
+		require.NoError(t, err)
+		_, err = client.AttestUserData(context.Background(), first, []byte("hello"))
+		require.NoError(t, err)
+
+		// when
+		_, err = client.AttestUserData(context.Background(), second, []byte("hello"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAPIRateLimited)
+		require.NoError(t, issuer.Consume(second))
+	})
+
+	t.Run("error - random nonce", func(t *testing.T) {
+		// given
+		server := newChallengeServer(t, newChallengeIssuer(t, time.Minute, 10), unlimited)
+		client := networking.NewClientWithClient(server.URL, server.Client())
+		nonce, err := client.Nonce(context.Background())
+		require.NoError(t, err)
+
+		// when
+		_, err = client.AttestUserData(context.Background(), nonce, []byte("hello"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAPIInvalidChallenge)
+	})
+}
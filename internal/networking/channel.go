@@ -0,0 +1,117 @@
+package networking
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+const (
+	AttestChannelPath         = "/attest-channel"
+	ChannelBindingKey         = "channel_binding"
+	ChannelBindingTLSExporter = "tls-exporter"
+	tlsExporterLabel          = "EXPORTER-Channel-Binding"
+	tlsExporterSize           = 32
+)
+
+type ChannelBindingConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+func DefaultChannelBindingConfig() ChannelBindingConfig {
+	return ChannelBindingConfig{Enabled: false}
+}
+
+// TLSExporter returns the tls-exporter channel binding of RFC 9266 for a
+// connection. It is keying material derived from the TLS 1.3 handshake, so
+// both ends of a connection compute the same value, and a man in the middle,
+// who has a separate handshake with each end, cannot make them match.
+func TLSExporter(state *tls.ConnectionState) ([]byte, error) {
+	switch {
+	case state == nil:
+		return nil, channelBindingError("not a tls connection", nil)
+	case state.Version != tls.VersionTLS13:
+		return nil, channelBindingError("tls-exporter requires tls 1.3", nil)
+	}
+
+	binding, err := state.ExportKeyingMaterial(tlsExporterLabel, nil, tlsExporterSize)
+	if err != nil {
+		return nil, channelBindingError("exporting keying material", err)
+	}
+	return binding, nil
+}
+
+type AttestChannelRequest struct {
+	Nonce      []byte          `json:"nonce,omitempty"`
+	Commitment DigestAlgorithm `json:"commitment,omitempty"`
+}
+type AttestedChannel struct {
+	Type    string `json:"type"`
+	Binding []byte `json:"binding"`
+}
+type AttestChannelResponse = AttestResponse
+
+func (r AttestChannelRequest) AttestNonce() []byte               { return r.Nonce }
+func (r AttestChannelRequest) AttestCommitment() DigestAlgorithm { return r.Commitment }
+
+type tlsStateKey struct{}
+
+// MakeAttestChannelHandler attests the channel binding of the TLS connection
+// the request arrived on. It must be served by the Enclave's TLS server, with
+// TLS terminated inside the Enclave.
+func MakeAttestChannelHandler(attester *tee.Attester, logger *slog.Logger) http.HandlerFunc {
+	endpoint := &AttestedEndpoint[AttestChannelRequest, AttestedChannel]{
+		Name:     "channel",
+		Attester: NewDirectAttester(attester),
+		Logger:   logger,
+		Compute: func(ctx context.Context, _ AttestChannelRequest) (AttestedChannel, error) {
+			state, _ := ctx.Value(tlsStateKey{}).(*tls.ConnectionState)
+			binding, err := TLSExporter(state)
+			if err != nil {
+				return AttestedChannel{}, badRequestError("", err)
+			}
+			return AttestedChannel{Type: ChannelBindingTLSExporter, Binding: binding}, nil
+		},
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), tlsStateKey{}, r.TLS)
+		endpoint.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// VerifyChannelBinding checks that the channel binding attested in verified
+// is that of state, the client's end of the connection the attestation was
+// received on. If it is, the connection terminates inside the Enclave.
+func VerifyChannelBinding(
+	verified *tee.VerifyResult,
+	payload []byte,
+	state *tls.ConnectionState,
+) error {
+	payload, err := AttestedPayload(verified, payload)
+	if err != nil {
+		return err
+	}
+
+	attested := AttestedChannel{}
+	err = json.Unmarshal(payload, &attested)
+	if err != nil {
+		return channelBindingError("unmarshaling attested channel", err)
+	}
+	if attested.Type != ChannelBindingTLSExporter {
+		return channelBindingError("unsupported channel binding "+attested.Type, nil)
+	}
+
+	binding, err := TLSExporter(state)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(binding, attested.Binding) != 1 {
+		return channelBindingErrorMismatch("", nil)
+	}
+	return nil
+}
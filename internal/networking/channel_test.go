@@ -0,0 +1,151 @@
+package networking_test
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+type channelServer struct {
+	*httptest.Server
+	provider atomic.Pointer[tee.SelfSignedCertProvider]
+}
+
+// newChannelServer starts a TLS server, like the Enclave's, that serves the
+// channel attestation and Expr endpoints with a self-signed certificate.
+func newChannelServer(t *testing.T) *channelServer {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	logger := slog.New(slog.DiscardHandler)
+
+	server := &channelServer{}
+	server.rotateCert(t)
+	routes := map[string]http.Handler{
+		"POST " + networking.AttestChannelPath: networking.MakeAttestChannelHandler(attester, logger),
+	}
+	server.Server = newEnclaveServer(
+		t,
+		routes,
+		func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return server.provider.Load().GetCert(hello.Context())
+		},
+	)
+	return server
+}
+
+// rotateCert switches the server to a certificate with a new key.
+func (s *channelServer) rotateCert(t *testing.T) {
+	t.Helper()
+	provider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, time.Hour)
+	require.NoError(t, err)
+	s.provider.Store(provider)
+}
+
+func TestClient_BindChannel(t *testing.T) {
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	expression := `balance > 1000 ? "approved" : "denied"`
+	env := map[string]any{"balance": 123456789}
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		server := newChannelServer(t)
+		client := networking.NewClient(
+			server.URL,
+			networking.WithClientChannelBinding(verifier),
+			networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		)
+
+		// when
+		resp, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.NoError(t, err)
+		assert.NotEmpty(t, resp.Payload)
+	})
+
+	t.Run("error - tls terminated outside the enclave", func(t *testing.T) {
+		// given
+		server := newChannelServer(t)
+		target, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		}
+		mitm := httptest.NewTLSServer(proxy)
+		t.Cleanup(mitm.Close)
+		client := networking.NewClient(mitm.URL, networking.WithClientChannelBinding(verifier))
+
+		// when
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrChannelBindingMismatch)
+	})
+
+	t.Run("error - different certificate after binding", func(t *testing.T) {
+		// given
+		server := newChannelServer(t)
+		client := networking.NewClientWithClient(
+			server.URL,
+			&http.Client{Transport: &http.Transport{DisableKeepAlives: true}},
+			networking.WithClientChannelBinding(verifier),
+		)
+		err := client.BindChannel(context.Background())
+		require.NoError(t, err)
+
+		server.rotateCert(t)
+
+		// when
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrChannelBindingMismatch)
+	})
+
+	t.Run("error - no verifier", func(t *testing.T) {
+		// given
+		server := newChannelServer(t)
+		client := networking.NewClient(server.URL, networking.WithClientChannelBinding(nil))
+
+		// when
+		err := client.BindChannel(context.Background())
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClient)
+	})
+}
+
+func TestMakeAttestChannelHandler(t *testing.T) {
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+
+	t.Run("error - not a tls connection", func(t *testing.T) {
+		// given
+		handler := networking.MakeAttestChannelHandler(attester, slog.New(slog.DiscardHandler))
+		req := makeRequest(t, http.MethodPost, networking.AttestChannelPath, nil)
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "not a tls connection")
+	})
+}
@@ -3,48 +3,168 @@ package networking
 import (
 	"bytes"
 	"context"
+	"crypto/hpke"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tahardi/bearclave/tee"
 )
 
-var (
-	ErrClient               = errors.New("client")
-	ErrClientNon200Response = fmt.Errorf("%w: non-200 response", ErrClient)
+const (
+	DefaultClientMaxRetries    = 2
+	DefaultClientMaxRetryAfter = 10 * time.Second
+	maxErrorResponseSize       = 64 * 1024
 )
 
 type Client struct {
-	host   string
-	client *http.Client
+	host             string
+	client           *http.Client
+	maxResponseBytes int64
+	commitment       DigestAlgorithm
+	maxRetries       int
+	maxRetryAfter    time.Duration
+	credential       *Credential
+	challenges       bool
+	codec            Codec
+
+	// keyVerifier checks the attestations of the session and HPKE keys.
+	keyVerifier      *tee.Verifier
+	keyVerifyOptions []tee.VerifyOption
+	sessionMu        sync.Mutex
+	sessionKey       *AttestedKey
+	encrypt          bool
+	hpkeMu           sync.Mutex
+	hpkeKey          *AttestedHPKEKey
+
+	// channelLeaf is the SPKI fingerprint of the Enclave's TLS certificate
+	// once a channel binding has been verified. channelMu serializes binding.
+	channelBinding bool
+	channelMu      sync.Mutex
+	channelLeaf    atomic.Pointer[string]
+
+	// certs are the cert chains trusted by AddCertChain, or the domain of the
+	// last chain pinned in pins. With certClient, they are refreshed from the
+	// Enclave when it presents a certificate that is not trusted. certMu
+	// serializes changes.
+	certMu     sync.Mutex
+	certs      atomic.Pointer[trustedCerts]
+	certClient *Client
+	certDomain string
+	certCached bool
+	pins       *CertPins
 }
 
-func NewClient(host string) *Client {
+type trustedCerts struct {
+	pool   *x509.CertPool
+	domain string
+}
+
+func NewClient(host string, options ...ClientOption) *Client {
 	client := &http.Client{}
-	return NewClientWithClient(host, client)
+	return NewClientWithClient(host, client, options...)
 }
 
 func NewClientWithClient(
 	host string,
 	client *http.Client,
+	options ...ClientOption,
 ) *Client {
+	opts := MakeDefaultClientOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
 	return &Client{
-		host:   host,
-		client: client,
+		host:             host,
+		client:           client,
+		maxResponseBytes: opts.MaxResponseBytes,
+		commitment:       opts.Commitment,
+		maxRetries:       opts.MaxRetries,
+		maxRetryAfter:    opts.MaxRetryAfter,
+		credential:       opts.Credential,
+		challenges:       opts.Challenges,
+		codec:            opts.Codec,
+
+		keyVerifier:      opts.KeyVerifier,
+		keyVerifyOptions: opts.KeyVerifyOptions,
+		encrypt:          opts.Encrypt,
+		channelBinding:   opts.ChannelBinding,
+		certClient:       opts.CertClient,
+		certDomain:       opts.CertDomain,
+		certCached:       opts.CertCached,
+		pins:             opts.CertPins,
 	}
 }
 
+// AddCertChain trusts the certificates in certChainJSON, a JSON array of DER
+// certificates such as an attested AttestCertResponse payload, for TLS
+// connections to domain. The Enclave's self-signed certificate is its own
+// root, so it is trusted directly. With WithClientCertPins, only the leaf key
+// is pinned for domain instead.
 func (c *Client) AddCertChain(certChainJSON []byte, domain string) error {
-	chainDER := [][]byte{}
-	err := json.Unmarshal(certChainJSON, &chainDER)
-	if err != nil {
-		return fmt.Errorf("unmarshaling cert chain json: %w", err)
+	c.certMu.Lock()
+	defer c.certMu.Unlock()
+	return c.addCertChain(certChainJSON, domain, false)
+}
+
+// addCertChain must be called with certMu held. Unless replace is set, the
+// chain is trusted in addition to the chains added before it. Pins are always
+// added, since other Enclaves serving domain may still use theirs.
+func (c *Client) addCertChain(certChainJSON []byte, domain string, replace bool) error {
+	current := c.certs.Load()
+	var pool *x509.CertPool
+	if c.pins != nil {
+		err := c.pins.Pin(domain, certChainJSON)
+		if err != nil {
+			return err
+		}
+	} else {
+		chainDER := [][]byte{}
+		err := json.Unmarshal(certChainJSON, &chainDER)
+		if err != nil {
+			return fmt.Errorf("unmarshaling cert chain json: %w", err)
+		}
+
+		pool = x509.NewCertPool()
+		if current != nil && !replace {
+			pool = current.pool.Clone()
+		}
+		for i, certBytes := range chainDER {
+			x509Cert, err := x509.ParseCertificate(certBytes)
+			if err != nil {
+				return fmt.Errorf("parsing chain %d: %w", i, err)
+			}
+			pool.AddCert(x509Cert)
+		}
 	}
 
+	if current == nil {
+		err := c.configureCertChain(domain)
+		if err != nil {
+			return err
+		}
+	}
+	c.certs.Store(&trustedCerts{pool: pool, domain: domain})
+	return nil
+}
+
+// configureCertChain replaces certificate verification with verifyCertChain,
+// which reads the trusted chains on every handshake so that they can be
+// replaced without touching a TLS config that is in use.
+func (c *Client) configureCertChain(domain string) error {
 	if c.client.Transport == nil {
 		c.client.Transport = &http.Transport{}
 	}
@@ -56,27 +176,100 @@ func (c *Client) AddCertChain(certChainJSON []byte, domain string) error {
 	if transport.TLSClientConfig == nil {
 		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
 	}
-	if transport.TLSClientConfig.RootCAs == nil {
-		transport.TLSClientConfig.RootCAs = x509.NewCertPool()
+	// An Enclave reached by IP address, such as through a local proxy, gets no
+	// server name from its URL, so it is sent domain instead.
+	if transport.TLSClientConfig.ServerName == "" && isIPHost(c.host) {
+		transport.TLSClientConfig.ServerName = domain
+	}
+	// verifyCertChain performs the verification that is skipped here.
+	transport.TLSClientConfig.InsecureSkipVerify = true //nolint:gosec
+	transport.TLSClientConfig.VerifyConnection = c.verifyCertChain
+	return nil
+}
+
+func (c *Client) verifyCertChain(state tls.ConnectionState) error {
+	if c.pins != nil {
+		return c.pins.VerifyConnection(state)
+	}
+
+	certs := c.certs.Load()
+	if certs == nil || len(state.PeerCertificates) == 0 {
+		return clientErrorUnknownCert("no trusted cert chain", nil)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       certs.domain,
+		Roots:         certs.pool,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		return clientErrorUnknownCert("verifying enclave cert", err)
+	}
+	return nil
+}
+
+func isIPHost(host string) bool {
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return false
 	}
-	transport.TLSClientConfig.ServerName = domain
+	return net.ParseIP(hostURL.Hostname()) != nil
+}
 
-	// Add certificates to the client's RootCAs pool. This allows us to use
-	// an Enclave's self-signed certificates to establish TLS.
-	for i, certBytes := range chainDER {
-		x509Cert, err := x509.ParseCertificate(certBytes)
+// RefreshCertChain fetches the Enclave's current cert chain from
+// AttestCertPath, verifies its attestation, and trusts it in place of the
+// chains trusted before, or pins its leaf key with WithClientCertPins. It
+// requires WithClientCertRefresh, and Do calls it when the Enclave presents a
+// certificate the client does not trust.
+func (c *Client) RefreshCertChain(ctx context.Context) error {
+	return c.refreshCertChain(ctx, c.certs.Load())
+}
+
+// refreshCertChain refetches the cert chain unless another request already
+// replaced stale.
+func (c *Client) refreshCertChain(ctx context.Context, stale *trustedCerts) error {
+	c.certMu.Lock()
+	defer c.certMu.Unlock()
+
+	if c.certs.Load() != stale {
+		return nil
+	}
+	if c.certClient == nil || c.keyVerifier == nil {
+		return clientError("no cert refresh client", nil)
+	}
+
+	var nonce []byte
+	if !c.certCached {
+		var err error
+		nonce, err = c.certClient.Nonce(ctx)
 		if err != nil {
-			return fmt.Errorf("parsing chain %d: %w", i, err)
+			return err
 		}
-		transport.TLSClientConfig.RootCAs.AddCert(x509Cert)
 	}
-	return nil
+	resp, err := c.certClient.AttestCertChain(ctx, nonce)
+	if err != nil {
+		return err
+	}
+	verified, err := c.verifyKeyAttestation(resp, nonce)
+	if err != nil {
+		return clientError("verifying cert chain attestation", err)
+	}
+	chainJSON, err := AttestedPayload(verified, resp.Payload)
+	if err != nil {
+		return clientError("verifying cert chain", err)
+	}
+	return c.addCertChain(chainJSON, c.certDomain, true)
 }
 
 func (c *Client) AttestCertChain(
 	ctx context.Context,
+	nonce []byte,
 ) (AttestCertResponse, error) {
-	attestCertReq := AttestCertRequest{}
+	attestCertReq := AttestCertRequest{Nonce: nonce, Commitment: c.commitment}
 	attestCertResp := AttestCertResponse{}
 	err := c.Do(
 		ctx,
@@ -89,15 +282,148 @@ func (c *Client) AttestCertChain(
 		return AttestCertResponse{},
 			fmt.Errorf("doing attest cert request: %w", err)
 	}
+
+	err = c.verifyResponse(ctx, attestCertResp, nonce)
+	if err != nil {
+		return AttestCertResponse{}, err
+	}
 	return attestCertResp, nil
 }
 
+// AttestChannel fetches an attestation of the channel binding of the TLS
+// connection the request is sent on, along with that connection's state. Most
+// callers want BindChannel, which also verifies it.
+func (c *Client) AttestChannel(
+	ctx context.Context,
+	nonce []byte,
+) (AttestChannelResponse, *tls.ConnectionState, error) {
+	attestChannelReq := AttestChannelRequest{Nonce: nonce, Commitment: c.commitment}
+	bodyBytes, err := c.codec.Marshal(attestChannelReq)
+	if err != nil {
+		return AttestChannelResponse{}, nil, clientError("marshaling request body", err)
+	}
+
+	var state *tls.ConnectionState
+	ctx = context.WithValue(ctx, tlsStateCaptureKey{}, &state)
+	attestChannelResp := AttestChannelResponse{}
+	err = c.do(ctx, "POST", AttestChannelPath, bodyBytes, &attestChannelResp, nil)
+	if err != nil {
+		return AttestChannelResponse{}, nil,
+			fmt.Errorf("doing attest channel request: %w", err)
+	}
+
+	err = c.verifyResponse(ctx, attestChannelResp, nonce)
+	if err != nil {
+		return AttestChannelResponse{}, nil, err
+	}
+	return attestChannelResp, state, nil
+}
+
+// BindChannel verifies that the client's TLS connection terminates inside the
+// Enclave and pins the key of the certificate the Enclave presented on it, so
+// that later connections are only made to the same Enclave, even after it
+// rotates its certificate. It is a no-op once the
+// channel is bound, and Do calls it before every request when
+// WithClientChannelBinding is set.
+func (c *Client) BindChannel(ctx context.Context) error {
+	if c.channelLeaf.Load() != nil {
+		return nil
+	}
+
+	c.channelMu.Lock()
+	defer c.channelMu.Unlock()
+	if c.channelLeaf.Load() != nil {
+		return nil
+	}
+	if c.keyVerifier == nil {
+		return clientError("no channel binding verifier", nil)
+	}
+
+	err := c.configureChannelBinding()
+	if err != nil {
+		return err
+	}
+	nonce, err := c.nonce(ctx)
+	if err != nil {
+		return err
+	}
+	resp, state, err := c.AttestChannel(ctx, nonce)
+	if err != nil {
+		return err
+	}
+	verified, err := c.verifyKeyAttestation(resp, nonce)
+	if err != nil {
+		return clientError("verifying channel attestation", err)
+	}
+	err = VerifyChannelBinding(verified, resp.Payload, state)
+	if err != nil {
+		return clientError("verifying channel binding", err)
+	}
+
+	leaf := SPKIFingerprint(state.PeerCertificates[0])
+	c.channelLeaf.Store(&leaf)
+	return nil
+}
+
+// configureChannelBinding replaces certificate verification with the leaf
+// pin checked by verifyChannel.
+func (c *Client) configureChannelBinding() error {
+	if c.client.Transport == nil {
+		c.client.Transport = &http.Transport{}
+	}
+	transport, ok := c.client.Transport.(*http.Transport)
+	if !ok {
+		return clientError("transport is not an HTTP Transport", nil)
+	}
+
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.MinVersion = tls.VersionTLS13
+	// The Enclave's certificate is self-signed. It is trusted because the
+	// Enclave attests the channel binding of the connection it is presented
+	// on, and verifyChannel then requires every connection to present it.
+	transport.TLSClientConfig.InsecureSkipVerify = true //nolint:gosec
+	transport.TLSClientConfig.VerifyConnection = c.verifyChannel
+	return nil
+}
+
+// verifyChannel accepts any connection until the channel is bound, since
+// BindChannel verifies the one it attests over, and afterwards only
+// connections that present the pinned key.
+func (c *Client) verifyChannel(state tls.ConnectionState) error {
+	leaf := c.channelLeaf.Load()
+	if leaf == nil {
+		return nil
+	}
+	if len(state.PeerCertificates) == 0 ||
+		SPKIFingerprint(state.PeerCertificates[0]) != *leaf {
+		return channelBindingErrorMismatch("certificate key is not the bound enclave's", nil)
+	}
+	return nil
+}
+
 func (c *Client) AttestHTTPCall(
 	ctx context.Context,
+	nonce []byte,
 	method string,
 	url string,
+	options ...HTTPCallOption,
 ) (AttestHTTPCallResponse, error) {
-	attestHTTPCallRequest := AttestHTTPCallRequest{Method: method, URL: url}
+	opts := MakeDefaultHTTPCallOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	attestHTTPCallRequest := AttestHTTPCallRequest{
+		Nonce:       nonce,
+		Commitment:  c.commitment,
+		Method:      method,
+		URL:         url,
+		Body:        opts.Body,
+		Headers:     opts.Headers,
+		ContentType: opts.ContentType,
+	}
 	attestHTTPCallResponse := AttestHTTPCallResponse{}
 	err := c.Do(
 		ctx,
@@ -110,15 +436,35 @@ func (c *Client) AttestHTTPCall(
 		return AttestHTTPCallResponse{},
 			fmt.Errorf("doing attest http call request: %w", err)
 	}
+
+	err = c.verifyResponse(ctx, attestHTTPCallResponse, nonce)
+	if err != nil {
+		return AttestHTTPCallResponse{}, err
+	}
 	return attestHTTPCallResponse, nil
 }
 
 func (c *Client) AttestHTTPSCall(
 	ctx context.Context,
+	nonce []byte,
 	method string,
 	url string,
+	options ...HTTPCallOption,
 ) (AttestHTTPSCallResponse, error) {
-	attestHTTPSCallRequest := AttestHTTPSCallRequest{Method: method, URL: url}
+	opts := MakeDefaultHTTPCallOptions()
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	attestHTTPSCallRequest := AttestHTTPSCallRequest{
+		Nonce:       nonce,
+		Commitment:  c.commitment,
+		Method:      method,
+		URL:         url,
+		Body:        opts.Body,
+		Headers:     opts.Headers,
+		ContentType: opts.ContentType,
+	}
 	attestHTTPSCallResponse := AttestHTTPSCallResponse{}
 	err := c.Do(
 		ctx,
@@ -131,15 +477,26 @@ func (c *Client) AttestHTTPSCall(
 		return AttestHTTPSCallResponse{},
 			fmt.Errorf("doing attest https call request: %w", err)
 	}
+
+	err = c.verifyResponse(ctx, attestHTTPSCallResponse, nonce)
+	if err != nil {
+		return AttestHTTPSCallResponse{}, err
+	}
 	return attestHTTPSCallResponse, nil
 }
 
 func (c *Client) AttestCEL(
 	ctx context.Context,
+	nonce []byte,
 	expression string,
 	env map[string]any,
 ) (AttestCELResponse, error) {
-	attestCELRequest := AttestCELRequest{Expression: expression, Env: env}
+	attestCELRequest := AttestCELRequest{
+		Nonce:      nonce,
+		Commitment: c.commitment,
+		Expression: expression,
+		Env:        env,
+	}
 	attestCELResponse := AttestCELResponse{}
 	err := c.Do(
 		ctx,
@@ -152,15 +509,26 @@ func (c *Client) AttestCEL(
 		return AttestCELResponse{},
 			fmt.Errorf("doing attest cel request: %w", err)
 	}
+
+	err = c.verifyResponse(ctx, attestCELResponse, nonce)
+	if err != nil {
+		return AttestCELResponse{}, err
+	}
 	return attestCELResponse, nil
 }
 
 func (c *Client) AttestExpr(
 	ctx context.Context,
+	nonce []byte,
 	expression string,
 	env map[string]any,
 ) (AttestExprResponse, error) {
-	attestExprRequest := AttestExprRequest{Expression: expression, Env: env}
+	attestExprRequest := AttestExprRequest{
+		Nonce:      nonce,
+		Commitment: c.commitment,
+		Expression: expression,
+		Env:        env,
+	}
 	attestExprResponse := AttestExprResponse{}
 	err := c.Do(
 		ctx,
@@ -173,6 +541,11 @@ func (c *Client) AttestExpr(
 		return AttestExprResponse{},
 			fmt.Errorf("doing attest expr request: %w", err)
 	}
+
+	err = c.verifyResponse(ctx, attestExprResponse, nonce)
+	if err != nil {
+		return AttestExprResponse{}, err
+	}
 	return attestExprResponse, nil
 }
 
@@ -181,7 +554,11 @@ func (c *Client) AttestUserData(
 	nonce []byte,
 	userData []byte,
 ) (AttestUserDataResponse, error) {
-	attestUserDataRequest := AttestUserDataRequest{Nonce: nonce, UserData: userData}
+	attestUserDataRequest := AttestUserDataRequest{
+		Nonce:      nonce,
+		Commitment: c.commitment,
+		UserData:   userData,
+	}
 	attestUserDataResponse := AttestUserDataResponse{}
 	err := c.Do(
 		ctx,
@@ -193,9 +570,273 @@ func (c *Client) AttestUserData(
 	if err != nil {
 		return AttestUserDataResponse{}, fmt.Errorf("doing attest user data request: %w", err)
 	}
+
+	err = c.verifyResponse(ctx, attestUserDataResponse, nonce)
+	if err != nil {
+		return AttestUserDataResponse{}, err
+	}
 	return attestUserDataResponse, nil
 }
 
+// Challenge fetches a single-use challenge from the Enclave. Like the HPKE
+// key request, it is never encrypted, since it carries nothing private.
+func (c *Client) Challenge(ctx context.Context) (ChallengeResponse, error) {
+	bodyBytes, err := c.codec.Marshal(ChallengeRequest{})
+	if err != nil {
+		return ChallengeResponse{}, clientError("marshaling request body", err)
+	}
+
+	challengeResponse := ChallengeResponse{}
+	err = c.do(ctx, "POST", ChallengePath, bodyBytes, &challengeResponse, nil)
+	if err != nil {
+		return ChallengeResponse{}, fmt.Errorf("doing challenge request: %w", err)
+	}
+	return challengeResponse, nil
+}
+
+// Nonce returns a nonce for the next attest request. With
+// WithClientChallenges it is a challenge fetched from the Enclave, which the
+// Enclave accepts only once and only while it is fresh; otherwise it is
+// random. With WithClientChannelBinding the channel is bound first, so that
+// challenges are only fetched from the Enclave.
+func (c *Client) Nonce(ctx context.Context) ([]byte, error) {
+	if c.channelBinding {
+		err := c.BindChannel(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.nonce(ctx)
+}
+
+func (c *Client) nonce(ctx context.Context) ([]byte, error) {
+	if !c.challenges {
+		nonce, err := NewNonce()
+		if err != nil {
+			return nil, clientError("making nonce", err)
+		}
+		return nonce, nil
+	}
+
+	resp, err := c.Challenge(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Challenge, nil
+}
+
+// AttestKey fetches the attested session key. Most callers want SessionKey,
+// which also verifies and caches it.
+func (c *Client) AttestKey(ctx context.Context, nonce []byte) (AttestKeyResponse, error) {
+	attestKeyRequest := AttestKeyRequest{Nonce: nonce, Commitment: c.commitment}
+	attestKeyResponse := AttestKeyResponse{}
+	err := c.Do(
+		ctx,
+		"POST",
+		AttestKeyPath,
+		attestKeyRequest,
+		&attestKeyResponse,
+	)
+	if err != nil {
+		return AttestKeyResponse{}, fmt.Errorf("doing attest key request: %w", err)
+	}
+
+	err = c.verifyResponse(ctx, attestKeyResponse, nonce)
+	if err != nil {
+		return AttestKeyResponse{}, err
+	}
+	return attestKeyResponse, nil
+}
+
+// SessionKey returns the Enclave's session key, fetching and verifying its
+// attestation if it is not already cached. It requires WithClientSessionKey.
+func (c *Client) SessionKey(ctx context.Context) (AttestedKey, error) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	if c.sessionKey != nil {
+		return *c.sessionKey, nil
+	}
+	return c.fetchSessionKey(ctx)
+}
+
+// fetchSessionKey must be called with sessionMu held.
+func (c *Client) fetchSessionKey(ctx context.Context) (AttestedKey, error) {
+	if c.keyVerifier == nil {
+		return AttestedKey{}, clientError("no session key verifier", nil)
+	}
+
+	nonce, err := c.Nonce(ctx)
+	if err != nil {
+		return AttestedKey{}, err
+	}
+	resp, err := c.AttestKey(ctx, nonce)
+	if err != nil {
+		return AttestedKey{}, err
+	}
+
+	verified, err := c.verifyKeyAttestation(resp, nonce)
+	if err != nil {
+		return AttestedKey{}, clientError("verifying session key attestation", err)
+	}
+
+	key, err := AttestedSessionKey(verified, resp.Payload)
+	if err != nil {
+		return AttestedKey{}, clientError("verifying session key", err)
+	}
+	c.sessionKey = &key
+	return key, nil
+}
+
+// AttestHPKEKey fetches the attested HPKE key. The request is never
+// encrypted, since it is how the client learns the key. Most callers want
+// HPKEKey, which also verifies and caches it.
+func (c *Client) AttestHPKEKey(ctx context.Context, nonce []byte) (AttestHPKEKeyResponse, error) {
+	attestHPKEKeyRequest := AttestHPKEKeyRequest{Nonce: nonce, Commitment: c.commitment}
+	bodyBytes, err := c.codec.Marshal(attestHPKEKeyRequest)
+	if err != nil {
+		return AttestHPKEKeyResponse{}, clientError("marshaling request body", err)
+	}
+
+	attestHPKEKeyResponse := AttestHPKEKeyResponse{}
+	err = c.do(ctx, "POST", AttestHPKEKeyPath, bodyBytes, &attestHPKEKeyResponse, nil)
+	if err != nil {
+		return AttestHPKEKeyResponse{}, fmt.Errorf("doing attest hpke key request: %w", err)
+	}
+
+	err = c.verifyResponse(ctx, attestHPKEKeyResponse, nonce)
+	if err != nil {
+		return AttestHPKEKeyResponse{}, err
+	}
+	return attestHPKEKeyResponse, nil
+}
+
+// HPKEKey returns the Enclave's HPKE key, fetching and verifying its
+// attestation if it is not already cached. It requires WithClientEncryption.
+func (c *Client) HPKEKey(ctx context.Context) (AttestedHPKEKey, error) {
+	c.hpkeMu.Lock()
+	defer c.hpkeMu.Unlock()
+
+	if c.hpkeKey != nil {
+		return *c.hpkeKey, nil
+	}
+	return c.fetchHPKEKey(ctx)
+}
+
+// refreshHPKEKey refetches the HPKE key unless another request already
+// replaced staleKeyID.
+func (c *Client) refreshHPKEKey(ctx context.Context, staleKeyID string) (AttestedHPKEKey, error) {
+	c.hpkeMu.Lock()
+	defer c.hpkeMu.Unlock()
+
+	if c.hpkeKey != nil && c.hpkeKey.KeyID != staleKeyID {
+		return *c.hpkeKey, nil
+	}
+	return c.fetchHPKEKey(ctx)
+}
+
+// fetchHPKEKey must be called with hpkeMu held.
+func (c *Client) fetchHPKEKey(ctx context.Context) (AttestedHPKEKey, error) {
+	if c.keyVerifier == nil {
+		return AttestedHPKEKey{}, clientError("no hpke key verifier", nil)
+	}
+
+	nonce, err := c.Nonce(ctx)
+	if err != nil {
+		return AttestedHPKEKey{}, err
+	}
+	resp, err := c.AttestHPKEKey(ctx, nonce)
+	if err != nil {
+		return AttestedHPKEKey{}, err
+	}
+
+	verified, err := c.verifyKeyAttestation(resp, nonce)
+	if err != nil {
+		return AttestedHPKEKey{}, clientError("verifying hpke key attestation", err)
+	}
+
+	key, err := ParseAttestedHPKEKey(verified, resp.Payload)
+	if err != nil {
+		return AttestedHPKEKey{}, clientError("verifying hpke key", err)
+	}
+	c.hpkeKey = &key
+	return key, nil
+}
+
+func (c *Client) verifyKeyAttestation(resp AttestResponse, nonce []byte) (*tee.VerifyResult, error) {
+	options := append(slices.Clone(c.keyVerifyOptions), tee.WithVerifyNonce(nonce))
+	return c.keyVerifier.Verify(resp.Attestation, options...)
+}
+
+// verifySignature checks resp's session signature against the cached session
+// key. A signature from a different key means the Enclave has restarted, so
+// the key is refetched once before giving up.
+func (c *Client) verifySignature(ctx context.Context, resp AttestResponse, nonce []byte) error {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	if c.sessionKey == nil || c.sessionKey.KeyID != resp.Signature.KeyID {
+		_, err := c.fetchSessionKey(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.sessionKey.Verify(nonce, resp.Payload, resp.Signature)
+	if err != nil {
+		return clientError("verifying session signature", err)
+	}
+	return nil
+}
+
+// verifyResponse checks that resp's payload is bound to its attestation,
+// either by inclusion in an attested batch or by an attested commitment. It
+// does not verify the attestation itself; callers must still do that with a
+// tee.Verifier. Signed responses are fully verified against the session key.
+func (c *Client) verifyResponse(ctx context.Context, resp AttestResponse, nonce []byte) error {
+	if resp.Signature != nil {
+		return c.verifySignature(ctx, resp, nonce)
+	}
+	if resp.Attestation == nil {
+		return clientError("missing attestation", nil)
+	}
+	if resp.Proof != nil {
+		err := VerifyBatchInclusion(resp.Attestation.UserData, nonce, resp.Payload, resp.Proof)
+		if err != nil {
+			return clientError("verifying batch inclusion", err)
+		}
+		return nil
+	}
+	return c.verifyCommitment(resp.Attestation, resp.Payload)
+}
+
+// verifyCommitment checks that payload matches the commitment in attestation
+// when the client is in commitment mode.
+func (c *Client) verifyCommitment(attestation *tee.AttestResult, payload []byte) error {
+	if c.commitment == DigestAlgorithmNone {
+		return nil
+	}
+
+	switch {
+	case len(payload) == 0:
+		return clientError("missing committed payload", nil)
+	case !strings.HasPrefix(string(attestation.UserData), string(c.commitment)+":"):
+		msg := "expected " + string(c.commitment) + " commitment"
+		return clientError(msg, attestedPayloadErrorMismatch("", nil))
+	}
+
+	err := VerifyCommitment(attestation.UserData, payload)
+	if err != nil {
+		return clientError("verifying commitment", err)
+	}
+	return nil
+}
+
+// Do sends apiReq to api, encoded with the client's codec, and decodes the
+// response into apiResp with the codec the server answered in. With
+// WithClientEncryption the request is sealed to the Enclave's HPKE key, and a
+// request sealed to a key the Enclave no longer has is retried once with the
+// new key.
 func (c *Client) Do(
 	ctx context.Context,
 	method string,
@@ -203,58 +844,550 @@ func (c *Client) Do(
 	apiReq any,
 	apiResp any,
 ) error {
-	bodyBytes, err := json.Marshal(apiReq)
+	bodyBytes, err := c.codec.Marshal(apiReq)
 	if err != nil {
 		return clientError("marshaling request body", err)
 	}
 
-	url := c.host + api
-	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(bodyBytes))
+	// Retries reuse the request ID so the server logs tie them together.
+	if RequestIDFromContext(ctx) == "" {
+		ctx = WithRequestID(ctx, NewRequestID())
+	}
+	if c.channelBinding {
+		err = c.BindChannel(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if c.certClient != nil && c.certs.Load() == nil {
+		err = c.refreshCertChain(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if !c.encrypt {
+		return c.do(ctx, method, api, bodyBytes, apiResp, nil)
+	}
+
+	key, err := c.HPKEKey(ctx)
 	if err != nil {
-		return clientError("creating request", err)
+		return err
+	}
+	err = c.do(ctx, method, api, bodyBytes, apiResp, &key)
+	if !errors.Is(err, ErrAPIUnknownKey) {
+		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	//nolint:gosec
-	resp, err := c.client.Do(req)
-	switch {
-	case err != nil:
-		return clientError("sending request", err)
-	case resp.StatusCode != http.StatusOK:
-		msg := strconv.Itoa(resp.StatusCode)
-		return clientErrorNon200Response(msg, nil)
+	key, err = c.refreshHPKEKey(ctx, key.KeyID)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, api, bodyBytes, apiResp, &key)
+}
+
+// tlsStateCaptureKey holds a **tls.ConnectionState that do sets to the state
+// of the connection a successful response arrived on.
+type tlsStateCaptureKey struct{}
+
+// do sends body, sealed to key unless key is nil, retrying rate limited and
+// overloaded requests.
+func (c *Client) do(
+	ctx context.Context,
+	method string,
+	api string,
+	body []byte,
+	apiResp any,
+	key *AttestedHPKEKey,
+) error {
+	contentType := c.codec.ContentType()
+	var sealedReq SealedRequest
+	var responseKey hpke.PrivateKey
+	if key != nil {
+		var err error
+		sealedReq, responseKey, err = key.SealRequest(method, api, body)
+		if err != nil {
+			return clientError("sealing request", err)
+		}
+		sealedReq.ContentType = contentType
+		body, err = json.Marshal(sealedReq)
+		if err != nil {
+			return clientError("marshaling sealed request", err)
+		}
+		contentType = EncryptedContentType
+	}
+
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		var err error
+		resp, err = c.send(ctx, method, api, body, contentType)
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			err = c.openResponse(resp, responseKey, sealedReq)
+			if err != nil {
+				return err
+			}
+		}
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+
+		wait, retry := c.retryAfter(resp, attempt)
+		if !retry {
+			return decodeErrorResponse(resp)
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorResponseSize))
+		resp.Body.Close()
+
+		err = sleep(ctx, wait)
+		if err != nil {
+			return clientError("waiting to retry", err)
+		}
 	}
 	defer resp.Body.Close()
 
-	bodyBytes, err = io.ReadAll(resp.Body)
+	if state, ok := ctx.Value(tlsStateCaptureKey{}).(**tls.ConnectionState); ok {
+		*state = resp.TLS
+	}
+	bodyBytes, err := ReadAllLimited(resp.Body, c.maxResponseBytes)
 	if err != nil {
 		return clientError("reading response body", err)
 	}
 
-	err = json.Unmarshal(bodyBytes, apiResp)
+	// Responses without a known Content-Type are assumed to be in our codec.
+	codec, ok := CodecFor(resp.Header.Get("Content-Type"))
+	if !ok {
+		codec = c.codec
+	}
+	err = codec.Unmarshal(bodyBytes, apiResp)
 	if err != nil {
 		return clientError("unmarshaling response", err)
 	}
 	return nil
 }
 
-func wrapClientError(clientErr error, msg string, err error) error {
+// send sends body once, or twice if the Enclave presented a certificate the
+// client did not trust and WithClientCertRefresh found a new one.
+func (c *Client) send(
+	ctx context.Context,
+	method string,
+	api string,
+	body []byte,
+	contentType string,
+) (*http.Response, error) {
+	stale := c.certs.Load()
+	resp, err := c.sendOnce(ctx, method, api, body, contentType)
+	if c.certClient == nil || !errors.Is(err, ErrClientUnknownCert) {
+		return resp, err
+	}
+
+	err = c.refreshCertChain(ctx, stale)
+	if err != nil {
+		return nil, err
+	}
+	return c.sendOnce(ctx, method, api, body, contentType)
+}
+
+func (c *Client) sendOnce(
+	ctx context.Context,
+	method string,
+	api string,
+	body []byte,
+	contentType string,
+) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, api, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", c.codec.ContentType())
+
+	//nolint:gosec
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, clientError("sending request", err)
+	}
+	return resp, nil
+}
+
+// openResponse replaces a sealed response body with its plaintext. Only the
+// Enclave can seal a response, so a successful response that is not sealed
+// is rejected. Errors from in front of the Enclave, such as a proxy's rate
+// limiter, are not sealed and are left as they are.
+func (c *Client) openResponse(
+	resp *http.Response,
+	responseKey hpke.PrivateKey,
+	sealedReq SealedRequest,
+) error {
+	if resp.Header.Get("Content-Type") != EncryptedContentType {
+		if resp.StatusCode != http.StatusOK {
+			return nil
+		}
+		resp.Body.Close()
+		return clientError("response is not encrypted", nil)
+	}
+
+	bodyBytes, err := ReadAllLimited(resp.Body, c.maxResponseBytes)
+	resp.Body.Close()
+	if err != nil {
+		return clientError("reading response body", err)
+	}
+	sealedResp := SealedResponse{}
+	err = json.Unmarshal(bodyBytes, &sealedResp)
+	if err != nil {
+		return clientError("unmarshaling sealed response", err)
+	}
+	bodyBytes, err = OpenResponse(responseKey, sealedReq, sealedResp)
+	if err != nil {
+		return clientError("opening response", err)
+	}
+
+	contentType := sealedResp.ContentType
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	resp.Header.Set("Content-Type", contentType)
+	return nil
+}
+
+// Health fetches the server's readiness. A server that is up but not ready is
+// not an error; its HealthStatus reports the failed checks.
+func (c *Client) Health(ctx context.Context) (HealthStatus, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, ReadyzPath, nil)
+	if err != nil {
+		return HealthStatus{}, err
+	}
+
+	//nolint:gosec
+	resp, err := c.client.Do(req)
 	switch {
-	case msg == "" && err == nil:
-		return clientErr
-	case msg != "" && err != nil:
-		return fmt.Errorf("%w: %s: %w", clientErr, msg, err)
-	case msg != "":
-		return fmt.Errorf("%w: %s", clientErr, msg)
+	case err != nil:
+		return HealthStatus{}, clientError("sending request", err)
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable:
+		return HealthStatus{}, decodeErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ReadAllLimited(resp.Body, maxErrorResponseSize)
+	if err != nil {
+		return HealthStatus{}, clientError("reading response body", err)
+	}
+
+	// A 503 may also come from something other than the readiness handler,
+	// such as an overloaded server, so fall back to the error response.
+	status := HealthStatus{}
+	err = json.Unmarshal(bodyBytes, &status)
+	if err != nil || status.Status == "" {
+		resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		return HealthStatus{}, decodeErrorResponse(resp)
+	}
+	return status, nil
+}
+
+// newRequest creates a request carrying the request ID and, if the client
+// has a credential, its API key or signature. Retries call it again so that
+// each attempt is signed with a fresh timestamp.
+func (c *Client) newRequest(
+	ctx context.Context,
+	method string,
+	api string,
+	body []byte,
+) (*http.Request, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.host+api, bodyReader)
+	if err != nil {
+		return nil, clientError("creating request", err)
+	}
+
+	requestID := RequestIDFromContext(ctx)
+	if requestID == "" {
+		requestID = NewRequestID()
+	}
+	req.Header.Set(RequestIDHeader, requestID)
+
+	switch {
+	case c.credential == nil:
+	case c.credential.Type == CredentialTypeAPIKey:
+		req.Header.Set(APIKeyHeader, c.credential.Secret)
+	case c.credential.Type == CredentialTypeHMAC:
+		SignRequest(req, c.credential.ID, []byte(c.credential.Secret), body, time.Now())
 	default:
-		return fmt.Errorf("%w: %w", clientErr, err)
+		return nil, clientError("unknown credential type "+string(c.credential.Type), nil)
+	}
+	return req, nil
+}
+
+// retryAfter reports whether a rate limited or overloaded response should be
+// retried, and after how long. The client only retries when the server sent a
+// Retry-After no longer than the client is willing to wait.
+func (c *Client) retryAfter(resp *http.Response, attempt int) (time.Duration, bool) {
+	if attempt >= c.maxRetries {
+		return 0, false
+	}
+	if resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	wait, ok := parseRetryAfter(resp.Header.Get(RetryAfterHeader), time.Now())
+	if !ok || wait > c.maxRetryAfter {
+		return 0, false
+	}
+	return wait, true
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// decodeErrorResponse turns a non-200 response into an error. If the server
+// sent an ErrorResponse, the returned error wraps its APIError so callers can
+// use errors.Is against the ErrAPI sentinels.
+func decodeErrorResponse(resp *http.Response) error {
+	msg := strconv.Itoa(resp.StatusCode)
+	if requestID := resp.Header.Get(RequestIDHeader); requestID != "" {
+		msg += " (request id " + requestID + ")"
+	}
+	if resp.Body == nil {
+		return clientErrorNon200Response(msg, nil)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorResponseSize))
+	if err != nil {
+		return clientErrorNon200Response(msg, nil)
+	}
+
+	errResp := ErrorResponse{}
+	codec, ok := CodecFor(resp.Header.Get("Content-Type"))
+	if !ok {
+		codec = JSONCodec
+	}
+	err = codec.Unmarshal(bodyBytes, &errResp)
+	if err != nil || errResp.Error == nil {
+		if text := strings.TrimSpace(string(bodyBytes)); text != "" {
+			msg += ": " + text
+		}
+		return clientErrorNon200Response(msg, nil)
+	}
+	errResp.Error.RetryAfter, _ = parseRetryAfter(resp.Header.Get(RetryAfterHeader), time.Now())
+	return clientErrorNon200Response(msg, errResp.Error)
+}
+
+type ClientOption func(*ClientOptions)
+type ClientOptions struct {
+	MaxResponseBytes int64
+	Commitment       DigestAlgorithm
+	MaxRetries       int
+	MaxRetryAfter    time.Duration
+	Credential       *Credential
+	Challenges       bool
+	Codec            Codec
+
+	KeyVerifier      *tee.Verifier
+	KeyVerifyOptions []tee.VerifyOption
+	Encrypt          bool
+	ChannelBinding   bool
+	CertClient       *Client
+	CertDomain       string
+	CertCached       bool
+	CertPins         *CertPins
+}
+
+// WithClientCommitment asks the Enclave to attest an alg commitment to each
+// payload instead of the payload itself. The client recomputes the digest of
+// every returned payload and rejects responses that do not match.
+func WithClientCommitment(alg DigestAlgorithm) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.Commitment = alg
 	}
 }
 
-func clientError(msg string, err error) error {
-	return wrapClientError(ErrClient, msg, err)
+func WithClientMaxResponseBytes(maxBytes int64) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.MaxResponseBytes = maxBytes
+	}
 }
 
-func clientErrorNon200Response(msg string, err error) error {
-	return wrapClientError(ErrClientNon200Response, msg, err)
+// WithClientRetries sets how many times the client retries a rate limited
+// or overloaded request, and the longest Retry-After it will wait for. Zero
+// retries disables retrying.
+func WithClientRetries(maxRetries int, maxRetryAfter time.Duration) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.MaxRetries = maxRetries
+		opts.MaxRetryAfter = maxRetryAfter
+	}
+}
+
+// WithClientCredential authenticates every request with cred. API keys are
+// sent as is, and HMAC credentials sign each request. Scopes are ignored, and
+// a credential without a secret leaves requests unauthenticated.
+func WithClientCredential(cred Credential) ClientOption {
+	return func(opts *ClientOptions) {
+		if cred.Secret == "" {
+			opts.Credential = nil
+			return
+		}
+		opts.Credential = &cred
+	}
+}
+
+// WithClientSessionKey lets the client accept responses signed with the
+// Enclave's session key. The key is fetched from AttestKeyPath the first time
+// it is needed, its attestation is checked with verifier and options, which
+// should include the expected measurement, and it is then cached so that
+// signatures are verified locally.
+func WithClientSessionKey(verifier *tee.Verifier, options ...tee.VerifyOption) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.KeyVerifier = verifier
+		opts.KeyVerifyOptions = options
+	}
+}
+
+// WithClientCodec encodes requests with codec and asks for responses in it.
+// CBORCodec makes binary fields, such as nonces and attestation reports,
+// much smaller than their base64 JSON encoding. Error responses, and
+// responses from servers that do not support the codec, are still decoded
+// by their Content-Type.
+func WithClientCodec(codec Codec) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.Codec = codec
+	}
+}
+
+// WithClientChallenges makes Nonce fetch challenges from an Enclave that
+// requires them. The client uses Nonce for the session and HPKE key requests
+// it makes itself, and callers should use it for their own attest requests.
+func WithClientChallenges() ClientOption {
+	return func(opts *ClientOptions) {
+		opts.Challenges = true
+	}
+}
+
+// WithClientEncryption seals every request to the Enclave's HPKE key and
+// expects every successful response to be sealed to a key generated for that
+// request, so that whatever sits between the client and the Enclave only sees
+// ciphertext. The key is fetched from AttestHPKEKeyPath and verified like the
+// session key of WithClientSessionKey, which shares verifier and options.
+func WithClientEncryption(verifier *tee.Verifier, options ...tee.VerifyOption) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.KeyVerifier = verifier
+		opts.KeyVerifyOptions = options
+		opts.Encrypt = true
+	}
+}
+
+// WithClientChannelBinding trusts the Enclave's TLS certificate by its
+// attested channel binding instead of AddCertChain. Before its first request
+// the client asks AttestChannelPath to attest the tls-exporter binding of the
+// connection, checks the attestation with verifier and options, which should
+// include the expected measurement, and compares the binding with its own
+// end of the same connection. It then pins the Enclave's certificate. The
+// verifier and options are shared with WithClientSessionKey. With
+// WithClientChallenges, the challenge for the binding is fetched over the
+// connection being bound.
+func WithClientChannelBinding(verifier *tee.Verifier, options ...tee.VerifyOption) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.KeyVerifier = verifier
+		opts.KeyVerifyOptions = options
+		opts.ChannelBinding = true
+	}
+}
+
+// WithClientCertRefresh keeps the client's trusted cert chain in step with
+// the Enclave's certificate rotations. The chain for domain is fetched from
+// AttestCertPath with certClient, usually a client of the Enclave's plain
+// HTTP server, before the first request and whenever the Enclave presents a
+// certificate the client does not trust, after which the request is sent
+// once more. Its attestation is checked with verifier and options, which
+// should include the expected measurement, and which are shared with
+// WithClientSessionKey.
+func WithClientCertRefresh(
+	certClient *Client,
+	domain string,
+	verifier *tee.Verifier,
+	options ...tee.VerifyOption,
+) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.KeyVerifier = verifier
+		opts.KeyVerifyOptions = options
+		opts.CertClient = certClient
+		opts.CertDomain = domain
+	}
+}
+
+// WithClientCachedCertChain makes WithClientCertRefresh fetch the cert chain
+// without a nonce, so that the Enclave can serve it from its
+// CertAttestationCache. The attestation may then be up to the cache's TTL old,
+// which is fine for trusting the chain, but not for proving the Enclave is
+// still up.
+func WithClientCachedCertChain() ClientOption {
+	return func(opts *ClientOptions) {
+		opts.CertCached = true
+	}
+}
+
+// WithClientCertPins makes AddCertChain and RefreshCertChain pin the leaf key
+// of each chain in pins, instead of trusting its certificates as root CAs.
+// Chains for several domains, or for several Enclaves serving one domain, can
+// be pinned, and pins can be shared by clients. A connection to an Enclave
+// whose key is not pinned fails with ErrClientPinMismatch, which also
+// triggers WithClientCertRefresh.
+func WithClientCertPins(pins *CertPins) ClientOption {
+	return func(opts *ClientOptions) {
+		opts.CertPins = pins
+	}
+}
+
+func MakeDefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		MaxResponseBytes: DefaultMaxResponseBytes,
+		Commitment:       DigestAlgorithmNone,
+		MaxRetries:       DefaultClientMaxRetries,
+		MaxRetryAfter:    DefaultClientMaxRetryAfter,
+		Codec:            JSONCodec,
+	}
+}
+
+type HTTPCallOption func(*HTTPCallOptions)
+type HTTPCallOptions struct {
+	Body        []byte
+	Headers     map[string]string
+	ContentType string
+}
+
+func WithHTTPCallBody(body []byte, contentType string) HTTPCallOption {
+	return func(opts *HTTPCallOptions) {
+		opts.Body = body
+		opts.ContentType = contentType
+	}
+}
+
+func WithHTTPCallHeaders(headers map[string]string) HTTPCallOption {
+	return func(opts *HTTPCallOptions) {
+		if opts.Headers == nil {
+			opts.Headers = map[string]string{}
+		}
+		maps.Copy(opts.Headers, headers)
+	}
+}
+
+func MakeDefaultHTTPCallOptions() HTTPCallOptions {
+	return HTTPCallOptions{
+		Body:        nil,
+		Headers:     nil,
+		ContentType: "",
+	}
 }
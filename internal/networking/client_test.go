@@ -2,11 +2,14 @@ package networking_test
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/tahardi/bearclave-examples/internal/engine"
 	"github.com/tahardi/bearclave-examples/internal/networking"
 
 	"github.com/stretchr/testify/assert"
@@ -17,6 +20,56 @@ import (
 	"github.com/tahardi/bearclave/tee"
 )
 
+// newEnclaveServer starts a server, like the Enclave's, that serves the Expr
+// endpoint along with routes, which may replace it. The server uses TLS with
+// the certificates returned by getCert, unless getCert is nil.
+func newEnclaveServer(
+	t *testing.T,
+	routes map[string]http.Handler,
+	getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	exprPattern := "POST " + networking.AttestExprPath
+	if _, ok := routes[exprPattern]; !ok {
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		exprEngine, err := engine.NewExprEngine()
+		require.NoError(t, err)
+		logger := slog.New(slog.DiscardHandler)
+		mux.Handle(
+			exprPattern,
+			networking.MakeAttestExprHandler(
+				exprEngine,
+				defaultTimeout,
+				attester,
+				networking.NewSequencer(),
+				logger,
+			),
+		)
+	}
+	for pattern, handler := range routes {
+		mux.Handle(pattern, handler)
+	}
+
+	server := httptest.NewUnstartedServer(mux)
+	if getCert == nil {
+		server.Start()
+		t.Cleanup(server.Close)
+		return server
+	}
+	server.TLS = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCert,
+	}
+	server.StartTLS()
+	// StartTLS installs httptest's own certificate, which would otherwise be
+	// served instead of getCert's to clients that send no SNI.
+	server.TLS.Certificates = nil
+	t.Cleanup(server.Close)
+	return server
+}
+
 func writeError(w http.ResponseWriter, err error) {
 	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
@@ -35,6 +88,7 @@ func TestClient_AttestHTTPCall(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		// given
 		ctx := context.Background()
+		nonce := []byte("nonce")
 		method := http.MethodGet
 		url := "http://httpbin.org/get"
 		want := &tee.AttestResult{Base: &bearclave.AttestResult{Report: []byte("attestation")}}
@@ -43,11 +97,49 @@ func TestClient_AttestHTTPCall(t *testing.T) {
 			assert.Equal(t, http.MethodPost, r.Method)
 			assert.Contains(t, r.URL.Path, networking.AttestHTTPCallPath)
 
+			req := networking.AttestHTTPCallRequest{}
+			err := json.NewDecoder(r.Body).Decode(&req)
+			assert.NoError(t, err)
+			assert.Equal(t, nonce, req.Nonce)
+			assert.Equal(t, method, req.Method)
+			assert.Equal(t, url, req.URL)
+
+			resp := networking.AttestHTTPCallResponse{Attestation: want}
+			writeResponse(t, w, resp)
+		})
+
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		got, err := client.AttestHTTPCall(ctx, nonce, method, url)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got.Attestation)
+	})
+
+	t.Run("happy path - body and headers", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		method := http.MethodPost
+		url := "http://httpbin.org/post"
+		body := []byte(`{"hello":"world"}`)
+		contentType := "application/json"
+		headers := map[string]string{"Accept": "application/json"}
+		want := &tee.AttestResult{Base: &bearclave.AttestResult{Report: []byte("attestation")}}
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			req := networking.AttestHTTPCallRequest{}
 			err := json.NewDecoder(r.Body).Decode(&req)
 			assert.NoError(t, err)
 			assert.Equal(t, method, req.Method)
 			assert.Equal(t, url, req.URL)
+			assert.Equal(t, body, req.Body)
+			assert.Equal(t, headers, req.Headers)
+			assert.Equal(t, contentType, req.ContentType)
 
 			resp := networking.AttestHTTPCallResponse{Attestation: want}
 			writeResponse(t, w, resp)
@@ -59,7 +151,14 @@ func TestClient_AttestHTTPCall(t *testing.T) {
 		client := networking.NewClientWithClient(server.URL, server.Client())
 
 		// when
-		got, err := client.AttestHTTPCall(ctx, method, url)
+		got, err := client.AttestHTTPCall(
+			ctx,
+			nil,
+			method,
+			url,
+			networking.WithHTTPCallBody(body, contentType),
+			networking.WithHTTPCallHeaders(headers),
+		)
 
 		// then
 		require.NoError(t, err)
@@ -69,6 +168,7 @@ func TestClient_AttestHTTPCall(t *testing.T) {
 	t.Run("error - doing attest http call request", func(t *testing.T) {
 		// given
 		ctx := context.Background()
+		nonce := []byte("nonce")
 		method := http.MethodGet
 		url := "http://httpbin.org/get"
 
@@ -82,7 +182,7 @@ func TestClient_AttestHTTPCall(t *testing.T) {
 		client := networking.NewClientWithClient(server.URL, server.Client())
 
 		// when
-		_, err := client.AttestHTTPCall(ctx, method, url)
+		_, err := client.AttestHTTPCall(ctx, nonce, method, url)
 
 		// then
 		require.ErrorIs(t, err, networking.ErrClient)
@@ -90,10 +190,89 @@ func TestClient_AttestHTTPCall(t *testing.T) {
 	})
 }
 
+func TestClient_AttestCEL(t *testing.T) {
+	t.Run("happy path - batched", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		nonce := []byte("nonce")
+		expression := `"Hello, CEL"`
+		payload := []byte(`{"output":"Hello, CEL"}`)
+
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		batcher := networking.NewBatchAttester(attester, networking.WithBatchMaxSize(1))
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := networking.AttestCELRequest{}
+			err := json.NewDecoder(r.Body).Decode(&req)
+			assert.NoError(t, err)
+
+			att, proof, err := batcher.Attest(r.Context(), req.Nonce, payload)
+			assert.NoError(t, err)
+
+			resp := networking.AttestCELResponse{Attestation: att, Payload: payload, Proof: proof}
+			writeResponse(t, w, resp)
+		})
+
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		got, err := client.AttestCEL(ctx, nonce, expression, nil)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, payload, got.Payload)
+		require.NotNil(t, got.Proof)
+	})
+
+	t.Run("error - payload not in batch", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		nonce := []byte("nonce")
+		expression := `"Hello, CEL"`
+
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		batcher := networking.NewBatchAttester(attester, networking.WithBatchMaxSize(1))
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := networking.AttestCELRequest{}
+			err := json.NewDecoder(r.Body).Decode(&req)
+			assert.NoError(t, err)
+
+			att, proof, err := batcher.Attest(r.Context(), req.Nonce, []byte("attested"))
+			assert.NoError(t, err)
+
+			resp := networking.AttestCELResponse{
+				Attestation: att,
+				Payload:     []byte("tampered"),
+				Proof:       proof,
+			}
+			writeResponse(t, w, resp)
+		})
+
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		_, err = client.AttestCEL(ctx, nonce, expression, nil)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClient)
+		require.ErrorIs(t, err, networking.ErrMerkleProof)
+	})
+}
+
 func TestClient_AttestExpr(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		// given
 		ctx := context.Background()
+		nonce := []byte("nonce")
 		env := map[string]any{
 			"targetUrl": "http://httpbin.org/get",
 		}
@@ -107,6 +286,7 @@ func TestClient_AttestExpr(t *testing.T) {
 			req := networking.AttestExprRequest{}
 			err := json.NewDecoder(r.Body).Decode(&req)
 			assert.NoError(t, err)
+			assert.Equal(t, nonce, req.Nonce)
 			assert.Equal(t, expression, req.Expression)
 			assert.Equal(t, env, req.Env)
 
@@ -120,7 +300,7 @@ func TestClient_AttestExpr(t *testing.T) {
 		client := networking.NewClientWithClient(server.URL, server.Client())
 
 		// when
-		got, err := client.AttestExpr(ctx, expression, env)
+		got, err := client.AttestExpr(ctx, nonce, expression, env)
 
 		// then
 		require.NoError(t, err)
@@ -130,6 +310,7 @@ func TestClient_AttestExpr(t *testing.T) {
 	t.Run("error - doing attest request", func(t *testing.T) {
 		// given
 		ctx := context.Background()
+		nonce := []byte("nonce")
 		env := map[string]any{
 			"targetUrl": "http://httpbin.org/get",
 		}
@@ -145,7 +326,7 @@ func TestClient_AttestExpr(t *testing.T) {
 		client := networking.NewClientWithClient(server.URL, server.Client())
 
 		// when
-		_, err := client.AttestExpr(ctx, expression, env)
+		_, err := client.AttestExpr(ctx, nonce, expression, env)
 
 		// then
 		require.ErrorIs(t, err, networking.ErrClient)
@@ -168,6 +349,7 @@ func TestClient_AttestUserData(t *testing.T) {
 			req := networking.AttestUserDataRequest{}
 			err := json.NewDecoder(r.Body).Decode(&req)
 			assert.NoError(t, err)
+			assert.Equal(t, nonce, req.Nonce)
 			assert.Equal(t, data, req.UserData)
 
 			resp := networking.AttestUserDataResponse{Attestation: want}
@@ -187,6 +369,106 @@ func TestClient_AttestUserData(t *testing.T) {
 		assert.Equal(t, want, got.Attestation)
 	})
 
+	t.Run("happy path - commitment", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		data := []byte("hello world")
+		nonce := []byte("nonce")
+		commitment, err := networking.Commit(networking.DigestAlgorithmSHA256, data)
+		require.NoError(t, err)
+		want := &tee.AttestResult{UserData: commitment}
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := networking.AttestUserDataRequest{}
+			err := json.NewDecoder(r.Body).Decode(&req)
+			assert.NoError(t, err)
+			assert.Equal(t, networking.DigestAlgorithmSHA256, req.Commitment)
+
+			resp := networking.AttestUserDataResponse{Attestation: want, Payload: data}
+			writeResponse(t, w, resp)
+		})
+
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		)
+
+		// when
+		got, err := client.AttestUserData(ctx, nonce, data)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want, got.Attestation)
+		assert.Equal(t, data, got.Payload)
+	})
+
+	t.Run("error - payload does not match commitment", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		data := []byte("hello world")
+		nonce := []byte("nonce")
+		commitment, err := networking.Commit(networking.DigestAlgorithmSHA256, data)
+		require.NoError(t, err)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			resp := networking.AttestUserDataResponse{
+				Attestation: &tee.AttestResult{UserData: commitment},
+				Payload:     []byte("tampered"),
+			}
+			writeResponse(t, w, resp)
+		})
+
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		)
+
+		// when
+		_, err = client.AttestUserData(ctx, nonce, data)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClient)
+		require.ErrorIs(t, err, networking.ErrAttestedPayloadMismatch)
+	})
+
+	t.Run("error - missing committed payload", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		data := []byte("hello world")
+		nonce := []byte("nonce")
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			resp := networking.AttestUserDataResponse{
+				Attestation: &tee.AttestResult{UserData: data},
+			}
+			writeResponse(t, w, resp)
+		})
+
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		)
+
+		// when
+		_, err := client.AttestUserData(ctx, nonce, data)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClient)
+		assert.ErrorContains(t, err, "missing committed payload")
+	})
+
 	t.Run("error - doing attest user data request", func(t *testing.T) {
 		// given
 		ctx := context.Background()
@@ -388,6 +670,66 @@ func TestClient_Do(t *testing.T) {
 		assert.ErrorContains(t, err, "non-200 response")
 	})
 
+	t.Run("error - received error response", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		method := http.MethodPost
+		api := "/"
+		apiReq := &doRequest{}
+		apiResp := &doResponse{}
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			err := networking.NewAPIError(networking.ErrorCodeAttestation, "attesting", assert.AnError)
+			networking.WriteError(w, err)
+		})
+
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		err := client.Do(ctx, method, api, apiReq, apiResp)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClientNon200Response)
+		require.ErrorIs(t, err, networking.ErrAPIAttestation)
+
+		apiErr := &networking.APIError{}
+		require.ErrorAs(t, err, &apiErr)
+		assert.True(t, apiErr.Retryable)
+		assert.Contains(t, apiErr.Message, "attesting")
+	})
+
+	t.Run("error - response too large", func(t *testing.T) {
+		// given
+		ctx := context.Background()
+		method := http.MethodPost
+		api := "/"
+		apiReq := &doRequest{}
+		apiResp := &doResponse{}
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			writeResponse(t, w, doResponse{Data: []byte("this response is too large")})
+		})
+
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientMaxResponseBytes(8),
+		)
+
+		// when
+		err := client.Do(ctx, method, api, apiReq, apiResp)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClient)
+		require.ErrorIs(t, err, networking.ErrPayloadTooLarge)
+	})
+
 	t.Run("error - reading response body", func(t *testing.T) {
 		// given
 		ctx := context.Background()
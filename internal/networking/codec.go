@@ -0,0 +1,161 @@
+package networking
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	CodecKey        = "codec"
+	ContentTypeJSON = "application/json"
+	ContentTypeCBOR = "application/cbor"
+)
+
+// Codec encodes request and response bodies. Both codecs use the json struct
+// tags, so every request and response type can be sent as either.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSONCodec Codec = jsonCodec{}
+
+	// CBORCodec sends byte slices, such as nonces, user data and attestation
+	// reports, as raw bytes rather than base64. Times keep their nanoseconds,
+	// and maps and integers decode into `any` the way CEL and Expr expect.
+	CBORCodec Codec = newCBORCodec()
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                { return ContentTypeJSON }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type cborCodec struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORCodec() cborCodec {
+	enc, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	dec, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeFor[map[string]any](),
+		IntDec:         cbor.IntDecConvertSignedOrFail,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return cborCodec{enc: enc, dec: dec}
+}
+
+func (cborCodec) ContentType() string                  { return ContentTypeCBOR }
+func (c cborCodec) Marshal(v any) ([]byte, error)      { return c.enc.Marshal(v) }
+func (c cborCodec) Unmarshal(data []byte, v any) error { return c.dec.Unmarshal(data, v) }
+
+// CodecByName returns the codec named "json" or "cbor".
+func CodecByName(name string) (Codec, error) {
+	switch strings.ToLower(name) {
+	case "", "json":
+		return JSONCodec, nil
+	case "cbor":
+		return CBORCodec, nil
+	default:
+		return nil, codecError("unknown codec "+name, nil)
+	}
+}
+
+// CodecFor returns the codec for a Content-Type. An empty Content-Type means
+// JSON, which is what every client sent before CBOR was supported.
+func CodecFor(contentType string) (Codec, bool) {
+	if contentType == "" {
+		return JSONCodec, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	switch mediaType {
+	case ContentTypeJSON:
+		return JSONCodec, true
+	case ContentTypeCBOR:
+		return CBORCodec, true
+	default:
+		return nil, false
+	}
+}
+
+// RequestCodec returns the codec for r's body.
+func RequestCodec(r *http.Request) (Codec, error) {
+	contentType := r.Header.Get("Content-Type")
+	codec, ok := CodecFor(contentType)
+	if !ok {
+		return nil, codecError("unsupported content type "+contentType, nil)
+	}
+	return codec, nil
+}
+
+// ResponseCodec picks the codec for the response to r from its Accept header,
+// preferring the one with the highest quality. Without a usable Accept header
+// the response uses the codec of the request, so a client that sends CBOR
+// gets CBOR back.
+func ResponseCodec(r *http.Request) Codec {
+	var best Codec
+	bestQuality := 0.0
+	for accept := range strings.SplitSeq(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		codec, ok := CodecFor(mediaType)
+		if ok && mediaType != "" && quality > bestQuality {
+			best, bestQuality = codec, quality
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	codec, ok := CodecFor(r.Header.Get("Content-Type"))
+	if !ok {
+		return JSONCodec
+	}
+	return codec
+}
+
+// WriteNegotiatedResponse writes out with the codec r asks for. Errors are
+// always written as JSON by WriteError, since they may come from anything in
+// front of the Enclave, and clients decode them by their Content-Type.
+func WriteNegotiatedResponse(w http.ResponseWriter, r *http.Request, out any) {
+	writeResponse(w, ResponseCodec(r), out)
+}
+
+func writeResponse(w http.ResponseWriter, codec Codec, out any) {
+	data, err := codec.Marshal(out)
+	if err != nil {
+		WriteError(w, internalError("marshaling response", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", codec.ContentType())
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
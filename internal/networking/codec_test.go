@@ -0,0 +1,293 @@
+package networking_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/engine"
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestCodecFor(t *testing.T) {
+	tests := map[string]networking.Codec{
+		"":                                networking.JSONCodec,
+		"application/json":                networking.JSONCodec,
+		"application/json; charset=utf-8": networking.JSONCodec,
+		"application/cbor":                networking.CBORCodec,
+	}
+	for contentType, want := range tests {
+		t.Run("happy path - "+contentType, func(t *testing.T) {
+			// when
+			got, ok := networking.CodecFor(contentType)
+
+			// then
+			require.True(t, ok)
+			assert.Equal(t, want.ContentType(), got.ContentType())
+		})
+	}
+
+	t.Run("error - unsupported", func(t *testing.T) {
+		// when
+		_, ok := networking.CodecFor("text/plain")
+
+		// then
+		assert.False(t, ok)
+	})
+}
+
+func TestResponseCodec(t *testing.T) {
+	tests := map[string]struct {
+		contentType string
+		accept      string
+		want        string
+	}{
+		"accept cbor": {
+			accept: "application/cbor",
+			want:   networking.ContentTypeCBOR,
+		},
+		"highest quality wins": {
+			accept: "application/cbor;q=0.5, application/json",
+			want:   networking.ContentTypeJSON,
+		},
+		"no accept follows request": {
+			contentType: networking.ContentTypeCBOR,
+			want:        networking.ContentTypeCBOR,
+		},
+		"unsupported accept follows request": {
+			contentType: networking.ContentTypeCBOR,
+			accept:      "text/html, */*",
+			want:        networking.ContentTypeCBOR,
+		},
+		"defaults to json": {
+			want: networking.ContentTypeJSON,
+		},
+	}
+	for name, tc := range tests {
+		t.Run("happy path - "+name, func(t *testing.T) {
+			// given
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set("Accept", tc.accept)
+
+			// when
+			got := networking.ResponseCodec(req)
+
+			// then
+			assert.Equal(t, tc.want, got.ContentType())
+		})
+	}
+}
+
+func TestCBORCodec(t *testing.T) {
+	t.Run("happy path - decodes like json", func(t *testing.T) {
+		// given
+		want := networking.AttestCELRequest{
+			Nonce:      []byte("nonce"),
+			Expression: "account.balance > 1000",
+			Env:        map[string]any{"account": map[string]any{"balance": 5000}},
+		}
+		data, err := networking.CBORCodec.Marshal(want)
+		require.NoError(t, err)
+
+		// when
+		got := networking.AttestCELRequest{}
+		err = networking.CBORCodec.Unmarshal(data, &got)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, want.Nonce, got.Nonce)
+		assert.Equal(t, map[string]any{"balance": int64(5000)}, got.Env["account"])
+	})
+
+	t.Run("happy path - smaller than json", func(t *testing.T) {
+		// given
+		report := make([]byte, 4096)
+		resp := networking.AttestResponse{Attestation: &tee.AttestResult{UserData: report}}
+		jsonData, err := networking.JSONCodec.Marshal(resp)
+		require.NoError(t, err)
+
+		// when
+		cborData, err := networking.CBORCodec.Marshal(resp)
+
+		// then
+		require.NoError(t, err)
+		assert.Less(t, len(cborData), len(report)+64)
+		assert.Greater(t, len(jsonData), len(report)*4/3)
+	})
+
+	t.Run("happy path - keeps nanoseconds", func(t *testing.T) {
+		// given
+		want := networking.ChallengeResponse{ExpiresAt: time.Unix(1, 123456789).UTC()}
+		data, err := networking.CBORCodec.Marshal(want)
+		require.NoError(t, err)
+
+		// when
+		got := networking.ChallengeResponse{}
+		err = networking.CBORCodec.Unmarshal(data, &got)
+
+		// then
+		require.NoError(t, err)
+		assert.True(t, want.ExpiresAt.Equal(got.ExpiresAt))
+	})
+}
+
+func TestAttestedEndpoint_Codec(t *testing.T) {
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	handler := networking.MakeAttestUserDataHandler(attester, slog.New(slog.DiscardHandler))
+
+	t.Run("happy path - cbor request and response", func(t *testing.T) {
+		// given
+		body, err := networking.CBORCodec.Marshal(networking.AttestUserDataRequest{
+			UserData: []byte("hello"),
+		})
+		require.NoError(t, err)
+		req := httptest.NewRequest(
+			http.MethodPost,
+			networking.AttestUserDataPath,
+			bytes.NewReader(body),
+		)
+		req.Header.Set("Content-Type", networking.ContentTypeCBOR)
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, networking.ContentTypeCBOR, recorder.Header().Get("Content-Type"))
+
+		resp := networking.AttestUserDataResponse{}
+		err = networking.CBORCodec.Unmarshal(recorder.Body.Bytes(), &resp)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("hello"), resp.Attestation.UserData)
+	})
+
+	t.Run("error - unsupported content type", func(t *testing.T) {
+		// given
+		req := makeRequest(t, http.MethodPost, networking.AttestUserDataPath, nil)
+		req.Header.Set("Content-Type", "text/plain")
+		recorder := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "unsupported content type")
+	})
+}
+
+func TestClient_Codec(t *testing.T) {
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	exprEngine, err := engine.NewExprEngine()
+	require.NoError(t, err)
+	expression := `balance > 1000 ? "approved" : "denied"`
+	env := map[string]any{"balance": 123456789}
+
+	t.Run("happy path - cbor", func(t *testing.T) {
+		// given
+		var mu sync.Mutex
+		var contentTypes []string
+		handler := networking.MakeAttestExprHandler(
+			exprEngine,
+			defaultTimeout,
+			attester,
+			networking.NewSequencer(),
+			slog.New(slog.DiscardHandler),
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, r)
+
+			mu.Lock()
+			contentTypes = append(
+				contentTypes,
+				r.Header.Get("Content-Type"),
+				recorder.Header().Get("Content-Type"),
+			)
+			mu.Unlock()
+
+			for name, values := range recorder.Header() {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(recorder.Code)
+			_, _ = w.Write(recorder.Body.Bytes())
+		}))
+		t.Cleanup(server.Close)
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientCodec(networking.CBORCodec),
+		)
+
+		// when
+		resp, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []string{networking.ContentTypeCBOR, networking.ContentTypeCBOR}, contentTypes)
+
+		verified, err := verifier.Verify(resp.Attestation, tee.WithVerifyNonce([]byte("nonce")))
+		require.NoError(t, err)
+		got := networking.AttestedExpr{}
+		err = json.Unmarshal(verified.UserData, &got)
+		require.NoError(t, err)
+		assert.Equal(t, "approved", got.Output)
+	})
+
+	t.Run("happy path - cbor sealed", func(t *testing.T) {
+		// given
+		server := newEncryptionServer(t)
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientCodec(networking.CBORCodec),
+			networking.WithClientEncryption(verifier),
+			networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		)
+
+		// when
+		resp, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.NoError(t, err)
+		got := networking.AttestedExpr{}
+		err = json.Unmarshal(resp.Payload, &got)
+		require.NoError(t, err)
+		assert.Equal(t, "approved", got.Output)
+	})
+
+	t.Run("error - errors are decoded as json", func(t *testing.T) {
+		// given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			networking.WriteError(w, networking.NewAPIError(networking.ErrorCodeForbidden, "no", nil))
+		}))
+		t.Cleanup(server.Close)
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientCodec(networking.CBORCodec),
+		)
+
+		// when
+		_, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAPIForbidden)
+	})
+}
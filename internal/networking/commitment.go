@@ -0,0 +1,126 @@
+package networking
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+// DigestAlgorithm selects how an attested payload is bound to its attestation.
+// With DigestAlgorithmNone the payload itself is the attested user data. With
+// any other algorithm the Enclave attests a "<alg>:<hex>" commitment to the
+// payload and returns the full payload next to the attestation, which keeps
+// the user data small enough for every platform's report-data field.
+type DigestAlgorithm string
+
+const (
+	DigestAlgorithmNone   DigestAlgorithm = ""
+	DigestAlgorithmSHA256 DigestAlgorithm = "sha256"
+	DigestAlgorithmSHA512 DigestAlgorithm = "sha512"
+)
+
+func (a DigestAlgorithm) Validate() error {
+	switch a {
+	case DigestAlgorithmNone, DigestAlgorithmSHA256, DigestAlgorithmSHA512:
+		return nil
+	default:
+		return commitmentError("unsupported digest algorithm "+string(a), nil)
+	}
+}
+
+func (a DigestAlgorithm) Digest(data []byte) (string, error) {
+	switch a {
+	case DigestAlgorithmSHA256:
+		return DigestSHA256(data), nil
+	case DigestAlgorithmSHA512:
+		return DigestSHA512(data), nil
+	case DigestAlgorithmNone:
+		return "", commitmentError("no digest algorithm", nil)
+	default:
+		return "", commitmentError("unsupported digest algorithm "+string(a), nil)
+	}
+}
+
+// DigestSHA512 returns a self-describing "sha512:<hex>" digest of data.
+func DigestSHA512(data []byte) string {
+	sum := sha512.Sum512(data)
+	return "sha512:" + hex.EncodeToString(sum[:])
+}
+
+// Commit returns the commitment to payload that the Enclave attests in place
+// of payload.
+func Commit(alg DigestAlgorithm, payload []byte) ([]byte, error) {
+	digest, err := alg.Digest(payload)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(digest), nil
+}
+
+// VerifyCommitment recomputes the digest of payload using the algorithm named
+// in commitment and checks that the two match.
+func VerifyCommitment(commitment []byte, payload []byte) error {
+	name, _, found := strings.Cut(string(commitment), ":")
+	if !found {
+		return commitmentError("malformed commitment", nil)
+	}
+
+	want, err := DigestAlgorithm(name).Digest(payload)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(want), commitment) != 1 {
+		msg := "payload digest mismatch: expected " + string(commitment) + ", got " + want
+		return attestedPayloadErrorMismatch(msg, nil)
+	}
+	return nil
+}
+
+// AttestedPayload returns the payload bound to a verified attestation. If
+// payload is empty, the attested user data is the payload. Otherwise the user
+// data must be a commitment to payload.
+func AttestedPayload(verified *tee.VerifyResult, payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return verified.UserData, nil
+	}
+
+	err := VerifyCommitment(verified.UserData, payload)
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// attestPayload attests payload directly or, if alg is set, attests a
+// commitment to payload. The returned payload is non-nil only in the latter
+// case, and must be sent alongside the attestation.
+func attestPayload(
+	attester *tee.Attester,
+	nonce []byte,
+	payload []byte,
+	alg DigestAlgorithm,
+) (*tee.AttestResult, []byte, error) {
+	if alg == DigestAlgorithmNone {
+		attestation, err := attester.Attest(
+			tee.WithAttestNonce(nonce),
+			tee.WithAttestUserData(payload),
+		)
+		return attestation, nil, err
+	}
+
+	commitment, err := Commit(alg, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	attestation, err := attester.Attest(
+		tee.WithAttestNonce(nonce),
+		tee.WithAttestUserData(commitment),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attestation, payload, nil
+}
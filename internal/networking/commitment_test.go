@@ -0,0 +1,118 @@
+package networking_test
+
+import (
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestCommit(t *testing.T) {
+	t.Run("happy path - sha256", func(t *testing.T) {
+		// given
+		payload := []byte("hello world")
+
+		// when
+		commitment, err := networking.Commit(networking.DigestAlgorithmSHA256, payload)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, networking.DigestSHA256(payload), string(commitment))
+		require.NoError(t, networking.VerifyCommitment(commitment, payload))
+	})
+
+	t.Run("happy path - sha512", func(t *testing.T) {
+		// given
+		payload := []byte("hello world")
+
+		// when
+		commitment, err := networking.Commit(networking.DigestAlgorithmSHA512, payload)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, networking.DigestSHA512(payload), string(commitment))
+		require.NoError(t, networking.VerifyCommitment(commitment, payload))
+	})
+
+	t.Run("error - unsupported algorithm", func(t *testing.T) {
+		// given
+		payload := []byte("hello world")
+
+		// when
+		_, err := networking.Commit(networking.DigestAlgorithm("md5"), payload)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrCommitment)
+	})
+}
+
+func TestVerifyCommitment(t *testing.T) {
+	t.Run("error - payload mismatch", func(t *testing.T) {
+		// given
+		commitment, err := networking.Commit(networking.DigestAlgorithmSHA256, []byte("hello"))
+		require.NoError(t, err)
+
+		// when
+		err = networking.VerifyCommitment(commitment, []byte("goodbye"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAttestedPayloadMismatch)
+	})
+
+	t.Run("error - malformed commitment", func(t *testing.T) {
+		// given
+		commitment := []byte("not a commitment")
+
+		// when
+		err := networking.VerifyCommitment(commitment, []byte("hello"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrCommitment)
+	})
+}
+
+func TestAttestedPayload(t *testing.T) {
+	t.Run("happy path - user data is payload", func(t *testing.T) {
+		// given
+		userData := []byte("hello world")
+		verified := &tee.VerifyResult{UserData: userData}
+
+		// when
+		got, err := networking.AttestedPayload(verified, nil)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, userData, got)
+	})
+
+	t.Run("happy path - user data is commitment", func(t *testing.T) {
+		// given
+		payload := []byte("hello world")
+		commitment, err := networking.Commit(networking.DigestAlgorithmSHA512, payload)
+		require.NoError(t, err)
+		verified := &tee.VerifyResult{UserData: commitment}
+
+		// when
+		got, err := networking.AttestedPayload(verified, payload)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, payload, got)
+	})
+
+	t.Run("error - payload does not match commitment", func(t *testing.T) {
+		// given
+		commitment, err := networking.Commit(networking.DigestAlgorithmSHA256, []byte("hello"))
+		require.NoError(t, err)
+		verified := &tee.VerifyResult{UserData: commitment}
+
+		// when
+		_, err = networking.AttestedPayload(verified, []byte("goodbye"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAttestedPayloadMismatch)
+	})
+}
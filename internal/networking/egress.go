@@ -0,0 +1,235 @@
+package networking
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultHTTPPort  = 80
+	DefaultHTTPSPort = 443
+	EgressPolicyKey  = "egress"
+)
+
+// DefaultDeniedCIDRs blocks loopback, private, link-local (which includes the
+// cloud metadata address 169.254.169.254), CGNAT, benchmarking, multicast,
+// and unspecified ranges, as well as the NAT64 and 6to4 ranges, which embed
+// IPv4 addresses and so can reach the private ones.
+var DefaultDeniedCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"2002::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+type EgressPolicy struct {
+	AllowedHosts   []string `mapstructure:"allowed_hosts"`
+	AllowedDomains []string `mapstructure:"allowed_domains"`
+	DeniedCIDRs    []string `mapstructure:"denied_cidrs"`
+	AllowedSchemes []string `mapstructure:"allowed_schemes"`
+	AllowedPorts   []int    `mapstructure:"allowed_ports"`
+	AllowedMethods []string `mapstructure:"allowed_methods"`
+	ResolveHosts   bool     `mapstructure:"resolve_hosts"`
+}
+
+func DefaultEgressPolicy() EgressPolicy {
+	return EgressPolicy{
+		AllowedHosts:   []string{},
+		AllowedDomains: []string{},
+		DeniedCIDRs:    slices.Clone(DefaultDeniedCIDRs),
+		AllowedSchemes: []string{"http", "https"},
+		AllowedPorts:   []int{DefaultHTTPPort, DefaultHTTPSPort},
+		AllowedMethods: []string{http.MethodGet, http.MethodHead},
+		ResolveHosts:   true,
+	}
+}
+
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network string, host string) ([]netip.Addr, error)
+}
+
+// EgressGuard enforces an EgressPolicy on outbound requests. An empty host and
+// domain allowlist permits any host that does not resolve into a denied CIDR.
+type EgressGuard struct {
+	policy   EgressPolicy
+	denied   []netip.Prefix
+	resolver Resolver
+}
+
+func NewEgressGuard(policy EgressPolicy) (*EgressGuard, error) {
+	return NewEgressGuardWithResolver(policy, net.DefaultResolver)
+}
+
+// NewEgressGuardWithResolver is NewEgressGuard with hosts resolved by
+// resolver. A policy that denies CIDRs but does not resolve hosts must
+// allowlist hosts or domains, since any other hostname, such as one that
+// points at 127.0.0.1, could not be checked against the denied CIDRs.
+func NewEgressGuardWithResolver(
+	policy EgressPolicy,
+	resolver Resolver,
+) (*EgressGuard, error) {
+	denied := make([]netip.Prefix, 0, len(policy.DeniedCIDRs))
+	for _, cidr := range policy.DeniedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, egressError("parsing denied cidr "+cidr, err)
+		}
+		denied = append(denied, prefix.Masked())
+	}
+	if len(denied) > 0 && !policy.ResolveHosts &&
+		len(policy.AllowedHosts) == 0 && len(policy.AllowedDomains) == 0 {
+		return nil, egressError("resolve_hosts disabled without allowed hosts or domains", nil)
+	}
+	return &EgressGuard{
+		policy:   policy,
+		denied:   denied,
+		resolver: resolver,
+	}, nil
+}
+
+func (g *EgressGuard) Check(
+	ctx context.Context,
+	method string,
+	target *url.URL,
+) error {
+	if len(g.policy.AllowedMethods) > 0 &&
+		!slices.ContainsFunc(g.policy.AllowedMethods, equalFold(method)) {
+		return egressErrorDenied("method not allowed: "+method, nil)
+	}
+
+	scheme := strings.ToLower(target.Scheme)
+	if len(g.policy.AllowedSchemes) > 0 &&
+		!slices.ContainsFunc(g.policy.AllowedSchemes, equalFold(scheme)) {
+		return egressErrorDenied("scheme not allowed: "+scheme, nil)
+	}
+
+	port, err := urlPort(target)
+	if err != nil {
+		return egressErrorDenied("invalid port", err)
+	}
+	if len(g.policy.AllowedPorts) > 0 && !slices.Contains(g.policy.AllowedPorts, port) {
+		return egressErrorDenied("port not allowed: "+strconv.Itoa(port), nil)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(target.Hostname(), "."))
+	if host == "" {
+		return egressErrorDenied("missing host", nil)
+	}
+	if !g.hostAllowed(host) {
+		return egressErrorDenied("host not allowed: "+host, nil)
+	}
+	return g.checkAddrs(ctx, host)
+}
+
+// RoundTripper wraps next so that every request, including redirects, is
+// checked against the policy before it leaves the enclave.
+func (g *EgressGuard) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		err := g.Check(req.Context(), req.Method, req.URL)
+		if err != nil {
+			return nil, err
+		}
+		return next.RoundTrip(req)
+	})
+}
+
+// Apply installs the guard on client's transport and returns client.
+func (g *EgressGuard) Apply(client *http.Client) *http.Client {
+	client.Transport = g.RoundTripper(client.Transport)
+	return client
+}
+
+func (g *EgressGuard) hostAllowed(host string) bool {
+	if len(g.policy.AllowedHosts) == 0 && len(g.policy.AllowedDomains) == 0 {
+		return true
+	}
+	if slices.ContainsFunc(g.policy.AllowedHosts, equalFold(host)) {
+		return true
+	}
+	for _, domain := range g.policy.AllowedDomains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddrs resolves host and rejects it if any address falls within a
+// denied CIDR. Note that the outbound proxy performs its own resolution, so
+// this narrows, but does not fully close, the DNS rebinding window. Nitro
+// Enclaves have no DNS, so they must disable ResolveHosts and rely on the host
+// allowlists, which NewEgressGuardWithResolver then requires; IP literals are
+// still checked.
+func (g *EgressGuard) checkAddrs(ctx context.Context, host string) error {
+	if len(g.denied) == 0 {
+		return nil
+	}
+
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else if g.policy.ResolveHosts {
+		addrs, err = g.resolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return egressErrorDenied("resolving host "+host, err)
+		}
+	}
+
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		for _, prefix := range g.denied {
+			if prefix.Contains(addr) {
+				msg := fmt.Sprintf("host %s resolves to denied address %s", host, addr)
+				return egressErrorDenied(msg, nil)
+			}
+		}
+	}
+	return nil
+}
+
+func urlPort(target *url.URL) (int, error) {
+	if p := target.Port(); p != "" {
+		return strconv.Atoi(p)
+	}
+	switch strings.ToLower(target.Scheme) {
+	case "http":
+		return DefaultHTTPPort, nil
+	case "https":
+		return DefaultHTTPSPort, nil
+	default:
+		return 0, egressError("unknown default port for scheme "+target.Scheme, nil)
+	}
+}
+
+func equalFold(s string) func(string) bool {
+	return func(v string) bool { return strings.EqualFold(v, s) }
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
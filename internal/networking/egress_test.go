@@ -0,0 +1,286 @@
+package networking_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticResolver map[string][]netip.Addr
+
+func (s staticResolver) LookupNetIP(
+	_ context.Context,
+	_ string,
+	host string,
+) ([]netip.Addr, error) {
+	addrs, ok := s[host]
+	if !ok {
+		return nil, assert.AnError
+	}
+	return addrs, nil
+}
+
+func parseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u
+}
+
+func TestEgressGuard_Check(t *testing.T) {
+	resolver := staticResolver{
+		"httpbin.org":       {netip.MustParseAddr("34.227.213.82")},
+		"api.example.com":   {netip.MustParseAddr("93.184.216.34")},
+		"metadata.internal": {netip.MustParseAddr("169.254.169.254")},
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		policy := networking.DefaultEgressPolicy()
+		policy.AllowedHosts = []string{"httpbin.org"}
+		policy.AllowedDomains = []string{"example.com"}
+
+		guard, err := networking.NewEgressGuardWithResolver(policy, resolver)
+		require.NoError(t, err)
+
+		// when
+		errHost := guard.Check(context.Background(), http.MethodGet, parseURL(t, "http://httpbin.org/get"))
+		errDomain := guard.Check(context.Background(), http.MethodGet, parseURL(t, "https://api.example.com/v1"))
+
+		// then
+		require.NoError(t, errHost)
+		require.NoError(t, errDomain)
+	})
+
+	t.Run("error - method not allowed", func(t *testing.T) {
+		// given
+		policy := networking.DefaultEgressPolicy()
+		guard, err := networking.NewEgressGuardWithResolver(policy, resolver)
+		require.NoError(t, err)
+
+		// when
+		err = guard.Check(context.Background(), http.MethodDelete, parseURL(t, "http://httpbin.org/delete"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEgressDenied)
+		assert.ErrorContains(t, err, "method not allowed")
+	})
+
+	t.Run("error - scheme not allowed", func(t *testing.T) {
+		// given
+		policy := networking.DefaultEgressPolicy()
+		guard, err := networking.NewEgressGuardWithResolver(policy, resolver)
+		require.NoError(t, err)
+
+		// when
+		err = guard.Check(context.Background(), http.MethodGet, parseURL(t, "file:///etc/passwd"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEgressDenied)
+		assert.ErrorContains(t, err, "scheme not allowed")
+	})
+
+	t.Run("error - port not allowed", func(t *testing.T) {
+		// given
+		policy := networking.DefaultEgressPolicy()
+		guard, err := networking.NewEgressGuardWithResolver(policy, resolver)
+		require.NoError(t, err)
+
+		// when
+		err = guard.Check(context.Background(), http.MethodGet, parseURL(t, "http://httpbin.org:22/"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEgressDenied)
+		assert.ErrorContains(t, err, "port not allowed")
+	})
+
+	t.Run("error - host not allowed", func(t *testing.T) {
+		// given
+		policy := networking.DefaultEgressPolicy()
+		policy.AllowedDomains = []string{"example.com"}
+		guard, err := networking.NewEgressGuardWithResolver(policy, resolver)
+		require.NoError(t, err)
+
+		// when
+		err = guard.Check(context.Background(), http.MethodGet, parseURL(t, "http://notexample.com/"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEgressDenied)
+		assert.ErrorContains(t, err, "host not allowed")
+	})
+
+	t.Run("error - ip literal in denied cidr", func(t *testing.T) {
+		tests := map[string]string{
+			"unspecified":      "http://0.0.0.0/",
+			"private":          "http://10.0.0.1/",
+			"cgnat":            "http://100.64.0.1/",
+			"loopback":         "http://127.0.0.1/",
+			"metadata":         "http://169.254.169.254/latest",
+			"private 172":      "http://172.16.0.1/",
+			"private 192":      "http://192.168.1.1/",
+			"benchmarking":     "http://198.18.0.1/",
+			"multicast":        "http://224.0.0.1/",
+			"ipv6 unspecified": "http://[::]/",
+			"ipv6 loopback":    "http://[::1]/",
+			"nat64":            "http://[64:ff9b::a00:1]/",
+			"6to4":             "http://[2002:a00:1::1]/",
+			"unique local":     "http://[fd00::1]/",
+			"ipv6 link-local":  "http://[fe80::1]/",
+			"ipv6 multicast":   "http://[ff02::1]/",
+		}
+		for name, rawURL := range tests {
+			t.Run(name, func(t *testing.T) {
+				// given
+				policy := networking.DefaultEgressPolicy()
+				guard, err := networking.NewEgressGuardWithResolver(policy, resolver)
+				require.NoError(t, err)
+
+				// when
+				err = guard.Check(context.Background(), http.MethodGet, parseURL(t, rawURL))
+
+				// then
+				require.ErrorIs(t, err, networking.ErrEgressDenied)
+				assert.ErrorContains(t, err, "denied address")
+			})
+		}
+	})
+
+	t.Run("error - resolved address in denied cidr", func(t *testing.T) {
+		// given
+		policy := networking.DefaultEgressPolicy()
+		guard, err := networking.NewEgressGuardWithResolver(policy, resolver)
+		require.NoError(t, err)
+
+		// when
+		err = guard.Check(context.Background(), http.MethodGet, parseURL(t, "http://metadata.internal/"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEgressDenied)
+		assert.ErrorContains(t, err, "denied address 169.254.169.254")
+	})
+
+	t.Run("error - resolving host", func(t *testing.T) {
+		// given
+		policy := networking.DefaultEgressPolicy()
+		guard, err := networking.NewEgressGuardWithResolver(policy, resolver)
+		require.NoError(t, err)
+
+		// when
+		err = guard.Check(context.Background(), http.MethodGet, parseURL(t, "http://unknown.org/"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEgressDenied)
+		assert.ErrorContains(t, err, "resolving host")
+	})
+
+	t.Run("error - hostname not resolved", func(t *testing.T) {
+		// given
+		policy := networking.DefaultEgressPolicy()
+		policy.AllowedHosts = []string{"httpbin.org"}
+		policy.ResolveHosts = false
+		guard, err := networking.NewEgressGuardWithResolver(policy, resolver)
+		require.NoError(t, err)
+
+		// when
+		err = guard.Check(context.Background(), http.MethodGet, parseURL(t, "http://metadata.internal/"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEgressDenied)
+		assert.ErrorContains(t, err, "host not allowed")
+	})
+
+	t.Run("error - resolve hosts disabled without allowlist", func(t *testing.T) {
+		// given
+		policy := networking.DefaultEgressPolicy()
+		policy.ResolveHosts = false
+
+		// when
+		_, err := networking.NewEgressGuardWithResolver(policy, resolver)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEgress)
+		assert.ErrorContains(t, err, "resolve_hosts disabled")
+	})
+
+	t.Run("error - parsing denied cidr", func(t *testing.T) {
+		// given
+		policy := networking.DefaultEgressPolicy()
+		policy.DeniedCIDRs = []string{"not a cidr"}
+
+		// when
+		_, err := networking.NewEgressGuardWithResolver(policy, resolver)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEgress)
+		assert.ErrorContains(t, err, "parsing denied cidr")
+	})
+}
+
+func TestEgressGuard_Apply(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		backend := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		defer backend.Close()
+
+		policy := networking.DefaultEgressPolicy()
+		policy.DeniedCIDRs = []string{}
+		policy.AllowedPorts = []int{}
+
+		guard, err := networking.NewEgressGuard(policy)
+		require.NoError(t, err)
+		client := guard.Apply(backend.Client())
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, backend.URL, nil)
+		require.NoError(t, err)
+
+		// when
+		resp, err := client.Do(req)
+
+		// then
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("error - request denied before sending", func(t *testing.T) {
+		// given
+		called := false
+		backend := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		defer backend.Close()
+
+		policy := networking.DefaultEgressPolicy()
+		policy.AllowedPorts = []int{}
+
+		guard, err := networking.NewEgressGuard(policy)
+		require.NoError(t, err)
+		client := guard.Apply(backend.Client())
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, backend.URL, nil)
+		require.NoError(t, err)
+
+		// when
+		//nolint:bodyclose
+		_, err = client.Do(req)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEgressDenied)
+		assert.False(t, called)
+	})
+}
@@ -0,0 +1,319 @@
+package networking
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/hpke"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+const (
+	AttestHPKEKeyPath      = "/attest-hpke-key"
+	EncryptionKey          = "encryption"
+	EncryptedContentType   = "application/vnd.bearclave.hpke+json"
+	hpkeRequestInfoPrefix  = "bearclave-hpke-v1 request\n"
+	hpkeResponseInfoPrefix = "bearclave-hpke-v1 response\n"
+)
+
+// The HPKE suite is DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and
+// ChaCha20Poly1305, which every platform can run without AES hardware.
+var (
+	hpkeKEM  = hpke.DHKEM(ecdh.X25519())
+	hpkeKDF  = hpke.HKDFSHA256()
+	hpkeAEAD = hpke.ChaCha20Poly1305()
+)
+
+type EncryptionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+func DefaultEncryptionConfig() EncryptionConfig {
+	return EncryptionConfig{Enabled: false}
+}
+
+// SealedRequest is the body of an encrypted request. Sealed is the HPKE
+// encapsulated key followed by the ciphertext of the plaintext body, and
+// ResponseKey is a public key, generated for this request only, that the
+// Enclave seals its response to. ContentType is that of the plaintext, and
+// defaults to JSON; it is not sealed, since changing it can only make the
+// plaintext fail to decode.
+type SealedRequest struct {
+	KeyID       string `json:"key_id"`
+	ResponseKey []byte `json:"response_key"`
+	ContentType string `json:"content_type,omitempty"`
+	Sealed      []byte `json:"sealed"`
+}
+
+// SealedResponse is the body of an encrypted response.
+type SealedResponse struct {
+	ContentType string `json:"content_type,omitempty"`
+	Sealed      []byte `json:"sealed"`
+}
+
+// HPKEKey is an X25519 key pair generated when the Enclave starts. Its public
+// key is attested through AttestHPKEKeyPath so that clients can encrypt
+// requests that only this Enclave can read. Like a SessionKey, the private key
+// never leaves the Enclave's memory and a restart yields a new key.
+type HPKEKey struct {
+	id      string
+	private hpke.PrivateKey
+}
+
+func NewHPKEKey() (*HPKEKey, error) {
+	private, err := hpkeKEM.GenerateKey()
+	if err != nil {
+		return nil, encryptionError("generating key", err)
+	}
+	return &HPKEKey{id: DigestSHA256(private.PublicKey().Bytes()), private: private}, nil
+}
+
+func (k *HPKEKey) ID() string {
+	return k.id
+}
+
+func (k *HPKEKey) PublicKey() []byte {
+	return k.private.PublicKey().Bytes()
+}
+
+// OpenRequest decrypts a request sent to method and path, returning its body
+// and the key to seal the response to.
+func (k *HPKEKey) OpenRequest(
+	method string,
+	path string,
+	req SealedRequest,
+) ([]byte, hpke.PublicKey, error) {
+	if req.KeyID != k.id {
+		return nil, nil, encryptionErrorUnknownKey(req.KeyID, nil)
+	}
+	responseKey, err := hpkeKEM.NewPublicKey(req.ResponseKey)
+	if err != nil {
+		return nil, nil, encryptionError("parsing response key", err)
+	}
+
+	info := hpkeRequestInfo(method, path, req.ResponseKey)
+	plaintext, err := hpke.Open(k.private, hpkeKDF, hpkeAEAD, info, req.Sealed)
+	if err != nil {
+		return nil, nil, encryptionError("opening request", err)
+	}
+	return plaintext, responseKey, nil
+}
+
+// AttestedHPKEKey is the attested payload of an AttestHPKEKeyPath response.
+// Like every key attestation, it carries no Stamp.
+type AttestedHPKEKey struct {
+	KeyID     string `json:"key_id"`
+	KEM       uint16 `json:"kem"`
+	KDF       uint16 `json:"kdf"`
+	AEAD      uint16 `json:"aead"`
+	PublicKey []byte `json:"public_key"`
+}
+
+// Validate checks that the key uses the supported suite and that its ID
+// matches.
+func (a AttestedHPKEKey) Validate() error {
+	switch {
+	case a.KEM != hpkeKEM.ID() || a.KDF != hpkeKDF.ID() || a.AEAD != hpkeAEAD.ID():
+		return encryptionError("unsupported hpke suite", nil)
+	case a.KeyID != DigestSHA256(a.PublicKey):
+		return encryptionError("key id does not match public key", nil)
+	}
+	_, err := hpkeKEM.NewPublicKey(a.PublicKey)
+	if err != nil {
+		return encryptionError("parsing public key", err)
+	}
+	return nil
+}
+
+// SealRequest encrypts body for a request to method and path. It returns the
+// private key that OpenResponse needs to decrypt the reply.
+func (a AttestedHPKEKey) SealRequest(
+	method string,
+	path string,
+	body []byte,
+) (SealedRequest, hpke.PrivateKey, error) {
+	publicKey, err := hpkeKEM.NewPublicKey(a.PublicKey)
+	if err != nil {
+		return SealedRequest{}, nil, encryptionError("parsing public key", err)
+	}
+	responseKey, err := hpkeKEM.GenerateKey()
+	if err != nil {
+		return SealedRequest{}, nil, encryptionError("generating response key", err)
+	}
+
+	req := SealedRequest{KeyID: a.KeyID, ResponseKey: responseKey.PublicKey().Bytes()}
+	info := hpkeRequestInfo(method, path, req.ResponseKey)
+	req.Sealed, err = hpke.Seal(publicKey, hpkeKDF, hpkeAEAD, info, body)
+	if err != nil {
+		return SealedRequest{}, nil, encryptionError("sealing request", err)
+	}
+	return req, responseKey, nil
+}
+
+// SealResponse encrypts body to the response key of req.
+func SealResponse(
+	responseKey hpke.PublicKey,
+	req SealedRequest,
+	body []byte,
+) (SealedResponse, error) {
+	sealed, err := hpke.Seal(responseKey, hpkeKDF, hpkeAEAD, hpkeResponseInfo(req), body)
+	if err != nil {
+		return SealedResponse{}, encryptionError("sealing response", err)
+	}
+	return SealedResponse{Sealed: sealed}, nil
+}
+
+// OpenResponse decrypts the response to req with the response key returned by
+// SealRequest.
+func OpenResponse(
+	responseKey hpke.PrivateKey,
+	req SealedRequest,
+	resp SealedResponse,
+) ([]byte, error) {
+	body, err := hpke.Open(responseKey, hpkeKDF, hpkeAEAD, hpkeResponseInfo(req), resp.Sealed)
+	if err != nil {
+		return nil, encryptionError("opening response", err)
+	}
+	return body, nil
+}
+
+// hpkeRequestInfo binds a request to its method, path and response key, so
+// that the proxy can neither replay it against another endpoint nor swap in
+// its own response key.
+func hpkeRequestInfo(method string, path string, responseKey []byte) []byte {
+	info := []byte(hpkeRequestInfoPrefix + method + " " + path + "\n")
+	return append(info, responseKey...)
+}
+
+// hpkeResponseInfo binds a response to the request it answers.
+func hpkeResponseInfo(req SealedRequest) []byte {
+	return append([]byte(hpkeResponseInfoPrefix), DigestSHA256(req.Sealed)...)
+}
+
+// DecryptRequests serves requests with an EncryptedContentType body by
+// opening them with key, passing the plaintext to next, and sealing whatever
+// next writes, including errors, to the request's response key. Status codes
+// and headers are left in the clear. Plaintext requests pass through, as does
+// everything when key is nil.
+func DecryptRequests(key *HPKEKey, logger *slog.Logger, next http.Handler) http.Handler {
+	if key == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != EncryptedContentType {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger := LoggerFromContext(r.Context(), logger)
+
+		sealedReq := SealedRequest{}
+		err := json.NewDecoder(r.Body).Decode(&sealedReq)
+		if err != nil {
+			WriteError(w, badRequestError("decoding sealed request", err))
+			return
+		}
+		body, responseKey, err := key.OpenRequest(r.Method, r.URL.Path, sealedReq)
+		switch {
+		case errors.Is(err, ErrEncryptionUnknownKey):
+			logger.Warn("request sealed to unknown key", slog.String("key_id", sealedReq.KeyID))
+			WriteError(w, NewAPIError(ErrorCodeUnknownKey, "", err))
+			return
+		case err != nil:
+			WriteError(w, badRequestError("", err))
+			return
+		}
+
+		contentType := sealedReq.ContentType
+		if contentType == "" {
+			contentType = ContentTypeJSON
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Type", contentType)
+
+		buffer := newResponseBuffer(w.Header().Clone())
+		next.ServeHTTP(buffer, r)
+
+		sealedResp, err := SealResponse(responseKey, sealedReq, buffer.body.Bytes())
+		if err != nil {
+			logger.Error("sealing response failed", slog.String("error", err.Error()))
+			WriteError(w, internalError("", err))
+			return
+		}
+		sealedResp.ContentType = buffer.header.Get("Content-Type")
+		data, err := json.Marshal(sealedResp)
+		if err != nil {
+			WriteError(w, internalError("marshaling sealed response", err))
+			return
+		}
+
+		for name, values := range buffer.header {
+			w.Header()[name] = values
+		}
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Type", EncryptedContentType)
+		w.WriteHeader(buffer.status)
+		_, _ = w.Write(data)
+	})
+}
+
+type AttestHPKEKeyRequest struct {
+	Nonce      []byte          `json:"nonce,omitempty"`
+	Commitment DigestAlgorithm `json:"commitment,omitempty"`
+}
+type AttestHPKEKeyResponse = AttestResponse
+
+func (r AttestHPKEKeyRequest) AttestNonce() []byte               { return r.Nonce }
+func (r AttestHPKEKeyRequest) AttestCommitment() DigestAlgorithm { return r.Commitment }
+
+// MakeAttestHPKEKeyHandler attests hpkeKey's public key.
+func MakeAttestHPKEKeyHandler(
+	attester *tee.Attester,
+	hpkeKey *HPKEKey,
+	logger *slog.Logger,
+) http.HandlerFunc {
+	endpoint := &AttestedEndpoint[AttestHPKEKeyRequest, AttestedHPKEKey]{
+		Name:     "hpke key",
+		Attester: NewDirectAttester(attester),
+		Logger:   logger,
+		Compute: func(context.Context, AttestHPKEKeyRequest) (AttestedHPKEKey, error) {
+			return AttestedHPKEKey{
+				KeyID:     hpkeKey.ID(),
+				KEM:       hpkeKEM.ID(),
+				KDF:       hpkeKDF.ID(),
+				AEAD:      hpkeAEAD.ID(),
+				PublicKey: hpkeKey.PublicKey(),
+			}, nil
+		},
+		LogAttrs: func(_ AttestHPKEKeyRequest, key AttestedHPKEKey) []any {
+			return []any{slog.String("key_id", key.KeyID)}
+		},
+	}
+	return endpoint.ServeHTTP
+}
+
+// ParseAttestedHPKEKey returns the HPKE key attested in verified, checking
+// payload against its commitment if one was used.
+func ParseAttestedHPKEKey(verified *tee.VerifyResult, payload []byte) (AttestedHPKEKey, error) {
+	payload, err := AttestedPayload(verified, payload)
+	if err != nil {
+		return AttestedHPKEKey{}, err
+	}
+
+	key := AttestedHPKEKey{}
+	err = json.Unmarshal(payload, &key)
+	if err != nil {
+		return AttestedHPKEKey{}, encryptionError("unmarshaling attested key", err)
+	}
+	err = key.Validate()
+	if err != nil {
+		return AttestedHPKEKey{}, err
+	}
+	return key, nil
+}
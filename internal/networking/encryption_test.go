@@ -0,0 +1,405 @@
+package networking_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/engine"
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func attestedHPKEKey(t *testing.T, hpkeKey *networking.HPKEKey) networking.AttestedHPKEKey {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	req := makeRequest(t, "POST", networking.AttestHPKEKeyPath, networking.AttestHPKEKeyRequest{})
+	handler := networking.MakeAttestHPKEKeyHandler(attester, hpkeKey, slog.New(slog.DiscardHandler))
+	handler.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	response := networking.AttestHPKEKeyResponse{}
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+	verified, err := verifier.Verify(response.Attestation)
+	require.NoError(t, err)
+	key, err := networking.ParseAttestedHPKEKey(verified, response.Payload)
+	require.NoError(t, err)
+	return key
+}
+
+func TestHPKEKey_OpenRequest(t *testing.T) {
+	hpkeKey, err := networking.NewHPKEKey()
+	require.NoError(t, err)
+	attestedKey := attestedHPKEKey(t, hpkeKey)
+	body := []byte(`{"env":{"balance":100}}`)
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		sealedReq, responseKey, err := attestedKey.SealRequest("POST", "/attest-expr", body)
+		require.NoError(t, err)
+
+		// when
+		got, sealTo, err := hpkeKey.OpenRequest("POST", "/attest-expr", sealedReq)
+		require.NoError(t, err)
+		sealedResp, err := networking.SealResponse(sealTo, sealedReq, []byte("response"))
+		require.NoError(t, err)
+		resp, err := networking.OpenResponse(responseKey, sealedReq, sealedResp)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, body, got)
+		assert.Equal(t, []byte("response"), resp)
+		assert.NotContains(t, string(sealedReq.Sealed), "balance")
+	})
+
+	t.Run("error - other path", func(t *testing.T) {
+		// given
+		sealedReq, _, err := attestedKey.SealRequest("POST", "/attest-expr", body)
+		require.NoError(t, err)
+
+		// when
+		_, _, err = hpkeKey.OpenRequest("POST", "/attest-cel", sealedReq)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEncryption)
+	})
+
+	t.Run("error - swapped response key", func(t *testing.T) {
+		// given
+		sealedReq, _, err := attestedKey.SealRequest("POST", "/attest-expr", body)
+		require.NoError(t, err)
+		other, _, err := attestedKey.SealRequest("POST", "/attest-expr", body)
+		require.NoError(t, err)
+		sealedReq.ResponseKey = other.ResponseKey
+
+		// when
+		_, _, err = hpkeKey.OpenRequest("POST", "/attest-expr", sealedReq)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEncryption)
+	})
+
+	t.Run("error - unknown key", func(t *testing.T) {
+		// given
+		otherKey, err := networking.NewHPKEKey()
+		require.NoError(t, err)
+		sealedReq, _, err := attestedHPKEKey(t, otherKey).SealRequest("POST", "/attest-expr", body)
+		require.NoError(t, err)
+
+		// when
+		_, _, err = hpkeKey.OpenRequest("POST", "/attest-expr", sealedReq)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEncryptionUnknownKey)
+	})
+
+	t.Run("error - response to another request", func(t *testing.T) {
+		// given
+		sealedReq, responseKey, err := attestedKey.SealRequest("POST", "/attest-expr", body)
+		require.NoError(t, err)
+		_, sealTo, err := hpkeKey.OpenRequest("POST", "/attest-expr", sealedReq)
+		require.NoError(t, err)
+		otherReq, _, err := attestedKey.SealRequest("POST", "/attest-expr", body)
+		require.NoError(t, err)
+		sealedResp, err := networking.SealResponse(sealTo, otherReq, []byte("response"))
+		require.NoError(t, err)
+
+		// when
+		_, err = networking.OpenResponse(responseKey, sealedReq, sealedResp)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrEncryption)
+	})
+}
+
+func TestAttestedHPKEKey_Validate(t *testing.T) {
+	hpkeKey, err := networking.NewHPKEKey()
+	require.NoError(t, err)
+	valid := attestedHPKEKey(t, hpkeKey)
+
+	unsupportedSuite := valid
+	unsupportedSuite.AEAD = 1
+	keyIDMismatch := valid
+	keyIDMismatch.KeyID = "sha256:00"
+	invalidPublicKey := valid
+	invalidPublicKey.PublicKey = []byte("short")
+	invalidPublicKey.KeyID = networking.DigestSHA256(invalidPublicKey.PublicKey)
+
+	tests := map[string]networking.AttestedHPKEKey{
+		"unsupported suite":  unsupportedSuite,
+		"key id mismatch":    keyIDMismatch,
+		"invalid public key": invalidPublicKey,
+	}
+	for name, key := range tests {
+		t.Run("error - "+name, func(t *testing.T) {
+			// when
+			err := key.Validate()
+
+			// then
+			require.ErrorIs(t, err, networking.ErrEncryption)
+		})
+	}
+}
+
+func TestDecryptRequests(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	hpkeKey, err := networking.NewHPKEKey()
+	require.NoError(t, err)
+	attestedKey := attestedHPKEKey(t, hpkeKey)
+
+	t.Run("happy path - plaintext passes through", func(t *testing.T) {
+		// given
+		handler := networking.DecryptRequests(hpkeKey, logger, okHandler())
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "POST", "/", map[string]any{})
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("happy path - errors are sealed", func(t *testing.T) {
+		// given
+		inner := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			networking.WriteError(w, networking.NewAPIError(networking.ErrorCodeBadRequest, "secret", nil))
+		})
+		handler := networking.DecryptRequests(hpkeKey, logger, inner)
+		sealedReq, responseKey, err := attestedKey.SealRequest("POST", "/", []byte("{}"))
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "POST", "/", sealedReq)
+		req.Header.Set("Content-Type", networking.EncryptedContentType)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, networking.EncryptedContentType, recorder.Header().Get("Content-Type"))
+		assert.NotContains(t, recorder.Body.String(), "secret")
+
+		sealedResp := networking.SealedResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&sealedResp)
+		require.NoError(t, err)
+		body, err := networking.OpenResponse(responseKey, sealedReq, sealedResp)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "secret")
+	})
+
+	t.Run("error - unknown key", func(t *testing.T) {
+		// given
+		otherKey, err := networking.NewHPKEKey()
+		require.NoError(t, err)
+		handler := networking.DecryptRequests(otherKey, logger, okHandler())
+		sealedReq, _, err := attestedKey.SealRequest("POST", "/", []byte("{}"))
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "POST", "/", sealedReq)
+		req.Header.Set("Content-Type", networking.EncryptedContentType)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, networking.ErrorCodeUnknownKey, decodeAPIError(t, recorder).Code)
+	})
+}
+
+// encryptionServer serves AttestHPKEKeyPath and an encrypted Expr endpoint
+// for the HPKE key currently stored in hpkeKey, counting key attestations and
+// recording every body that crosses it, as a proxy would see them.
+type encryptionServer struct {
+	*httptest.Server
+	hpkeKey     atomic.Pointer[networking.HPKEKey]
+	keyRequests atomic.Int32
+
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newEncryptionServer(t *testing.T) *encryptionServer {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	exprEngine, err := engine.NewExprEngine()
+	require.NoError(t, err)
+	hpkeKey, err := networking.NewHPKEKey()
+	require.NoError(t, err)
+	logger := slog.New(slog.DiscardHandler)
+
+	server := &encryptionServer{}
+	server.hpkeKey.Store(hpkeKey)
+	exprHandler := networking.MakeAttestExprHandler(
+		exprEngine,
+		defaultTimeout,
+		attester,
+		networking.NewSequencer(),
+		logger,
+	)
+	routes := map[string]http.Handler{
+		"POST " + networking.AttestHPKEKeyPath: server.record(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				server.keyRequests.Add(1)
+				networking.MakeAttestHPKEKeyHandler(attester, server.hpkeKey.Load(), logger).
+					ServeHTTP(w, r)
+			},
+		)),
+		"POST " + networking.AttestExprPath: server.record(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				networking.DecryptRequests(server.hpkeKey.Load(), logger, exprHandler).
+					ServeHTTP(w, r)
+			},
+		)),
+	}
+	server.Server = newEnclaveServer(t, routes, nil)
+	return server
+}
+
+// record records the request and response bodies of next.
+func (s *encryptionServer) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		recorder := httptest.NewRecorder()
+		next.ServeHTTP(recorder, r)
+
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body), recorder.Body.String())
+		s.mu.Unlock()
+
+		for name, values := range recorder.Header() {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(recorder.Code)
+		_, _ = w.Write(recorder.Body.Bytes())
+	})
+}
+
+func TestClient_Encryption(t *testing.T) {
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	expression := `balance > 1000 ? "approved" : "denied"`
+	env := map[string]any{"balance": 123456789}
+
+	t.Run("happy path - proxy only sees ciphertext", func(t *testing.T) {
+		// given
+		server := newEncryptionServer(t)
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientEncryption(verifier),
+			networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		)
+
+		// when
+		var responses []networking.AttestExprResponse
+		for range 2 {
+			resp, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+			require.NoError(t, err)
+			responses = append(responses, resp)
+		}
+
+		// then
+		assert.Equal(t, int32(1), server.keyRequests.Load())
+		for _, resp := range responses {
+			got := networking.AttestedExpr{}
+			err := json.Unmarshal(resp.Payload, &got)
+			require.NoError(t, err)
+			assert.Equal(t, "approved", got.Output)
+		}
+		for _, body := range server.bodies[2:] {
+			assert.NotContains(t, body, "123456789")
+			assert.NotContains(t, body, "approved")
+		}
+	})
+
+	t.Run("happy path - refetches key after restart", func(t *testing.T) {
+		// given
+		server := newEncryptionServer(t)
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientEncryption(verifier),
+		)
+		_, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+		require.NoError(t, err)
+
+		newKey, err := networking.NewHPKEKey()
+		require.NoError(t, err)
+		server.hpkeKey.Store(newKey)
+
+		// when
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), server.keyRequests.Load())
+
+		cached, err := client.HPKEKey(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, newKey.ID(), cached.KeyID)
+	})
+
+	t.Run("error - plaintext response", func(t *testing.T) {
+		// given
+		server := newEncryptionServer(t)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == networking.AttestHPKEKeyPath {
+				server.Config.Handler.ServeHTTP(w, r)
+				return
+			}
+			networking.WriteResponse(w, networking.AttestResponse{Payload: []byte(`{}`)})
+		})
+		forgery := httptest.NewServer(handler)
+		defer forgery.Close()
+		client := networking.NewClientWithClient(
+			forgery.URL,
+			forgery.Client(),
+			networking.WithClientEncryption(verifier),
+		)
+
+		// when
+		_, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClient)
+		assert.Contains(t, err.Error(), "not encrypted")
+	})
+
+	t.Run("error - no verifier", func(t *testing.T) {
+		// given
+		server := newEncryptionServer(t)
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientEncryption(nil),
+		)
+
+		// when
+		_, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClient)
+		assert.Equal(t, int32(0), server.keyRequests.Load())
+	})
+}
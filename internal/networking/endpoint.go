@@ -0,0 +1,243 @@
+package networking
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+// AttestRequest is implemented by every attest request so that an
+// AttestedEndpoint can find the caller's nonce and commitment mode.
+type AttestRequest interface {
+	AttestNonce() []byte
+	AttestCommitment() DigestAlgorithm
+}
+
+// AttestResponse is the response body of every attested endpoint. Payload is
+// set when the attestation commits to the payload rather than containing it,
+// and Proof is set when the attestation covers a batch of payloads. Responses
+// signed with a SessionKey carry a Signature and the Payload instead of an
+// Attestation.
+type AttestResponse struct {
+	Attestation *tee.AttestResult `json:"attestation"`
+	Payload     []byte            `json:"payload,omitempty"`
+	Proof       *MerkleProof      `json:"proof,omitempty"`
+	Signature   *SessionSignature `json:"signature,omitempty"`
+}
+
+// PayloadAttester binds a payload, and the caller's nonce, to an attestation.
+type PayloadAttester interface {
+	AttestPayload(
+		ctx context.Context,
+		nonce []byte,
+		payload []byte,
+		commitment DigestAlgorithm,
+	) (AttestResponse, error)
+}
+
+// DirectAttester attests every payload individually.
+type DirectAttester struct {
+	attester *tee.Attester
+}
+
+func NewDirectAttester(attester *tee.Attester) *DirectAttester {
+	return &DirectAttester{attester: attester}
+}
+
+func (d *DirectAttester) AttestPayload(
+	_ context.Context,
+	nonce []byte,
+	payload []byte,
+	commitment DigestAlgorithm,
+) (AttestResponse, error) {
+	attestation, committed, err := attestPayload(d.attester, nonce, payload, commitment)
+	if err != nil {
+		return AttestResponse{}, err
+	}
+	return AttestResponse{Attestation: attestation, Payload: committed}, nil
+}
+
+// AttestedEndpoint is an HTTP handler that decodes a Req, validates it,
+// computes a Result, builds the payload for that Result, and attests it. Every
+// step is logged, through the request-scoped logger if AccessLog installed one,
+// and every failure is written as an APIError. Compute should
+// return errors classified with the APIError helpers, since unclassified
+// errors are reported as internal errors.
+type AttestedEndpoint[Req AttestRequest, Result any] struct {
+	// Name identifies the endpoint in logs, e.g. "cel".
+	Name     string
+	Attester PayloadAttester
+	Logger   *slog.Logger
+
+	// Timeout bounds Compute and Attest. Zero means no timeout.
+	Timeout time.Duration
+
+	// MaxRequestBytes bounds the request body unless it is already bounded by
+	// LimitRequestBody. Zero means DefaultMaxRequestBytes.
+	MaxRequestBytes int64
+
+	// NonceOptional lets requests without a nonce through when challenges are
+	// required, for results whose attestation needs no freshness.
+	NonceOptional bool
+
+	// Decode defaults to decoding a JSON or CBOR request body, as given by its
+	// Content-Type.
+	Decode   func(r *http.Request) (Req, error)
+	Validate func(req Req) error
+	Compute  func(ctx context.Context, req Req) (Result, error)
+
+	// Payload defaults to marshaling Result as canonical JSON (RFC 8785), so
+	// that verifiers in any language can re-derive the attested bytes.
+	Payload func(req Req, result Result) ([]byte, error)
+
+	// Attest overrides the call to Attester, e.g. to serve from a cache.
+	Attest func(
+		ctx context.Context,
+		req Req,
+		result Result,
+		payload []byte,
+	) (AttestResponse, error)
+
+	// LogAttrs adds endpoint-specific attributes to the attesting log line.
+	LogAttrs func(req Req, result Result) []any
+}
+
+func (e *AttestedEndpoint[Req, Result]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	result := e.serve(w, r)
+	observeEndpoint(e.Name, result, time.Since(start))
+}
+
+// serve handles the request and returns the result label for the endpoint
+// metrics, which is either "ok" or the ErrorCode of the failure.
+func (e *AttestedEndpoint[Req, Result]) serve(w http.ResponseWriter, r *http.Request) string {
+	logger := LoggerFromContext(r.Context(), e.Logger)
+	logger.Info("received attest " + e.Name + " request")
+
+	if !requestBodyLimited(r) {
+		maxBytes := e.MaxRequestBytes
+		if maxBytes == 0 {
+			maxBytes = DefaultMaxRequestBytes
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	}
+
+	req, err := e.decode(r)
+	if err != nil {
+		return e.writeError(w, logger, badRequestError("decoding request", err))
+	}
+
+	err = req.AttestCommitment().Validate()
+	if err != nil {
+		return e.writeError(w, logger, badRequestError("validating commitment", err))
+	}
+	if e.Validate != nil {
+		err = e.Validate(req)
+		if err != nil {
+			return e.writeError(w, logger, badRequestError("validating request", err))
+		}
+	}
+
+	// The challenge is consumed only once the request is known to be well
+	// formed, so that a malformed request does not waste it.
+	issuer := challengeIssuerFromContext(r.Context())
+	if issuer != nil && (!e.NonceOptional || len(req.AttestNonce()) > 0) {
+		err = issuer.Consume(req.AttestNonce())
+		if err != nil {
+			return e.writeError(w, logger, challengeAPIError(err))
+		}
+	}
+
+	ctx := r.Context()
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	result, err := e.Compute(ctx, req)
+	if err != nil {
+		return e.writeError(w, logger, err)
+	}
+
+	payload, err := e.payload(req, result)
+	if err != nil {
+		return e.writeError(w, logger, internalError("marshaling result", err))
+	}
+
+	attrs := []any{
+		slog.String("nonce", base64.StdEncoding.EncodeToString(req.AttestNonce())),
+	}
+	if e.LogAttrs != nil {
+		attrs = append(attrs, e.LogAttrs(req, result)...)
+	}
+	logger.Info("attesting "+e.Name, attrs...)
+
+	attestStart := time.Now()
+	resp, err := e.attest(ctx, req, result, payload)
+	attestLatency := time.Since(attestStart)
+	recordAttestLatency(ctx, attestLatency)
+	observeAttestation(e.Name, attestLatency)
+	if err != nil {
+		return e.writeError(w, logger, attestationError("attesting", err))
+	}
+	WriteNegotiatedResponse(w, r, resp)
+	return resultOK
+}
+
+func (e *AttestedEndpoint[Req, Result]) decode(r *http.Request) (Req, error) {
+	if e.Decode != nil {
+		return e.Decode(r)
+	}
+
+	var req Req
+	codec, err := RequestCodec(r)
+	if err != nil {
+		return req, err
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return req, err
+	}
+	err = codec.Unmarshal(body, &req)
+	return req, err
+}
+
+func (e *AttestedEndpoint[Req, Result]) payload(req Req, result Result) ([]byte, error) {
+	if e.Payload != nil {
+		return e.Payload(req, result)
+	}
+	return MarshalCanonicalJSON(result)
+}
+
+func (e *AttestedEndpoint[Req, Result]) attest(
+	ctx context.Context,
+	req Req,
+	result Result,
+	payload []byte,
+) (AttestResponse, error) {
+	if e.Attest != nil {
+		return e.Attest(ctx, req, result, payload)
+	}
+	return e.Attester.AttestPayload(ctx, req.AttestNonce(), payload, req.AttestCommitment())
+}
+
+func (e *AttestedEndpoint[Req, Result]) writeError(
+	w http.ResponseWriter,
+	logger *slog.Logger,
+	err error,
+) string {
+	apiErr := AsAPIError(err)
+	logger.Error(
+		"attest "+e.Name+" request failed",
+		slog.String("code", string(apiErr.Code)),
+		slog.String("error", apiErr.Message),
+	)
+	WriteError(w, apiErr)
+	return string(apiErr.Code)
+}
@@ -0,0 +1,170 @@
+package networking_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+type greetRequest struct {
+	Nonce []byte `json:"nonce,omitempty"`
+	Name  string `json:"name"`
+}
+
+func (r greetRequest) AttestNonce() []byte { return r.Nonce }
+
+func (r greetRequest) AttestCommitment() networking.DigestAlgorithm {
+	return networking.DigestAlgorithmNone
+}
+
+type greeting struct {
+	Message string `json:"message"`
+}
+
+func makeGreetEndpoint(
+	t *testing.T,
+	logger *slog.Logger,
+) *networking.AttestedEndpoint[greetRequest, greeting] {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+
+	return &networking.AttestedEndpoint[greetRequest, greeting]{
+		Name:     "greet",
+		Attester: networking.NewDirectAttester(attester),
+		Logger:   logger,
+		Validate: func(req greetRequest) error {
+			if req.Name == "" {
+				return errors.New("missing name")
+			}
+			return nil
+		},
+		Compute: func(_ context.Context, req greetRequest) (greeting, error) {
+			if req.Name == "error" {
+				return greeting{}, assert.AnError
+			}
+			return greeting{Message: "Hello, " + req.Name}, nil
+		},
+	}
+}
+
+func TestAttestedEndpoint_ServeHTTP(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+		endpoint := makeGreetEndpoint(t, logger)
+
+		nonce := []byte("nonce")
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "POST", "/greet", greetRequest{Nonce: nonce, Name: "world"})
+
+		// when
+		endpoint.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.Contains(t, logBuffer.String(), "received attest greet request")
+		assert.Contains(t, logBuffer.String(), "attesting greet")
+
+		response := networking.AttestResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+
+		verified, err := verifier.Verify(response.Attestation, tee.WithVerifyNonce(nonce))
+		require.NoError(t, err)
+
+		got := greeting{}
+		err = json.Unmarshal(verified.UserData, &got)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello, world", got.Message)
+	})
+
+	t.Run("error - validating request", func(t *testing.T) {
+		// given
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+		endpoint := makeGreetEndpoint(t, logger)
+
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "POST", "/greet", greetRequest{})
+
+		// when
+		endpoint.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "missing name")
+		assert.Contains(t, logBuffer.String(), "attest greet request failed")
+	})
+
+	t.Run("error - unclassified compute error", func(t *testing.T) {
+		// given
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+		endpoint := makeGreetEndpoint(t, logger)
+
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "POST", "/greet", greetRequest{Name: "error"})
+
+		// when
+		endpoint.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), string(networking.ErrorCodeInternal))
+	})
+
+	t.Run("error - request too large", func(t *testing.T) {
+		// given
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+		endpoint := makeGreetEndpoint(t, logger)
+		endpoint.MaxRequestBytes = 16
+
+		recorder := httptest.NewRecorder()
+		name := strings.Repeat("a", 32)
+		req := makeRequest(t, "POST", "/greet", greetRequest{Name: name})
+
+		// when
+		endpoint.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	})
+
+	t.Run("happy path - limit set by middleware", func(t *testing.T) {
+		// given
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+		endpoint := makeGreetEndpoint(t, logger)
+		endpoint.MaxRequestBytes = 16
+		handler := networking.LimitRequestBody(1024, endpoint)
+
+		recorder := httptest.NewRecorder()
+		name := strings.Repeat("a", 32)
+		req := makeRequest(t, "POST", "/greet", greetRequest{Name: name})
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+}
@@ -0,0 +1,188 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrPayloadTooLarge         = errors.New("payload too large")
+	ErrACME                    = errors.New("acme")
+	ErrAPI                     = errors.New("api")
+	ErrAPIBadRequest           = fmt.Errorf("%w: bad request", ErrAPI)
+	ErrAPIUnauthorized         = fmt.Errorf("%w: unauthorized", ErrAPI)
+	ErrAPIForbidden            = fmt.Errorf("%w: forbidden", ErrAPI)
+	ErrAPIUnknownKey           = fmt.Errorf("%w: unknown key", ErrAPI)
+	ErrAPIInvalidChallenge     = fmt.Errorf("%w: invalid challenge", ErrAPI)
+	ErrAPIEvaluation           = fmt.Errorf("%w: evaluation failed", ErrAPI)
+	ErrAPIUpstream             = fmt.Errorf("%w: upstream failed", ErrAPI)
+	ErrAPIUpstreamTimeout      = fmt.Errorf("%w: upstream timeout", ErrAPI)
+	ErrAPIAttestation          = fmt.Errorf("%w: attestation failed", ErrAPI)
+	ErrAPIInternal             = fmt.Errorf("%w: internal", ErrAPI)
+	ErrAPIRateLimited          = fmt.Errorf("%w: rate limited", ErrAPI)
+	ErrAPIOverloaded           = fmt.Errorf("%w: overloaded", ErrAPI)
+	ErrAPIPayloadTooLarge      = fmt.Errorf("%w: %w", ErrAPI, ErrPayloadTooLarge)
+	ErrAPIUpstreamTooLarge     = fmt.Errorf("%w: %w", ErrAPIUpstream, ErrPayloadTooLarge)
+	ErrAuth                    = errors.New("auth")
+	ErrAttestedPayload         = errors.New("attested payload")
+	ErrAttestedPayloadMismatch = fmt.Errorf("%w: mismatch", ErrAttestedPayload)
+	ErrCanonicalJSON           = errors.New("canonical json")
+	ErrChallenge               = errors.New("challenge")
+	ErrChannelBinding          = errors.New("channel binding")
+	ErrChannelBindingMismatch  = fmt.Errorf("%w: mismatch", ErrChannelBinding)
+	ErrChallengeCacheFull      = fmt.Errorf("%w: replay cache full", ErrChallenge)
+	ErrChallengeExpired        = fmt.Errorf("%w: expired", ErrChallenge)
+	ErrChallengeReplayed       = fmt.Errorf("%w: replayed", ErrChallenge)
+	ErrClient                  = errors.New("client")
+	ErrCodec                   = errors.New("codec")
+	ErrCommitment              = errors.New("commitment")
+	ErrCertRotation            = errors.New("cert rotation")
+	ErrClientNon200Response    = fmt.Errorf("%w: non-200 response", ErrClient)
+	ErrClientUnknownCert       = fmt.Errorf("%w: unknown certificate", ErrClient)
+	ErrClientPinMismatch       = fmt.Errorf("%w: pin mismatch", ErrClientUnknownCert)
+	ErrEgress                  = errors.New("egress")
+	ErrEgressDenied            = fmt.Errorf("%w: denied", ErrEgress)
+	ErrEncryption              = errors.New("encryption")
+	ErrEncryptionUnknownKey    = fmt.Errorf("%w: unknown key", ErrEncryption)
+	ErrHealth                  = errors.New("health")
+	ErrMerkleProof             = errors.New("merkle proof")
+	ErrSessionKey              = errors.New("session key")
+	ErrSessionKeySignature     = fmt.Errorf("%w: invalid signature", ErrSessionKey)
+	ErrStamp                   = errors.New("stamp")
+	ErrStampGap                = fmt.Errorf("%w: sequence gap", ErrStamp)
+	ErrStampReordered          = fmt.Errorf("%w: sequence reordered", ErrStamp)
+	ErrStampTooOld             = fmt.Errorf("%w: too old", ErrStamp)
+)
+
+func payloadTooLargeError(msg string, err error) error {
+	return wrapError(ErrPayloadTooLarge, msg, err)
+}
+
+func wrapError(baseErr error, msg string, err error) error {
+	switch {
+	case msg == "" && err == nil:
+		return baseErr
+	case msg != "" && err != nil:
+		return fmt.Errorf("%w: %s: %w", baseErr, msg, err)
+	case msg != "":
+		return fmt.Errorf("%w: %s", baseErr, msg)
+	default:
+		return fmt.Errorf("%w: %w", baseErr, err)
+	}
+}
+
+func acmeError(msg string, err error) error {
+	return wrapError(ErrACME, msg, err)
+}
+
+func attestedPayloadErrorMismatch(msg string, err error) error {
+	return wrapError(ErrAttestedPayloadMismatch, msg, err)
+}
+
+func authError(msg string, err error) error {
+	return wrapError(ErrAuth, msg, err)
+}
+
+func canonicalJSONError(msg string, err error) error {
+	return wrapError(ErrCanonicalJSON, msg, err)
+}
+
+func certRotationError(msg string, err error) error {
+	return wrapError(ErrCertRotation, msg, err)
+}
+
+func challengeError(msg string, err error) error {
+	return wrapError(ErrChallenge, msg, err)
+}
+
+func challengeErrorCacheFull(msg string, err error) error {
+	return wrapError(ErrChallengeCacheFull, msg, err)
+}
+
+func challengeErrorExpired(msg string, err error) error {
+	return wrapError(ErrChallengeExpired, msg, err)
+}
+
+func challengeErrorReplayed(msg string, err error) error {
+	return wrapError(ErrChallengeReplayed, msg, err)
+}
+
+func channelBindingError(msg string, err error) error {
+	return wrapError(ErrChannelBinding, msg, err)
+}
+
+func channelBindingErrorMismatch(msg string, err error) error {
+	return wrapError(ErrChannelBindingMismatch, msg, err)
+}
+
+func clientError(msg string, err error) error {
+	return wrapError(ErrClient, msg, err)
+}
+
+func clientErrorNon200Response(msg string, err error) error {
+	return wrapError(ErrClientNon200Response, msg, err)
+}
+
+func clientErrorPinMismatch(msg string, err error) error {
+	return wrapError(ErrClientPinMismatch, msg, err)
+}
+
+func clientErrorUnknownCert(msg string, err error) error {
+	return wrapError(ErrClientUnknownCert, msg, err)
+}
+
+func codecError(msg string, err error) error {
+	return wrapError(ErrCodec, msg, err)
+}
+
+func commitmentError(msg string, err error) error {
+	return wrapError(ErrCommitment, msg, err)
+}
+
+func egressError(msg string, err error) error {
+	return wrapError(ErrEgress, msg, err)
+}
+
+func egressErrorDenied(msg string, err error) error {
+	return wrapError(ErrEgressDenied, msg, err)
+}
+
+func encryptionError(msg string, err error) error {
+	return wrapError(ErrEncryption, msg, err)
+}
+
+func encryptionErrorUnknownKey(msg string, err error) error {
+	return wrapError(ErrEncryptionUnknownKey, msg, err)
+}
+
+func healthError(msg string, err error) error {
+	return wrapError(ErrHealth, msg, err)
+}
+
+func merkleProofError(msg string, err error) error {
+	return wrapError(ErrMerkleProof, msg, err)
+}
+
+func sessionKeyError(msg string, err error) error {
+	return wrapError(ErrSessionKey, msg, err)
+}
+
+func sessionKeyErrorSignature(msg string, err error) error {
+	return wrapError(ErrSessionKeySignature, msg, err)
+}
+
+func stampError(msg string, err error) error {
+	return wrapError(ErrStamp, msg, err)
+}
+
+func stampErrorGap(msg string, err error) error {
+	return wrapError(ErrStampGap, msg, err)
+}
+
+func stampErrorReordered(msg string, err error) error {
+	return wrapError(ErrStampReordered, msg, err)
+}
+
+func stampErrorTooOld(msg string, err error) error {
+	return wrapError(ErrStampTooOld, msg, err)
+}
@@ -1,13 +1,18 @@
 package networking
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/tahardi/bearclave-examples/internal/engine"
@@ -25,377 +30,588 @@ const (
 	DefaultTimeout      = 15 * time.Second
 )
 
-type AttestCertRequest struct{}
-type AttestCertResponse struct {
-	Attestation *tee.AttestResult `json:"attestation"`
+type AttestCertRequest struct {
+	Nonce      []byte          `json:"nonce,omitempty"`
+	Commitment DigestAlgorithm `json:"commitment,omitempty"`
 }
+type AttestCertResponse = AttestResponse
 
+func (r AttestCertRequest) AttestNonce() []byte               { return r.Nonce }
+func (r AttestCertRequest) AttestCommitment() DigestAlgorithm { return r.Commitment }
+
+type attestedCertChain struct {
+	fingerprint string
+	chainDER    [][]byte
+}
+
+// MakeAttestCertHandler attests the cert chain handed out by certProvider.
+// Requests without a nonce are served from cache, if one is given; requests
+// with a nonce always get a fresh attestation. With a cache, requests without
+// a nonce are accepted even when challenges are required, since the key the
+// chain binds never leaves the Enclave, so an older attestation of the chain
+// is as good as a fresh one.
 func MakeAttestCertHandler(
 	attester *tee.Attester,
 	certProvider tee.CertProvider,
+	cache *CertAttestationCache,
 	logger *slog.Logger,
 ) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Info("received attest cert request")
-		certReq := AttestCertRequest{}
-		err := json.NewDecoder(r.Body).Decode(&certReq)
-		if err != nil {
-			logger.Error("decoding request", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("decoding request: %w", err))
-			return
-		}
-
-		cert, err := certProvider.GetCert(r.Context())
-		if err != nil {
-			logger.Error("getting cert", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("getting cert: %w", err))
-			return
-		}
-
-		chainDER := append([][]byte{}, cert.Certificate...)
-		chainJSON, err := json.Marshal(chainDER)
-		if err != nil {
-			logger.Error("marshaling chain", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("marshaling chain: %w", err))
-			return
-		}
-
-		logger.Info("attesting cert")
-		att, err := attester.Attest(tee.WithAttestUserData(chainJSON))
-		if err != nil {
-			logger.Error("attesting", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("attesting: %w", err))
-			return
-		}
-		tee.WriteResponse(w, AttestCertResponse{Attestation: att})
+	direct := NewDirectAttester(attester)
+	endpoint := &AttestedEndpoint[AttestCertRequest, attestedCertChain]{
+		Name:          "cert",
+		Attester:      direct,
+		Logger:        logger,
+		NonceOptional: cache != nil,
+		Compute: func(ctx context.Context, _ AttestCertRequest) (attestedCertChain, error) {
+			cert, err := certProvider.GetCert(ctx)
+			if err != nil {
+				return attestedCertChain{}, internalError("getting cert", err)
+			}
+			if len(cert.Certificate) == 0 {
+				return attestedCertChain{}, internalError("getting cert: empty cert chain", nil)
+			}
+			return attestedCertChain{
+				fingerprint: CertFingerprint(cert.Certificate[0]),
+				chainDER:    append([][]byte{}, cert.Certificate...),
+			}, nil
+		},
+		Payload: func(_ AttestCertRequest, chain attestedCertChain) ([]byte, error) {
+			return MarshalCanonicalJSON(chain.chainDER)
+		},
+		Attest: func(
+			ctx context.Context,
+			req AttestCertRequest,
+			chain attestedCertChain,
+			payload []byte,
+		) (AttestResponse, error) {
+			attest := func() (AttestResponse, error) {
+				return direct.AttestPayload(ctx, req.Nonce, payload, req.Commitment)
+			}
+			if cache == nil || len(req.Nonce) > 0 {
+				return attest()
+			}
+			return cache.getOrAttest(chain.fingerprint, req.Commitment, attest)
+		},
+		LogAttrs: func(_ AttestCertRequest, chain attestedCertChain) []any {
+			return []any{slog.String("fingerprint", chain.fingerprint)}
+		},
 	}
+	return endpoint.ServeHTTP
 }
 
 type AttestCELRequest struct {
-	Expression string         `json:"expression"`
-	Env        map[string]any `json:"env"`
+	Nonce      []byte          `json:"nonce,omitempty"`
+	Commitment DigestAlgorithm `json:"commitment,omitempty"`
+	Expression string          `json:"expression"`
+	Env        map[string]any  `json:"env"`
 }
 type AttestedCEL struct {
+	Stamp
 	Expression string `json:"expression"`
 	Env        any    `json:"env"`
 	Output     any    `json:"output"`
 }
-type AttestCELResponse struct {
-	Attestation *tee.AttestResult `json:"attestation"`
-}
+type AttestCELResponse = AttestResponse
+
+func (r AttestCELRequest) AttestNonce() []byte               { return r.Nonce }
+func (r AttestCELRequest) AttestCommitment() DigestAlgorithm { return r.Commitment }
 
 func MakeAttestCELHandler(
 	celEngine *engine.CELEngine,
 	celTimeout time.Duration,
 	attester *tee.Attester,
+	sequencer *Sequencer,
 	logger *slog.Logger,
 ) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Info("received attest CEL request")
-		exprReq := AttestCELRequest{}
-		err := json.NewDecoder(r.Body).Decode(&exprReq)
-		if err != nil {
-			logger.Error("decoding request", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("decoding request: %w", err))
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(r.Context(), celTimeout)
-		defer cancel()
-
-		logger.Info("executing cel", slog.String("expression", exprReq.Expression))
-		output, err := celEngine.Execute(ctx, exprReq.Expression, exprReq.Env)
-		if err != nil {
-			logger.Error("executing expression", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("executing expression: %w", err))
-			return
-		}
+	return makeAttestCELHandler(
+		celEngine,
+		celTimeout,
+		NewDirectAttester(attester),
+		sequencer,
+		logger,
+	)
+}
 
-		result := AttestedCEL{
-			Expression: exprReq.Expression,
-			Env:        exprReq.Env,
-			Output:     output,
-		}
-		resBytes, err := json.Marshal(result)
-		if err != nil {
-			logger.Error("marshaling result", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("marshaling result: %w", err))
-			return
-		}
+// MakeBatchedAttestCELHandler is MakeAttestCELHandler with attestations
+// coalesced by batcher. Responses always carry the marshaled AttestedCEL as
+// the payload, along with its inclusion proof in the attested batch root.
+func MakeBatchedAttestCELHandler(
+	celEngine *engine.CELEngine,
+	celTimeout time.Duration,
+	batcher *BatchAttester,
+	sequencer *Sequencer,
+	logger *slog.Logger,
+) http.HandlerFunc {
+	return makeAttestCELHandler(celEngine, celTimeout, batcher, sequencer, logger)
+}
 
-		logger.Info("attesting cel", slog.Any("result", result))
-		attestation, err := attester.Attest(tee.WithAttestUserData(resBytes))
-		if err != nil {
-			logger.Error("attesting", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("attesting: %w", err))
-			return
-		}
+// MakeSignedAttestCELHandler is MakeAttestCELHandler with responses signed by
+// sessionKey instead of attested.
+func MakeSignedAttestCELHandler(
+	celEngine *engine.CELEngine,
+	celTimeout time.Duration,
+	sessionKey *SessionKey,
+	sequencer *Sequencer,
+	logger *slog.Logger,
+) http.HandlerFunc {
+	return makeAttestCELHandler(celEngine, celTimeout, sessionKey, sequencer, logger)
+}
 
-		apiCallResp := AttestCELResponse{
-			Attestation: attestation,
-		}
-		WriteResponse(w, apiCallResp)
+func makeAttestCELHandler(
+	celEngine *engine.CELEngine,
+	celTimeout time.Duration,
+	attester PayloadAttester,
+	sequencer *Sequencer,
+	logger *slog.Logger,
+) http.HandlerFunc {
+	endpoint := &AttestedEndpoint[AttestCELRequest, AttestedCEL]{
+		Name:     "cel",
+		Attester: attester,
+		Logger:   logger,
+		Timeout:  celTimeout,
+		Compute: func(ctx context.Context, req AttestCELRequest) (AttestedCEL, error) {
+			reqLogger := LoggerFromContext(ctx, logger)
+			reqLogger.Info("executing cel", slog.String("expression", req.Expression))
+			output, err := celEngine.Execute(ctx, req.Expression, req.Env)
+			if err != nil {
+				return AttestedCEL{}, evaluationError("executing expression", err)
+			}
+			return AttestedCEL{
+				Stamp:      sequencer.Next(),
+				Expression: req.Expression,
+				Env:        req.Env,
+				Output:     output,
+			}, nil
+		},
+		LogAttrs: func(_ AttestCELRequest, result AttestedCEL) []any {
+			return []any{slog.Any("result", result)}
+		},
 	}
+	return endpoint.ServeHTTP
 }
 
 type AttestExprRequest struct {
-	Expression string         `json:"expression"`
-	Env        map[string]any `json:"env"`
+	Nonce      []byte          `json:"nonce,omitempty"`
+	Commitment DigestAlgorithm `json:"commitment,omitempty"`
+	Expression string          `json:"expression"`
+	Env        map[string]any  `json:"env"`
 }
 type AttestedExpr struct {
+	Stamp
 	Expression string `json:"expression"`
 	Env        any    `json:"env"`
 	Output     any    `json:"output"`
 }
-type AttestExprResponse struct {
-	Attestation *tee.AttestResult `json:"attestation"`
-}
+type AttestExprResponse = AttestResponse
+
+func (r AttestExprRequest) AttestNonce() []byte               { return r.Nonce }
+func (r AttestExprRequest) AttestCommitment() DigestAlgorithm { return r.Commitment }
 
 func MakeAttestExprHandler(
 	exprEngine *engine.ExprEngine,
 	exprTimeout time.Duration,
 	attester *tee.Attester,
+	sequencer *Sequencer,
 	logger *slog.Logger,
 ) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Info("received attest expr request")
-		exprReq := AttestExprRequest{}
-		err := json.NewDecoder(r.Body).Decode(&exprReq)
-		if err != nil {
-			logger.Error("decoding request", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("decoding request: %w", err))
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(r.Context(), exprTimeout)
-		defer cancel()
+	return makeAttestExprHandler(
+		exprEngine,
+		exprTimeout,
+		NewDirectAttester(attester),
+		sequencer,
+		logger,
+	)
+}
 
-		logger.Info("executing expr", slog.String("expression", exprReq.Expression))
-		output, err := exprEngine.Execute(ctx, exprReq.Expression, exprReq.Env)
-		if err != nil {
-			logger.Error("executing expression", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("executing expression: %w", err))
-			return
-		}
+// MakeSignedAttestExprHandler is MakeAttestExprHandler with responses signed
+// by sessionKey instead of attested.
+func MakeSignedAttestExprHandler(
+	exprEngine *engine.ExprEngine,
+	exprTimeout time.Duration,
+	sessionKey *SessionKey,
+	sequencer *Sequencer,
+	logger *slog.Logger,
+) http.HandlerFunc {
+	return makeAttestExprHandler(exprEngine, exprTimeout, sessionKey, sequencer, logger)
+}
 
-		result := AttestedExpr{
-			Expression: exprReq.Expression,
-			Env:        exprReq.Env,
-			Output:     output,
-		}
-		resBytes, err := json.Marshal(result)
-		if err != nil {
-			logger.Error("marshaling result", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("marshaling result: %w", err))
-			return
-		}
+func makeAttestExprHandler(
+	exprEngine *engine.ExprEngine,
+	exprTimeout time.Duration,
+	attester PayloadAttester,
+	sequencer *Sequencer,
+	logger *slog.Logger,
+) http.HandlerFunc {
+	endpoint := &AttestedEndpoint[AttestExprRequest, AttestedExpr]{
+		Name:     "expr",
+		Attester: attester,
+		Logger:   logger,
+		Timeout:  exprTimeout,
+		Compute: func(ctx context.Context, req AttestExprRequest) (AttestedExpr, error) {
+			reqLogger := LoggerFromContext(ctx, logger)
+			reqLogger.Info("executing expr", slog.String("expression", req.Expression))
+			output, err := exprEngine.Execute(ctx, req.Expression, req.Env)
+			if err != nil {
+				return AttestedExpr{}, evaluationError("executing expression", err)
+			}
+			return AttestedExpr{
+				Stamp:      sequencer.Next(),
+				Expression: req.Expression,
+				Env:        req.Env,
+				Output:     output,
+			}, nil
+		},
+		LogAttrs: func(_ AttestExprRequest, result AttestedExpr) []any {
+			return []any{slog.Any("result", result)}
+		},
+	}
+	return endpoint.ServeHTTP
+}
 
-		logger.Info("attesting expr", slog.Any("result", result))
-		attestation, err := attester.Attest(tee.WithAttestUserData(resBytes))
-		if err != nil {
-			logger.Error("attesting", slog.String("error", err.Error()))
-			WriteError(w, fmt.Errorf("attesting: %w", err))
-			return
-		}
+// AttestedHTTPHeaders are the response headers included in an AttestedHTTPCall.
+var AttestedHTTPHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Date",
+	"ETag",
+	"Last-Modified",
+}
 
-		apiCallResp := AttestExprResponse{
-			Attestation: attestation,
-		}
-		WriteResponse(w, apiCallResp)
-	}
+// DeniedHTTPCallHeaders are the request headers a caller may not set on an
+// HTTP call. Hop-by-hop headers manage the Enclave's own connection, and
+// Host and Content-Length are derived from the URL and body.
+var DeniedHTTPCallHeaders = []string{
+	"Connection",
+	"Content-Length",
+	"Host",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
 }
 
 type AttestHTTPCallRequest struct {
-	Method string `json:"method"`
-	URL    string `json:"url"`
+	Nonce       []byte            `json:"nonce,omitempty"`
+	Commitment  DigestAlgorithm   `json:"commitment,omitempty"`
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	Body        []byte            `json:"body,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
 }
-type AttestHTTPCallResponse struct {
-	Attestation *tee.AttestResult `json:"attestation"`
+type AttestedHTTPCall struct {
+	Stamp
+	Method             string            `json:"method"`
+	URL                string            `json:"url"`
+	RequestContentType string            `json:"request_content_type,omitempty"`
+	RequestBodyDigest  string            `json:"request_body_digest,omitempty"`
+	StatusCode         int               `json:"status_code"`
+	Headers            map[string]string `json:"headers,omitempty"`
+	Body               []byte            `json:"body,omitempty"`
+	FetchedAt          time.Time         `json:"fetched_at"`
 }
+type AttestHTTPCallResponse = AttestResponse
+
+func (r AttestHTTPCallRequest) AttestNonce() []byte               { return r.Nonce }
+func (r AttestHTTPCallRequest) AttestCommitment() DigestAlgorithm { return r.Commitment }
 
 func MakeAttestHTTPCallHandler(
 	ctxTimeout time.Duration,
 	attester *tee.Attester,
+	sequencer *Sequencer,
 	client *http.Client,
 	logger *slog.Logger,
 ) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Info("received attest HTTP call request")
-		httpCallReq := AttestHTTPCallRequest{}
-		err := json.NewDecoder(r.Body).Decode(&httpCallReq)
-		if err != nil {
-			WriteError(w, fmt.Errorf("decoding request: %w", err))
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(r.Context(), ctxTimeout)
-		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, httpCallReq.Method, httpCallReq.URL, nil)
-		if err != nil {
-			WriteError(w, fmt.Errorf("creating request: %w", err))
-			return
-		}
-
-		logger.Info(
-			"making HTTP call",
-			slog.String("method", httpCallReq.Method),
-			slog.String("URL", httpCallReq.URL),
-		)
-		// G704 - potential for Server-Side Request Forgery (SSRF). Normally,
-		// you would sanitize and check the target URL to ensure the client
-		// isn't using the Enclave to make calls that it should not have access
-		// to. Since this is an example program, we don't bother checking.
-		//nolint:gosec
-		resp, err := client.Do(req)
-		if err != nil {
-			WriteError(w, fmt.Errorf("sending request: %w", err))
-			return
-		}
-		defer resp.Body.Close()
+	return makeAttestHTTPCallHandler[AttestHTTPCallRequest](
+		"HTTP call",
+		ctxTimeout,
+		NewDirectAttester(attester),
+		sequencer,
+		client,
+		logger,
+	)
+}
 
-		respBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			WriteError(w, fmt.Errorf("reading response body: %w", err))
-			return
-		}
+// MakeSignedAttestHTTPCallHandler is MakeAttestHTTPCallHandler with responses
+// signed by sessionKey instead of attested.
+func MakeSignedAttestHTTPCallHandler(
+	ctxTimeout time.Duration,
+	sessionKey *SessionKey,
+	sequencer *Sequencer,
+	client *http.Client,
+	logger *slog.Logger,
+) http.HandlerFunc {
+	return makeAttestHTTPCallHandler[AttestHTTPCallRequest](
+		"HTTP call",
+		ctxTimeout,
+		sessionKey,
+		sequencer,
+		client,
+		logger,
+	)
+}
 
-		logger.Info("attesting HTTP call")
-		attestation, err := attester.Attest(tee.WithAttestUserData(respBytes))
-		if err != nil {
-			WriteError(w, fmt.Errorf("attesting: %w", err))
-			return
-		}
+// httpCallRequest is a request for an attested HTTP call, whichever path it
+// was made to.
+type httpCallRequest interface {
+	AttestHTTPCallRequest | AttestHTTPSCallRequest
+	AttestRequest
+}
 
-		httpCallResp := AttestHTTPCallResponse{
-			Attestation: attestation,
-		}
-		WriteResponse(w, httpCallResp)
+// makeAttestHTTPCallHandler builds the HTTP and HTTPS call handlers, which
+// differ only in their request type and name.
+func makeAttestHTTPCallHandler[Req httpCallRequest](
+	name string,
+	ctxTimeout time.Duration,
+	attester PayloadAttester,
+	sequencer *Sequencer,
+	client *http.Client,
+	logger *slog.Logger,
+) http.HandlerFunc {
+	endpoint := &AttestedEndpoint[Req, AttestedHTTPCall]{
+		Name:     name,
+		Attester: attester,
+		Logger:   logger,
+		Timeout:  ctxTimeout,
+		Compute: func(ctx context.Context, req Req) (AttestedHTTPCall, error) {
+			callReq := AttestHTTPCallRequest(req)
+			LoggerFromContext(ctx, logger).Info(
+				"making "+name,
+				slog.String("method", callReq.Method),
+				slog.String("URL", callReq.URL),
+			)
+			return doHTTPCall(ctx, client, sequencer, callReq)
+		},
+		LogAttrs: logHTTPCallStatus[Req],
 	}
+	return endpoint.ServeHTTP
 }
 
 type AttestHTTPSCallRequest struct {
-	Method string `json:"method"`
-	URL    string `json:"url"`
-}
-type AttestHTTPSCallResponse struct {
-	Attestation *tee.AttestResult `json:"attestation"`
+	Nonce       []byte            `json:"nonce,omitempty"`
+	Commitment  DigestAlgorithm   `json:"commitment,omitempty"`
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	Body        []byte            `json:"body,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
 }
+type AttestHTTPSCallResponse = AttestResponse
+
+func (r AttestHTTPSCallRequest) AttestNonce() []byte               { return r.Nonce }
+func (r AttestHTTPSCallRequest) AttestCommitment() DigestAlgorithm { return r.Commitment }
 
 func MakeAttestHTTPSCallHandler(
 	ctxTimeout time.Duration,
 	attester *tee.Attester,
+	sequencer *Sequencer,
 	client *http.Client,
 	logger *slog.Logger,
 ) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Info("received attest HTTPS call request")
-		httpsCallReq := AttestHTTPSCallRequest{}
-		err := json.NewDecoder(r.Body).Decode(&httpsCallReq)
-		if err != nil {
-			WriteError(w, fmt.Errorf("decoding request: %w", err))
-			return
-		}
+	return makeAttestHTTPCallHandler[AttestHTTPSCallRequest](
+		"HTTPS call",
+		ctxTimeout,
+		NewDirectAttester(attester),
+		sequencer,
+		client,
+		logger,
+	)
+}
 
-		ctx, cancel := context.WithTimeout(r.Context(), ctxTimeout)
-		defer cancel()
+// MakeSignedAttestHTTPSCallHandler is MakeAttestHTTPSCallHandler with
+// responses signed by sessionKey instead of attested.
+func MakeSignedAttestHTTPSCallHandler(
+	ctxTimeout time.Duration,
+	sessionKey *SessionKey,
+	sequencer *Sequencer,
+	client *http.Client,
+	logger *slog.Logger,
+) http.HandlerFunc {
+	return makeAttestHTTPCallHandler[AttestHTTPSCallRequest](
+		"HTTPS call",
+		ctxTimeout,
+		sessionKey,
+		sequencer,
+		client,
+		logger,
+	)
+}
 
-		req, err := http.NewRequestWithContext(ctx, httpsCallReq.Method, httpsCallReq.URL, nil)
-		if err != nil {
-			WriteError(w, fmt.Errorf("creating request: %w", err))
-			return
-		}
+func doHTTPCall(
+	ctx context.Context,
+	client *http.Client,
+	sequencer *Sequencer,
+	callReq AttestHTTPCallRequest,
+) (AttestedHTTPCall, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		callReq.Method,
+		callReq.URL,
+		bytes.NewReader(callReq.Body),
+	)
+	if err != nil {
+		return AttestedHTTPCall{}, badRequestError("creating request", err)
+	}
+	err = SetHTTPCallHeaders(req, callReq.Headers, callReq.ContentType)
+	if err != nil {
+		return AttestedHTTPCall{}, err
+	}
 
-		logger.Info(
-			"making HTTPS call",
-			slog.String("method", httpsCallReq.Method),
-			slog.String("URL", httpsCallReq.URL),
-		)
-		// G704 - potential for Server-Side Request Forgery (SSRF). Normally,
-		// you would sanitize and check the target URL to ensure the client
-		// isn't using the Enclave to make calls that it should not have access
-		// to. Since this is an example program, we don't bother checking.
-		//nolint:gosec
-		resp, err := client.Do(req)
-		if err != nil {
-			WriteError(w, fmt.Errorf("sending request: %w", err))
-			return
-		}
-		defer resp.Body.Close()
+	// G704 - potential for Server-Side Request Forgery (SSRF). Callers
+	// should install an EgressGuard on client so that the target URL is
+	// checked against an EgressPolicy before the request leaves the Enclave.
+	fetchedAt := time.Now().UTC()
+	//nolint:gosec
+	resp, err := client.Do(req)
+	if err != nil {
+		return AttestedHTTPCall{}, upstreamError("sending request", err)
+	}
+	defer resp.Body.Close()
 
-		respBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			WriteError(w, fmt.Errorf("reading response body: %w", err))
-			return
-		}
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return AttestedHTTPCall{}, upstreamError("reading response body", err)
+	}
+	attested := NewAttestedHTTPCall(req, callReq.Body, resp, respBytes, fetchedAt)
+	attested.Stamp = sequencer.Next()
+	return attested, nil
+}
+
+func logHTTPCallStatus[Req AttestRequest](_ Req, result AttestedHTTPCall) []any {
+	return []any{slog.Int("status", result.StatusCode)}
+}
 
-		logger.Info("attesting HTTPS call")
-		attestation, err := attester.Attest(tee.WithAttestUserData(respBytes))
-		if err != nil {
-			WriteError(w, fmt.Errorf("attesting: %w", err))
-			return
+// SetHTTPCallHeaders sets the caller supplied headers on req, rejecting any
+// listed in DeniedHTTPCallHeaders.
+func SetHTTPCallHeaders(
+	req *http.Request,
+	headers map[string]string,
+	contentType string,
+) error {
+	for key, value := range headers {
+		if slices.Contains(DeniedHTTPCallHeaders, http.CanonicalHeaderKey(key)) {
+			return badRequestError("header not allowed: "+key, nil)
 		}
+		req.Header.Set(key, value)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return nil
+}
 
-		httpsCallResp := AttestHTTPSCallResponse{
-			Attestation: attestation,
+// NewAttestedHTTPCall builds the attested envelope for an HTTP call. Request
+// headers are deliberately left out because they often carry credentials;
+// the request body is committed to by digest only.
+func NewAttestedHTTPCall(
+	req *http.Request,
+	reqBody []byte,
+	resp *http.Response,
+	respBody []byte,
+	fetchedAt time.Time,
+) AttestedHTTPCall {
+	headers := map[string]string{}
+	for _, key := range AttestedHTTPHeaders {
+		if values := resp.Header.Values(key); len(values) > 0 {
+			headers[http.CanonicalHeaderKey(key)] = strings.Join(values, ", ")
 		}
-		WriteResponse(w, httpsCallResp)
 	}
+
+	attested := AttestedHTTPCall{
+		Method:             req.Method,
+		URL:                req.URL.String(),
+		RequestContentType: req.Header.Get("Content-Type"),
+		StatusCode:         resp.StatusCode,
+		Headers:            headers,
+		Body:               respBody,
+		FetchedAt:          fetchedAt,
+	}
+	if len(reqBody) > 0 {
+		attested.RequestBodyDigest = DigestSHA256(reqBody)
+	}
+	return attested
 }
 
-type AttestUserDataRequest struct {
-	Nonce    []byte `json:"nonce,omitempty"`
-	UserData []byte `json:"userdata,omitempty"`
+// Matches checks that the attested call is the one the caller requested.
+func (a AttestedHTTPCall) Matches(method string, rawURL string) error {
+	if !strings.EqualFold(a.Method, method) {
+		msg := fmt.Sprintf("method mismatch: expected %s, got %s", method, a.Method)
+		return attestedPayloadErrorMismatch(msg, nil)
+	}
+
+	want, err := url.Parse(rawURL)
+	if err != nil {
+		return attestedPayloadErrorMismatch("parsing url", err)
+	}
+	if a.URL != want.String() {
+		msg := fmt.Sprintf("url mismatch: expected %s, got %s", want, a.URL)
+		return attestedPayloadErrorMismatch(msg, nil)
+	}
+	return nil
+}
+
+// MatchesBody checks that the attested call was made with the given body.
+func (a AttestedHTTPCall) MatchesBody(body []byte) error {
+	want := ""
+	if len(body) > 0 {
+		want = DigestSHA256(body)
+	}
+	if a.RequestBodyDigest != want {
+		msg := fmt.Sprintf(
+			"request body digest mismatch: expected %s, got %s",
+			want,
+			a.RequestBodyDigest,
+		)
+		return attestedPayloadErrorMismatch(msg, nil)
+	}
+	return nil
 }
-type AttestUserDataResponse struct {
-	Attestation *tee.AttestResult `json:"attestation"`
+
+// DigestSHA256 returns a self-describing "sha256:<hex>" digest of data.
+func DigestSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
 }
 
+type AttestUserDataRequest struct {
+	Nonce      []byte          `json:"nonce,omitempty"`
+	Commitment DigestAlgorithm `json:"commitment,omitempty"`
+	UserData   []byte          `json:"userdata,omitempty"`
+}
+type AttestUserDataResponse = AttestResponse
+
+func (r AttestUserDataRequest) AttestNonce() []byte               { return r.Nonce }
+func (r AttestUserDataRequest) AttestCommitment() DigestAlgorithm { return r.Commitment }
+
+// MakeAttestUserDataHandler attests user data exactly as the client sent it,
+// so unlike the other results it carries no Stamp.
 func MakeAttestUserDataHandler(
 	attester *tee.Attester,
 	logger *slog.Logger,
 ) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Info("received attest user data request")
-		req := AttestUserDataRequest{}
-		err := json.NewDecoder(r.Body).Decode(&req)
-		if err != nil {
-			WriteError(w, fmt.Errorf("decoding request: %w", err))
-			return
-		}
-
-		logger.Info(
-			"attesting",
-			slog.String("nonce", base64.StdEncoding.EncodeToString(req.Nonce)),
-			slog.String("userdata", base64.StdEncoding.EncodeToString(req.UserData)),
-		)
-		att, err := attester.Attest(
-			tee.WithAttestNonce(req.Nonce),
-			tee.WithAttestUserData(req.UserData),
-		)
-		if err != nil {
-			WriteError(w, fmt.Errorf("attesting: %w", err))
-			return
-		}
-		WriteResponse(w, AttestUserDataResponse{Attestation: att})
+	endpoint := &AttestedEndpoint[AttestUserDataRequest, []byte]{
+		Name:     "user data",
+		Attester: NewDirectAttester(attester),
+		Logger:   logger,
+		Compute: func(_ context.Context, req AttestUserDataRequest) ([]byte, error) {
+			return req.UserData, nil
+		},
+		Payload: func(_ AttestUserDataRequest, userData []byte) ([]byte, error) {
+			return userData, nil
+		},
+		LogAttrs: func(_ AttestUserDataRequest, userData []byte) []any {
+			return []any{slog.String("userdata", base64.StdEncoding.EncodeToString(userData))}
+		},
 	}
+	return endpoint.ServeHTTP
 }
 
-func WriteError(w http.ResponseWriter, err error) {
-	http.Error(w, err.Error(), http.StatusInternalServerError)
-}
-
+// WriteResponse writes out as JSON. Handlers that can serve CBOR use
+// WriteNegotiatedResponse instead.
 func WriteResponse(w http.ResponseWriter, out any) {
-	data, err := json.Marshal(out)
-	if err != nil {
-		WriteError(w, fmt.Errorf("marshaling response: %w", err))
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(data)
-	if err != nil {
-		WriteError(w, fmt.Errorf("writing response: %w", err))
-		return
-	}
+	writeResponse(w, JSONCodec, out)
 }
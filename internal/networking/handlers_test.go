@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/tahardi/bearclave-examples/internal/engine"
 	"github.com/tahardi/bearclave-examples/internal/networking"
@@ -90,6 +91,196 @@ func makeRequest(
 	return req
 }
 
+func TestMakeAttestCertHandler(t *testing.T) {
+	attestCert := func(
+		t *testing.T,
+		handler http.Handler,
+		nonce []byte,
+	) networking.AttestCertResponse {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		body := networking.AttestCertRequest{Nonce: nonce}
+		req := makeRequest(t, "POST", networking.AttestCertPath, body)
+
+		handler.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		response := networking.AttestCertResponse{}
+		err := json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+		return response
+	}
+
+	t.Run("happy path - cached", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+		certProvider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, time.Hour)
+		require.NoError(t, err)
+
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		cache := networking.NewCertAttestationCache(time.Hour)
+		handler := networking.MakeAttestCertHandler(attester, certProvider, cache, logger)
+
+		// when
+		first := attestCert(t, handler, nil)
+		second := attestCert(t, handler, nil)
+
+		// then
+		assert.Equal(t, first.Attestation, second.Attestation)
+
+		verified, err := verifier.Verify(second.Attestation)
+		require.NoError(t, err)
+
+		cert, err := certProvider.GetCert(context.Background())
+		require.NoError(t, err)
+		chainJSON, err := json.Marshal(cert.Certificate)
+		require.NoError(t, err)
+		assert.Equal(t, chainJSON, verified.UserData)
+	})
+
+	t.Run("happy path - nonce bypasses cache", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+		certProvider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, time.Hour)
+		require.NoError(t, err)
+
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		cache := networking.NewCertAttestationCache(time.Hour)
+		handler := networking.MakeAttestCertHandler(attester, certProvider, cache, logger)
+		nonce := []byte("nonce")
+
+		// when
+		cached := attestCert(t, handler, nil)
+		fresh := attestCert(t, handler, nonce)
+
+		// then
+		assert.NotEqual(t, cached.Attestation, fresh.Attestation)
+		_, err = verifier.Verify(fresh.Attestation, tee.WithVerifyNonce(nonce))
+		require.NoError(t, err)
+	})
+
+	t.Run("happy path - cached without challenge", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		certProvider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, time.Hour)
+		require.NoError(t, err)
+
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		cache := networking.NewCertAttestationCache(time.Hour)
+		issuer := newChallengeIssuer(t, time.Minute, 10)
+		handler := issuer.Wrap(
+			networking.MakeAttestCertHandler(attester, certProvider, cache, logger),
+		)
+
+		// when
+		first := attestCert(t, handler, nil)
+		second := attestCert(t, handler, nil)
+
+		// then
+		assert.Equal(t, first.Attestation, second.Attestation)
+	})
+
+	t.Run("error - challenge required without cache", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		certProvider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, time.Hour)
+		require.NoError(t, err)
+
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		issuer := newChallengeIssuer(t, time.Minute, 10)
+		handler := issuer.Wrap(
+			networking.MakeAttestCertHandler(attester, certProvider, nil, logger),
+		)
+
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "POST", networking.AttestCertPath, networking.AttestCertRequest{})
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		apiErr := decodeAPIError(t, recorder)
+		assert.Equal(t, networking.ErrorCodeInvalidChallenge, apiErr.Code)
+	})
+
+	t.Run("happy path - cert rotated", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+		certProvider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, time.Hour)
+		require.NoError(t, err)
+
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		cache := networking.NewCertAttestationCache(time.Hour)
+		handler := networking.MakeAttestCertHandler(attester, certProvider, cache, logger)
+
+		before := attestCert(t, handler, nil)
+		err = certProvider.RotateCert(context.Background())
+		require.NoError(t, err)
+
+		// when
+		after := attestCert(t, handler, nil)
+
+		// then
+		verifiedBefore, err := verifier.Verify(before.Attestation)
+		require.NoError(t, err)
+		verifiedAfter, err := verifier.Verify(after.Attestation)
+		require.NoError(t, err)
+		assert.NotEqual(t, verifiedBefore.UserData, verifiedAfter.UserData)
+	})
+
+	t.Run("happy path - ttl elapsed", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		certProvider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, time.Hour)
+		require.NoError(t, err)
+
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		cache := networking.NewCertAttestationCache(time.Nanosecond)
+		handler := networking.MakeAttestCertHandler(attester, certProvider, cache, logger)
+
+		// when
+		first := attestCert(t, handler, nil)
+		time.Sleep(time.Millisecond)
+		second := attestCert(t, handler, nil)
+
+		// then
+		assert.NotEqual(t, first.Attestation, second.Attestation)
+	})
+
+	t.Run("error - decoding request", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		certProvider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, time.Hour)
+		require.NoError(t, err)
+
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		handler := networking.MakeAttestCertHandler(attester, certProvider, nil, logger)
+
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "POST", networking.AttestCertPath, []byte("invalid json"))
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "decoding request")
+	})
+}
+
 func TestMakeAttestCELHandler(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		// given
@@ -125,14 +316,17 @@ func TestMakeAttestCELHandler(t *testing.T) {
 		env := map[string]any{
 			"targetUrl": backend.URL,
 		}
+		nonce := []byte("nonce")
 		recorder := httptest.NewRecorder()
-		body := networking.AttestCELRequest{Expression: expression, Env: env}
+		body := networking.AttestCELRequest{Nonce: nonce, Expression: expression, Env: env}
 		req := makeRequest(t, "POST", networking.AttestCELPath, body)
 
+		sequencer := networking.NewSequencer()
 		handler := networking.MakeAttestCELHandler(
 			celEngine,
 			defaultTimeout,
 			attester,
+			sequencer,
 			logger,
 		)
 
@@ -146,7 +340,7 @@ func TestMakeAttestCELHandler(t *testing.T) {
 		err = json.NewDecoder(recorder.Body).Decode(&response)
 		require.NoError(t, err)
 
-		verified, err := verifier.Verify(response.Attestation)
+		verified, err := verifier.Verify(response.Attestation, tee.WithVerifyNonce(nonce))
 		require.NoError(t, err)
 
 		got := networking.AttestedCEL{}
@@ -154,6 +348,10 @@ func TestMakeAttestCELHandler(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, expression, got.Expression)
 		assert.Equal(t, env, got.Env)
+		require.NoError(t, networking.VerifyCanonicalJSON(verified.UserData, got))
+		assert.Equal(t, sequencer.InstanceID(), got.InstanceID)
+		assert.NotZero(t, got.Sequence)
+		require.NoError(t, got.CheckAge(time.Now(), networking.DefaultMaxStampAge))
 
 		gotOutput, ok := got.Output.(string)
 		require.True(t, ok)
@@ -179,6 +377,7 @@ func TestMakeAttestCELHandler(t *testing.T) {
 			celEngine,
 			defaultTimeout,
 			attester,
+			networking.NewSequencer(),
 			logger,
 		)
 
@@ -186,7 +385,7 @@ func TestMakeAttestCELHandler(t *testing.T) {
 		handler.ServeHTTP(recorder, req)
 
 		// then
-		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
 		assert.Contains(t, recorder.Body.String(), "decoding request")
 	})
 
@@ -213,6 +412,7 @@ func TestMakeAttestCELHandler(t *testing.T) {
 			celEngine,
 			defaultTimeout,
 			attester,
+			networking.NewSequencer(),
 			logger,
 		)
 
@@ -220,10 +420,48 @@ func TestMakeAttestCELHandler(t *testing.T) {
 		handler.ServeHTTP(recorder, req)
 
 		// then
-		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
 		assert.Contains(t, recorder.Body.String(), "executing expression")
 	})
 
+	t.Run("error - evaluating expr at runtime", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		expression := `1 / zero`
+		env := map[string]any{"zero": 0}
+		recorder := httptest.NewRecorder()
+		body := networking.AttestCELRequest{Expression: expression, Env: env}
+		req := makeRequest(t, "POST", networking.AttestCELPath, body)
+
+		celEngine, err := engine.NewCELEngine()
+		require.NoError(t, err)
+
+		handler := networking.MakeAttestCELHandler(
+			celEngine,
+			defaultTimeout,
+			attester,
+			networking.NewSequencer(),
+			logger,
+		)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+
+		response := networking.ErrorResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+		assert.Equal(t, networking.ErrorCodeEvaluation, response.Error.Code)
+		assert.Contains(t, response.Error.Message, "executing expression")
+	})
+
 	t.Run("error - attesting expr", func(t *testing.T) {
 		// given
 		want := map[string]string{"status": "ok"}
@@ -268,6 +506,7 @@ func TestMakeAttestCELHandler(t *testing.T) {
 			celEngine,
 			defaultTimeout,
 			attester,
+			networking.NewSequencer(),
 			logger,
 		)
 
@@ -275,11 +514,101 @@ func TestMakeAttestCELHandler(t *testing.T) {
 		handler.ServeHTTP(recorder, req)
 
 		// then
-		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
 		assert.Contains(t, recorder.Body.String(), "attesting")
 	})
 }
 
+func TestMakeBatchedAttestCELHandler(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		celEngine, err := engine.NewCELEngine()
+		require.NoError(t, err)
+		batcher := networking.NewBatchAttester(attester, networking.WithBatchMaxSize(1))
+
+		expression := `greeting + ", CEL"`
+		env := map[string]any{"greeting": "Hello"}
+		nonce := []byte("nonce")
+		recorder := httptest.NewRecorder()
+		body := networking.AttestCELRequest{Nonce: nonce, Expression: expression, Env: env}
+		req := makeRequest(t, "POST", networking.AttestCELPath, body)
+
+		handler := networking.MakeBatchedAttestCELHandler(
+			celEngine,
+			defaultTimeout,
+			batcher,
+			networking.NewSequencer(),
+			logger,
+		)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		response := networking.AttestCELResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+		require.NotNil(t, response.Proof)
+
+		verified, err := verifier.Verify(response.Attestation)
+		require.NoError(t, err)
+
+		payload, err := networking.AttestedBatchPayload(
+			verified,
+			nonce,
+			response.Payload,
+			response.Proof,
+		)
+		require.NoError(t, err)
+
+		got := networking.AttestedCEL{}
+		err = json.Unmarshal(payload, &got)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello, CEL", got.Output)
+	})
+
+	t.Run("error - decoding request", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		recorder := httptest.NewRecorder()
+		body := []byte("invalid json")
+		req := makeRequest(t, "POST", networking.AttestCELPath, body)
+
+		celEngine, err := engine.NewCELEngine()
+		require.NoError(t, err)
+
+		handler := networking.MakeBatchedAttestCELHandler(
+			celEngine,
+			defaultTimeout,
+			networking.NewBatchAttester(attester),
+			networking.NewSequencer(),
+			logger,
+		)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "decoding request")
+	})
+}
+
 func TestMakeAttestExprHandler(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		// given
@@ -315,14 +644,16 @@ func TestMakeAttestExprHandler(t *testing.T) {
 		env := map[string]any{
 			"targetUrl": backend.URL,
 		}
+		nonce := []byte("nonce")
 		recorder := httptest.NewRecorder()
-		body := networking.AttestExprRequest{Expression: expression, Env: env}
+		body := networking.AttestExprRequest{Nonce: nonce, Expression: expression, Env: env}
 		req := makeRequest(t, "POST", networking.AttestExprPath, body)
 
 		handler := networking.MakeAttestExprHandler(
 			exprEngine,
 			defaultTimeout,
 			attester,
+			networking.NewSequencer(),
 			logger,
 		)
 
@@ -336,7 +667,7 @@ func TestMakeAttestExprHandler(t *testing.T) {
 		err = json.NewDecoder(recorder.Body).Decode(&response)
 		require.NoError(t, err)
 
-		verified, err := verifier.Verify(response.Attestation)
+		verified, err := verifier.Verify(response.Attestation, tee.WithVerifyNonce(nonce))
 		require.NoError(t, err)
 
 		got := networking.AttestedExpr{}
@@ -369,6 +700,7 @@ func TestMakeAttestExprHandler(t *testing.T) {
 			exprEngine,
 			defaultTimeout,
 			attester,
+			networking.NewSequencer(),
 			logger,
 		)
 
@@ -376,7 +708,7 @@ func TestMakeAttestExprHandler(t *testing.T) {
 		handler.ServeHTTP(recorder, req)
 
 		// then
-		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
 		assert.Contains(t, recorder.Body.String(), "decoding request")
 	})
 
@@ -403,6 +735,7 @@ func TestMakeAttestExprHandler(t *testing.T) {
 			exprEngine,
 			defaultTimeout,
 			attester,
+			networking.NewSequencer(),
 			logger,
 		)
 
@@ -410,10 +743,42 @@ func TestMakeAttestExprHandler(t *testing.T) {
 		handler.ServeHTTP(recorder, req)
 
 		// then
-		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
 		assert.Contains(t, recorder.Body.String(), "executing expression")
 	})
 
+	t.Run("error - expr times out", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		exprEngine, err := engine.NewExprEngine()
+		require.NoError(t, err)
+
+		// The expression makes no upstream calls, so the timeout is its own.
+		expression := `all(1..2000, all(1..2000, # > 0))`
+		recorder := httptest.NewRecorder()
+		body := networking.AttestExprRequest{Expression: expression}
+		req := makeRequest(t, "POST", networking.AttestExprPath, body)
+
+		handler := networking.MakeAttestExprHandler(
+			exprEngine,
+			time.Millisecond,
+			attester,
+			networking.NewSequencer(),
+			slog.New(slog.DiscardHandler),
+		)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		apiErr := decodeAPIError(t, recorder)
+		assert.Equal(t, networking.ErrorCodeEvaluation, apiErr.Code)
+		assert.False(t, apiErr.Retryable)
+		assert.Contains(t, apiErr.Error(), context.DeadlineExceeded.Error())
+	})
+
 	t.Run("error - attesting expr", func(t *testing.T) {
 		// given
 		want := map[string]string{"status": "ok"}
@@ -458,6 +823,7 @@ func TestMakeAttestExprHandler(t *testing.T) {
 			exprEngine,
 			defaultTimeout,
 			attester,
+			networking.NewSequencer(),
 			logger,
 		)
 
@@ -465,7 +831,7 @@ func TestMakeAttestExprHandler(t *testing.T) {
 		handler.ServeHTTP(recorder, req)
 
 		// then
-		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
 		assert.Contains(t, recorder.Body.String(), "attesting")
 	})
 }
@@ -495,15 +861,17 @@ func TestMakeAttestHTTPCallHandler(t *testing.T) {
 		var logBuffer bytes.Buffer
 		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
 
+		nonce := []byte("nonce")
 		method := "GET"
 		url := backend.URL
 		recorder := httptest.NewRecorder()
-		body := networking.AttestHTTPCallRequest{Method: method, URL: url}
+		body := networking.AttestHTTPCallRequest{Nonce: nonce, Method: method, URL: url}
 		req := makeRequest(t, "POST", networking.AttestHTTPCallPath, body)
 
 		handler := networking.MakeAttestHTTPCallHandler(
 			networking.DefaultTimeout,
 			attester,
+			networking.NewSequencer(),
 			backend.Client(),
 			logger,
 		)
@@ -518,15 +886,95 @@ func TestMakeAttestHTTPCallHandler(t *testing.T) {
 		err = json.NewDecoder(recorder.Body).Decode(&response)
 		require.NoError(t, err)
 
-		verified, err := verifier.Verify(response.Attestation)
+		verified, err := verifier.Verify(response.Attestation, tee.WithVerifyNonce(nonce))
 		require.NoError(t, err)
 
+		attested := networking.AttestedHTTPCall{}
+		err = json.Unmarshal(verified.UserData, &attested)
+		require.NoError(t, err)
+		require.NoError(t, attested.Matches(method, url))
+		assert.Equal(t, http.StatusOK, attested.StatusCode)
+		assert.Equal(t, "application/json", attested.Headers["Content-Type"])
+		assert.False(t, attested.FetchedAt.IsZero())
+
 		var got map[string]string
-		err = json.Unmarshal(verified.UserData, &got)
+		err = json.Unmarshal(attested.Body, &got)
 		require.NoError(t, err)
 		assert.Equal(t, want, got)
 	})
 
+	t.Run("happy path - body and headers", func(t *testing.T) {
+		// given
+		reqBody := []byte(`{"hello":"world"}`)
+		contentType := "application/json"
+		headers := map[string]string{"Accept": "application/json", "Authorization": "Bearer secret"}
+
+		backend := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "POST", r.Method)
+				assert.Equal(t, contentType, r.Header.Get("Content-Type"))
+				assert.Equal(t, "application/json", r.Header.Get("Accept"))
+				assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+				gotBody, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, reqBody, gotBody)
+				w.WriteHeader(http.StatusCreated)
+			}),
+		)
+		defer backend.Close()
+
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		method := "POST"
+		url := backend.URL
+		recorder := httptest.NewRecorder()
+		body := networking.AttestHTTPCallRequest{
+			Method:      method,
+			URL:         url,
+			Body:        reqBody,
+			Headers:     headers,
+			ContentType: contentType,
+		}
+		req := makeRequest(t, "POST", networking.AttestHTTPCallPath, body)
+
+		handler := networking.MakeAttestHTTPCallHandler(
+			networking.DefaultTimeout,
+			attester,
+			networking.NewSequencer(),
+			backend.Client(),
+			logger,
+		)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		response := networking.AttestHTTPCallResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+
+		verified, err := verifier.Verify(response.Attestation)
+		require.NoError(t, err)
+		assert.NotContains(t, string(verified.UserData), "secret")
+
+		attested := networking.AttestedHTTPCall{}
+		err = json.Unmarshal(verified.UserData, &attested)
+		require.NoError(t, err)
+		require.NoError(t, attested.Matches(method, url))
+		require.NoError(t, attested.MatchesBody(reqBody))
+		assert.Equal(t, contentType, attested.RequestContentType)
+		assert.Equal(t, http.StatusCreated, attested.StatusCode)
+	})
+
 	t.Run("error - decoding request", func(t *testing.T) {
 		// given
 		attester, err := tee.NewAttester(tee.NoTEE)
@@ -542,6 +990,7 @@ func TestMakeAttestHTTPCallHandler(t *testing.T) {
 		handler := networking.MakeAttestHTTPCallHandler(
 			networking.DefaultTimeout,
 			attester,
+			networking.NewSequencer(),
 			nil,
 			logger,
 		)
@@ -550,10 +999,82 @@ func TestMakeAttestHTTPCallHandler(t *testing.T) {
 		handler.ServeHTTP(recorder, req)
 
 		// then
-		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
 		assert.Contains(t, recorder.Body.String(), "decoding request")
 	})
 
+	t.Run("error - header not allowed", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		recorder := httptest.NewRecorder()
+		body := networking.AttestHTTPCallRequest{
+			Method:  "GET",
+			URL:     "http://example.com",
+			Headers: map[string]string{"transfer-encoding": "chunked"},
+		}
+		req := makeRequest(t, "POST", networking.AttestHTTPCallPath, body)
+
+		handler := networking.MakeAttestHTTPCallHandler(
+			networking.DefaultTimeout,
+			attester,
+			networking.NewSequencer(),
+			nil,
+			logger,
+		)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "header not allowed")
+	})
+
+	t.Run("error - egress denied", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		guard, err := networking.NewEgressGuard(networking.DefaultEgressPolicy())
+		require.NoError(t, err)
+		client := guard.Apply(&http.Client{})
+
+		method := "GET"
+		url := "http://169.254.169.254/latest/meta-data"
+		recorder := httptest.NewRecorder()
+		body := networking.AttestHTTPCallRequest{Method: method, URL: url}
+		req := makeRequest(t, "POST", networking.AttestHTTPCallPath, body)
+
+		handler := networking.MakeAttestHTTPCallHandler(
+			networking.DefaultTimeout,
+			attester,
+			networking.NewSequencer(),
+			client,
+			logger,
+		)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "egress: denied")
+
+		response := networking.ErrorResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+		assert.Equal(t, networking.ErrorCodeForbidden, response.Error.Code)
+		assert.False(t, response.Error.Retryable)
+	})
+
 	t.Run("error - attesting userdata", func(t *testing.T) {
 		// given
 		want := map[string]string{"status": "ok"}
@@ -589,6 +1110,7 @@ func TestMakeAttestHTTPCallHandler(t *testing.T) {
 		handler := networking.MakeAttestHTTPCallHandler(
 			networking.DefaultTimeout,
 			attester,
+			networking.NewSequencer(),
 			backend.Client(),
 			logger,
 		)
@@ -597,11 +1119,54 @@ func TestMakeAttestHTTPCallHandler(t *testing.T) {
 		handler.ServeHTTP(recorder, req)
 
 		// then
-		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
 		assert.Contains(t, recorder.Body.String(), "attesting")
 	})
 }
 
+func TestAttestedHTTPCall_Matches(t *testing.T) {
+	attested := networking.AttestedHTTPCall{
+		Method:     http.MethodGet,
+		URL:        "http://httpbin.org/get",
+		StatusCode: http.StatusOK,
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		// when
+		err := attested.Matches("get", "http://httpbin.org/get")
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - method mismatch", func(t *testing.T) {
+		// when
+		err := attested.Matches(http.MethodPost, "http://httpbin.org/get")
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAttestedPayloadMismatch)
+		assert.ErrorContains(t, err, "method mismatch")
+	})
+
+	t.Run("error - url mismatch", func(t *testing.T) {
+		// when
+		err := attested.Matches(http.MethodGet, "http://httpbin.org/anything")
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAttestedPayloadMismatch)
+		assert.ErrorContains(t, err, "url mismatch")
+	})
+
+	t.Run("error - request body digest mismatch", func(t *testing.T) {
+		// when
+		err := attested.MatchesBody([]byte("unexpected body"))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAttestedPayloadMismatch)
+		assert.ErrorContains(t, err, "request body digest mismatch")
+	})
+}
+
 func TestMakeAttestUserDataHandler(t *testing.T) {
 	t.Run("happy path", func(t *testing.T) {
 		// given
@@ -638,6 +1203,72 @@ func TestMakeAttestUserDataHandler(t *testing.T) {
 		assert.Equal(t, userData, verified.UserData)
 	})
 
+	t.Run("happy path - commitment", func(t *testing.T) {
+		// given
+		userData := bytes.Repeat([]byte("a"), 1024)
+		nonce := []byte("nonce")
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		recorder := httptest.NewRecorder()
+		body := networking.AttestUserDataRequest{
+			Nonce:      nonce,
+			Commitment: networking.DigestAlgorithmSHA512,
+			UserData:   userData,
+		}
+		req := makeRequest(t, "POST", networking.AttestUserDataPath, body)
+
+		handler := networking.MakeAttestUserDataHandler(attester, logger)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		response := networking.AttestUserDataResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+
+		verified, err := verifier.Verify(response.Attestation, tee.WithVerifyNonce(nonce))
+		require.NoError(t, err)
+		assert.Equal(t, networking.DigestSHA512(userData), string(verified.UserData))
+
+		got, err := networking.AttestedPayload(verified, response.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, userData, got)
+	})
+
+	t.Run("error - unsupported commitment", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		recorder := httptest.NewRecorder()
+		body := networking.AttestUserDataRequest{
+			Commitment: networking.DigestAlgorithm("md5"),
+			UserData:   []byte("hello world"),
+		}
+		req := makeRequest(t, "POST", networking.AttestUserDataPath, body)
+
+		handler := networking.MakeAttestUserDataHandler(attester, logger)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "validating commitment")
+	})
+
 	t.Run("error - decoding request", func(t *testing.T) {
 		// given
 		attester, err := tee.NewAttester(tee.NoTEE)
@@ -656,10 +1287,35 @@ func TestMakeAttestUserDataHandler(t *testing.T) {
 		handler.ServeHTTP(recorder, req)
 
 		// then
-		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
 		assert.Contains(t, recorder.Body.String(), "decoding request")
 	})
 
+	t.Run("error - request too large", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		recorder := httptest.NewRecorder()
+		body := networking.AttestUserDataRequest{UserData: bytes.Repeat([]byte("a"), 1024)}
+		req := makeRequest(t, "POST", networking.AttestUserDataPath, body)
+
+		handler := networking.LimitRequestBody(
+			512,
+			networking.MakeAttestUserDataHandler(attester, logger),
+		)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), string(networking.ErrorCodePayloadTooLarge))
+	})
+
 	t.Run("error - attesting userdata", func(t *testing.T) {
 		// given
 		data := []byte("hello world")
@@ -684,7 +1340,7 @@ func TestMakeAttestUserDataHandler(t *testing.T) {
 		handler.ServeHTTP(recorder, req)
 
 		// then
-		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
 		assert.Contains(t, logBuffer.String(), base64.StdEncoding.EncodeToString(nonce))
 		assert.Contains(t, logBuffer.String(), base64.StdEncoding.EncodeToString(data))
 		assert.Contains(t, recorder.Body.String(), "attesting")
@@ -0,0 +1,299 @@
+package networking
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/metrics"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+const (
+	HealthzPath         = "/healthz"
+	ReadyzPath          = "/readyz"
+	DefaultReadyTimeout = 5 * time.Second
+	// DefaultAttesterCheckTTL bounds how often readiness probes, which are
+	// unauthenticated, can make the Enclave attest.
+	DefaultAttesterCheckTTL = 30 * time.Second
+
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+type HealthStatus struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+func (h HealthStatus) OK() bool {
+	return h.Status == HealthStatusOK
+}
+
+type CheckResult struct {
+	Name    string        `json:"name"`
+	Status  string        `json:"status"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+// HealthCheck is a named readiness check. Check should return promptly once
+// ctx is done.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Executor is implemented by the CEL and Expr engines.
+type Executor interface {
+	Execute(ctx context.Context, expression string, env map[string]any) (any, error)
+}
+
+// MakeHealthzHandler reports that the process is up and serving requests.
+func MakeHealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		WriteResponse(w, HealthStatus{Status: HealthStatusOK})
+	}
+}
+
+// MakeReadyzHandler runs checks concurrently and responds with 200 if they all
+// pass within timeout, or 503 otherwise. The body lists every check result.
+func MakeReadyzHandler(
+	timeout time.Duration,
+	logger *slog.Logger,
+	checks ...HealthCheck,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		status := RunHealthChecks(ctx, checks...)
+		if status.OK() {
+			WriteResponse(w, status)
+			return
+		}
+
+		logger := LoggerFromContext(r.Context(), logger)
+		for _, result := range status.Checks {
+			if result.Status != HealthStatusOK {
+				logger.Warn(
+					"readiness check failed",
+					slog.String("check", result.Name),
+					slog.String("error", result.Error),
+				)
+			}
+		}
+		writeHealthStatus(w, http.StatusServiceUnavailable, status)
+	}
+}
+
+// RunHealthChecks runs checks concurrently. Checks still running when ctx is
+// done are reported as failed.
+func RunHealthChecks(ctx context.Context, checks ...HealthCheck) HealthStatus {
+	start := time.Now()
+	done := make([]chan CheckResult, len(checks))
+	for i, check := range checks {
+		done[i] = make(chan CheckResult, 1)
+		go func() {
+			checkStart := time.Now()
+			err := check.Check(ctx)
+			done[i] <- checkResult(check.Name, time.Since(checkStart), err)
+		}()
+	}
+
+	status := HealthStatus{Status: HealthStatusOK, Checks: make([]CheckResult, len(checks))}
+	for i, check := range checks {
+		var result CheckResult
+		select {
+		case result = <-done[i]:
+		case <-ctx.Done():
+			result = checkResult(check.Name, time.Since(start), ctx.Err())
+		}
+
+		if result.Status != HealthStatusOK {
+			status.Status = HealthStatusUnavailable
+		}
+		status.Checks[i] = result
+	}
+	return status
+}
+
+func checkResult(name string, latency time.Duration, err error) CheckResult {
+	if err != nil {
+		return CheckResult{
+			Name:    name,
+			Status:  HealthStatusUnavailable,
+			Error:   err.Error(),
+			Latency: latency,
+		}
+	}
+	return CheckResult{Name: name, Status: HealthStatusOK, Latency: latency}
+}
+
+// AttesterCheck confirms that attester can produce an attestation. The result
+// is reused for DefaultAttesterCheckTTL, so probes attest at most that often.
+func AttesterCheck(attester *tee.Attester) HealthCheck {
+	return CachedCheck(HealthCheck{
+		Name: "attester",
+		Check: func(context.Context) error {
+			_, err := attester.Attest(tee.WithAttestUserData([]byte(ReadyzPath)))
+			if err != nil {
+				return healthError("attesting", err)
+			}
+			return nil
+		},
+	}, DefaultAttesterCheckTTL)
+}
+
+// CachedCheck runs check at most once per ttl and reports its last result in
+// between, failures included. Concurrent callers share a single run.
+func CachedCheck(check HealthCheck, ttl time.Duration) HealthCheck {
+	var mu sync.Mutex
+	var checkedAt time.Time
+	var lastErr error
+	return HealthCheck{
+		Name: check.Name,
+		Check: func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if !checkedAt.IsZero() && time.Since(checkedAt) < ttl {
+				return lastErr
+			}
+			lastErr = check.Check(ctx)
+			checkedAt = time.Now()
+			return lastErr
+		},
+	}
+}
+
+// CertProviderCheck confirms that certProvider holds a currently valid leaf
+// certificate.
+func CertProviderCheck(certProvider tee.CertProvider) HealthCheck {
+	return HealthCheck{
+		Name: "cert_provider",
+		Check: func(ctx context.Context) error {
+			cert, err := certProvider.GetCert(ctx)
+			switch {
+			case err != nil:
+				return healthError("getting cert", err)
+			case cert == nil || len(cert.Certificate) == 0:
+				return healthError("missing cert", nil)
+			}
+
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return healthError("parsing cert", err)
+			}
+
+			now := time.Now()
+			switch {
+			case now.Before(leaf.NotBefore):
+				return healthError("cert not valid until "+leaf.NotBefore.Format(time.RFC3339), nil)
+			case now.After(leaf.NotAfter):
+				return healthError("cert expired at "+leaf.NotAfter.Format(time.RFC3339), nil)
+			}
+			return nil
+		},
+	}
+}
+
+// EngineCheck confirms that engine, and the whitelist it was built with, can
+// compile and run a trivial expression.
+func EngineCheck(name string, engine Executor) HealthCheck {
+	return HealthCheck{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			_, err := engine.Execute(ctx, "true", map[string]any{})
+			if err != nil {
+				return healthError("executing expression", err)
+			}
+			return nil
+		},
+	}
+}
+
+// UpstreamCheck confirms that a GET to url through client returns a 200. The
+// proxies use it to check that they can reach the enclave's HealthzPath.
+func UpstreamCheck(name string, client *http.Client, url string) HealthCheck {
+	return HealthCheck{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return healthError("creating request", err)
+			}
+
+			//nolint:gosec
+			resp, err := client.Do(req)
+			if err != nil {
+				return healthError("sending request", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return healthError(fmt.Sprintf("unexpected status %d", resp.StatusCode), nil)
+			}
+			return nil
+		},
+	}
+}
+
+// SocketCheck confirms that a GET to HealthzPath sent through roundTrip gets
+// a 200. The hello-world proxy uses it to check that it can reach the enclave
+// over its socket.
+func SocketCheck(
+	name string,
+	roundTrip func(ctx context.Context, req SocketRequest) (SocketResponse, error),
+) HealthCheck {
+	return HealthCheck{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			req := SocketRequest{
+				RequestID: RequestIDFromContext(ctx),
+				Method:    http.MethodGet,
+				Path:      HealthzPath,
+			}
+			resp, err := roundTrip(ctx, req)
+			if err != nil {
+				return healthError("sending request", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return healthError(fmt.Sprintf("unexpected status %d", resp.StatusCode), nil)
+			}
+			return nil
+		},
+	}
+}
+
+// NewAdminServer serves metrics, liveness and readiness on addr, with checks
+// deciding readiness. It is meant for proxies, which always run outside the
+// enclave on a regular socket and whose handlers cannot be wrapped.
+func NewAdminServer(
+	ctx context.Context,
+	addr string,
+	logger *slog.Logger,
+	checks ...HealthCheck,
+) (*tee.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("GET "+metrics.Path, metrics.Handler())
+	mux.Handle("GET "+HealthzPath, MakeHealthzHandler())
+	mux.Handle("GET "+ReadyzPath, MakeReadyzHandler(DefaultReadyTimeout, logger, checks...))
+	return tee.NewServer(ctx, tee.NoTEE, addr, mux, logger)
+}
+
+func writeHealthStatus(w http.ResponseWriter, code int, status HealthStatus) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		WriteError(w, internalError("marshaling health status", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(data)
+}
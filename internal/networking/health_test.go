@@ -0,0 +1,298 @@
+package networking_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/engine"
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func okCheck(name string) networking.HealthCheck {
+	return networking.HealthCheck{
+		Name:  name,
+		Check: func(context.Context) error { return nil },
+	}
+}
+
+func TestMakeHealthzHandler(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		handler := networking.MakeHealthzHandler()
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, networking.HealthzPath, nil)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		status := networking.HealthStatus{}
+		err := json.NewDecoder(recorder.Body).Decode(&status)
+		require.NoError(t, err)
+		assert.True(t, status.OK())
+	})
+}
+
+func TestMakeReadyzHandler(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		handler := networking.MakeReadyzHandler(time.Second, logger, okCheck("a"), okCheck("b"))
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, networking.ReadyzPath, nil)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		status := networking.HealthStatus{}
+		err := json.NewDecoder(recorder.Body).Decode(&status)
+		require.NoError(t, err)
+		assert.True(t, status.OK())
+		require.Len(t, status.Checks, 2)
+		assert.Equal(t, "a", status.Checks[0].Name)
+		assert.Equal(t, "b", status.Checks[1].Name)
+	})
+
+	t.Run("error - failed check", func(t *testing.T) {
+		// given
+		failing := networking.HealthCheck{
+			Name:  "failing",
+			Check: func(context.Context) error { return assert.AnError },
+		}
+		handler := networking.MakeReadyzHandler(time.Second, logger, okCheck("a"), failing)
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, networking.ReadyzPath, nil)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		status := networking.HealthStatus{}
+		err := json.NewDecoder(recorder.Body).Decode(&status)
+		require.NoError(t, err)
+		assert.False(t, status.OK())
+		assert.Equal(t, networking.HealthStatusOK, status.Checks[0].Status)
+		assert.Equal(t, networking.HealthStatusUnavailable, status.Checks[1].Status)
+		assert.Equal(t, assert.AnError.Error(), status.Checks[1].Error)
+	})
+
+	t.Run("error - check timed out", func(t *testing.T) {
+		// given
+		release := make(chan struct{})
+		defer close(release)
+		stuck := networking.HealthCheck{
+			Name: "stuck",
+			Check: func(context.Context) error {
+				<-release
+				return nil
+			},
+		}
+		handler := networking.MakeReadyzHandler(10*time.Millisecond, logger, stuck)
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, networking.ReadyzPath, nil)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), context.DeadlineExceeded.Error())
+	})
+}
+
+func TestHealthChecks(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		certProvider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, time.Hour)
+		require.NoError(t, err)
+
+		celEngine, err := engine.NewCELEngine()
+		require.NoError(t, err)
+
+		upstream := httptest.NewServer(networking.MakeHealthzHandler())
+		defer upstream.Close()
+
+		socketMux := http.NewServeMux()
+		socketMux.Handle("GET "+networking.HealthzPath, networking.MakeHealthzHandler())
+
+		// when
+		status := networking.RunHealthChecks(
+			context.Background(),
+			networking.AttesterCheck(attester),
+			networking.CertProviderCheck(certProvider),
+			networking.EngineCheck("cel_engine", celEngine),
+			networking.UpstreamCheck("enclave", upstream.Client(), upstream.URL+networking.HealthzPath),
+			networking.SocketCheck("enclave_socket", serveSocket(socketMux)),
+		)
+
+		// then
+		assert.True(t, status.OK(), status)
+	})
+
+	t.Run("error - expired cert", func(t *testing.T) {
+		// given
+		certProvider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, -time.Hour)
+		require.NoError(t, err)
+		check := networking.CertProviderCheck(certProvider)
+
+		// when
+		err = check.Check(context.Background())
+
+		// then
+		require.ErrorIs(t, err, networking.ErrHealth)
+		assert.ErrorContains(t, err, "cert expired")
+	})
+
+	t.Run("error - upstream not ok", func(t *testing.T) {
+		// given
+		upstream := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}),
+		)
+		defer upstream.Close()
+		check := networking.UpstreamCheck("enclave", upstream.Client(), upstream.URL)
+
+		// when
+		err := check.Check(context.Background())
+
+		// then
+		require.ErrorIs(t, err, networking.ErrHealth)
+		assert.ErrorContains(t, err, "unexpected status 502")
+	})
+
+	t.Run("error - socket not ok", func(t *testing.T) {
+		// given
+		check := networking.SocketCheck("enclave", serveSocket(http.NotFoundHandler()))
+
+		// when
+		err := check.Check(context.Background())
+
+		// then
+		require.ErrorIs(t, err, networking.ErrHealth)
+		assert.ErrorContains(t, err, "unexpected status 404")
+	})
+}
+
+// serveSocket round trips socket requests to handler, like an Enclave behind
+// a socket would.
+func serveSocket(
+	handler http.Handler,
+) func(context.Context, networking.SocketRequest) (networking.SocketResponse, error) {
+	return func(ctx context.Context, req networking.SocketRequest) (networking.SocketResponse, error) {
+		return networking.ServeSocketRequest(ctx, handler, req), nil
+	}
+}
+
+func TestCachedCheck(t *testing.T) {
+	countingCheck := func(calls *int) networking.HealthCheck {
+		return networking.HealthCheck{
+			Name: "counting",
+			Check: func(context.Context) error {
+				*calls++
+				return networking.ErrHealth
+			},
+		}
+	}
+
+	t.Run("happy path - reuses result within ttl", func(t *testing.T) {
+		// given
+		calls := 0
+		check := networking.CachedCheck(countingCheck(&calls), time.Hour)
+
+		// when
+		first := check.Check(context.Background())
+		second := check.Check(context.Background())
+
+		// then
+		require.ErrorIs(t, first, networking.ErrHealth)
+		require.ErrorIs(t, second, networking.ErrHealth)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("happy path - reruns after ttl", func(t *testing.T) {
+		// given
+		calls := 0
+		check := networking.CachedCheck(countingCheck(&calls), 0)
+
+		// when
+		_ = check.Check(context.Background())
+		_ = check.Check(context.Background())
+
+		// then
+		assert.Equal(t, 2, calls)
+	})
+}
+
+func TestClient_Health(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		server := httptest.NewServer(networking.MakeReadyzHandler(time.Second, logger, okCheck("a")))
+		defer server.Close()
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		status, err := client.Health(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.True(t, status.OK())
+		require.Len(t, status.Checks, 1)
+	})
+
+	t.Run("happy path - not ready", func(t *testing.T) {
+		// given
+		failing := networking.HealthCheck{
+			Name:  "failing",
+			Check: func(context.Context) error { return assert.AnError },
+		}
+		server := httptest.NewServer(networking.MakeReadyzHandler(time.Second, logger, failing))
+		defer server.Close()
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		status, err := client.Health(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.False(t, status.OK())
+		assert.Equal(t, "failing", status.Checks[0].Name)
+	})
+
+	t.Run("error - not a health status", func(t *testing.T) {
+		// given
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				networking.WriteError(w, networking.NewAPIError(networking.ErrorCodeAttestation, "overloaded", nil))
+			}),
+		)
+		defer server.Close()
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		_, err := client.Health(context.Background())
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClientNon200Response)
+		require.ErrorIs(t, err, networking.ErrAPIAttestation)
+	})
+}
@@ -0,0 +1,109 @@
+package networking
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+const (
+	DefaultMaxRequestBytes          = 1 * tee.Megabyte
+	DefaultMaxUpstreamResponseBytes = 8 * tee.Megabyte
+	DefaultMaxResponseBytes         = 16 * tee.Megabyte
+	LimitsKey                       = "limits"
+)
+
+type Limits struct {
+	MaxRequestBytes          int64 `mapstructure:"max_request_bytes"`
+	MaxUpstreamResponseBytes int64 `mapstructure:"max_upstream_response_bytes"`
+	MaxResponseBytes         int64 `mapstructure:"max_response_bytes"`
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxRequestBytes:          DefaultMaxRequestBytes,
+		MaxUpstreamResponseBytes: DefaultMaxUpstreamResponseBytes,
+		MaxResponseBytes:         DefaultMaxResponseBytes,
+	}
+}
+
+type requestLimitKey struct{}
+
+// LimitRequestBody caps the size of every request body read by next. Reads
+// past the limit fail with an *http.MaxBytesError.
+func LimitRequestBody(maxBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		ctx := context.WithValue(r.Context(), requestLimitKey{}, maxBytes)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func requestBodyLimited(r *http.Request) bool {
+	_, ok := r.Context().Value(requestLimitKey{}).(int64)
+	return ok
+}
+
+// LimitResponseBody caps the size of every response body returned by next.
+// Reads past the limit fail with ErrPayloadTooLarge.
+func LimitResponseBody(maxBytes int64, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.ContentLength > maxBytes {
+			resp.Body.Close()
+			msg := fmt.Sprintf("response content length %d exceeds %d bytes", resp.ContentLength, maxBytes)
+			return nil, payloadTooLargeError(msg, nil)
+		}
+		resp.Body = &limitedReadCloser{ReadCloser: resp.Body, remaining: maxBytes, max: maxBytes}
+		return resp, nil
+	})
+}
+
+// ReadAllLimited reads r to EOF, failing with ErrPayloadTooLarge if r holds
+// more than maxBytes.
+func ReadAllLimited(r io.Reader, maxBytes int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, payloadTooLargeError(fmt.Sprintf("exceeds %d bytes", maxBytes), nil)
+	}
+	return data, nil
+}
+
+type limitedReadCloser struct {
+	io.ReadCloser
+	remaining int64
+	max       int64
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Probe for one more byte so that a body of exactly max bytes still
+		// reads cleanly to EOF.
+		var probe [1]byte
+		n, err := l.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, payloadTooLargeError(fmt.Sprintf("exceeds %d bytes", l.max), nil)
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
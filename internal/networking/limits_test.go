@@ -0,0 +1,142 @@
+package networking_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitRequestBody(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			networking.WriteError(w, networking.NewAPIError(networking.ErrorCodePayloadTooLarge, "", err))
+			return
+		}
+		_, _ = w.Write(body)
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		handler := networking.LimitRequestBody(4, echo)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abcd"))
+		rec := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(rec, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "abcd", rec.Body.String())
+	})
+
+	t.Run("error - request too large", func(t *testing.T) {
+		// given
+		handler := networking.LimitRequestBody(4, echo)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abcde"))
+		rec := httptest.NewRecorder()
+
+		// when
+		handler.ServeHTTP(rec, req)
+
+		// then
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+}
+
+func TestLimitResponseBody(t *testing.T) {
+	get := func(t *testing.T, body []byte, chunked bool, maxBytes int64) ([]byte, error) {
+		t.Helper()
+		backend := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				if chunked {
+					// Flushing before writing hides the content length.
+					w.(http.Flusher).Flush()
+				}
+				_, _ = w.Write(body)
+			}),
+		)
+		defer backend.Close()
+
+		client := backend.Client()
+		client.Transport = networking.LimitResponseBody(maxBytes, client.Transport)
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, backend.URL, nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		body := []byte("abcd")
+
+		// when
+		got, err := get(t, body, true, int64(len(body)))
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, body, got)
+	})
+
+	t.Run("error - content length too large", func(t *testing.T) {
+		// given
+		body := []byte("abcde")
+
+		// when
+		_, err := get(t, body, false, 4)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrPayloadTooLarge)
+	})
+
+	t.Run("error - streamed body too large", func(t *testing.T) {
+		// given
+		body := []byte("abcde")
+
+		// when
+		_, err := get(t, body, true, 4)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrPayloadTooLarge)
+	})
+}
+
+func TestReadAllLimited(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		data := []byte("abcd")
+
+		// when
+		got, err := networking.ReadAllLimited(bytes.NewReader(data), int64(len(data)))
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+	})
+
+	t.Run("error - exceeds limit", func(t *testing.T) {
+		// given
+		data := []byte("abcde")
+
+		// when
+		_, err := networking.ReadAllLimited(bytes.NewReader(data), 4)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrPayloadTooLarge)
+	})
+}
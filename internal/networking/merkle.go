@@ -0,0 +1,144 @@
+package networking
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// The Merkle tree follows RFC 6962 (Certificate Transparency): leaves and
+// interior nodes are hashed with distinct prefixes so that a leaf can never be
+// passed off as an interior node.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleProof is an RFC 6962 audit path for the leaf at LeafIndex in a tree
+// of TreeSize leaves.
+type MerkleProof struct {
+	LeafIndex int      `json:"leaf_index"`
+	TreeSize  int      `json:"tree_size"`
+	Hashes    [][]byte `json:"hashes"`
+}
+
+type MerkleTree struct {
+	leaves [][]byte
+	root   []byte
+}
+
+// NewMerkleTree builds a tree over leaves. Leaves are hashed, so they may be
+// of any length.
+func NewMerkleTree(leaves [][]byte) *MerkleTree {
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = MerkleLeafHash(leaf)
+	}
+	return &MerkleTree{leaves: hashes, root: merkleRoot(hashes)}
+}
+
+func (t *MerkleTree) Root() []byte {
+	return bytes.Clone(t.root)
+}
+
+func (t *MerkleTree) Size() int {
+	return len(t.leaves)
+}
+
+func (t *MerkleTree) Proof(index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(t.leaves) {
+		msg := fmt.Sprintf("leaf index %d out of range for tree size %d", index, len(t.leaves))
+		return nil, merkleProofError(msg, nil)
+	}
+	return &MerkleProof{
+		LeafIndex: index,
+		TreeSize:  len(t.leaves),
+		Hashes:    merklePath(index, t.leaves),
+	}, nil
+}
+
+// Verify checks that leaf is included in the tree with the given root, using
+// the algorithm from RFC 9162 section 2.1.3.2.
+func (p *MerkleProof) Verify(leaf []byte, root []byte) error {
+	if p.LeafIndex < 0 || p.LeafIndex >= p.TreeSize {
+		msg := fmt.Sprintf("leaf index %d out of range for tree size %d", p.LeafIndex, p.TreeSize)
+		return merkleProofError(msg, nil)
+	}
+
+	fn := p.LeafIndex
+	sn := p.TreeSize - 1
+	r := MerkleLeafHash(leaf)
+	for _, hash := range p.Hashes {
+		if sn == 0 {
+			return merkleProofError("proof too long", nil)
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(hash, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, hash)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 {
+		return merkleProofError("proof too short", nil)
+	}
+	if !bytes.Equal(r, root) {
+		return merkleProofError("computed root does not match", ErrAttestedPayloadMismatch)
+	}
+	return nil
+}
+
+func MerkleLeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafPrefix})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func merkleNodeHash(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+func merkleRoot(hashes [][]byte) []byte {
+	switch len(hashes) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return hashes[0]
+	}
+
+	k := merkleSplit(len(hashes))
+	return merkleNodeHash(merkleRoot(hashes[:k]), merkleRoot(hashes[k:]))
+}
+
+func merklePath(index int, hashes [][]byte) [][]byte {
+	if len(hashes) <= 1 {
+		return [][]byte{}
+	}
+
+	k := merkleSplit(len(hashes))
+	if index < k {
+		return append(merklePath(index, hashes[:k]), merkleRoot(hashes[k:]))
+	}
+	return append(merklePath(index-k, hashes[k:]), merkleRoot(hashes[:k]))
+}
+
+// merkleSplit returns the largest power of two smaller than n.
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
@@ -0,0 +1,124 @@
+package networking_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = fmt.Appendf(nil, "leaf %d", i)
+	}
+	return leaves
+}
+
+func TestMerkleTree_Root(t *testing.T) {
+	t.Run("happy path - single leaf", func(t *testing.T) {
+		// given
+		leaves := makeLeaves(1)
+
+		// when
+		tree := networking.NewMerkleTree(leaves)
+
+		// then
+		assert.Equal(t, networking.MerkleLeafHash(leaves[0]), tree.Root())
+	})
+
+	t.Run("happy path - root depends on every leaf", func(t *testing.T) {
+		// given
+		leaves := makeLeaves(5)
+		tampered := makeLeaves(5)
+		tampered[4] = []byte("tampered")
+
+		// when
+		root := networking.NewMerkleTree(leaves).Root()
+		tamperedRoot := networking.NewMerkleTree(tampered).Root()
+
+		// then
+		assert.NotEqual(t, root, tamperedRoot)
+	})
+}
+
+func TestMerkleProof_Verify(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		for size := 1; size <= 17; size++ {
+			// given
+			leaves := makeLeaves(size)
+			tree := networking.NewMerkleTree(leaves)
+
+			for i, leaf := range leaves {
+				proof, err := tree.Proof(i)
+				require.NoError(t, err)
+
+				// when
+				err = proof.Verify(leaf, tree.Root())
+
+				// then
+				require.NoError(t, err, "size %d, leaf %d", size, i)
+			}
+		}
+	})
+
+	t.Run("error - wrong leaf", func(t *testing.T) {
+		// given
+		leaves := makeLeaves(7)
+		tree := networking.NewMerkleTree(leaves)
+		proof, err := tree.Proof(3)
+		require.NoError(t, err)
+
+		// when
+		err = proof.Verify(leaves[4], tree.Root())
+
+		// then
+		require.ErrorIs(t, err, networking.ErrMerkleProof)
+		require.ErrorIs(t, err, networking.ErrAttestedPayloadMismatch)
+	})
+
+	t.Run("error - wrong index", func(t *testing.T) {
+		// given
+		leaves := makeLeaves(7)
+		tree := networking.NewMerkleTree(leaves)
+		proof, err := tree.Proof(3)
+		require.NoError(t, err)
+		proof.LeafIndex = 2
+
+		// when
+		err = proof.Verify(leaves[3], tree.Root())
+
+		// then
+		require.ErrorIs(t, err, networking.ErrMerkleProof)
+	})
+
+	t.Run("error - truncated proof", func(t *testing.T) {
+		// given
+		leaves := makeLeaves(8)
+		tree := networking.NewMerkleTree(leaves)
+		proof, err := tree.Proof(0)
+		require.NoError(t, err)
+		proof.Hashes = proof.Hashes[:1]
+
+		// when
+		err = proof.Verify(leaves[0], tree.Root())
+
+		// then
+		require.ErrorIs(t, err, networking.ErrMerkleProof)
+		assert.ErrorContains(t, err, "proof too short")
+	})
+
+	t.Run("error - index out of range", func(t *testing.T) {
+		// given
+		tree := networking.NewMerkleTree(makeLeaves(2))
+
+		// when
+		_, err := tree.Proof(2)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrMerkleProof)
+	})
+}
@@ -0,0 +1,146 @@
+package networking
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/metrics"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+const resultOK = "ok"
+
+var (
+	endpointRequestsTotal = metrics.Default.NewCounter(
+		"bearclave_attest_requests_total",
+		"Attested endpoint requests by endpoint and result.",
+		"endpoint",
+		"result",
+	)
+	endpointRequestDuration = metrics.Default.NewHistogram(
+		"bearclave_attest_request_duration_seconds",
+		"Time spent serving attested endpoint requests.",
+		nil,
+		"endpoint",
+	)
+	attestationDuration = metrics.Default.NewHistogram(
+		"bearclave_attestation_duration_seconds",
+		"Time spent producing attestations.",
+		nil,
+		"endpoint",
+	)
+	httpRequestsTotal = metrics.Default.NewCounter(
+		"bearclave_http_requests_total",
+		"HTTP requests by handler and status code.",
+		"handler",
+		"code",
+	)
+	httpRequestDuration = metrics.Default.NewHistogram(
+		"bearclave_http_request_duration_seconds",
+		"Time spent serving HTTP requests.",
+		nil,
+		"handler",
+	)
+	httpRequestsInFlight = metrics.Default.NewGauge(
+		"bearclave_http_requests_in_flight",
+		"HTTP requests currently being served.",
+		"handler",
+	)
+	upstreamRequestsTotal = metrics.Default.NewCounter(
+		"bearclave_upstream_requests_total",
+		"Outbound HTTP requests by client, method and status code.",
+		"client",
+		"method",
+		"code",
+	)
+	upstreamRequestDuration = metrics.Default.NewHistogram(
+		"bearclave_upstream_request_duration_seconds",
+		"Time until outbound HTTP response headers are received.",
+		nil,
+		"client",
+	)
+	dialsTotal = metrics.Default.NewCounter(
+		"bearclave_dials_total",
+		"Connections dialed by dialer and result.",
+		"dialer",
+		"result",
+	)
+	dialDuration = metrics.Default.NewHistogram(
+		"bearclave_dial_duration_seconds",
+		"Time spent dialing connections.",
+		nil,
+		"dialer",
+	)
+)
+
+func observeEndpoint(endpoint string, result string, d time.Duration) {
+	endpointRequestsTotal.With(endpoint, result).Inc()
+	endpointRequestDuration.With(endpoint).Observe(d.Seconds())
+}
+
+func observeAttestation(endpoint string, d time.Duration) {
+	attestationDuration.With(endpoint).Observe(d.Seconds())
+}
+
+// InstrumentHandler records request counts, latencies and in-flight requests
+// for next under the given handler name.
+func InstrumentHandler(handler string, next http.Handler) http.Handler {
+	inFlight := httpRequestsInFlight.With(handler)
+	duration := httpRequestDuration.With(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		rec := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		duration.Observe(time.Since(start).Seconds())
+		httpRequestsTotal.With(handler, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// InstrumentRoundTripper records outbound request counts and latencies for
+// next under the given client name. Requests that fail before a response is
+// received are counted with the code "error".
+func InstrumentRoundTripper(client string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	duration := upstreamRequestDuration.With(client)
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		duration.Observe(time.Since(start).Seconds())
+
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+		}
+		upstreamRequestsTotal.With(client, req.Method, code).Inc()
+		return resp, err
+	})
+}
+
+// InstrumentDialContext records dial counts and latencies for dial under the
+// given dialer name. It is used for the reverse proxies, whose handlers cannot
+// be wrapped.
+func InstrumentDialContext(dialer string, dial tee.DialContext) tee.DialContext {
+	duration := dialDuration.With(dialer)
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := dial(ctx, network, addr)
+		duration.Observe(time.Since(start).Seconds())
+
+		result := resultOK
+		if err != nil {
+			result = "error"
+		}
+		dialsTotal.With(dialer, result).Inc()
+		return conn, err
+	}
+}
@@ -0,0 +1,179 @@
+package networking_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/metrics"
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrape returns the value of series in the default registry, or zero if it
+// has not been recorded.
+func scrape(t *testing.T, series string) float64 {
+	t.Helper()
+	var buf bytes.Buffer
+	err := metrics.Default.WriteText(&buf)
+	require.NoError(t, err)
+
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), series+" ")
+		if !ok {
+			continue
+		}
+		got, err := strconv.ParseFloat(value, 64)
+		require.NoError(t, err)
+		return got
+	}
+	return 0
+}
+
+func TestAttestedEndpoint_Metrics(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		endpoint := makeGreetEndpoint(t, slog.New(slog.DiscardHandler))
+		endpoint.Name = "metrics greet"
+
+		okReq := makeRequest(t, "POST", "/greet", greetRequest{Name: "world"})
+		badReq := makeRequest(t, "POST", "/greet", greetRequest{})
+
+		// when
+		endpoint.ServeHTTP(httptest.NewRecorder(), okReq)
+		endpoint.ServeHTTP(httptest.NewRecorder(), badReq)
+
+		// then
+		assert.InDelta(t, 1.0, scrape(t, `bearclave_attest_requests_total{endpoint="metrics greet",result="ok"}`), 0)
+		assert.InDelta(t, 1.0, scrape(t, `bearclave_attest_requests_total{endpoint="metrics greet",result="bad_request"}`), 0)
+		assert.InDelta(t, 2.0, scrape(t, `bearclave_attest_request_duration_seconds_count{endpoint="metrics greet"}`), 0)
+		assert.InDelta(t, 1.0, scrape(t, `bearclave_attestation_duration_seconds_count{endpoint="metrics greet"}`), 0)
+	})
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		inFlight := 0.0
+		handler := networking.InstrumentHandler(
+			"instrument_test",
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				inFlight = scrape(t, `bearclave_http_requests_in_flight{handler="instrument_test"}`)
+				w.WriteHeader(http.StatusTeapot)
+			}),
+		)
+
+		// when
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		// then
+		assert.InDelta(t, 1.0, inFlight, 0)
+		assert.InDelta(t, 0.0, scrape(t, `bearclave_http_requests_in_flight{handler="instrument_test"}`), 0)
+		assert.InDelta(t, 1.0, scrape(t, `bearclave_http_requests_total{handler="instrument_test",code="418"}`), 0)
+		assert.InDelta(t, 1.0, scrape(t, `bearclave_http_request_duration_seconds_count{handler="instrument_test"}`), 0)
+	})
+}
+
+func TestInstrumentRoundTripper(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		backend := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			}),
+		)
+		defer backend.Close()
+
+		client := backend.Client()
+		client.Transport = networking.InstrumentRoundTripper("round_tripper_test", client.Transport)
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, backend.URL, nil)
+		require.NoError(t, err)
+
+		// when
+		resp, err := client.Do(req)
+
+		// then
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.InDelta(
+			t,
+			1.0,
+			scrape(t, `bearclave_upstream_requests_total{client="round_tripper_test",method="GET",code="202"}`),
+			0,
+		)
+		assert.InDelta(
+			t,
+			1.0,
+			scrape(t, `bearclave_upstream_request_duration_seconds_count{client="round_tripper_test"}`),
+			0,
+		)
+	})
+
+	t.Run("error - request failed", func(t *testing.T) {
+		// given
+		failing := networking.InstrumentRoundTripper(
+			"round_tripper_error_test",
+			failingRoundTripper{},
+		)
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://example.com", nil)
+		require.NoError(t, err)
+
+		// when
+		//nolint:bodyclose
+		_, err = failing.RoundTrip(req)
+
+		// then
+		require.ErrorIs(t, err, assert.AnError)
+		assert.InDelta(
+			t,
+			1.0,
+			scrape(t, `bearclave_upstream_requests_total{client="round_tripper_error_test",method="POST",code="error"}`),
+			0,
+		)
+	})
+}
+
+type failingRoundTripper struct{}
+
+func (failingRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, assert.AnError
+}
+
+func TestInstrumentDialContext(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		dial := networking.InstrumentDialContext(
+			"dial_test",
+			func(_ context.Context, _ string, addr string) (net.Conn, error) {
+				if addr == "bad" {
+					return nil, assert.AnError
+				}
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			},
+		)
+
+		// when
+		conn, errOK := dial(context.Background(), "tcp4", "good")
+		_, errBad := dial(context.Background(), "tcp4", "bad")
+
+		// then
+		require.NoError(t, errOK)
+		conn.Close()
+		require.ErrorIs(t, errBad, assert.AnError)
+		assert.InDelta(t, 1.0, scrape(t, `bearclave_dials_total{dialer="dial_test",result="ok"}`), 0)
+		assert.InDelta(t, 1.0, scrape(t, `bearclave_dials_total{dialer="dial_test",result="error"}`), 0)
+	})
+}
@@ -0,0 +1,17 @@
+package networking
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+const DefaultNonceSize = 32
+
+func NewNonce() ([]byte, error) {
+	nonce := make([]byte, DefaultNonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("reading random nonce: %w", err)
+	}
+	return nonce, nil
+}
@@ -0,0 +1,24 @@
+package networking_test
+
+import (
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewNonce(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// when
+		first, err := networking.NewNonce()
+		require.NoError(t, err)
+		second, err := networking.NewNonce()
+		require.NoError(t, err)
+
+		// then
+		assert.Len(t, first, networking.DefaultNonceSize)
+		assert.NotEqual(t, first, second)
+	})
+}
@@ -0,0 +1,113 @@
+package networking
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// CertPins pins the leaf public keys of attested cert chains per domain. A
+// connection is accepted only if its leaf key is pinned for the server name
+// it was made to, so a pin never vouches for another domain and the chain's
+// certificates are never trusted as CAs. A domain can have several pins, one
+// per Enclave serving it. Certificates are not otherwise checked, since the
+// attested key is what identifies the Enclave, and so pins survive
+// rotations that keep the key.
+type CertPins struct {
+	mu   sync.RWMutex
+	pins map[string][]string
+}
+
+func NewCertPins() *CertPins {
+	return &CertPins{pins: map[string][]string{}}
+}
+
+// Pin pins the leaf key of certChainJSON, a JSON array of DER certificates
+// such as an attested AttestCertResponse payload, for domain. The leaf must
+// be valid for domain.
+func (p *CertPins) Pin(domain string, certChainJSON []byte) error {
+	chainDER := [][]byte{}
+	err := json.Unmarshal(certChainJSON, &chainDER)
+	if err != nil {
+		return clientError("unmarshaling cert chain json", err)
+	}
+	if len(chainDER) == 0 {
+		return clientError("empty cert chain", nil)
+	}
+	leaf, err := x509.ParseCertificate(chainDER[0])
+	if err != nil {
+		return clientError("parsing leaf cert", err)
+	}
+	err = leaf.VerifyHostname(domain)
+	if err != nil {
+		return clientError("pinning leaf cert", err)
+	}
+
+	name := normalizeServerName(domain)
+	pin := SPKIFingerprint(leaf)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !slices.Contains(p.pins[name], pin) {
+		p.pins[name] = append(p.pins[name], pin)
+	}
+	return nil
+}
+
+// Unpin removes every pin for domain.
+func (p *CertPins) Unpin(domain string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pins, normalizeServerName(domain))
+}
+
+// Pins returns the pins for domain.
+func (p *CertPins) Pins(domain string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.Clone(p.pins[normalizeServerName(domain)])
+}
+
+// VerifyConnection is a tls.Config VerifyConnection that accepts the
+// connection only if its leaf key is pinned for its server name.
+func (p *CertPins) VerifyConnection(state tls.ConnectionState) error {
+	name := normalizeServerName(state.ServerName)
+	if name == "" {
+		return clientErrorPinMismatch("no server name to look up pins for", nil)
+	}
+	if len(state.PeerCertificates) == 0 {
+		return clientErrorPinMismatch(fmt.Sprintf("%q presented no certificate", name), nil)
+	}
+
+	pins := p.Pins(name)
+	if len(pins) == 0 {
+		return clientErrorPinMismatch(fmt.Sprintf("no pins for %q", name), nil)
+	}
+	pin := SPKIFingerprint(state.PeerCertificates[0])
+	if !slices.Contains(pins, pin) {
+		msg := fmt.Sprintf(
+			"leaf key %s of %q is not pinned, expected one of %s",
+			pin,
+			name,
+			strings.Join(pins, ", "),
+		)
+		return clientErrorPinMismatch(msg, nil)
+	}
+	return nil
+}
+
+// SPKIFingerprint is the hex encoded SHA-256 digest of cert's
+// SubjectPublicKeyInfo.
+func SPKIFingerprint(cert *x509.Certificate) string {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(digest[:])
+}
+
+func normalizeServerName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,260 @@
+package networking_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+// certChainJSON returns provider's current chain as AttestCertPath would.
+func certChainJSON(t *testing.T, provider tee.CertProvider) []byte {
+	t.Helper()
+	cert, err := provider.GetCert(context.Background())
+	require.NoError(t, err)
+	chainJSON, err := networking.MarshalCanonicalJSON(cert.Certificate)
+	require.NoError(t, err)
+	return chainJSON
+}
+
+// makeConnectionState returns the state of a connection to serverName on
+// which provider's certificate was presented.
+func makeConnectionState(
+	t *testing.T,
+	serverName string,
+	provider tee.CertProvider,
+) tls.ConnectionState {
+	t.Helper()
+	cert, err := provider.GetCert(context.Background())
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return tls.ConnectionState{ServerName: serverName, PeerCertificates: []*x509.Certificate{leaf}}
+}
+
+func newCertProvider(t *testing.T, domain string) *tee.SelfSignedCertProvider {
+	t.Helper()
+	provider, err := tee.NewSelfSignedCertProvider(domain, tee.DefaultIP, time.Hour)
+	require.NoError(t, err)
+	return provider
+}
+
+func TestCertPins_Pin(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		pins := networking.NewCertPins()
+		provider := newCertProvider(t, acmeDomain)
+
+		// when
+		err := pins.Pin(acmeDomain, certChainJSON(t, provider))
+
+		// then
+		require.NoError(t, err)
+		assert.Len(t, pins.Pins(acmeDomain), 1)
+	})
+
+	t.Run("error - leaf not valid for domain", func(t *testing.T) {
+		// given
+		pins := networking.NewCertPins()
+		provider := newCertProvider(t, tee.DefaultDomain)
+
+		// when
+		err := pins.Pin(acmeDomain, certChainJSON(t, provider))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClient)
+		assert.Empty(t, pins.Pins(acmeDomain))
+	})
+
+	t.Run("error - empty chain", func(t *testing.T) {
+		// given
+		pins := networking.NewCertPins()
+
+		// when
+		err := pins.Pin(acmeDomain, []byte(`[]`))
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClient)
+	})
+}
+
+func TestCertPins_VerifyConnection(t *testing.T) {
+	first := newCertProvider(t, acmeDomain)
+	second := newCertProvider(t, acmeDomain)
+	other := newCertProvider(t, tee.DefaultDomain)
+	pins := networking.NewCertPins()
+	require.NoError(t, pins.Pin(acmeDomain, certChainJSON(t, first)))
+	require.NoError(t, pins.Pin(acmeDomain, certChainJSON(t, second)))
+	require.NoError(t, pins.Pin(tee.DefaultDomain, certChainJSON(t, other)))
+
+	t.Run("happy path - several enclaves per domain", func(t *testing.T) {
+		for _, provider := range []tee.CertProvider{first, second} {
+			// given
+			state := makeConnectionState(t, "Enclave.Example.com", provider)
+
+			// when
+			err := pins.VerifyConnection(state)
+
+			// then
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("happy path - pin survives rotation", func(t *testing.T) {
+		// given
+		provider := newCertProvider(t, acmeDomain)
+		rotatedPins := networking.NewCertPins()
+		require.NoError(t, rotatedPins.Pin(acmeDomain, certChainJSON(t, provider)))
+		require.NoError(t, provider.RotateCert(context.Background()))
+
+		// when
+		err := rotatedPins.VerifyConnection(makeConnectionState(t, acmeDomain, provider))
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - pinned for another domain", func(t *testing.T) {
+		// given
+		state := makeConnectionState(t, tee.DefaultDomain, first)
+
+		// when
+		err := pins.VerifyConnection(state)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClientPinMismatch)
+		require.ErrorContains(t, err, "is not pinned")
+	})
+
+	t.Run("error - no pins for domain", func(t *testing.T) {
+		// given
+		state := makeConnectionState(t, "unknown.example.com", first)
+
+		// when
+		err := pins.VerifyConnection(state)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClientPinMismatch)
+		require.ErrorContains(t, err, `no pins for "unknown.example.com"`)
+	})
+
+	t.Run("error - no server name", func(t *testing.T) {
+		// given
+		state := makeConnectionState(t, "", first)
+
+		// when
+		err := pins.VerifyConnection(state)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClientPinMismatch)
+	})
+}
+
+func TestClient_AddCertChain_Pins(t *testing.T) {
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	expression := `balance > 1000 ? "approved" : "denied"`
+	env := map[string]any{"balance": 123456789}
+
+	// Keep-alives would let requests reuse connections made before rotation.
+	newTransport := func() *http.Client {
+		return &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	}
+
+	t.Run("happy path - several enclaves share pins", func(t *testing.T) {
+		// given
+		pins := networking.NewCertPins()
+		servers := []*rotatingServer{newRotatingServer(t, time.Hour), newRotatingServer(t, time.Hour)}
+		clients := []*networking.Client{}
+		for _, server := range servers {
+			client := networking.NewClientWithClient(
+				server.tlsServer.URL,
+				newTransport(),
+				networking.WithClientCertPins(pins),
+			)
+			err := client.AddCertChain(certChainJSON(t, server.provider), tee.DefaultDomain)
+			require.NoError(t, err)
+			clients = append(clients, client)
+		}
+
+		for _, client := range clients {
+			// when
+			_, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+			// then
+			require.NoError(t, err)
+		}
+		assert.Len(t, pins.Pins(tee.DefaultDomain), 2)
+	})
+
+	t.Run("happy path - keeps working after rotation", func(t *testing.T) {
+		// given
+		server := newRotatingServer(t, time.Hour)
+		client := networking.NewClientWithClient(
+			server.tlsServer.URL,
+			newTransport(),
+			networking.WithClientCertPins(networking.NewCertPins()),
+		)
+		err := client.AddCertChain(certChainJSON(t, server.provider), tee.DefaultDomain)
+		require.NoError(t, err)
+		err = server.provider.RotateCert(context.Background())
+		require.NoError(t, err)
+
+		// when
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("happy path - refresh pins a new enclave key", func(t *testing.T) {
+		// given
+		restarted := newRotatingServer(t, time.Hour)
+		client := networking.NewClientWithClient(
+			restarted.tlsServer.URL,
+			newTransport(),
+			networking.WithClientCertPins(networking.NewCertPins()),
+			networking.WithClientCertRefresh(
+				networking.NewClient(restarted.certServer.URL),
+				tee.DefaultDomain,
+				verifier,
+			),
+		)
+		previous := newCertProvider(t, tee.DefaultDomain)
+		err := client.AddCertChain(certChainJSON(t, previous), tee.DefaultDomain)
+		require.NoError(t, err)
+
+		// when
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - enclave with another key", func(t *testing.T) {
+		// given
+		server := newRotatingServer(t, time.Hour)
+		client := networking.NewClientWithClient(
+			server.tlsServer.URL,
+			newTransport(),
+			networking.WithClientCertPins(networking.NewCertPins()),
+		)
+		other := newCertProvider(t, tee.DefaultDomain)
+		err := client.AddCertChain(certChainJSON(t, other), tee.DefaultDomain)
+		require.NoError(t, err)
+
+		// when
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClientPinMismatch)
+	})
+}
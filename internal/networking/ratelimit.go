@@ -0,0 +1,281 @@
+package networking
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/metrics"
+)
+
+const (
+	DefaultRequestsPerSecond = 10
+	DefaultBurst             = 20
+	DefaultMaxConcurrent     = 32
+	DefaultMaxQueue          = 64
+	DefaultQueueTimeout      = 5 * time.Second
+	DefaultMaxClients        = 10000
+	RateLimitKey             = "rate_limit"
+)
+
+// RateLimitConfig configures a Limiter. A non-positive RequestsPerSecond
+// disables per-client rate limits, and a non-positive MaxConcurrent disables
+// the concurrency cap and its queue.
+type RateLimitConfig struct {
+	RequestsPerSecond float64       `mapstructure:"requests_per_second"`
+	Burst             int           `mapstructure:"burst"`
+	MaxConcurrent     int           `mapstructure:"max_concurrent"`
+	MaxQueue          int           `mapstructure:"max_queue"`
+	QueueTimeout      time.Duration `mapstructure:"queue_timeout"`
+	MaxClients        int           `mapstructure:"max_clients"`
+
+	// TrustForwardedFor keys clients by the address the reverse proxy
+	// appends to X-Forwarded-For, since every request reaches the enclave
+	// from the proxy's address. Enable it only behind such a proxy, since
+	// clients that connect directly, or through a TLS passthrough, set the
+	// header themselves.
+	TrustForwardedFor bool `mapstructure:"trust_forwarded_for"`
+
+	// ExemptPaths are never limited, so that liveness probes and metrics
+	// scrapes are not shed under load. Paths ending in a slash exempt every
+	// path under them.
+	ExemptPaths []string `mapstructure:"exempt_paths"`
+}
+
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		RequestsPerSecond: DefaultRequestsPerSecond,
+		Burst:             DefaultBurst,
+		MaxConcurrent:     DefaultMaxConcurrent,
+		MaxQueue:          DefaultMaxQueue,
+		QueueTimeout:      DefaultQueueTimeout,
+		MaxClients:        DefaultMaxClients,
+		TrustForwardedFor: false,
+		ExemptPaths:       []string{HealthzPath, metrics.Path, ACMEChallengePath},
+	}
+}
+
+var (
+	shedRequestsTotal = metrics.Default.NewCounter(
+		"bearclave_shed_requests_total",
+		"Requests rejected by rate limits or load shedding, by reason.",
+		"reason",
+	)
+	queuedRequests = metrics.Default.NewGauge(
+		"bearclave_queued_requests",
+		"Requests waiting for a concurrency slot.",
+	)
+)
+
+type clientIdentityKey struct{}
+
+// WithClientIdentity returns a copy of ctx carrying the authenticated client
+// identity, which the Limiter prefers over the source address.
+func WithClientIdentity(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientIdentityKey{}, id)
+}
+
+func ClientIdentityFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(clientIdentityKey{}).(string)
+	return id
+}
+
+// Limiter protects expensive handlers. Each client gets a token bucket, and a
+// global semaphore caps how many requests run at once. Requests over their
+// client's rate are rejected with a 429, and requests that find the queue for
+// the semaphore full, or that wait in it too long, are shed with a 503. Both
+// carry a Retry-After header. Wrap the Authenticator in it, so that requests
+// with bad credentials, which are still costly to check, are limited too.
+type Limiter struct {
+	config RateLimitConfig
+	logger *slog.Logger
+	slots  chan struct{}
+	queued atomic.Int64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(config RateLimitConfig, logger *slog.Logger) *Limiter {
+	limiter := &Limiter{
+		config:  config,
+		logger:  logger,
+		buckets: map[string]*tokenBucket{},
+	}
+	if config.MaxConcurrent > 0 {
+		limiter.slots = make(chan struct{}, config.MaxConcurrent)
+	}
+	return limiter
+}
+
+// Wrap applies the limits to next. Handlers that share a Limiter share its
+// concurrency cap.
+func (l *Limiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matchesPath(l.config.ExemptPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger := LoggerFromContext(r.Context(), l.logger)
+		client := l.clientKey(r)
+		if ok, retryAfter := l.allow(client); !ok {
+			shedRequestsTotal.With("rate_limited").Inc()
+			logger.Warn("rate limited", slog.String("client", client))
+			apiErr := NewAPIError(ErrorCodeRateLimited, "rate limit exceeded", nil)
+			apiErr.RetryAfter = retryAfter
+			WriteError(w, apiErr)
+			return
+		}
+
+		release, apiErr := l.acquire(r.Context())
+		if apiErr != nil {
+			shedRequestsTotal.With(string(apiErr.Code)).Inc()
+			logger.Warn("shedding load", slog.String("error", apiErr.Message))
+			WriteError(w, apiErr)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token from client's bucket. If there is none, it returns how
+// long until one is available.
+func (l *Limiter) allow(client string) (bool, time.Duration) {
+	rate := l.config.RequestsPerSecond
+	if rate <= 0 {
+		return true, 0
+	}
+	burst := math.Max(float64(l.config.Burst), 1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.buckets[client]
+	if !ok {
+		l.evict(now)
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[client] = bucket
+	}
+
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(burst, bucket.tokens+elapsed*rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := (1 - bucket.tokens) / rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// evict bounds the number of tracked clients. It first drops buckets that
+// have refilled, which are indistinguishable from new ones, and then the
+// least recently used bucket if that was not enough.
+func (l *Limiter) evict(now time.Time) {
+	if l.config.MaxClients <= 0 || len(l.buckets) < l.config.MaxClients {
+		return
+	}
+
+	burst := math.Max(float64(l.config.Burst), 1)
+	refill := time.Duration(burst / l.config.RequestsPerSecond * float64(time.Second))
+	oldestClient := ""
+	oldest := now
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= refill {
+			delete(l.buckets, client)
+			continue
+		}
+		if bucket.last.Before(oldest) {
+			oldestClient, oldest = client, bucket.last
+		}
+	}
+	if len(l.buckets) >= l.config.MaxClients {
+		delete(l.buckets, oldestClient)
+	}
+}
+
+// acquire waits for a concurrency slot. It returns an overloaded APIError if
+// the queue is full or the wait exceeds the queue timeout.
+func (l *Limiter) acquire(ctx context.Context) (func(), *APIError) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if l.queued.Add(1) > int64(l.config.MaxQueue) {
+		l.queued.Add(-1)
+		return nil, l.overloaded("queue full")
+	}
+	queuedRequests.With().Inc()
+	defer func() {
+		l.queued.Add(-1)
+		queuedRequests.With().Dec()
+	}()
+
+	var timeout <-chan time.Time
+	if l.config.QueueTimeout > 0 {
+		timer := time.NewTimer(l.config.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		return nil, l.overloaded("timed out waiting in queue")
+	case <-ctx.Done():
+		return nil, l.overloaded("request canceled waiting in queue")
+	}
+}
+
+func (l *Limiter) overloaded(msg string) *APIError {
+	apiErr := NewAPIError(ErrorCodeOverloaded, msg, nil)
+	apiErr.RetryAfter = time.Second
+	return apiErr
+}
+
+func (l *Limiter) clientKey(r *http.Request) string {
+	if id := ClientIdentityFromContext(r.Context()); id != "" {
+		return "id:" + id
+	}
+
+	if l.config.TrustForwardedFor {
+		// The reverse proxy appends the address it saw, so only the last
+		// entry is trustworthy; earlier entries are client-controlled.
+		forwarded := r.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			entries := strings.Split(forwarded[len(forwarded)-1], ",")
+			if addr := strings.TrimSpace(entries[len(entries)-1]); addr != "" {
+				return "addr:" + addr
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "addr:" + r.RemoteAddr
+	}
+	return "addr:" + host
+}
@@ -0,0 +1,345 @@
+package networking_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func serveFrom(handler http.Handler, remoteAddr string, forwardedFor string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func decodeAPIError(t *testing.T, recorder *httptest.ResponseRecorder) *networking.APIError {
+	t.Helper()
+	response := networking.ErrorResponse{}
+	err := json.NewDecoder(recorder.Body).Decode(&response)
+	require.NoError(t, err)
+	require.NotNil(t, response.Error)
+	return response.Error
+}
+
+func TestLimiter_Wrap(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+
+	t.Run("happy path - clients have separate buckets", func(t *testing.T) {
+		// given
+		config := networking.DefaultRateLimitConfig()
+		config.RequestsPerSecond = 1
+		config.Burst = 1
+		config.TrustForwardedFor = false
+		handler := networking.NewLimiter(config, logger).Wrap(okHandler())
+
+		// when
+		first := serveFrom(handler, "10.0.0.1:1234", "")
+		second := serveFrom(handler, "10.0.0.2:1234", "")
+
+		// then
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusOK, second.Code)
+	})
+
+	t.Run("happy path - keyed by client identity", func(t *testing.T) {
+		// given
+		config := networking.DefaultRateLimitConfig()
+		config.RequestsPerSecond = 1
+		config.Burst = 1
+		limiter := networking.NewLimiter(config, logger)
+
+		var identity atomic.Value
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := networking.WithClientIdentity(r.Context(), identity.Load().(string))
+			limiter.Wrap(okHandler()).ServeHTTP(w, r.WithContext(ctx))
+		})
+
+		// when
+		identity.Store("alice")
+		first := serveFrom(handler, "10.0.0.1:1234", "")
+		identity.Store("bob")
+		second := serveFrom(handler, "10.0.0.1:1234", "")
+		third := serveFrom(handler, "10.0.0.1:1234", "")
+
+		// then
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, http.StatusTooManyRequests, third.Code)
+	})
+
+	t.Run("error - rate limited", func(t *testing.T) {
+		// given
+		config := networking.DefaultRateLimitConfig()
+		config.RequestsPerSecond = 1
+		config.Burst = 1
+		handler := networking.NewLimiter(config, logger).Wrap(okHandler())
+
+		// when
+		first := serveFrom(handler, "10.0.0.1:1234", "")
+		second := serveFrom(handler, "10.0.0.1:5678", "")
+
+		// then
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusTooManyRequests, second.Code)
+		assert.Equal(t, "1", second.Header().Get(networking.RetryAfterHeader))
+		assert.Equal(t, networking.ErrorCodeRateLimited, decodeAPIError(t, second).Code)
+	})
+
+	t.Run("happy path - exempt path", func(t *testing.T) {
+		// given
+		config := networking.DefaultRateLimitConfig()
+		config.RequestsPerSecond = 1
+		config.Burst = 1
+		handler := networking.NewLimiter(config, logger).Wrap(okHandler())
+
+		// when
+		var codes []int
+		for range 3 {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, networking.HealthzPath, nil)
+			handler.ServeHTTP(recorder, req)
+			codes = append(codes, recorder.Code)
+		}
+
+		// then
+		assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK}, codes)
+	})
+
+	t.Run("error - forwarded address not trusted by default", func(t *testing.T) {
+		// given
+		config := networking.DefaultRateLimitConfig()
+		config.RequestsPerSecond = 1
+		config.Burst = 1
+		handler := networking.NewLimiter(config, logger).Wrap(okHandler())
+
+		// when
+		first := serveFrom(handler, "10.0.0.1:1234", "1.1.1.1")
+		spoofed := serveFrom(handler, "10.0.0.1:1234", "2.2.2.2")
+
+		// then
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusTooManyRequests, spoofed.Code)
+	})
+
+	t.Run("error - rate limited by forwarded address", func(t *testing.T) {
+		// given
+		config := networking.DefaultRateLimitConfig()
+		config.RequestsPerSecond = 1
+		config.Burst = 1
+		config.TrustForwardedFor = true
+		handler := networking.NewLimiter(config, logger).Wrap(okHandler())
+
+		// when
+		first := serveFrom(handler, "127.0.0.1:8080", "1.1.1.1, 9.9.9.9")
+		spoofed := serveFrom(handler, "127.0.0.1:8080", "2.2.2.2, 9.9.9.9")
+		other := serveFrom(handler, "127.0.0.1:8080", "8.8.8.8")
+
+		// then
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusTooManyRequests, spoofed.Code)
+		assert.Equal(t, http.StatusOK, other.Code)
+	})
+
+	t.Run("error - queue full", func(t *testing.T) {
+		// given
+		config := networking.DefaultRateLimitConfig()
+		config.RequestsPerSecond = 0
+		config.MaxConcurrent = 1
+		config.MaxQueue = 0
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		handler := networking.NewLimiter(config, logger).Wrap(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				close(started)
+				<-release
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- serveFrom(handler, "10.0.0.1:1234", "") }()
+		<-started
+
+		// when
+		shed := serveFrom(handler, "10.0.0.2:1234", "")
+		close(release)
+
+		// then
+		assert.Equal(t, http.StatusOK, (<-done).Code)
+		assert.Equal(t, http.StatusServiceUnavailable, shed.Code)
+		assert.Equal(t, "1", shed.Header().Get(networking.RetryAfterHeader))
+		apiErr := decodeAPIError(t, shed)
+		assert.Equal(t, networking.ErrorCodeOverloaded, apiErr.Code)
+		assert.Contains(t, apiErr.Message, "queue full")
+	})
+
+	t.Run("error - queue timeout", func(t *testing.T) {
+		// given
+		config := networking.DefaultRateLimitConfig()
+		config.RequestsPerSecond = 0
+		config.MaxConcurrent = 1
+		config.MaxQueue = 1
+		config.QueueTimeout = 10 * time.Millisecond
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		handler := networking.NewLimiter(config, logger).Wrap(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				close(started)
+				<-release
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- serveFrom(handler, "10.0.0.1:1234", "") }()
+		<-started
+
+		// when
+		shed := serveFrom(handler, "10.0.0.2:1234", "")
+		close(release)
+
+		// then
+		assert.Equal(t, http.StatusOK, (<-done).Code)
+		assert.Equal(t, http.StatusServiceUnavailable, shed.Code)
+		assert.Contains(t, decodeAPIError(t, shed).Message, "timed out waiting in queue")
+	})
+
+	t.Run("happy path - queued request is served", func(t *testing.T) {
+		// given
+		config := networking.DefaultRateLimitConfig()
+		config.RequestsPerSecond = 0
+		config.MaxConcurrent = 1
+		config.MaxQueue = 1
+
+		var calls atomic.Int32
+		started := make(chan struct{})
+		release := make(chan struct{})
+		handler := networking.NewLimiter(config, logger).Wrap(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				if calls.Add(1) == 1 {
+					close(started)
+					<-release
+				}
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- serveFrom(handler, "10.0.0.1:1234", "") }()
+		<-started
+
+		// when
+		queued := make(chan *httptest.ResponseRecorder)
+		go func() { queued <- serveFrom(handler, "10.0.0.2:1234", "") }()
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+
+		// then
+		assert.Equal(t, http.StatusOK, (<-done).Code)
+		assert.Equal(t, http.StatusOK, (<-queued).Code)
+	})
+}
+
+func TestClient_Do_RetryAfter(t *testing.T) {
+	t.Run("happy path - retries after rate limit", func(t *testing.T) {
+		// given
+		var calls atomic.Int32
+		var requestIDs []string
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestIDs = append(requestIDs, r.Header.Get(networking.RequestIDHeader))
+			if calls.Add(1) == 1 {
+				apiErr := networking.NewAPIError(networking.ErrorCodeRateLimited, "slow down", nil)
+				w.Header().Set(networking.RetryAfterHeader, "0")
+				networking.WriteError(w, apiErr)
+				return
+			}
+			writeResponse(t, w, doResponse{Data: []byte("ok")})
+		})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		resp := doResponse{}
+		err := client.Do(context.Background(), http.MethodPost, "/", doRequest{}, &resp)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, []byte("ok"), resp.Data)
+		assert.Equal(t, int32(2), calls.Load())
+		require.Len(t, requestIDs, 2)
+		assert.Equal(t, requestIDs[0], requestIDs[1])
+	})
+
+	t.Run("error - retries exhausted", func(t *testing.T) {
+		// given
+		var calls atomic.Int32
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			w.Header().Set(networking.RetryAfterHeader, "0")
+			networking.WriteError(w, networking.NewAPIError(networking.ErrorCodeOverloaded, "busy", nil))
+		})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientRetries(1, time.Second),
+		)
+
+		// when
+		err := client.Do(context.Background(), http.MethodPost, "/", doRequest{}, &doResponse{})
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAPIOverloaded)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("error - retry after too long", func(t *testing.T) {
+		// given
+		var calls atomic.Int32
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			apiErr := networking.NewAPIError(networking.ErrorCodeRateLimited, "slow down", nil)
+			apiErr.RetryAfter = time.Minute
+			networking.WriteError(w, apiErr)
+		})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		err := client.Do(context.Background(), http.MethodPost, "/", doRequest{}, &doResponse{})
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAPIRateLimited)
+		assert.Equal(t, int32(1), calls.Load())
+
+		apiErr := &networking.APIError{}
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, time.Minute, apiErr.RetryAfter)
+	})
+}
@@ -0,0 +1,154 @@
+package networking
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	requestIDSize      = 16
+	maxRequestIDLength = 128
+)
+
+type (
+	requestIDKey     struct{}
+	requestLoggerKey struct{}
+	requestStatsKey  struct{}
+)
+
+func NewRequestID() string {
+	id := make([]byte, requestIDSize)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// WithRequestID returns a copy of ctx carrying id. The Client sends it in the
+// RequestIDHeader instead of generating a new one.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// LoggerFromContext returns the request-scoped logger installed by AccessLog,
+// or fallback if there is none.
+func LoggerFromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(requestLoggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// AccessLog assigns every request an ID, taken from the RequestIDHeader if the
+// caller sent a well-formed one, and echoes it in the response. Handlers can
+// get a logger tagged with the ID from LoggerFromContext. Once next returns,
+// AccessLog writes a single access-log line for the request.
+func AccessLog(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = NewRequestID()
+		}
+		r.Header.Set(RequestIDHeader, requestID)
+		w.Header().Set(RequestIDHeader, requestID)
+
+		reqLogger := logger.With(slog.String("request_id", requestID))
+		stats := &requestStats{}
+		ctx := WithRequestID(r.Context(), requestID)
+		ctx = context.WithValue(ctx, requestLoggerKey{}, reqLogger)
+		ctx = context.WithValue(ctx, requestStatsKey{}, stats)
+
+		body := &countingReadCloser{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		rec := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		reqLogger.Info(
+			"access",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Duration("attest_latency", time.Duration(stats.attestLatency.Load())),
+			slog.Int64("bytes_in", body.n),
+			slog.Int64("bytes_out", rec.bytes),
+		)
+	})
+}
+
+type requestStats struct {
+	attestLatency atomic.Int64
+}
+
+// recordAttestLatency adds d to the attestation latency reported in the
+// access log for the request carried by ctx.
+func recordAttestLatency(ctx context.Context, d time.Duration) {
+	if stats, ok := ctx.Value(requestStatsKey{}).(*requestStats); ok {
+		stats.attestLatency.Add(int64(d))
+	}
+}
+
+// validRequestID accepts short IDs made of characters that are safe to log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type accessRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (a *accessRecorder) WriteHeader(status int) {
+	if !a.wroteHeader {
+		a.status = status
+		a.wroteHeader = true
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *accessRecorder) Write(p []byte) (int, error) {
+	a.wroteHeader = true
+	n, err := a.ResponseWriter.Write(p)
+	a.bytes += int64(n)
+	return n, err
+}
+
+func (a *accessRecorder) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,198 @@
+package networking_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestAccessLog(t *testing.T) {
+	t.Run("happy path - generates request id", func(t *testing.T) {
+		// given
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		gotID := ""
+		handler := networking.AccessLog(logger, http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				gotID = networking.RequestIDFromContext(r.Context())
+				networking.LoggerFromContext(r.Context(), nil).Info("handling")
+				_, _ = w.Write([]byte("hello"))
+			}),
+		)
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/path", strings.NewReader("abc"))
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		require.NotEmpty(t, gotID)
+		assert.Equal(t, gotID, recorder.Header().Get(networking.RequestIDHeader))
+
+		logs := logBuffer.String()
+		assert.Contains(t, logs, "msg=handling request_id="+gotID)
+		assert.Contains(t, logs, "msg=access request_id="+gotID)
+		assert.Contains(t, logs, "path=/path status=200")
+		assert.Contains(t, logs, "bytes_in=0 bytes_out=5")
+	})
+
+	t.Run("happy path - keeps caller request id", func(t *testing.T) {
+		// given
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		handler := networking.AccessLog(logger, http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}),
+		)
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(networking.RequestIDHeader, "abc-123")
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, "abc-123", recorder.Header().Get(networking.RequestIDHeader))
+		assert.Contains(t, logBuffer.String(), "request_id=abc-123")
+		assert.Contains(t, logBuffer.String(), "status=418")
+	})
+
+	t.Run("happy path - replaces malformed request id", func(t *testing.T) {
+		// given
+		logger := slog.New(slog.DiscardHandler)
+		handler := networking.AccessLog(logger, http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(networking.RequestIDHeader, "bad id\nlevel=ERROR")
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		got := recorder.Header().Get(networking.RequestIDHeader)
+		require.NotEmpty(t, got)
+		assert.NotContains(t, got, "bad id")
+	})
+
+	t.Run("happy path - request id in error and attest latency logged", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+		handler := networking.AccessLog(
+			logger,
+			networking.MakeAttestUserDataHandler(attester, logger),
+		)
+
+		recorder := httptest.NewRecorder()
+		req := makeRequest(t, "POST", networking.AttestUserDataPath, []byte("invalid json"))
+		req.Header.Set(networking.RequestIDHeader, "req-1")
+
+		okRecorder := httptest.NewRecorder()
+		okReq := makeRequest(
+			t,
+			"POST",
+			networking.AttestUserDataPath,
+			networking.AttestUserDataRequest{UserData: []byte("hello")},
+		)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+		handler.ServeHTTP(okRecorder, okReq)
+
+		// then
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		errResp := networking.ErrorResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&errResp)
+		require.NoError(t, err)
+		assert.Equal(t, "req-1", errResp.Error.RequestID)
+
+		assert.Equal(t, http.StatusOK, okRecorder.Code)
+		assert.Contains(t, logBuffer.String(), "attest_latency=")
+	})
+}
+
+func TestClient_Do_RequestID(t *testing.T) {
+	t.Run("happy path - request id from context", func(t *testing.T) {
+		// given
+		gotID := ""
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotID = r.Header.Get(networking.RequestIDHeader)
+			writeResponse(t, w, doResponse{})
+		})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(server.URL, server.Client())
+		ctx := networking.WithRequestID(context.Background(), "req-1")
+
+		// when
+		err := client.Do(ctx, http.MethodPost, "/", doRequest{}, &doResponse{})
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, "req-1", gotID)
+	})
+
+	t.Run("happy path - generates request id", func(t *testing.T) {
+		// given
+		gotID := ""
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotID = r.Header.Get(networking.RequestIDHeader)
+			writeResponse(t, w, doResponse{})
+		})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		err := client.Do(context.Background(), http.MethodPost, "/", doRequest{}, &doResponse{})
+
+		// then
+		require.NoError(t, err)
+		assert.NotEmpty(t, gotID)
+	})
+
+	t.Run("error - request id in error", func(t *testing.T) {
+		// given
+		logger := slog.New(slog.DiscardHandler)
+		handler := networking.AccessLog(logger, http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				networking.WriteError(w, networking.NewAPIError(networking.ErrorCodeInternal, "boom", nil))
+			}),
+		)
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		client := networking.NewClientWithClient(server.URL, server.Client())
+		ctx := networking.WithRequestID(context.Background(), "req-1")
+
+		// when
+		err := client.Do(ctx, http.MethodPost, "/", doRequest{}, &doResponse{})
+
+		// then
+		require.ErrorIs(t, err, networking.ErrAPIInternal)
+		assert.ErrorContains(t, err, "request id req-1")
+	})
+}
@@ -0,0 +1,30 @@
+package networking
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+// MakeReverseProxyHandler forwards requests to targetAddr over dialContext,
+// like tee.NewReverseProxyWithDialContext, but through AccessLog, so that the
+// proxy logs each request under the same request ID as the Enclave.
+func MakeReverseProxyHandler(
+	targetAddr string,
+	dialContext tee.DialContext,
+	logger *slog.Logger,
+) (http.Handler, error) {
+	targetURL, err := url.Parse(targetAddr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing target url: %w", err)
+	}
+
+	reverseProxy := httputil.NewSingleHostReverseProxy(targetURL)
+	reverseProxy.Transport = &http.Transport{DialContext: dialContext}
+	reverseProxy.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelError)
+	return AccessLog(logger, reverseProxy), nil
+}
@@ -0,0 +1,57 @@
+package networking_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeReverseProxyHandler(t *testing.T) {
+	t.Run("happy path - forwards and logs request id", func(t *testing.T) {
+		// given
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+
+		gotID := ""
+		enclave := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotID = r.Header.Get(networking.RequestIDHeader)
+			_, _ = w.Write([]byte("hello"))
+		}))
+		t.Cleanup(enclave.Close)
+
+		dialer := &net.Dialer{}
+		handler, err := networking.MakeReverseProxyHandler(enclave.URL, dialer.DialContext, logger)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, networking.HealthzPath, nil)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "hello", recorder.Body.String())
+		require.NotEmpty(t, gotID)
+		assert.Equal(t, gotID, recorder.Header().Get(networking.RequestIDHeader))
+		assert.Contains(t, logBuffer.String(), "msg=access request_id="+gotID)
+	})
+
+	t.Run("error - invalid target", func(t *testing.T) {
+		// given
+		dialer := &net.Dialer{}
+
+		// when
+		_, err := networking.MakeReverseProxyHandler("://", dialer.DialContext, slog.New(slog.DiscardHandler))
+
+		// then
+		require.Error(t, err)
+	})
+}
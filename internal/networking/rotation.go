@@ -0,0 +1,153 @@
+package networking
+
+import (
+	"context"
+	"crypto/x509"
+	"log/slog"
+	"time"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+const (
+	CertRotationKey                  = "cert_rotation"
+	DefaultCertRotationValidity      = 24 * time.Hour
+	DefaultCertRotationRotateBefore  = time.Hour
+	DefaultCertRotationCheckInterval = time.Minute
+	DefaultCertRotationMaxBackoff    = time.Hour
+
+	issuerLifetimeFraction = 3
+)
+
+// CertRotationConfig rotates the Enclave's certificate once it is within
+// RotateBefore of expiring. Validity is how long the self-signed certificates
+// are issued for; zero leaves it to the issuer, as for ACME. After a failed
+// rotation, the time until the next check doubles, up to MaxBackoff. Rotation
+// is disabled by default, in which case self-signed certificates keep
+// tee.DefaultValidity.
+type CertRotationConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Validity      time.Duration `mapstructure:"validity"`
+	RotateBefore  time.Duration `mapstructure:"rotate_before"`
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+	MaxBackoff    time.Duration `mapstructure:"max_backoff"`
+}
+
+func DefaultCertRotationConfig() CertRotationConfig {
+	return CertRotationConfig{
+		Enabled:       false,
+		Validity:      DefaultCertRotationValidity,
+		RotateBefore:  DefaultCertRotationRotateBefore,
+		CheckInterval: DefaultCertRotationCheckInterval,
+		MaxBackoff:    DefaultCertRotationMaxBackoff,
+	}
+}
+
+// CertRotator replaces the certificate of a tee.CertProvider before it
+// expires. Only the new certificate is served after a rotation, but the old
+// one stays valid until it expires, so open connections keep working while
+// clients fetch and verify the new chain from AttestCertPath, which always
+// attests the provider's current certificate.
+type CertRotator struct {
+	provider     tee.CertProvider
+	rotateBefore time.Duration
+	validity     time.Duration
+	interval     time.Duration
+	maxBackoff   time.Duration
+	logger       *slog.Logger
+}
+
+func NewCertRotator(
+	provider tee.CertProvider,
+	config CertRotationConfig,
+	logger *slog.Logger,
+) (*CertRotator, error) {
+	switch {
+	case config.RotateBefore <= 0:
+		return nil, certRotationError("rotate before must be positive", nil)
+	case config.CheckInterval <= 0:
+		return nil, certRotationError("check interval must be positive", nil)
+	case config.MaxBackoff < config.CheckInterval:
+		return nil, certRotationError("max backoff must not be shorter than check interval", nil)
+	case config.Validity > 0 && config.RotateBefore >= config.Validity:
+		return nil, certRotationError("rotate before must be shorter than validity", nil)
+	}
+	return &CertRotator{
+		provider:     provider,
+		rotateBefore: config.RotateBefore,
+		validity:     config.Validity,
+		interval:     config.CheckInterval,
+		maxBackoff:   config.MaxBackoff,
+		logger:       logger,
+	}, nil
+}
+
+// Check rotates the certificate if it is within rotate before of expiring, and
+// reports whether it did. When the issuer picks the validity, as an ACME CA
+// does, rotate before is capped at a third of the certificate's lifetime, so
+// that a certificate shorter than rotate before is not rotated on every check.
+func (r *CertRotator) Check(ctx context.Context) (bool, error) {
+	cert, err := r.provider.GetCert(ctx)
+	if err != nil {
+		return false, certRotationError("getting cert", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, certRotationError("parsing cert", err)
+	}
+	rotateBefore := r.rotateBefore
+	if r.validity == 0 {
+		lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+		rotateBefore = min(rotateBefore, lifetime/issuerLifetimeFraction)
+	}
+	if time.Until(leaf.NotAfter) > rotateBefore {
+		return false, nil
+	}
+
+	err = r.provider.RotateCert(ctx)
+	if err != nil {
+		return false, certRotationError("rotating cert", err)
+	}
+	rotated, err := r.provider.GetCert(ctx)
+	if err != nil {
+		return false, certRotationError("getting rotated cert", err)
+	}
+	r.logger.Info(
+		"rotated cert",
+		slog.String("old_fingerprint", CertFingerprint(cert.Certificate[0])),
+		slog.String("new_fingerprint", CertFingerprint(rotated.Certificate[0])),
+		slog.Time("old_not_after", leaf.NotAfter),
+	)
+	return true, nil
+}
+
+// Run checks the certificate every check interval until ctx is done. Failed
+// rotations are logged and retried with exponential backoff, so that a CA
+// that keeps failing is not asked again every interval.
+func (r *CertRotator) Run(ctx context.Context) {
+	delay := r.interval
+	for {
+		_, err := r.Check(ctx)
+		if err != nil {
+			r.logger.Error(
+				"checking cert rotation",
+				slog.String("error", err.Error()),
+				slog.Duration("retry_in", delay),
+			)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err != nil {
+			delay = min(2*delay, r.maxBackoff)
+		} else {
+			delay = r.interval
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package networking_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+type rotatingServer struct {
+	tlsServer  *httptest.Server
+	certServer *httptest.Server
+	provider   *tee.SelfSignedCertProvider
+}
+
+// newRotatingServer starts a TLS server and a plain server, like the
+// Enclave's, that share a certificate provider. The TLS server serves the
+// Expr endpoint and the plain server attests the certificate.
+func newRotatingServer(t *testing.T, validity time.Duration) *rotatingServer {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	provider, err := tee.NewSelfSignedCertProvider(tee.DefaultDomain, tee.DefaultIP, validity)
+	require.NoError(t, err)
+
+	tlsServer := newEnclaveServer(
+		t,
+		nil,
+		func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return provider.GetCert(hello.Context())
+		},
+	)
+	certServer := httptest.NewServer(
+		networking.MakeAttestCertHandler(attester, provider, nil, slog.New(slog.DiscardHandler)),
+	)
+	t.Cleanup(certServer.Close)
+	return &rotatingServer{tlsServer: tlsServer, certServer: certServer, provider: provider}
+}
+
+func TestNewCertRotator(t *testing.T) {
+	t.Run("error - invalid config", func(t *testing.T) {
+		tests := map[string]func(*networking.CertRotationConfig){
+			"no rotate before":       func(c *networking.CertRotationConfig) { c.RotateBefore = 0 },
+			"no check interval":      func(c *networking.CertRotationConfig) { c.CheckInterval = 0 },
+			"rotate before validity": func(c *networking.CertRotationConfig) { c.RotateBefore = c.Validity },
+			"short max backoff":      func(c *networking.CertRotationConfig) { c.MaxBackoff = time.Second },
+		}
+		for name, modify := range tests {
+			t.Run(name, func(t *testing.T) {
+				// given
+				server := newRotatingServer(t, time.Hour)
+				config := networking.DefaultCertRotationConfig()
+				modify(&config)
+
+				// when
+				_, err := networking.NewCertRotator(
+					server.provider,
+					config,
+					slog.New(slog.DiscardHandler),
+				)
+
+				// then
+				require.ErrorIs(t, err, networking.ErrCertRotation)
+			})
+		}
+	})
+}
+
+func TestCertRotator_Check(t *testing.T) {
+	t.Run("happy path - rotates when due", func(t *testing.T) {
+		// given
+		server := newRotatingServer(t, 30*time.Minute)
+		rotator, err := networking.NewCertRotator(
+			server.provider,
+			networking.DefaultCertRotationConfig(),
+			slog.New(slog.DiscardHandler),
+		)
+		require.NoError(t, err)
+		before, err := server.provider.GetCert(context.Background())
+		require.NoError(t, err)
+
+		// when
+		rotated, err := rotator.Check(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.True(t, rotated)
+		after, err := server.provider.GetCert(context.Background())
+		require.NoError(t, err)
+		assert.NotEqual(t, before.Certificate[0], after.Certificate[0])
+	})
+
+	t.Run("happy path - keeps cert until due", func(t *testing.T) {
+		// given
+		server := newRotatingServer(t, 2*time.Hour)
+		rotator, err := networking.NewCertRotator(
+			server.provider,
+			networking.DefaultCertRotationConfig(),
+			slog.New(slog.DiscardHandler),
+		)
+		require.NoError(t, err)
+		before, err := server.provider.GetCert(context.Background())
+		require.NoError(t, err)
+
+		// when
+		rotated, err := rotator.Check(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.False(t, rotated)
+		after, err := server.provider.GetCert(context.Background())
+		require.NoError(t, err)
+		assert.Same(t, before, after)
+	})
+}
+
+// failingRotationProvider serves its certificate but fails every rotation,
+// counting the attempts.
+type failingRotationProvider struct {
+	tee.CertProvider
+	rotations atomic.Int32
+}
+
+func (p *failingRotationProvider) RotateCert(context.Context) error {
+	p.rotations.Add(1)
+	return errors.New("ca unavailable")
+}
+
+func TestCertRotator_Run(t *testing.T) {
+	t.Run("happy path - backs off after failed rotations", func(t *testing.T) {
+		// given
+		server := newRotatingServer(t, 30*time.Minute)
+		provider := &failingRotationProvider{CertProvider: server.provider}
+		config := networking.DefaultCertRotationConfig()
+		config.CheckInterval = 10 * time.Millisecond
+		rotator, err := networking.NewCertRotator(provider, config, slog.New(slog.DiscardHandler))
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		// when
+		rotator.Run(ctx)
+
+		// then
+		// Checks every interval would try 20 times; doubling the wait from 10ms
+		// tries at 0, 10, 30, 70 and 150ms.
+		assert.GreaterOrEqual(t, provider.rotations.Load(), int32(2))
+		assert.LessOrEqual(t, provider.rotations.Load(), int32(6))
+	})
+}
+
+func TestClient_RefreshCertChain(t *testing.T) {
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	expression := `balance > 1000 ? "approved" : "denied"`
+	env := map[string]any{"balance": 123456789}
+
+	// Keep-alives would let requests reuse connections made before rotation.
+	newTransport := func() *http.Client {
+		return &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	}
+
+	t.Run("happy path - refreshes after rotation", func(t *testing.T) {
+		// given
+		server := newRotatingServer(t, time.Hour)
+		client := networking.NewClientWithClient(
+			server.tlsServer.URL,
+			newTransport(),
+			networking.WithClientCertRefresh(
+				networking.NewClient(server.certServer.URL),
+				tee.DefaultDomain,
+				verifier,
+			),
+		)
+		_, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+		require.NoError(t, err)
+		err = server.provider.RotateCert(context.Background())
+		require.NoError(t, err)
+
+		// when
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("happy path - refreshes from cache", func(t *testing.T) {
+		// given
+		server := newRotatingServer(t, time.Hour)
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		mux := http.NewServeMux()
+		mux.Handle(networking.AttestCertPath, networking.MakeAttestCertHandler(
+			attester,
+			server.provider,
+			networking.NewCertAttestationCache(time.Hour),
+			slog.New(slog.DiscardHandler),
+		))
+		issuer := newChallengeIssuer(t, time.Minute, 10)
+		cachedServer := httptest.NewServer(issuer.Wrap(mux))
+		t.Cleanup(cachedServer.Close)
+		client := networking.NewClientWithClient(
+			server.tlsServer.URL,
+			newTransport(),
+			networking.WithClientCachedCertChain(),
+			networking.WithClientCertRefresh(
+				networking.NewClient(cachedServer.URL),
+				tee.DefaultDomain,
+				verifier,
+			),
+		)
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+		require.NoError(t, err)
+		err = server.provider.RotateCert(context.Background())
+		require.NoError(t, err)
+
+		// when
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - rotated without refresh", func(t *testing.T) {
+		// given
+		server := newRotatingServer(t, time.Hour)
+		client := networking.NewClientWithClient(server.tlsServer.URL, newTransport())
+		err := client.AddCertChain(certChainJSON(t, server.provider), tee.DefaultDomain)
+		require.NoError(t, err)
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+		require.NoError(t, err)
+		err = server.provider.RotateCert(context.Background())
+		require.NoError(t, err)
+
+		// when
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClientUnknownCert)
+	})
+
+	t.Run("error - attested chain is not the served one", func(t *testing.T) {
+		// given
+		server := newRotatingServer(t, time.Hour)
+		other := newRotatingServer(t, time.Hour)
+		client := networking.NewClientWithClient(
+			server.tlsServer.URL,
+			newTransport(),
+			networking.WithClientCertRefresh(
+				networking.NewClient(other.certServer.URL),
+				tee.DefaultDomain,
+				verifier,
+			),
+		)
+
+		// when
+		_, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClientUnknownCert)
+	})
+}
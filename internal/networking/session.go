@@ -0,0 +1,193 @@
+package networking
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/tahardi/bearclave/tee"
+)
+
+const (
+	AttestKeyPath          = "/attest-key"
+	SessionKeyKey          = "session_key"
+	SessionKeyAlgorithm    = "ed25519"
+	sessionSignaturePrefix = "bearclave-session-v1:"
+)
+
+type SessionKeyConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+func DefaultSessionKeyConfig() SessionKeyConfig {
+	return SessionKeyConfig{Enabled: false}
+}
+
+// SessionSignature is a session key's signature over a response's nonce and
+// payload.
+type SessionSignature struct {
+	KeyID     string `json:"key_id"`
+	Signature []byte `json:"signature"`
+}
+
+// SessionKey is an Ed25519 key pair generated when the Enclave starts. Its
+// public key is attested once through AttestKeyPath, after which responses
+// signed with it are as trustworthy as attested ones but far cheaper to
+// produce and verify. The private key never leaves the Enclave's memory, so a
+// restart yields a new key with a new ID.
+//
+// SessionKey is a PayloadAttester that signs rather than attests. Signed
+// responses always carry the full payload, so commitment modes are ignored.
+type SessionKey struct {
+	id      string
+	public  ed25519.PublicKey
+	private ed25519.PrivateKey
+}
+
+func NewSessionKey() (*SessionKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, sessionKeyError("generating key", err)
+	}
+	return &SessionKey{id: SessionKeyID(public), public: public, private: private}, nil
+}
+
+func (k *SessionKey) ID() string {
+	return k.id
+}
+
+func (k *SessionKey) PublicKey() ed25519.PublicKey {
+	return k.public
+}
+
+func (k *SessionKey) Sign(nonce []byte, payload []byte) SessionSignature {
+	signature := ed25519.Sign(k.private, sessionMessage(nonce, payload))
+	return SessionSignature{KeyID: k.id, Signature: signature}
+}
+
+func (k *SessionKey) AttestPayload(
+	_ context.Context,
+	nonce []byte,
+	payload []byte,
+	_ DigestAlgorithm,
+) (AttestResponse, error) {
+	signature := k.Sign(nonce, payload)
+	return AttestResponse{Payload: payload, Signature: &signature}, nil
+}
+
+// AttestedKey is the attested payload of an AttestKeyPath response. Like
+// every key attestation, it carries no Stamp.
+type AttestedKey struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	PublicKey []byte `json:"public_key"`
+}
+
+// Validate checks that the key is a well-formed Ed25519 key whose ID matches.
+func (a AttestedKey) Validate() error {
+	switch {
+	case a.Algorithm != SessionKeyAlgorithm:
+		return sessionKeyError("unsupported algorithm "+a.Algorithm, nil)
+	case len(a.PublicKey) != ed25519.PublicKeySize:
+		return sessionKeyError("invalid public key size", nil)
+	case a.KeyID != SessionKeyID(a.PublicKey):
+		return sessionKeyError("key id does not match public key", nil)
+	}
+	return nil
+}
+
+// Verify checks that signature is the key's signature over nonce and payload.
+func (a AttestedKey) Verify(nonce []byte, payload []byte, signature *SessionSignature) error {
+	if signature == nil {
+		return sessionKeyError("missing signature", nil)
+	}
+	if signature.KeyID != a.KeyID {
+		msg := "signed by " + signature.KeyID + ", expected " + a.KeyID
+		return sessionKeyErrorSignature(msg, nil)
+	}
+	return VerifySessionSignature(a.PublicKey, nonce, payload, signature.Signature)
+}
+
+// VerifySessionSignature checks an Ed25519 session signature over nonce and
+// payload. It is all a downstream consumer needs once it trusts publicKey.
+func VerifySessionSignature(
+	publicKey ed25519.PublicKey,
+	nonce []byte,
+	payload []byte,
+	signature []byte,
+) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return sessionKeyError("invalid public key size", nil)
+	}
+	if !ed25519.Verify(publicKey, sessionMessage(nonce, payload), signature) {
+		return sessionKeyErrorSignature("", nil)
+	}
+	return nil
+}
+
+// SessionKeyID is the "sha256:<hex>" digest of publicKey.
+func SessionKeyID(publicKey ed25519.PublicKey) string {
+	return DigestSHA256(publicKey)
+}
+
+// sessionMessage is the signed message. The prefix keeps session signatures
+// from being valid for any other purpose.
+func sessionMessage(nonce []byte, payload []byte) []byte {
+	return append([]byte(sessionSignaturePrefix), BatchLeaf(nonce, payload)...)
+}
+
+type AttestKeyRequest struct {
+	Nonce      []byte          `json:"nonce,omitempty"`
+	Commitment DigestAlgorithm `json:"commitment,omitempty"`
+}
+type AttestKeyResponse = AttestResponse
+
+func (r AttestKeyRequest) AttestNonce() []byte               { return r.Nonce }
+func (r AttestKeyRequest) AttestCommitment() DigestAlgorithm { return r.Commitment }
+
+// MakeAttestKeyHandler attests sessionKey's public key.
+func MakeAttestKeyHandler(
+	attester *tee.Attester,
+	sessionKey *SessionKey,
+	logger *slog.Logger,
+) http.HandlerFunc {
+	endpoint := &AttestedEndpoint[AttestKeyRequest, AttestedKey]{
+		Name:     "key",
+		Attester: NewDirectAttester(attester),
+		Logger:   logger,
+		Compute: func(context.Context, AttestKeyRequest) (AttestedKey, error) {
+			return AttestedKey{
+				KeyID:     sessionKey.ID(),
+				Algorithm: SessionKeyAlgorithm,
+				PublicKey: sessionKey.PublicKey(),
+			}, nil
+		},
+		LogAttrs: func(_ AttestKeyRequest, key AttestedKey) []any {
+			return []any{slog.String("key_id", key.KeyID)}
+		},
+	}
+	return endpoint.ServeHTTP
+}
+
+// AttestedSessionKey returns the session key attested in verified, checking
+// payload against its commitment if one was used.
+func AttestedSessionKey(verified *tee.VerifyResult, payload []byte) (AttestedKey, error) {
+	payload, err := AttestedPayload(verified, payload)
+	if err != nil {
+		return AttestedKey{}, err
+	}
+
+	key := AttestedKey{}
+	err = json.Unmarshal(payload, &key)
+	if err != nil {
+		return AttestedKey{}, sessionKeyError("unmarshaling attested key", err)
+	}
+	err = key.Validate()
+	if err != nil {
+		return AttestedKey{}, err
+	}
+	return key, nil
+}
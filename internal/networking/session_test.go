@@ -0,0 +1,344 @@
+package networking_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tahardi/bearclave-examples/internal/engine"
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestSessionKey_Sign(t *testing.T) {
+	sessionKey, err := networking.NewSessionKey()
+	require.NoError(t, err)
+	attestedKey := networking.AttestedKey{
+		KeyID:     sessionKey.ID(),
+		Algorithm: networking.SessionKeyAlgorithm,
+		PublicKey: sessionKey.PublicKey(),
+	}
+	require.NoError(t, attestedKey.Validate())
+
+	nonce := []byte("nonce")
+	payload := []byte("payload")
+
+	t.Run("happy path", func(t *testing.T) {
+		// when
+		signature := sessionKey.Sign(nonce, payload)
+
+		// then
+		assert.Equal(t, sessionKey.ID(), signature.KeyID)
+		require.NoError(t, attestedKey.Verify(nonce, payload, &signature))
+	})
+
+	t.Run("error - tampered", func(t *testing.T) {
+		// given
+		signature := sessionKey.Sign(nonce, payload)
+
+		// when
+		nonceErr := attestedKey.Verify([]byte("other nonce"), payload, &signature)
+		payloadErr := attestedKey.Verify(nonce, []byte("other payload"), &signature)
+
+		// then
+		require.ErrorIs(t, nonceErr, networking.ErrSessionKeySignature)
+		require.ErrorIs(t, payloadErr, networking.ErrSessionKeySignature)
+	})
+
+	t.Run("error - signed by another key", func(t *testing.T) {
+		// given
+		otherKey, err := networking.NewSessionKey()
+		require.NoError(t, err)
+		signature := otherKey.Sign(nonce, payload)
+
+		// when
+		err = attestedKey.Verify(nonce, payload, &signature)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrSessionKeySignature)
+	})
+}
+
+func TestAttestedKey_Validate(t *testing.T) {
+	sessionKey, err := networking.NewSessionKey()
+	require.NoError(t, err)
+
+	tests := map[string]networking.AttestedKey{
+		"unsupported algorithm": {
+			KeyID:     sessionKey.ID(),
+			Algorithm: "rsa",
+			PublicKey: sessionKey.PublicKey(),
+		},
+		"invalid public key size": {
+			KeyID:     sessionKey.ID(),
+			Algorithm: networking.SessionKeyAlgorithm,
+			PublicKey: []byte("short"),
+		},
+		"key id mismatch": {
+			KeyID:     "sha256:00",
+			Algorithm: networking.SessionKeyAlgorithm,
+			PublicKey: sessionKey.PublicKey(),
+		},
+	}
+	for name, key := range tests {
+		t.Run("error - "+name, func(t *testing.T) {
+			// when
+			err := key.Validate()
+
+			// then
+			require.ErrorIs(t, err, networking.ErrSessionKey)
+		})
+	}
+}
+
+func TestMakeAttestKeyHandler(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		attester, err := tee.NewAttester(tee.NoTEE)
+		require.NoError(t, err)
+		verifier, err := tee.NewVerifier(tee.NoTEE)
+		require.NoError(t, err)
+		sessionKey, err := networking.NewSessionKey()
+		require.NoError(t, err)
+
+		nonce := []byte("nonce")
+		recorder := httptest.NewRecorder()
+		body := networking.AttestKeyRequest{Nonce: nonce}
+		req := makeRequest(t, "POST", networking.AttestKeyPath, body)
+		handler := networking.MakeAttestKeyHandler(attester, sessionKey, slog.New(slog.DiscardHandler))
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		response := networking.AttestKeyResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+
+		verified, err := verifier.Verify(response.Attestation, tee.WithVerifyNonce(nonce))
+		require.NoError(t, err)
+
+		got, err := networking.AttestedSessionKey(verified, response.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, sessionKey.ID(), got.KeyID)
+		assert.Equal(t, []byte(sessionKey.PublicKey()), got.PublicKey)
+	})
+}
+
+func TestMakeSignedAttestHTTPSCallHandler(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		backend := httptest.NewTLSServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("ok"))
+			}),
+		)
+		defer backend.Close()
+		sessionKey, err := networking.NewSessionKey()
+		require.NoError(t, err)
+
+		nonce := []byte("nonce")
+		recorder := httptest.NewRecorder()
+		body := networking.AttestHTTPSCallRequest{Nonce: nonce, Method: "GET", URL: backend.URL}
+		req := makeRequest(t, "POST", networking.AttestHTTPSCallPath, body)
+		handler := networking.MakeSignedAttestHTTPSCallHandler(
+			networking.DefaultTimeout,
+			sessionKey,
+			networking.NewSequencer(),
+			backend.Client(),
+			slog.New(slog.DiscardHandler),
+		)
+
+		// when
+		handler.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		response := networking.AttestHTTPSCallResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&response)
+		require.NoError(t, err)
+		require.NotNil(t, response.Signature)
+		assert.Nil(t, response.Attestation)
+		err = networking.VerifySessionSignature(
+			sessionKey.PublicKey(),
+			nonce,
+			response.Payload,
+			response.Signature.Signature,
+		)
+		require.NoError(t, err)
+
+		got := networking.AttestedHTTPCall{}
+		err = json.Unmarshal(response.Payload, &got)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("ok"), got.Body)
+	})
+}
+
+// sessionServer serves AttestKeyPath and a signed Expr endpoint for the
+// session key currently stored in sessionKey, counting key attestations.
+type sessionServer struct {
+	*httptest.Server
+	sessionKey  atomic.Pointer[networking.SessionKey]
+	keyRequests atomic.Int32
+}
+
+func newSessionServer(t *testing.T) *sessionServer {
+	t.Helper()
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	exprEngine, err := engine.NewExprEngine()
+	require.NoError(t, err)
+	sessionKey, err := networking.NewSessionKey()
+	require.NoError(t, err)
+	logger := slog.New(slog.DiscardHandler)
+
+	server := &sessionServer{}
+	server.sessionKey.Store(sessionKey)
+	routes := map[string]http.Handler{
+		"POST " + networking.AttestKeyPath: http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				server.keyRequests.Add(1)
+				networking.MakeAttestKeyHandler(attester, server.sessionKey.Load(), logger).
+					ServeHTTP(w, r)
+			},
+		),
+		"POST " + networking.AttestExprPath: http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				networking.MakeSignedAttestExprHandler(
+					exprEngine,
+					defaultTimeout,
+					server.sessionKey.Load(),
+					networking.NewSequencer(),
+					logger,
+				).ServeHTTP(w, r)
+			},
+		),
+	}
+	server.Server = newEnclaveServer(t, routes, nil)
+	return server
+}
+
+func TestClient_SessionKey(t *testing.T) {
+	verifier, err := tee.NewVerifier(tee.NoTEE)
+	require.NoError(t, err)
+	expression := `greeting + ", Expr"`
+	env := map[string]any{"greeting": "Hello"}
+
+	t.Run("happy path - key is attested once", func(t *testing.T) {
+		// given
+		server := newSessionServer(t)
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientSessionKey(verifier),
+		)
+
+		// when
+		var responses []networking.AttestExprResponse
+		for range 3 {
+			nonce, err := networking.NewNonce()
+			require.NoError(t, err)
+			resp, err := client.AttestExpr(context.Background(), nonce, expression, env)
+			require.NoError(t, err)
+			responses = append(responses, resp)
+		}
+
+		// then
+		assert.Equal(t, int32(1), server.keyRequests.Load())
+		for _, resp := range responses {
+			assert.Nil(t, resp.Attestation)
+			require.NotNil(t, resp.Signature)
+			assert.Equal(t, server.sessionKey.Load().ID(), resp.Signature.KeyID)
+
+			got := networking.AttestedExpr{}
+			err := json.Unmarshal(resp.Payload, &got)
+			require.NoError(t, err)
+			assert.Equal(t, "Hello, Expr", got.Output)
+		}
+	})
+
+	t.Run("happy path - refetches key after restart", func(t *testing.T) {
+		// given
+		server := newSessionServer(t)
+		client := networking.NewClientWithClient(
+			server.URL,
+			server.Client(),
+			networking.WithClientSessionKey(verifier),
+			networking.WithClientCommitment(networking.DigestAlgorithmSHA256),
+		)
+		_, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+		require.NoError(t, err)
+
+		newKey, err := networking.NewSessionKey()
+		require.NoError(t, err)
+		server.sessionKey.Store(newKey)
+
+		// when
+		resp, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, newKey.ID(), resp.Signature.KeyID)
+		assert.Equal(t, int32(2), server.keyRequests.Load())
+
+		cached, err := client.SessionKey(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, newKey.ID(), cached.KeyID)
+	})
+
+	t.Run("error - no session key verifier", func(t *testing.T) {
+		// given
+		server := newSessionServer(t)
+		client := networking.NewClientWithClient(server.URL, server.Client())
+
+		// when
+		_, err := client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrClient)
+		assert.Equal(t, int32(0), server.keyRequests.Load())
+	})
+
+	t.Run("error - invalid signature", func(t *testing.T) {
+		// given
+		server := newSessionServer(t)
+		forger, err := networking.NewSessionKey()
+		require.NoError(t, err)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == networking.AttestKeyPath {
+				server.Config.Handler.ServeHTTP(w, r)
+				return
+			}
+			payload := []byte(`{"output":"forged"}`)
+			signature := forger.Sign([]byte("nonce"), payload)
+			signature.KeyID = server.sessionKey.Load().ID()
+			networking.WriteResponse(w, networking.AttestResponse{
+				Payload:   payload,
+				Signature: &signature,
+			})
+		})
+		forgery := httptest.NewServer(handler)
+		defer forgery.Close()
+		client := networking.NewClientWithClient(
+			forgery.URL,
+			forgery.Client(),
+			networking.WithClientSessionKey(verifier),
+		)
+
+		// when
+		_, err = client.AttestExpr(context.Background(), []byte("nonce"), expression, env)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrSessionKeySignature)
+	})
+}
@@ -0,0 +1,147 @@
+package networking
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+)
+
+// SocketRequest carries an HTTP request over a raw socket, where there are no
+// headers. An empty Method and Path mean a POST to AttestUserDataPath. Auth
+// carries the authentication headers, so that the Enclave rather than the
+// proxy authenticates the request. Socket messages are best sent with
+// CBORCodec, which carries Body as raw bytes.
+type SocketRequest struct {
+	RequestID   string            `json:"request_id,omitempty"`
+	Method      string            `json:"method,omitempty"`
+	Path        string            `json:"path,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Accept      string            `json:"accept,omitempty"`
+	Auth        map[string]string `json:"auth,omitempty"`
+	Body        []byte            `json:"body"`
+}
+
+// authHeaders are the headers an Authenticator reads.
+var authHeaders = []string{
+	APIKeyHeader,
+	AuthKeyIDHeader,
+	AuthTimestampHeader,
+	AuthSignatureHeader,
+}
+
+// SocketResponse carries the response to a SocketRequest. Body is opaque,
+// since it may be sealed or not be JSON at all.
+type SocketResponse struct {
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body"`
+}
+
+// NewSocketRequest forwards r, whose body has already been read into body.
+func NewSocketRequest(r *http.Request, body []byte) SocketRequest {
+	var auth map[string]string
+	for _, header := range authHeaders {
+		if value := r.Header.Get(header); value != "" {
+			if auth == nil {
+				auth = map[string]string{}
+			}
+			auth[header] = value
+		}
+	}
+	return SocketRequest{
+		RequestID:   RequestIDFromContext(r.Context()),
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		ContentType: r.Header.Get("Content-Type"),
+		Accept:      r.Header.Get("Accept"),
+		Auth:        auth,
+		Body:        body,
+	}
+}
+
+// ServeSocketRequest serves req with handler as if it had arrived over HTTP,
+// so that an Enclave behind a socket can use the same handlers and
+// middleware as one that serves HTTP itself.
+func ServeSocketRequest(ctx context.Context, handler http.Handler, req SocketRequest) SocketResponse {
+	method := req.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	path := req.Path
+	if path == "" {
+		path = AttestUserDataPath
+	}
+
+	buffer := newResponseBuffer(http.Header{})
+	httpReq, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(req.Body))
+	if err != nil {
+		WriteError(buffer, badRequestError("making request", err))
+		return buffer.socketResponse()
+	}
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	if req.Accept != "" {
+		httpReq.Header.Set("Accept", req.Accept)
+	}
+	if req.RequestID != "" {
+		httpReq.Header.Set(RequestIDHeader, req.RequestID)
+	}
+	for _, header := range authHeaders {
+		if value := req.Auth[header]; value != "" {
+			httpReq.Header.Set(header, value)
+		}
+	}
+
+	handler.ServeHTTP(buffer, httpReq)
+	return buffer.socketResponse()
+}
+
+// WriteSocketResponse writes a response received over a socket.
+func WriteSocketResponse(w http.ResponseWriter, resp SocketResponse) {
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(resp.Body)
+}
+
+// responseBuffer holds a response in memory so that it can be sealed or sent
+// over a socket once the handler is done with it.
+type responseBuffer struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newResponseBuffer(header http.Header) *responseBuffer {
+	return &responseBuffer{header: header, status: http.StatusOK}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.wroteHeader {
+		return
+	}
+	b.status = status
+	b.wroteHeader = true
+}
+
+func (b *responseBuffer) Write(data []byte) (int, error) {
+	b.wroteHeader = true
+	return b.body.Write(data)
+}
+
+func (b *responseBuffer) socketResponse() SocketResponse {
+	return SocketResponse{
+		StatusCode:  b.status,
+		ContentType: b.header.Get("Content-Type"),
+		Body:        b.body.Bytes(),
+	}
+}
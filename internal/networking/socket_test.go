@@ -0,0 +1,151 @@
+package networking_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tahardi/bearclave/tee"
+)
+
+func TestServeSocketRequest(t *testing.T) {
+	attester, err := tee.NewAttester(tee.NoTEE)
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.Handle(
+		"POST "+networking.AttestUserDataPath,
+		networking.MakeAttestUserDataHandler(attester, slog.New(slog.DiscardHandler)),
+	)
+
+	t.Run("happy path - forwards request and response", func(t *testing.T) {
+		// given
+		body, err := json.Marshal(networking.AttestUserDataRequest{UserData: []byte("hello")})
+		require.NoError(t, err)
+		req := makeRequest(t, "POST", networking.AttestUserDataPath, nil)
+		socketReq := networking.NewSocketRequest(req, body)
+
+		// when
+		socketResp := networking.ServeSocketRequest(context.Background(), mux, socketReq)
+		recorder := httptest.NewRecorder()
+		networking.WriteSocketResponse(recorder, socketResp)
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+		resp := networking.AttestUserDataResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(&resp)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("hello"), resp.Attestation.UserData)
+	})
+
+	t.Run("happy path - cbor message and body", func(t *testing.T) {
+		// given
+		body, err := networking.CBORCodec.Marshal(networking.AttestUserDataRequest{
+			UserData: []byte("hello"),
+		})
+		require.NoError(t, err)
+		req := makeRequest(t, "POST", networking.AttestUserDataPath, nil)
+		req.Header.Set("Content-Type", networking.ContentTypeCBOR)
+		req.Header.Set("Accept", networking.ContentTypeCBOR)
+		message, err := networking.CBORCodec.Marshal(networking.NewSocketRequest(req, body))
+		require.NoError(t, err)
+
+		socketReq := networking.SocketRequest{}
+		err = networking.CBORCodec.Unmarshal(message, &socketReq)
+		require.NoError(t, err)
+
+		// when
+		socketResp := networking.ServeSocketRequest(context.Background(), mux, socketReq)
+
+		// then
+		assert.Equal(t, http.StatusOK, socketResp.StatusCode)
+		assert.Equal(t, networking.ContentTypeCBOR, socketResp.ContentType)
+
+		resp := networking.AttestUserDataResponse{}
+		err = networking.CBORCodec.Unmarshal(socketResp.Body, &resp)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("hello"), resp.Attestation.UserData)
+	})
+
+	t.Run("happy path - defaults to attest user data", func(t *testing.T) {
+		// given
+		socketReq := networking.SocketRequest{Body: []byte(`{"userdata":"aGk="}`)}
+
+		// when
+		socketResp := networking.ServeSocketRequest(context.Background(), mux, socketReq)
+
+		// then
+		assert.Equal(t, http.StatusOK, socketResp.StatusCode)
+	})
+
+	t.Run("happy path - non-json response", func(t *testing.T) {
+		// given
+		socketReq := networking.SocketRequest{Path: "/unknown", Body: []byte(`{}`)}
+
+		// when
+		socketResp := networking.ServeSocketRequest(context.Background(), mux, socketReq)
+		_, err := json.Marshal(socketResp)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, socketResp.StatusCode)
+	})
+
+	t.Run("happy path - forwards signature to enclave authenticator", func(t *testing.T) {
+		// given
+		authenticator, err := networking.NewAuthenticator(
+			makeAuthConfig(),
+			slog.New(slog.DiscardHandler),
+		)
+		require.NoError(t, err)
+		body := `{"cel":"1 + 1"}`
+		req := makeAuthRequest(networking.AttestCELPath, body)
+		networking.SignRequest(req, "alice", []byte("alice secret"), []byte(body), time.Now())
+		message, err := networking.CBORCodec.Marshal(networking.NewSocketRequest(req, []byte(body)))
+		require.NoError(t, err)
+
+		socketReq := networking.SocketRequest{}
+		err = networking.CBORCodec.Unmarshal(message, &socketReq)
+		require.NoError(t, err)
+
+		// when
+		socketResp := networking.ServeSocketRequest(
+			context.Background(),
+			authenticator.Wrap(identityHandler()),
+			socketReq,
+		)
+
+		// then
+		assert.Equal(t, http.StatusOK, socketResp.StatusCode)
+		assert.Equal(t, "alice:"+body, string(socketResp.Body))
+	})
+
+	t.Run("error - unsigned request to enclave authenticator", func(t *testing.T) {
+		// given
+		authenticator, err := networking.NewAuthenticator(
+			makeAuthConfig(),
+			slog.New(slog.DiscardHandler),
+		)
+		require.NoError(t, err)
+		socketReq := networking.SocketRequest{Path: networking.AttestCELPath, Body: []byte(`{}`)}
+
+		// when
+		socketResp := networking.ServeSocketRequest(
+			context.Background(),
+			authenticator.Wrap(identityHandler()),
+			socketReq,
+		)
+
+		// then
+		assert.Equal(t, http.StatusUnauthorized, socketResp.StatusCode)
+	})
+}
@@ -0,0 +1,113 @@
+package networking
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxStampAge is how old a result may be before CheckAge rejects it.
+const DefaultMaxStampAge = time.Minute
+
+// Stamp records when and in what order an Enclave produced a result. It is
+// embedded in AttestedHTTPCall, AttestedCEL and AttestedExpr, so its fields
+// appear alongside the result's own. InstanceID is random per boot and
+// Sequence counts up from 1 within it, so together they order every result an
+// Enclave produces until it restarts.
+//
+// Attestations that are not results are not stamped: user data is attested as
+// the client sent it, keys and channel bindings are attested once and then
+// reused, and cert chains are served from a cache. Their freshness comes from
+// the client's nonce instead.
+type Stamp struct {
+	Timestamp  time.Time `json:"timestamp"`
+	InstanceID string    `json:"instance_id"`
+	Sequence   uint64    `json:"sequence"`
+}
+
+// CheckAge checks that s was produced within maxAge of now. Stamps from more
+// than maxAge in the future are rejected too, since they mean the Enclave's
+// clock, or the verifier's, cannot be trusted.
+func (s Stamp) CheckAge(now time.Time, maxAge time.Duration) error {
+	age := now.Sub(s.Timestamp)
+	switch {
+	case s.Timestamp.IsZero():
+		return stampError("missing timestamp", nil)
+	case age > maxAge:
+		msg := fmt.Sprintf("produced %s ago, more than %s", age, maxAge)
+		return stampErrorTooOld(msg, nil)
+	case age < -maxAge:
+		msg := fmt.Sprintf("produced %s in the future", -age)
+		return stampError(msg, nil)
+	}
+	return nil
+}
+
+// Sequencer stamps results for one boot of an Enclave. The Enclave makes one
+// and hands it to every handler that produces results.
+type Sequencer struct {
+	instanceID string
+	sequence   atomic.Uint64
+}
+
+func NewSequencer() *Sequencer {
+	return &Sequencer{instanceID: rand.Text()}
+}
+
+func (s *Sequencer) InstanceID() string {
+	return s.instanceID
+}
+
+// Next returns a stamp with the current time and the next sequence number.
+func (s *Sequencer) Next() Stamp {
+	return Stamp{
+		Timestamp:  time.Now().UTC(),
+		InstanceID: s.instanceID,
+		Sequence:   s.sequence.Add(1),
+	}
+}
+
+// SequenceTracker checks the order of results as a verifier receives them.
+// Sequence numbers are shared by every client of an Enclave, so a gap only
+// means that a verifier which expects to see every result has missed some.
+type SequenceTracker struct {
+	mu   sync.Mutex
+	last map[string]uint64
+}
+
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{last: map[string]uint64{}}
+}
+
+// Observe records s. It returns ErrStampReordered, and does not record s, if
+// a result with the same or a later sequence number from the same instance
+// was observed before. It returns ErrStampGap, after recording s, if results
+// between the last one observed and s were skipped. The first result from an
+// instance, including one from an Enclave that restarted, is always accepted.
+func (t *SequenceTracker) Observe(s Stamp) error {
+	if s.InstanceID == "" || s.Sequence == 0 {
+		return stampError("missing instance id or sequence", nil)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last, ok := t.last[s.InstanceID]
+	switch {
+	case !ok:
+		t.last[s.InstanceID] = s.Sequence
+		return nil
+	case s.Sequence <= last:
+		msg := fmt.Sprintf("sequence %d after %d", s.Sequence, last)
+		return stampErrorReordered(msg, nil)
+	}
+
+	t.last[s.InstanceID] = s.Sequence
+	if missed := s.Sequence - last - 1; missed > 0 {
+		msg := fmt.Sprintf("missed %d results between %d and %d", missed, last, s.Sequence)
+		return stampErrorGap(msg, nil)
+	}
+	return nil
+}
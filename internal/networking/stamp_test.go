@@ -0,0 +1,168 @@
+package networking_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tahardi/bearclave-examples/internal/networking"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStamp_CheckAge(t *testing.T) {
+	now := time.Now()
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		stamp := networking.Stamp{Timestamp: now.Add(-time.Second)}
+
+		// when
+		err := stamp.CheckAge(now, time.Minute)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - too old", func(t *testing.T) {
+		// given
+		stamp := networking.Stamp{Timestamp: now.Add(-2 * time.Minute)}
+
+		// when
+		err := stamp.CheckAge(now, time.Minute)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrStampTooOld)
+	})
+
+	t.Run("error - in the future", func(t *testing.T) {
+		// given
+		stamp := networking.Stamp{Timestamp: now.Add(2 * time.Minute)}
+
+		// when
+		err := stamp.CheckAge(now, time.Minute)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrStamp)
+		assert.NotErrorIs(t, err, networking.ErrStampTooOld)
+	})
+
+	t.Run("error - missing timestamp", func(t *testing.T) {
+		// when
+		err := networking.Stamp{}.CheckAge(now, time.Minute)
+
+		// then
+		require.ErrorIs(t, err, networking.ErrStamp)
+	})
+}
+
+func TestSequencer_Next(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		sequencer := networking.NewSequencer()
+
+		// when
+		first := sequencer.Next()
+		second := sequencer.Next()
+
+		// then
+		assert.Equal(t, sequencer.InstanceID(), first.InstanceID)
+		assert.Equal(t, sequencer.InstanceID(), second.InstanceID)
+		assert.Equal(t, uint64(1), first.Sequence)
+		assert.Equal(t, uint64(2), second.Sequence)
+		assert.False(t, second.Timestamp.Before(first.Timestamp))
+	})
+
+	t.Run("happy path - instance ids differ per boot", func(t *testing.T) {
+		// when
+		first := networking.NewSequencer()
+		second := networking.NewSequencer()
+
+		// then
+		assert.NotEqual(t, first.InstanceID(), second.InstanceID())
+	})
+}
+
+func TestSequenceTracker_Observe(t *testing.T) {
+	sequencer := networking.NewSequencer()
+	stamps := []networking.Stamp{
+		sequencer.Next(),
+		sequencer.Next(),
+		sequencer.Next(),
+		sequencer.Next(),
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		// given
+		tracker := networking.NewSequenceTracker()
+		require.NoError(t, tracker.Observe(stamps[0]))
+
+		// when
+		err := tracker.Observe(stamps[1])
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("happy path - restarted enclave", func(t *testing.T) {
+		// given
+		tracker := networking.NewSequenceTracker()
+		require.NoError(t, tracker.Observe(stamps[3]))
+
+		// when
+		err := tracker.Observe(networking.NewSequencer().Next())
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("error - gap", func(t *testing.T) {
+		// given
+		tracker := networking.NewSequenceTracker()
+		require.NoError(t, tracker.Observe(stamps[0]))
+
+		// when
+		err := tracker.Observe(stamps[3])
+
+		// then
+		require.ErrorIs(t, err, networking.ErrStampGap)
+		assert.ErrorContains(t, err, "missed 2 results")
+		require.NoError(t, tracker.Observe(networking.Stamp{
+			InstanceID: sequencer.InstanceID(),
+			Sequence:   stamps[3].Sequence + 1,
+		}))
+	})
+
+	t.Run("error - reordered", func(t *testing.T) {
+		// given
+		tracker := networking.NewSequenceTracker()
+		require.NoError(t, tracker.Observe(stamps[2]))
+
+		// when
+		err := tracker.Observe(stamps[1])
+
+		// then
+		require.ErrorIs(t, err, networking.ErrStampReordered)
+		require.NoError(t, tracker.Observe(stamps[3]))
+	})
+
+	t.Run("error - replayed", func(t *testing.T) {
+		// given
+		tracker := networking.NewSequenceTracker()
+		require.NoError(t, tracker.Observe(stamps[0]))
+
+		// when
+		err := tracker.Observe(stamps[0])
+
+		// then
+		require.ErrorIs(t, err, networking.ErrStampReordered)
+	})
+
+	t.Run("error - unstamped", func(t *testing.T) {
+		// when
+		err := networking.NewSequenceTracker().Observe(networking.Stamp{})
+
+		// then
+		require.ErrorIs(t, err, networking.ErrStamp)
+	})
+}
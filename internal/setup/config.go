@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 	"github.com/tahardi/bearclave/tee"
 )
@@ -33,11 +34,21 @@ func (e Enclave) GetArg(key string, defaultVal any) any {
 	return defaultVal
 }
 
+// DecodeArg decodes the structured arg at key into out. The out value is left
+// untouched when the arg is missing, so callers can pre-populate defaults.
+func (e Enclave) DecodeArg(key string, out any) error {
+	return decodeArg(e.Args, key, out)
+}
+
 type Proxy struct {
 	Addr       string `mapstructure:"addr"`
 	AddrTLS    string `mapstructure:"addr_tls"`
 	RevAddr    string `mapstructure:"rev_addr"`
 	RevAddrTLS string `mapstructure:"rev_addr_tls"`
+
+	// AdminAddr serves the proxy's metrics, liveness and readiness when set.
+	// The enclave serves its own, which are reached through RevAddr.
+	AdminAddr string `mapstructure:"admin_addr"`
 }
 
 type Nonclave struct {
@@ -56,6 +67,35 @@ func (n Nonclave) GetArg(key string, defaultVal any) any {
 	return defaultVal
 }
 
+// DecodeArg decodes the structured arg at key into out. The out value is left
+// untouched when the arg is missing, so callers can pre-populate defaults.
+func (n Nonclave) DecodeArg(key string, out any) error {
+	return decodeArg(n.Args, key, out)
+}
+
+func decodeArg(args map[string]any, key string, out any) error {
+	val, ok := args[key]
+	if !ok {
+		return nil
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ErrorUnused:      true,
+		ZeroFields:       true,
+		Result:           out,
+		WeaklyTypedInput: true,
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+	})
+	if err != nil {
+		return fmt.Errorf("making decoder for arg %s: %w", key, err)
+	}
+
+	if err := decoder.Decode(val); err != nil {
+		return fmt.Errorf("decoding arg %s: %w", key, err)
+	}
+	return nil
+}
+
 func LoadConfig(configFile string) (*Config, error) {
 	config := &Config{}
 	if _, err := os.Stat(configFile); err != nil {